@@ -30,28 +30,48 @@ import (
 	// Docker
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+
+	// Release env files
+	"github.com/kangarookube/kube-arc-data-services-installer-job/release"
 )
 
 // Globals
 var (
-	// Initiate with root TF directory
-	// Local run - will remain as aksTfModuleDir
-	// CI run - will get overwritten with temp folder from test_structure.CopyTerraformFolderToTemp for the duration of the run
-	testFolder   = aksTfModuleDir
-
 	// Command line variable - e.g. -args -releaseTrain=preview
-	releaseTrain = flag.String("releaseTrain", "", "Arc Data Services Release train - test, preview, stable")
+	// Comma separated list of release trains, falls back to RELEASE_TRAIN, then every release/release.<train>.env file found
+	releaseTrain = flag.String("releaseTrain", "", "Arc Data Services Release train(s) - test, preview, stable - comma separated, empty for RELEASE_TRAIN or all")
+
+	// Command line variable - e.g. -args -upgradeFrom=stable
+	// Release train to onboard with before upgrading in place to the train under test, empty to skip the upgrade stages
+	upgradeFrom = flag.String("upgradeFrom", "", "Arc Data Services Release train to onboard with and upgrade from - test, preview, stable - empty to skip upgrade stages")
 )
 
-// Test run that has skippable stages built in
+// Test run that has skippable stages built in, one subtest per release train
+//
+// Each release train gets its own copy of the Terraform module and Kustomize payload, so trains don't share state files or
+// generated manifests. Trains in the same process run one after another because the Job variables are process environment
+// variables - run one process per train (see Makefile) to run them in parallel.
 func TestAksIntegrationWithStages(t *testing.T) {
 	t.Parallel()
 
+	// Validate release input before anything is deployed
+	releaseTrains := resolveReleaseTrains(t, *releaseTrain)
+
 	// Set environment variables for ARM and TF authentication
 	setARMVariables(t)
 
-	// Copy the root Terraform module into a temporary directory
-	testFolder = test_structure.CopyTerraformFolderToTemp(t, "../", testFolder)
+	for _, train := range releaseTrains {
+		train := train
+		t.Run(train, func(t *testing.T) {
+			runAksIntegrationStages(t, train)
+		})
+	}
+}
+
+// Runs every stage for a single release train
+func runAksIntegrationStages(t *testing.T, releaseTrain string) {
+	// Copy the root Terraform module into a workspace for this release train
+	testFolder := createReleaseTrainWorkspace(t, releaseTrain)
 
 	defer test_structure.RunTestStage(t, "teardown_aks", func() {
 		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
@@ -61,6 +81,7 @@ func TestAksIntegrationWithStages(t *testing.T) {
 	test_structure.RunTestStage(t, "deploy_aks", func() {
 		// Creates for the first time run, this is NOT idempotent because of uniqueID
 		aksTfOpts := createaksTfOpts(t, testFolder)
+		aksTfOpts.Vars["tags"].(map[string]string)["ReleaseTrain"] = releaseTrain
 
 		// Save data to disk so that other test stages executed at a later time can read the data back in
 		test_structure.SaveTerraformOptions(t, testFolder, aksTfOpts)
@@ -71,18 +92,64 @@ func TestAksIntegrationWithStages(t *testing.T) {
 	test_structure.RunTestStage(t, "validate_aks", func() {
 		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
 		validateNodeCountWithARM(t, aksTfOpts)
+
+		// The same capacity preflight the installer runs before onboarding
+		validateClusterCapacityWithK8s(t, aksTfOpts)
 	})
 
 	test_structure.RunTestStage(t, "build_and_push_image", func() {
 		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
 
-		releaseEnvFileName := "release." + (*releaseTrain) + ".env"
-		releaseEnvFilePath := filepath.Join(releaseEnvFolder, releaseEnvFileName)
+		releaseEnvFilePath := release.EnvFilePath(releaseEnvFolder, releaseTrain)
 		buildArgs := createBuildArgFromFile(t, aksTfOpts, releaseEnvFilePath)
+		verifyWheelChecksum(t, buildArgs)
 
 		logger.Log(t, "Building image...")
 
-		buildTagPushDockerImage(t, aksTfOpts, buildArgs)
+		buildTagPushDockerImage(t, aksTfOpts, buildArgs, containerVersion)
+	})
+
+	test_structure.RunTestStage(t, "build_and_push_upgrade_from_image", func() {
+		if *upgradeFrom == "" {
+			logger.Log(t, "No -upgradeFrom release train, skipping")
+			return
+		}
+		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
+
+		releaseEnvFilePath := release.EnvFilePath(releaseEnvFolder, *upgradeFrom)
+		buildArgs := createBuildArgFromFile(t, aksTfOpts, releaseEnvFilePath)
+		verifyWheelChecksum(t, buildArgs)
+
+		logger.Logf(t, "Building %s image to upgrade from...", *upgradeFrom)
+
+		buildTagPushDockerImage(t, aksTfOpts, buildArgs, upgradeFromImageTag())
+	})
+
+	test_structure.RunTestStage(t, "plan_arc_onboarding", func() {
+		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
+		setArcJobVariables(t, aksTfOpts)
+
+		// Nothing exists on a fresh cluster, so everything is created
+		plan := runJobPlanWithK8s(t, aksTfOpts)
+
+		t.Run("plan_creates_every_resource", func(t *testing.T) {
+			assert.Equal(t, "onboard", plan.Mode)
+			assert.Equal(t, []string{
+				// GENERATE_AZDATA_PASSWORD is set and no password Secret exists yet
+				"create Secret",
+				"create ResourceGroup",
+				"create ResourceGroup",
+				"create ConnectedCluster",
+				"create Extension",
+				"role-assignment RoleAssignment",
+				"role-assignment RoleAssignment",
+				"create CustomLocation",
+				"tag CustomLocation",
+				"create DataController",
+				"tag DataController",
+				"update StatusConfigMap",
+			}, plan.changes())
+		})
 	})
 
 	test_structure.RunTestStage(t, "onboard_arc", func() {
@@ -91,14 +158,34 @@ func TestAksIntegrationWithStages(t *testing.T) {
 		// Environment variables will be converted into ConfigMap and Secret by Kustomize
 		setArcJobVariables(t, aksTfOpts)
 
-		// Run job in Onboard mode
-		os.Setenv("DELETE_FLAG", "false")
-		logger.Logf(t, "Running Job with DELETE_FLAG: %s", os.Getenv("DELETE_FLAG"))
-		tempKustomizedManifestPath := generateTemplateAndManifest(t, aksTfOpts)
-		logger.Log(t, "Deployable manifests in temp folder here:", tempKustomizedManifestPath)
+		// Onboard with the older release train's image, upgrade_arc moves it to the train under test
+		if *upgradeFrom != "" {
+			os.Setenv("JOB_IMAGE_TAG", upgradeFromImageTag())
+		}
 
 		// Apply Kustomize Payload and check job health - deletes the job and the temporary manifest folder
-		runJobWithK8s(t, aksTfOpts, tempKustomizedManifestPath)
+		run := runJobActionWithK8s(t, aksTfOpts, "onboard")
+
+		// The harness' service principal is allowed everything onboarding does, checked before anything is created
+		t.Run("ensure_permission_preflight_passed", func(t *testing.T) {
+			assert.Contains(t, run.Logs, fmt.Sprintf("INFO | Service principal %s has every Azure permission this run needs", os.Getenv("CLIENT_ID")))
+		})
+
+		// The installer ran its own capacity preflight before changing anything
+		t.Run("ensure_capacity_preflight_passed", func(t *testing.T) {
+			assert.Contains(t, run.Logs, "INFO | Cluster meets every capacity requirement for Arc Data Services")
+		})
+
+		// CUSTOM_LOCATION_OID is left unset, so the Custom Locations resource provider's object ID came from Microsoft Graph
+		t.Run("ensure_custom_location_oid_resolved", func(t *testing.T) {
+			assert.Regexp(t, `INFO \| CUSTOM_LOCATION_OID is not set, resolved [0-9a-f-]{36} from Microsoft Graph`, run.Logs)
+		})
+
+		// AZDATA_PASSWORD is left unset, so the installer generated one and kept it in a Secret
+		t.Run("ensure_azdata_password_generated", func(t *testing.T) {
+			assert.Contains(t, run.Logs, fmt.Sprintf("INFO | Generated AZDATA_PASSWORD, it is stored in Secret %s/%s before anything is created", statusNamespace, azdataSecret))
+		})
+		validateAzdataSecretWithK8s(t, aksTfOpts, run)
 	})
 
 	test_structure.RunTestStage(t, "validate_arc_onboarding", func() {
@@ -108,6 +195,161 @@ func TestAksIntegrationWithStages(t *testing.T) {
 		validateArcOnboardedWithK8s(t, aksTfOpts)
 		validateConnectedClusterWithARM(t, aksTfOpts)
 		validateDataServicesWithARM(t, aksTfOpts)
+
+		// Nothing installed differs from the release env the cluster was onboarded with
+		expected, err := release.ReadEnv(release.EnvFilePath(releaseEnvFolder, onboardedReleaseTrain(releaseTrain)))
+		require.NoError(t, err)
+		validateNoDriftWithK8s(t, aksTfOpts, expected)
+		validateNoDriftWithARM(t, aksTfOpts, expected)
+
+		// Everything onboarding created carries the installer's ownership tags, so offboarding may delete it
+		validateOwnershipTagsWithARM(t, aksTfOpts)
+
+		// And the harness' AZURE_TAGS, the same tags Terraform put on the AKS cluster
+		validateAzureTagsWithARM(t, aksTfOpts)
+
+		// The Bootstrapper extension's identity holds ARC_DATA_EXT_ROLE_ASSIGNMENTS
+		validateRoleAssignmentsWithARM(t, aksTfOpts)
+
+		// REGISTER_RESOURCE_PROVIDERS=true left every provider the resources belong to registered
+		validateResourceProvidersWithARM(t, aksTfOpts)
+	})
+
+	test_structure.RunTestStage(t, "status_arc", func() {
+		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
+		setArcJobVariables(t, aksTfOpts)
+
+		// Same image as onboarding, so drift means onboarding left something behind
+		if *upgradeFrom != "" {
+			os.Setenv("JOB_IMAGE_TAG", upgradeFromImageTag())
+		}
+
+		// Read-only - everything onboarded is reported healthy and nothing is recorded
+		run := runJobActionWithK8s(t, aksTfOpts, "status")
+
+		t.Run("ensure_status_reports_healthy_resources", func(t *testing.T) {
+			require.NotNil(t, run.Result)
+			for key, resource := range run.Result.Resources {
+				assert.Contains(t, []string{"Exists", "Succeeded", "Ready"}, resource.Status, key)
+			}
+		})
+
+		t.Run("ensure_status_reports_no_drift", func(t *testing.T) {
+			require.NotNil(t, run.Result)
+			assert.NoError(t, checkNoDrift(run.Result.Drift))
+		})
+
+		// Onboarding's status outlived its Job, so this one reads it
+		t.Run("ensure_status_reads_recorded_status", func(t *testing.T) {
+			assert.Contains(t, run.Logs, fmt.Sprintf("INFO | Recorded status in %s/%s: {", statusNamespace, installationStatusConfigMap))
+		})
+	})
+
+	test_structure.RunTestStage(t, "upgrade_arc", func() {
+		if *upgradeFrom == "" {
+			logger.Log(t, "No -upgradeFrom release train, skipping")
+			return
+		}
+		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
+		setArcJobVariables(t, aksTfOpts)
+
+		// Extension first, then the Data Controller - both end on the train under test's versions
+		run := runJobActionWithK8s(t, aksTfOpts, "upgrade")
+
+		t.Run("ensure_upgrade_used_release_train_versions", func(t *testing.T) {
+			expected, err := release.ReadEnv(release.EnvFilePath(releaseEnvFolder, releaseTrain))
+			require.NoError(t, err)
+			require.NotNil(t, run.Result)
+			assert.Equal(t, expected["ARC_DATA_EXT_VERSION"], run.Result.Versions["extensionVersion"])
+			assert.Equal(t, expected["ARC_DATA_CONTROLLER_VERSION"], run.Result.Versions["controllerVersion"])
+		})
+	})
+
+	test_structure.RunTestStage(t, "validate_arc_upgrade", func() {
+		if *upgradeFrom == "" {
+			logger.Log(t, "No -upgradeFrom release train, skipping")
+			return
+		}
+		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
+		setArcJobVariables(t, aksTfOpts) // Used during tests
+
+		expected, err := release.ReadEnv(release.EnvFilePath(releaseEnvFolder, releaseTrain))
+		require.NoError(t, err)
+
+		validateArcOnboardedWithK8s(t, aksTfOpts)
+		validateNoDriftWithK8s(t, aksTfOpts, expected)
+		validateNoDriftWithARM(t, aksTfOpts, expected)
+	})
+
+	test_structure.RunTestStage(t, "offboard_arc_data_services_only", func() {
+		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
+		setArcJobVariables(t, aksTfOpts)
+		os.Setenv("OFFBOARD_SCOPE", "data-services-only")
+
+		// Removes the data services, the cluster stays Arc-connected for other extensions
+		runJobActionWithK8s(t, aksTfOpts, "offboard")
+	})
+
+	test_structure.RunTestStage(t, "validate_arc_data_services_only_offboarding", func() {
+		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
+		setArcJobVariables(t, aksTfOpts) // Used during tests
+
+		validateOffboardScopeWithK8s(t, aksTfOpts, "data-services-only")
+		validateOffboardScopeWithARM(t, aksTfOpts, "data-services-only")
+	})
+
+	test_structure.RunTestStage(t, "reonboard_arc", func() {
+		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
+		setArcJobVariables(t, aksTfOpts)
+
+		// The Connected Cluster was kept, so only the data services are created again
+		run := runJobActionWithK8s(t, aksTfOpts, "onboard")
+
+		t.Run("ensure_reonboard_reused_connected_cluster", func(t *testing.T) {
+			require.NotNil(t, run.Result)
+			assert.Equal(t, "Exists", run.Result.Resources["connectedCluster"].Status)
+		})
+
+		// The Data Controller is recreated with the password generated on first onboarding
+		t.Run("ensure_reonboard_reused_azdata_password", func(t *testing.T) {
+			assert.Contains(t, run.Logs, fmt.Sprintf("INFO | Using AZDATA_PASSWORD from Secret %s/%s", statusNamespace, azdataSecret))
+		})
+	})
+
+	test_structure.RunTestStage(t, "plan_arc_offboarding", func() {
+		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
+		setArcJobVariables(t, aksTfOpts)
+		os.Setenv("ACTION", "offboard")
+
+		// Everything was onboarded, so everything is deleted
+		plan := runJobPlanWithK8s(t, aksTfOpts)
+
+		t.Run("plan_deletes_every_resource", func(t *testing.T) {
+			assert.Equal(t, "offboard", plan.Mode)
+			for _, action := range plan.Actions {
+				if action.Resource == "StatusConfigMap" {
+					continue
+				}
+				assert.Equal(t, "delete", action.Action, "%s %s", action.Resource, action.Name)
+			}
+		})
+	})
+
+	test_structure.RunTestStage(t, "offboard_arc_keep_connected_cluster", func() {
+		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
+		setArcJobVariables(t, aksTfOpts)
+		os.Setenv("OFFBOARD_SCOPE", "keep-connected-cluster")
+
+		// Removes the data services and their resource group, the cluster stays Arc-connected
+		runJobActionWithK8s(t, aksTfOpts, "offboard")
+	})
+
+	test_structure.RunTestStage(t, "validate_arc_keep_connected_cluster_offboarding", func() {
+		aksTfOpts := test_structure.LoadTerraformOptions(t, testFolder)
+		setArcJobVariables(t, aksTfOpts) // Used during tests
+
+		validateOffboardScopeWithK8s(t, aksTfOpts, "keep-connected-cluster")
+		validateOffboardScopeWithARM(t, aksTfOpts, "keep-connected-cluster")
 	})
 
 	test_structure.RunTestStage(t, "destroy_arc", func() {
@@ -116,14 +358,8 @@ func TestAksIntegrationWithStages(t *testing.T) {
 		// Environment variables will be converted into ConfigMap and Secret by Kustomize
 		setArcJobVariables(t, aksTfOpts)
 
-		// Run job in Destroy mode
-		os.Setenv("DELETE_FLAG", "true")
-		logger.Logf(t, "Running Job with DELETE_FLAG: %s", os.Getenv("DELETE_FLAG"))
-		tempKustomizedManifestPath := generateTemplateAndManifest(t, aksTfOpts)
-		logger.Log(t, "Deployable manifests in temp folder here:", tempKustomizedManifestPath)
-
 		// Apply Kustomize Payload and check job health - deletes the job and the temporary manifest folder
-		runJobWithK8s(t, aksTfOpts, tempKustomizedManifestPath)
+		runJobActionWithK8s(t, aksTfOpts, "offboard")
 	})
 
 	test_structure.RunTestStage(t, "validate_arc_offboarding", func() {
@@ -131,10 +367,12 @@ func TestAksIntegrationWithStages(t *testing.T) {
 		setArcJobVariables(t, aksTfOpts) // Used during tests
 
 		validateArcOffboardedWithK8s(t, aksTfOpts)
+		validateOffboardScopeWithK8s(t, aksTfOpts, "full")
+		validateOffboardScopeWithARM(t, aksTfOpts, "full")
 	})
 }
 
-// Validate that the Node Count is g.t.e 3 for Arc Data deployment
+// Validate that the Linux agent pools have at least the installer's minimum node count for Arc Data deployment
 func validateNodeCountWithARM(t *testing.T, aksTfOpts *terraform.Options) {
 	inputResourcePrefix := aksTfOpts.Vars["resource_prefix"].(string)
 
@@ -142,21 +380,76 @@ func validateNodeCountWithARM(t *testing.T, aksTfOpts *terraform.Options) {
 	expectedResourceGroupName := fmt.Sprintf("%s%s", inputResourcePrefix, "rg")
 	expectedClusterName := fmt.Sprintf("%s%s", inputResourcePrefix, "aks")
 
-	// Look up the cluster node count from ARM
+	// Look up the node count of every Linux agent pool from ARM
 	cluster, err := azure.GetManagedClusterE(t, expectedResourceGroupName, expectedClusterName, "")
 	require.NoError(t, err)
-	actualCount := *(*cluster.ManagedClusterProperties.AgentPoolProfiles)[0].Count
-	logger.Logf(t, "Found cluster with %d nodes", actualCount)
+	require.NotNil(t, cluster.ManagedClusterProperties.AgentPoolProfiles)
+
+	actualCount := int32(0)
+	for _, pool := range *cluster.ManagedClusterProperties.AgentPoolProfiles {
+		if pool.Count != nil && strings.EqualFold(string(pool.OsType), "Linux") {
+			actualCount += *pool.Count
+		}
+	}
+	logger.Logf(t, "Found cluster with %d Linux nodes in %d agent pools", actualCount, len(*cluster.ManagedClusterProperties.AgentPoolProfiles))
+
+	t.Run("aks_node_count_greater_than_equals_minimum", func(t *testing.T) {
+		assert.GreaterOrEqual(t, actualCount, int32(defaultCapacityMinimums.Nodes), "AKS Linux node count >= %d", defaultCapacityMinimums.Nodes)
+	})
+}
+
+// Validate the cluster meets the installer's capacity requirements, with the storage classes of the AKS control.json
+func validateClusterCapacityWithK8s(t *testing.T, aksTfOpts *terraform.Options) {
+	options := k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", aksTfOpts.TerraformDir), "default")
+	nodes, classes := getClusterCapacityWithK8s(t, options)
+
+	storageClasses, err := readControlJsonStorageClasses(aksControlJsonPath)
+	require.NoError(t, err)
 
-	t.Run("aks_node_count_greater_than_equals_three", func(t *testing.T) {
-		assert.GreaterOrEqual(t, int32(actualCount), int32(0), "AKS Node Count >= 3")
+	rows := checkClusterCapacity(nodes, classes, storageClasses, defaultCapacityMinimums)
+	logger.Logf(t, "Cluster capacity for Arc Data Services:\n%s", formatCapacityTable(rows))
+
+	t.Run("k8s_cluster_meets_capacity_requirements", func(t *testing.T) {
+		assert.NoError(t, checkCapacityRows(rows))
+	})
+}
+
+// Validate the installer kept the credentials it generated, read before the Job's manifest was deleted, the password
+// meets the Data Controller's policy and the Secret outlived the cleanup
+func validateAzdataSecretWithK8s(t *testing.T, aksTfOpts *terraform.Options, run jobRun) {
+	t.Run("k8s_azdata_secret_matches_username", func(t *testing.T) {
+		assert.Equal(t, os.Getenv("AZDATA_USERNAME"), run.AzdataUsername)
+	})
+
+	t.Run("k8s_azdata_secret_password_meets_policy", func(t *testing.T) {
+		assert.NoError(t, checkAzdataPassword(run.AzdataUsername, run.AzdataPassword))
+	})
+
+	t.Run("k8s_azdata_secret_survives_cleanup", func(t *testing.T) {
+		options := k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", aksTfOpts.TerraformDir), statusNamespace)
+		username, password := getAzdataSecretWithK8s(t, options)
+		assert.Equal(t, run.AzdataUsername, username)
+		assert.Equal(t, run.AzdataPassword, password)
 	})
 }
 
-func buildTagPushDockerImage(t *testing.T, aksTfOpts *terraform.Options, buildArgs map[string]string) {
+// Release train the cluster was onboarded with - the -upgradeFrom train if given, else the train under test
+func onboardedReleaseTrain(releaseTrain string) string {
+	if *upgradeFrom != "" {
+		return *upgradeFrom
+	}
+	return releaseTrain
+}
+
+// Image tag of the Job built from the -upgradeFrom release train - kept apart from the train under test's image
+func upgradeFromImageTag() string {
+	return fmt.Sprintf("%s-%s", containerVersion, *upgradeFrom)
+}
+
+func buildTagPushDockerImage(t *testing.T, aksTfOpts *terraform.Options, buildArgs map[string]string, imageTag string) {
 	// Grab Container Registry variables
 	acrName := terraform.Output(t, aksTfOpts, "acr_name")
-	tag := fmt.Sprintf("%s.azurecr.io/%s:%s", acrName, containerName, containerVersion)
+	tag := fmt.Sprintf("%s.azurecr.io/%s:%s", acrName, containerName, imageTag)
 
 	// Docker Client
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
@@ -182,15 +475,19 @@ func buildTagPushDockerImage(t *testing.T, aksTfOpts *terraform.Options, buildAr
 }
 
 // Deploys Kubernetes Deployable manifests via Kustomize
+//
+// The Kustomize folder is copied into the release train's workspace first, so parallel trains don't overwrite each other's kustomization.yaml
+// The Job runs the image tagged JOB_IMAGE_TAG, set by setArcJobVariables.
 func generateTemplateAndManifest(t *testing.T, aksTfOpts *terraform.Options) string {
 
 	// Replace placeholders in Kustomize manifest
 	replacements := make(map[string]string)
 	replacements["${IMAGE_REGISTRY}"] = fmt.Sprintf("%s.azurecr.io", terraform.Output(t, aksTfOpts, "acr_name"))
-	replacements["${IMAGE_TAG}"] = containerVersion
+	replacements["${IMAGE_TAG}"] = os.Getenv("JOB_IMAGE_TAG")
 
-	templatePath, err := filepath.Abs(k8sBasePayloadDir)
-	require.NoError(t, err)
+	kustomizeRoot := copyKustomizeToWorkspace(t, aksTfOpts.TerraformDir)
+
+	templatePath := filepath.Join(kustomizeRoot, "base")
 	templateFilePath := filepath.Join(templatePath, "kustomization.template.yaml")
 	payloadFilePath := filepath.Join(templatePath, "kustomization.yaml")
 
@@ -198,21 +495,119 @@ func generateTemplateAndManifest(t *testing.T, aksTfOpts *terraform.Options) str
 	generateTemplate(t, templateFilePath, payloadFilePath, replacements)
 
 	// Generate Kustomized manifest
-	kustomizePath, err := filepath.Abs(k8sAksOverlayPayloadDir)
-	require.NoError(t, err)
-	payloadPath, err := filepath.Abs(k8sPayloadTempDir)
-	require.NoError(t, err)
+	kustomizePath := filepath.Join(kustomizeRoot, "overlays", "aks")
+	payloadPath := filepath.Join(kustomizeRoot, ".temp")
 	tempKustomizedManifestPath := generateKustomizedManifest(t, kustomizePath, payloadPath)
 
 	// Return Path to Kustomize Manifest
 	return tempKustomizedManifestPath
 }
 
-// Apply Manifest and validate job succeeds - cleans up after itself and prints out the logs from Job run
-func runJobWithK8s(t *testing.T, aksRbacOpts *terraform.Options, tempKustomizedManifestPath string) {
+// What a successful Job run reports for each ACTION
+type jobActionExpectation struct {
+	ResourceStatuses  []string // Every resource in the job result ends in one of these
+	RequireIDs        bool     // Every resource in the job result has an ARM ID
+	InstallationState string   // State recorded in the status ConfigMap, empty if the action must not record one
+}
+
+var jobActionExpectations = map[string]jobActionExpectation{
+	"onboard":  {ResourceStatuses: []string{"Created", "Exists", "Ready"}, RequireIDs: true, InstallationState: "Onboarded"},
+	"repair":   {ResourceStatuses: []string{"Created", "Exists", "Ready"}, RequireIDs: true, InstallationState: "Onboarded"},
+	"upgrade":  {ResourceStatuses: []string{"Upgraded", "Current"}, RequireIDs: true, InstallationState: "Onboarded"},
+	"offboard": {ResourceStatuses: []string{"Deleted", "Kept", "Absent"}, InstallationState: "Offboarded"},
+	"status":   {ResourceStatuses: []string{"Exists", "Succeeded", "Ready", "Failed", "Absent"}},
+}
+
+// Runs the Job with the given ACTION and validates it succeeded - cleans up after itself and prints out the logs from Job run
+func runJobActionWithK8s(t *testing.T, aksRbacOpts *terraform.Options, action string) jobRun {
+	expected, ok := jobActionExpectations[action]
+	require.True(t, ok, "no expectations for ACTION %s", action)
+
+	os.Setenv("ACTION", action)
+	logger.Logf(t, "Running Job with ACTION: %s", action)
+	tempKustomizedManifestPath := generateTemplateAndManifest(t, aksRbacOpts)
+	logger.Log(t, "Deployable manifests in temp folder here:", tempKustomizedManifestPath)
+
+	statusOptions := k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", aksRbacOpts.TerraformDir), statusNamespace)
+	recorded := getInstallationStatusWithK8s(t, statusOptions)
+
+	run := applyJobAndWait(t, aksRbacOpts, tempKustomizedManifestPath)
+
+	// Publish unit test results
+	t.Run(fmt.Sprintf("ensure_%s_job_succeeded", action), func(t *testing.T) {
+		assert.True(t, run.Succeeded, "%s Kubernetes Job succeeded", action)
+	})
+
+	// Assert on the Job's own result rather than its logs
+	t.Run(fmt.Sprintf("ensure_%s_job_result", action), func(t *testing.T) {
+		require.NotNil(t, run.Result, "Job wrote a result to its termination message")
+		assert.Equal(t, "Succeeded", run.Result.Status)
+		assert.Equal(t, action, run.Result.Mode)
+		for key, resource := range run.Result.Resources {
+			assert.Contains(t, expected.ResourceStatuses, resource.Status, key)
+			if expected.RequireIDs {
+				assert.NotEmpty(t, resource.ID, "%s has an ARM ID", key)
+			}
+		}
+	})
+
+	t.Run(fmt.Sprintf("ensure_%s_status_recorded", action), func(t *testing.T) {
+		if expected.InstallationState == "" {
+			assert.Equal(t, recorded, run.Status, "ACTION=%s records nothing in the %s ConfigMap", action, installationStatusConfigMap)
+			return
+		}
+		require.NotNil(t, run.Status, "Job recorded its status in the %s ConfigMap", installationStatusConfigMap)
+		assert.NoError(t, run.Status.validate(expected.InstallationState))
+		if run.Result != nil && expected.InstallationState == "Onboarded" {
+			assert.Equal(t, run.Result.Versions["extensionVersion"], run.Status["extensionVersion"], "Status matches the versions the Job used")
+		}
+	})
+
+	// The status lives in STATUS_NAMESPACE, so deleting the Job's manifest keeps it for the next run
+	t.Run(fmt.Sprintf("ensure_%s_status_survives_cleanup", action), func(t *testing.T) {
+		assert.Equal(t, run.Status, getInstallationStatusWithK8s(t, statusOptions))
+	})
+
+	return run
+}
+
+// Runs the Job with DRY_RUN=true and returns the plan it printed - nothing is created or deleted
+//
+// Uses the current ACTION, so the plan is for onboarding, offboarding etc. accordingly
+func runJobPlanWithK8s(t *testing.T, aksRbacOpts *terraform.Options) installerPlan {
+	os.Setenv("DRY_RUN", "true")
+	defer os.Setenv("DRY_RUN", "false")
+
+	tempKustomizedManifestPath := generateTemplateAndManifest(t, aksRbacOpts)
+	logger.Log(t, "Deployable manifests in temp folder here:", tempKustomizedManifestPath)
+
+	run := applyJobAndWait(t, aksRbacOpts, tempKustomizedManifestPath)
+	require.True(t, run.Succeeded, "Dry run Kubernetes Job succeeded")
+
+	plan, err := parseInstallerPlan(run.Logs)
+	require.NoError(t, err)
+
+	return plan
+}
+
+// Outcome of one Job run
+type jobRun struct {
+	Succeeded bool
+	Logs      string
+	Result    *jobResult         // From the Pod's termination message, nil if there was none
+	Status    installationStatus // From the status ConfigMap, nil if there was none
+
+	// From the generated AZDATA password Secret, empty if there was none
+	AzdataUsername string
+	AzdataPassword string
+}
+
+// Applies the Job manifest and waits for the Job to succeed
+// Cleans up after itself and prints out the logs and result from Job run
+func applyJobAndWait(t *testing.T, aksRbacOpts *terraform.Options, tempKustomizedManifestPath string) (run jobRun) {
 
 	// Setup the kubectl config and namespace context - grabbed from Terraform module output
-	options := k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", testFolder), jobNamespace)
+	options := k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", aksRbacOpts.TerraformDir), jobNamespace)
 
 	// Clean up
 	defer func() {
@@ -221,6 +616,16 @@ func runJobWithK8s(t *testing.T, aksRbacOpts *terraform.Options, tempKustomizedM
 		output, err := k8s.RunKubectlAndGetOutputE(t, options, "logs", fmt.Sprintf("job/%s", jobName), "-n", jobNamespace)
 		require.NoError(t, err)
 		logger.Logf(t, "Job Log: \n %s", output)
+		run.Logs = output
+
+		// Get the result the installer wrote to its termination message
+		run.Result = getJobResultWithK8s(t, options, jobName)
+
+		// Get the installation status - the ConfigMap lives in STATUS_NAMESPACE, which the deletes keep
+		run.Status = getInstallationStatusWithK8s(t, options)
+
+		// Get the generated AZDATA credentials before the deletes too - the Secret also lives in STATUS_NAMESPACE
+		run.AzdataUsername, run.AzdataPassword = getAzdataSecretWithK8s(t, options)
 
 		// Delete all job resources
 		k8s.KubectlDelete(t, options, tempKustomizedManifestPath)
@@ -237,26 +642,14 @@ func runJobWithK8s(t *testing.T, aksRbacOpts *terraform.Options, tempKustomizedM
 	k8s.WaitUntilJobSucceed(t, options, jobName, arcInstallTimeOutInMins, retriesDuration)
 
 	// Get Job status
-	jobStatus := k8s.IsJobSucceeded(k8s.GetJob(t, options, jobName))
-
-	// Publish unit test results
-	if os.Getenv("DELETE_FLAG") == "false" {
-		t.Run("ensure_onboarding_job_succeeded", func(t *testing.T) {
-			assert.True(t, jobStatus, "Onboarding Kubernetes Job succeeded")
-		})
-	} else if os.Getenv("DELETE_FLAG") == "true" {
-		t.Run("ensure_offboarding_job_succeeded", func(t *testing.T) {
-			assert.True(t, jobStatus, "Offboarding Kubernetes Job succeeded")
-		})
-	} else {
-		t.Fatal("DELETE_FLAG is not correctly set")
-	}
+	run.Succeeded = k8s.IsJobSucceeded(k8s.GetJob(t, options, jobName))
+	return run
 }
 
 // Calls Kubernetes to get post-deployment health checks done
 func validateArcOnboardedWithK8s(t *testing.T, aksRbacOpts *terraform.Options) {
 	// Namespace: "azure-arc" - which is static
-	options := k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", testFolder), "azure-arc")
+	options := k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", aksRbacOpts.TerraformDir), "azure-arc")
 
 	// Get Last Connectivity Time for connected cluster
 	jsonPathQuery := "{.items[*]['status.lastConnectivityTime']}"
@@ -269,7 +662,7 @@ func validateArcOnboardedWithK8s(t *testing.T, aksRbacOpts *terraform.Options) {
 	})
 
 	// Get Data Controller Health Status
-	options = k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", testFolder), os.Getenv("ARC_DATA_NAMESPACE"))
+	options = k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", aksRbacOpts.TerraformDir), os.Getenv("ARC_DATA_NAMESPACE"))
 
 	jsonPathQuery = "{.items[*]['status']}"
 	controllerStatus, err := k8s.RunKubectlAndGetOutputE(t, options, "get", "datacontrollers", fmt.Sprintf("-o=jsonpath=%q", jsonPathQuery))
@@ -334,6 +727,116 @@ func validateConnectedClusterWithARM(t *testing.T, aksRbacOpts *terraform.Option
 
 }
 
+// Calls Kubernetes to check the datacontroller CR runs the release env's image tag and repository
+func validateNoDriftWithK8s(t *testing.T, aksRbacOpts *terraform.Options, expected release.Env) {
+	options := k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", aksRbacOpts.TerraformDir), os.Getenv("ARC_DATA_NAMESPACE"))
+
+	jsonPathQuery := "{.items[*]['spec.docker.imageTag']}"
+	controllerImageTag, err := k8s.RunKubectlAndGetOutputE(t, options, "get", "datacontrollers", fmt.Sprintf("-o=jsonpath=%q", jsonPathQuery))
+	require.NoError(t, err)
+	controllerImageTag = regexp.MustCompile(`^"(.*)"$`).ReplaceAllString(controllerImageTag, `$1`) // Remove quotes
+	logger.Logf(t, "Controller Image Tag: %s", controllerImageTag)
+
+	jsonPathQuery = "{.items[*]['spec.docker.repository']}"
+	controllerRepository, err := k8s.RunKubectlAndGetOutputE(t, options, "get", "datacontrollers", fmt.Sprintf("-o=jsonpath=%q", jsonPathQuery))
+	require.NoError(t, err)
+	controllerRepository = regexp.MustCompile(`^"(.*)"$`).ReplaceAllString(controllerRepository, `$1`) // Remove quotes
+	logger.Logf(t, "Controller Repository: %s", controllerRepository)
+
+	t.Run("k8s_ensure_controller_image_tag_matches_release_env", func(t *testing.T) {
+		assert.Equal(t, expected["ARC_DATA_CONTROLLER_VERSION"], controllerImageTag, "Controller runs the release train's image tag")
+	})
+
+	t.Run("k8s_ensure_controller_repository_matches_release_env", func(t *testing.T) {
+		repository, known := controllerRepositories[expected["ARC_DATA_RELEASE_TRAIN"]]
+		if !known {
+			t.Skipf("No known repository for release train %s", expected["ARC_DATA_RELEASE_TRAIN"])
+		}
+		assert.Equal(t, repository, controllerRepository, "Controller pulls from the release train's repository")
+	})
+}
+
+// Calls ARM to check the Bootstrapper extension runs the release env's version and release train, without auto-upgrade
+func validateNoDriftWithARM(t *testing.T, aksRbacOpts *terraform.Options, expected release.Env) {
+	cred := getAzureCred(t)
+	ctx := context.Background()
+
+	extensionProperty := getConnectedClusterExtension(t, ctx, cred, os.Getenv("CONNECTED_CLUSTER_RESOURCE_GROUP"), os.Getenv("CONNECTED_CLUSTER"), os.Getenv("ARC_DATA_EXT"))
+
+	t.Run("arm_ensure_data_service_bootstrapper_extension_version_matches_release_env", func(t *testing.T) {
+		require.NotNil(t, extensionProperty.Properties.Version, "Data Services Extension has a pinned version")
+		assert.Equal(t, expected["ARC_DATA_EXT_VERSION"], *extensionProperty.Properties.Version, "Data Services Extension runs the release train's version")
+	})
+
+	t.Run("arm_ensure_data_service_bootstrapper_extension_release_train_matches_release_env", func(t *testing.T) {
+		require.NotNil(t, extensionProperty.Properties.ReleaseTrain, "Data Services Extension has a release train")
+		assert.Equal(t, strings.ToLower(expected["ARC_DATA_RELEASE_TRAIN"]), strings.ToLower(*extensionProperty.Properties.ReleaseTrain), "Data Services Extension is on the release train")
+	})
+
+	t.Run("arm_ensure_data_service_bootstrapper_extension_auto_upgrade_matches_release_env", func(t *testing.T) {
+		require.NotNil(t, extensionProperty.Properties.AutoUpgradeMinorVersion)
+		assert.False(t, *extensionProperty.Properties.AutoUpgradeMinorVersion, "Data Services Extension Auto Upgrade is disabled")
+	})
+}
+
+// Function calls ARM to validate every resource the installer created is tagged as its own
+func validateOwnershipTagsWithARM(t *testing.T, aksRbacOpts *terraform.Options) {
+	cred := getAzureCred(t)
+	ctx := context.Background()
+
+	for key, tags := range getArcResourceTagsWithARM(t, ctx, cred) {
+		key, tags := key, tags
+		t.Run(fmt.Sprintf("arm_ensure_%s_has_ownership_tags", key), func(t *testing.T) {
+			assert.NoError(t, checkOwnershipTags(tags, os.Getenv("CONNECTED_CLUSTER")))
+		})
+	}
+}
+
+// Function calls ARM to validate every resource the installer created carries AZURE_TAGS
+func validateAzureTagsWithARM(t *testing.T, aksRbacOpts *terraform.Options) {
+	cred := getAzureCred(t)
+	ctx := context.Background()
+
+	expected := map[string]string{}
+	require.NoError(t, json.Unmarshal([]byte(os.Getenv("AZURE_TAGS")), &expected))
+	require.NotEmpty(t, expected, "AZURE_TAGS is set by setArcJobVariables")
+
+	for key, tags := range getArcResourceTagsWithARM(t, ctx, cred) {
+		key, tags := key, tags
+		t.Run(fmt.Sprintf("arm_ensure_%s_has_azure_tags", key), func(t *testing.T) {
+			assert.NoError(t, checkAzureTags(tags, expected))
+		})
+	}
+}
+
+// Function calls ARM to validate the Bootstrapper extension's managed identity holds ARC_DATA_EXT_ROLE_ASSIGNMENTS
+func validateRoleAssignmentsWithARM(t *testing.T, aksRbacOpts *terraform.Options) {
+	cred := getAzureCred(t)
+	ctx := context.Background()
+
+	arcDataRg := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", os.Getenv("AZURE_SUBSCRIPTION_ID"), os.Getenv("ARC_DATA_RESOURCE_GROUP"))
+	expected, err := parseRoleAssignments(os.Getenv("ARC_DATA_EXT_ROLE_ASSIGNMENTS"), arcDataRg)
+	require.NoError(t, err)
+
+	extension := getConnectedClusterExtension(t, ctx, cred, os.Getenv("CONNECTED_CLUSTER_RESOURCE_GROUP"), os.Getenv("CONNECTED_CLUSTER"), os.Getenv("ARC_DATA_EXT"))
+	require.NotNil(t, extension.Identity)
+	require.NotNil(t, extension.Identity.PrincipalID)
+
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, assignment := range expected {
+		if !seen[assignment.Scope] {
+			seen[assignment.Scope] = true
+			scopes = append(scopes, assignment.Scope)
+		}
+	}
+	assigned := getRoleAssignmentsWithARM(t, ctx, *extension.Identity.PrincipalID, scopes)
+
+	t.Run("arm_ensure_extension_identity_has_roles", func(t *testing.T) {
+		assert.NoError(t, checkRoleAssignments(assigned, expected))
+	})
+}
+
 // // Function calls ARM to validate Data Services
 func validateDataServicesWithARM(t *testing.T, aksRbacOpts *terraform.Options) {
 	// Authenticate to Azure and initiate context
@@ -363,10 +866,91 @@ func validateDataServicesWithARM(t *testing.T, aksRbacOpts *terraform.Options) {
 // Calls Kubernetes to get post-offboarding health checks done
 func validateArcOffboardedWithK8s(t *testing.T, aksRbacOpts *terraform.Options) {
 	// Get all Api Groups with Microsoft owned CRDs installed in Cluster
-	options := k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", testFolder), "default")
+	options := k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", aksRbacOpts.TerraformDir), "default")
 	microsoftApiGroups := getAllMicrosoftCrdApiGroups(t, options)
 	logger.Logf(t, "All Microsoft APIGroups for CRDs installed in the Cluster: %s", microsoftApiGroups)
 	t.Run("k8s_ensure_all_microsoft_crd_apigroups_uninstalled", func(t *testing.T) {
 		assert.LessOrEqual(t, len(microsoftApiGroups), 0, "All Microsoft CRD APIGroups are uninstalled from the Cluster")
 	})
 }
+
+// Arc resources each OFFBOARD_SCOPE keeps, keyed like the job result - everything else is gone
+var offboardScopeKeeps = map[string][]string{
+	"data-services-only":     {"connectedClusterResourceGroup", "arcDataResourceGroup", "connectedCluster"},
+	"keep-connected-cluster": {"connectedClusterResourceGroup", "connectedCluster"},
+	"full":                   {},
+}
+
+// Calls Kubernetes to check the data services are gone, and the Arc agents are still connected unless offboarding was full
+func validateOffboardScopeWithK8s(t *testing.T, aksRbacOpts *terraform.Options, scope string) {
+	options := k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", aksRbacOpts.TerraformDir), "default")
+
+	dataServicesNamespace, err := k8s.RunKubectlAndGetOutputE(t, options, "get", "namespace", os.Getenv("ARC_DATA_NAMESPACE"), "--ignore-not-found", "-o=name")
+	require.NoError(t, err)
+
+	t.Run(fmt.Sprintf("k8s_ensure_%s_removed_data_services_namespace", scope), func(t *testing.T) {
+		assert.Empty(t, dataServicesNamespace, "Data Services Namespace is deleted")
+	})
+
+	microsoftApiGroups := getAllMicrosoftCrdApiGroups(t, options)
+	logger.Logf(t, "All Microsoft APIGroups for CRDs installed in the Cluster: %s", microsoftApiGroups)
+
+	t.Run(fmt.Sprintf("k8s_ensure_%s_removed_arcdata_crds", scope), func(t *testing.T) {
+		for _, apiGroup := range microsoftApiGroups {
+			assert.NotContains(t, apiGroup, "arcdata", "Arc Data CRD APIGroups are uninstalled from the Cluster")
+		}
+	})
+
+	if scope == "full" {
+		return
+	}
+
+	// Namespace: "azure-arc" - which is static
+	options = k8s.NewKubectlOptions("", fmt.Sprintf("%s/kubeconfig", aksRbacOpts.TerraformDir), "azure-arc")
+	jsonPathQuery := "{.items[*]['status.lastConnectivityTime']}"
+	clusterConnectTime, err := k8s.RunKubectlAndGetOutputE(t, options, "get", "connectedclusters", fmt.Sprintf("-o=jsonpath=%q", jsonPathQuery)) // %q adds quotes
+	require.NoError(t, err)
+	logger.Logf(t, "Last Cluster Connectivity Time (UTC): %s", clusterConnectTime)
+
+	t.Run(fmt.Sprintf("k8s_ensure_%s_kept_cluster_connected", scope), func(t *testing.T) {
+		assert.NotEmpty(t, clusterConnectTime, "Cluster is still Arc-connected")
+	})
+}
+
+// Calls ARM to check only the resources the scope keeps are left
+func validateOffboardScopeWithARM(t *testing.T, aksRbacOpts *terraform.Options, scope string) {
+	keeps, ok := offboardScopeKeeps[scope]
+	require.True(t, ok, "unknown OFFBOARD_SCOPE %s", scope)
+
+	// Authenticate to Azure and initiate context
+	cred := getAzureCred(t)
+	ctx := context.Background()
+
+	found := getArcResourcesFoundWithARM(t, ctx, cred)
+
+	for key, exists := range found {
+		key, exists := key, exists
+		kept := false
+		for _, keep := range keeps {
+			if keep == key {
+				kept = true
+			}
+		}
+		outcome := "removed"
+		if kept {
+			outcome = "kept"
+		}
+		t.Run(fmt.Sprintf("arm_ensure_%s_%s_%s", scope, outcome, key), func(t *testing.T) {
+			assert.Equal(t, kept, exists, "%s exists in ARM", key)
+		})
+	}
+}
+
+// Function calls ARM to validate the subscription has registered every Arc Data Services resource provider
+func validateResourceProvidersWithARM(t *testing.T, aksRbacOpts *terraform.Options) {
+	states := getResourceProvidersWithARM(t, context.Background())
+
+	t.Run("arm_ensure_resource_providers_registered", func(t *testing.T) {
+		assert.NoError(t, checkProvidersRegistered(states))
+	})
+}
@@ -2,20 +2,34 @@ package test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"testing"
 
+	"github.com/gruntwork-io/terratest/modules/azure"
+	"github.com/gruntwork-io/terratest/modules/logger"
 	"github.com/gruntwork-io/terratest/modules/terraform"
-	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/require"
 
+	// Release env files
+	"github.com/kangarookube/kube-arc-data-services-installer-job/release"
+
+	// Azure and Kubernetes naming rules
+	"github.com/kangarookube/kube-arc-data-services-installer-job/naming"
+
 	// Azure
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/azurearcdata/armazurearcdata"                       // Data Controller
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/extendedlocation/armextendedlocation"               // Custom Location
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/hybridkubernetes/armhybridkubernetes"               // Connected Cluster
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/kubernetesconfiguration/armkubernetesconfiguration" // Extensions
+	"github.com/Azure/azure-sdk-for-go/services/authorization/mgmt/2015-07-01/authorization"                   // Role Assignments
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2020-10-01/resources"                           // Resource Providers
 )
 
 // Read variables from release.env file and convert into Docker buildArgs
@@ -25,13 +39,29 @@ import (
 // 1. EXT_ARCDATA_VERSION -> dictates the image tags available for deployment with "az arcdata ..."
 // 2. ARC_DATA_EXT_VERSION -> dictates the Helm Chart version applied to the Cluster
 // 3. ARC_DATA_CONTROLLER_VERSION -> dictates the data controller image tag deployed
+//
+// The file is validated with the same rules the release-env tool applies when writing it.
 
 func createBuildArgFromFile(t *testing.T, aksTfOpts *terraform.Options, releaseEnvFilePath string) map[string]string {
-	buildArgs, err := godotenv.Read(releaseEnvFilePath)
+	buildArgs, err := release.ReadEnv(releaseEnvFilePath)
 	require.NoError(t, err)
+	require.NoError(t, buildArgs.Validate())
 	return buildArgs
 }
 
+// Verifies the az arcdata wheel still matches the release env's ARC_DATA_WHL_SHA256 before spending time on a build
+func verifyWheelChecksum(t *testing.T, buildArgs map[string]string) {
+	checksum := buildArgs["ARC_DATA_WHL_SHA256"]
+	if checksum == "" {
+		logger.Logf(t, "WARNING | ARC_DATA_WHL_SHA256 is not set, wheel %s is not pinned - run 'go run ./cmd/release-env pin-wheel'", buildArgs["ARC_DATA_WHL_URL"])
+		return
+	}
+
+	logger.Logf(t, "Verifying wheel %s against sha256 %s", buildArgs["ARC_DATA_WHL_URL"], checksum)
+	err := release.VerifyWheel(buildArgs["ARC_DATA_WHL_URL"], checksum)
+	require.NoError(t, err)
+}
+
 // Injects environment variables for Arc ConfigMap/Secret creation for Kustomize
 // This function first checks if a value is already passed in, if not, it sets reasonable defaults
 
@@ -41,7 +71,8 @@ func createBuildArgFromFile(t *testing.T, aksTfOpts *terraform.Options, releaseE
 // export CLIENT_ID=$SPN_CLIENT_ID                               #                 "
 // export CLIENT_SECRET=$SPN_CLIENT_SECRET                       #                 "
 // export AZDATA_USERNAME='boor'                                 # boor
-// export AZDATA_PASSWORD='acntorPRESTO!'                        # acntorPRESTO!
+// export AZDATA_PASSWORD=''                                    # Empty - the installer generates one
+// export GENERATE_AZDATA_PASSWORD='true'                        # true - kept in the azure-arc-data-services-azdata Secret
 // export CONNECTED_CLUSTER_RESOURCE_GROUP="$resourceGroup-arc"  # Append "arc" to existing RG's name
 // export CONNECTED_CLUSTER_LOCATION="eastus"                    # If set use, if not, set to eastus
 // export ARC_DATA_RESOURCE_GROUP="$resourceGroup-arc-data"      # Append "arc-data" to  existing RG's name
@@ -51,7 +82,14 @@ func createBuildArgFromFile(t *testing.T, aksTfOpts *terraform.Options, releaseE
 // export ARC_DATA_NAMESPACE="azure-arc-data"                    # azure-arc-data
 // export ARC_DATA_CONTROLLER="azure-arc-data-controller"        # azure-arc-data-controller
 // export ARC_DATA_CONTROLLER_LOCATION="eastus"                  # If set use, if not, set to eastus
-// export DELETE_FLAG='false'                                    # Starts false - will be overwritten to true during test
+// export ACTION='onboard'                                       # Starts onboard - each stage sets the action it runs
+// export OFFBOARD_SCOPE='full'                                  # Starts full - partial offboarding stages set their scope
+// export DELETE_FLAG='false'                                    # false - kept for backward compatibility, ACTION is used instead
+// export DRY_RUN='false'                                        # Starts false - only true while a plan is requested
+// export VALIDATE_ONLY='false'                                  # false
+// export JOB_IMAGE_TAG='0.1.0'                                  # containerVersion - image the Job runs, onboard_arc uses the -upgradeFrom image
+// export AZURE_TAGS='{"Source":"terratest",...}'                # Terraform's tags var as JSON - the Arc resources get the AKS cluster's tags
+// export ARC_DATA_EXT_ROLE_ASSIGNMENTS='Contributor,Monitoring Metrics Publisher' # Installer default, on the Arc Data Services Resource Group
 
 func setArcJobVariables(t *testing.T, aksTfOpts *terraform.Options) {
 	os.Setenv("TENANT_ID", os.Getenv("SPN_TENANT_ID"))
@@ -59,7 +97,9 @@ func setArcJobVariables(t *testing.T, aksTfOpts *terraform.Options) {
 	os.Setenv("CLIENT_ID", os.Getenv("SPN_CLIENT_ID"))
 	os.Setenv("CLIENT_SECRET", os.Getenv("SPN_CLIENT_SECRET"))
 	os.Setenv("AZDATA_USERNAME", "boor")
-	os.Setenv("AZDATA_PASSWORD", "acntorPRESTO!")
+	// No fixed password - the installer generates one on first onboarding and reuses it from its Secret after that
+	os.Setenv("AZDATA_PASSWORD", "")
+	os.Setenv("GENERATE_AZDATA_PASSWORD", "true")
 
 	// Unique prefix for this deployment
 	inputResourcePrefix := aksTfOpts.Vars["resource_prefix"].(string)
@@ -81,7 +121,28 @@ func setArcJobVariables(t *testing.T, aksTfOpts *terraform.Options) {
 	if os.Getenv("ARC_DATA_CONTROLLER_LOCATION") == "" {
 		os.Setenv("ARC_DATA_CONTROLLER_LOCATION", "eastus")
 	}
+	os.Setenv("ACTION", "onboard")
+	os.Setenv("OFFBOARD_SCOPE", "full")
+	os.Setenv("JOB_IMAGE_TAG", containerVersion)
 	os.Setenv("DELETE_FLAG", "false")
+	os.Setenv("DRY_RUN", "false")
+	os.Setenv("VALIDATE_ONLY", "false")
+
+	// Same tags Terraform puts on the AKS cluster - a map[string]string, or map[string]interface{} once reloaded from disk
+	azureTags, err := json.Marshal(aksTfOpts.Vars["tags"])
+	require.NoError(t, err)
+	os.Setenv("AZURE_TAGS", string(azureTags))
+	os.Setenv("ARC_DATA_EXT_ROLE_ASSIGNMENTS", "Contributor,Monitoring Metrics Publisher")
+	os.Setenv("REGISTER_RESOURCE_PROVIDERS", "true")
+	// Tenant specific - the installer resolves it from Microsoft Graph
+	os.Unsetenv("CUSTOM_LOCATION_OID")
+
+	// Same checks as the installer's validation, before anything is deployed
+	env := map[string]string{}
+	for _, key := range naming.Variables() {
+		env[key] = os.Getenv(key)
+	}
+	require.NoError(t, naming.Validate(env))
 }
 
 // Retrieves the Azure Arc Connected Cluster Get response
@@ -150,3 +211,252 @@ func getDataController(t *testing.T, ctx context.Context, cred azcore.TokenCrede
 
 	return &dataControllerResponse
 }
+
+// Checks an ARM Get call found its resource - a 404 means it is gone, any other error fails the test
+func armResourceFound(t *testing.T, err error) bool {
+	if err == nil {
+		return true
+	}
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotFound {
+		return false
+	}
+	require.NoError(t, err)
+	return false
+}
+
+// Looks up which Arc resources ARM still has, keyed like the job result - e.g. connectedCluster, dataController
+func getArcResourcesFoundWithARM(t *testing.T, ctx context.Context, cred azcore.TokenCredential) map[string]bool {
+	subscriptionID := os.Getenv("AZURE_SUBSCRIPTION_ID")
+	connectedClusterRg := os.Getenv("CONNECTED_CLUSTER_RESOURCE_GROUP")
+	arcDataRg := os.Getenv("ARC_DATA_RESOURCE_GROUP")
+
+	connectedClusterClient, err := armhybridkubernetes.NewConnectedClusterClient(subscriptionID, cred, nil)
+	require.NoError(t, err)
+	extensionClient, err := armkubernetesconfiguration.NewExtensionsClient(subscriptionID, cred, nil)
+	require.NoError(t, err)
+	customLocationClient, err := armextendedlocation.NewCustomLocationsClient(subscriptionID, cred, nil)
+	require.NoError(t, err)
+	dataControllerClient, err := armazurearcdata.NewDataControllersClient(subscriptionID, cred, nil)
+	require.NoError(t, err)
+
+	found := map[string]bool{
+		"connectedClusterResourceGroup": azure.ResourceGroupExists(t, connectedClusterRg, subscriptionID),
+		"arcDataResourceGroup":          azure.ResourceGroupExists(t, arcDataRg, subscriptionID),
+	}
+	_, err = connectedClusterClient.Get(ctx, connectedClusterRg, os.Getenv("CONNECTED_CLUSTER"), nil)
+	found["connectedCluster"] = armResourceFound(t, err)
+	_, err = extensionClient.Get(ctx, connectedClusterRg, "Microsoft.Kubernetes", "connectedClusters", os.Getenv("CONNECTED_CLUSTER"), os.Getenv("ARC_DATA_EXT"), nil)
+	found["extension"] = armResourceFound(t, err)
+	_, err = customLocationClient.Get(ctx, arcDataRg, os.Getenv("ARC_DATA_NAMESPACE"), nil)
+	found["customLocation"] = armResourceFound(t, err)
+	_, err = dataControllerClient.GetDataController(ctx, arcDataRg, os.Getenv("ARC_DATA_CONTROLLER"), nil)
+	found["dataController"] = armResourceFound(t, err)
+
+	logger.Logf(t, "Arc resources found in ARM: %v", found)
+	return found
+}
+
+// Ownership tags the installer puts on everything it creates - see Ownership Tags in install-arc-data-services.sh
+const (
+	ownerTagManagedBy = "arc-installer-managed-by"
+	ownerTagCluster   = "arc-installer-cluster"
+	ownerTagRunID     = "arc-installer-run-id"
+	ownerManagedBy    = "kube-arc-data-services-installer-job"
+)
+
+// Checks a resource's tags mark it as created by the installer for cluster
+func checkOwnershipTags(tags map[string]string, cluster string) error {
+	problems := []string{}
+	if tags[ownerTagManagedBy] != ownerManagedBy {
+		problems = append(problems, fmt.Sprintf("%s is %q, expected %q", ownerTagManagedBy, tags[ownerTagManagedBy], ownerManagedBy))
+	}
+	if tags[ownerTagCluster] != cluster {
+		problems = append(problems, fmt.Sprintf("%s is %q, expected %q", ownerTagCluster, tags[ownerTagCluster], cluster))
+	}
+	if tags[ownerTagRunID] == "" {
+		problems = append(problems, fmt.Sprintf("%s is empty", ownerTagRunID))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("ownership tags are invalid: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Converts ARM's tag map, whose values are pointers, to plain strings
+func armTags(tags map[string]*string) map[string]string {
+	values := make(map[string]string, len(tags))
+	for key, value := range tags {
+		if value != nil {
+			values[key] = *value
+		}
+	}
+	return values
+}
+
+// Reads the tags of the Arc resources the installer tags, keyed like the job result - e.g. connectedCluster, dataController
+func getArcResourceTagsWithARM(t *testing.T, ctx context.Context, cred azcore.TokenCredential) map[string]map[string]string {
+	subscriptionID := os.Getenv("AZURE_SUBSCRIPTION_ID")
+	connectedClusterRg := os.Getenv("CONNECTED_CLUSTER_RESOURCE_GROUP")
+	arcDataRg := os.Getenv("ARC_DATA_RESOURCE_GROUP")
+
+	return map[string]map[string]string{
+		"connectedClusterResourceGroup": armTags(azure.GetAResourceGroup(t, connectedClusterRg, subscriptionID).Tags),
+		"arcDataResourceGroup":          armTags(azure.GetAResourceGroup(t, arcDataRg, subscriptionID).Tags),
+		"connectedCluster":              armTags(getConnectedClusterProperties(t, ctx, cred, connectedClusterRg, os.Getenv("CONNECTED_CLUSTER")).Tags),
+		"customLocation":                armTags(getCustomLocation(t, ctx, cred, arcDataRg, os.Getenv("ARC_DATA_NAMESPACE")).Tags),
+		"dataController":                armTags(getDataController(t, ctx, cred, arcDataRg, os.Getenv("ARC_DATA_CONTROLLER")).Tags),
+	}
+}
+
+// Checks a resource carries every expected tag with the expected value - other tags are allowed
+func checkAzureTags(tags map[string]string, expected map[string]string) error {
+	problems := []string{}
+	for key, value := range expected {
+		actual, found := tags[key]
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s is missing", key))
+		} else if actual != value {
+			problems = append(problems, fmt.Sprintf("%s is %q, expected %q", key, actual, value))
+		}
+	}
+	sort.Strings(problems)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("AZURE_TAGS are not applied: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// One role of the Bootstrapper extension's managed identity, on the scope it is assigned on
+type roleAssignment struct {
+	Role  string
+	Scope string
+}
+
+// Parses ARC_DATA_EXT_ROLE_ASSIGNMENTS like the installer does - comma separated role[@scope], defaulting to defaultScope
+func parseRoleAssignments(value, defaultScope string) ([]roleAssignment, error) {
+	assignments := []roleAssignment{}
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		role, scope, found := strings.Cut(entry, "@")
+		role, scope = strings.TrimSpace(role), strings.TrimSpace(scope)
+		if !found {
+			scope = defaultScope
+		}
+		if role == "" || !strings.HasPrefix(scope, "/subscriptions/") || strings.Contains(scope, "@") {
+			return nil, fmt.Errorf("%q is not a role, optionally followed by @<scope ARM ID>", entry)
+		}
+		assignments = append(assignments, roleAssignment{Role: role, Scope: scope})
+	}
+	if len(assignments) == 0 {
+		return nil, errors.New("no role assignments")
+	}
+	return assignments, nil
+}
+
+// Checks every expected role is assigned on its scope - other assignments are allowed, ARM IDs are case insensitive
+func checkRoleAssignments(assigned []roleAssignment, expected []roleAssignment) error {
+	problems := []string{}
+	for _, want := range expected {
+		found := false
+		for _, have := range assigned {
+			if strings.EqualFold(have.Role, want.Role) && strings.EqualFold(have.Scope, want.Scope) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s on %s is missing", want.Role, want.Scope))
+		}
+	}
+	sort.Strings(problems)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("extension roles are not assigned: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Reads the roles assigned to principalID directly on each of the scopes, by role name
+func getRoleAssignmentsWithARM(t *testing.T, ctx context.Context, principalID string, scopes []string) []roleAssignment {
+	authorizer, err := azure.NewAuthorizer()
+	require.NoError(t, err)
+
+	assignmentsClient := authorization.NewRoleAssignmentsClient(os.Getenv("AZURE_SUBSCRIPTION_ID"))
+	assignmentsClient.Authorizer = *authorizer
+	definitionsClient := authorization.NewRoleDefinitionsClient(os.Getenv("AZURE_SUBSCRIPTION_ID"))
+	definitionsClient.Authorizer = *authorizer
+
+	assigned := []roleAssignment{}
+	for _, scope := range scopes {
+		iterator, err := assignmentsClient.ListForScopeComplete(ctx, scope, fmt.Sprintf("principalId eq '%s'", principalID))
+		require.NoError(t, err)
+
+		for ; iterator.NotDone(); require.NoError(t, iterator.NextWithContext(ctx)) {
+			properties := iterator.Value().Properties
+			if properties == nil || properties.Scope == nil || !strings.EqualFold(*properties.Scope, scope) {
+				continue
+			}
+
+			definition, err := definitionsClient.GetByID(ctx, *properties.RoleDefinitionID)
+			require.NoError(t, err)
+			assigned = append(assigned, roleAssignment{Role: *definition.RoleName, Scope: scope})
+		}
+	}
+
+	return assigned
+}
+
+// Resource providers Arc Data Services needs registered in the subscription, in the order the installer checks them
+var arcResourceProviders = []string{"Microsoft.Kubernetes", "Microsoft.KubernetesConfiguration", "Microsoft.ExtendedLocation", "Microsoft.AzureArcData"}
+
+// Reads the registration state of each provider namespace, e.g. Registered, Registering or NotRegistered
+func getProviderRegistrationStates(ctx context.Context, client resources.ProvidersClient, namespaces []string) (map[string]string, error) {
+	states := map[string]string{}
+	for _, namespace := range namespaces {
+		provider, err := client.Get(ctx, namespace, "")
+		if err != nil {
+			return nil, fmt.Errorf("reading resource provider %s: %w", namespace, err)
+		}
+		if provider.RegistrationState == nil {
+			return nil, fmt.Errorf("resource provider %s has no registration state", namespace)
+		}
+		states[namespace] = *provider.RegistrationState
+	}
+	return states, nil
+}
+
+// Checks every Arc Data Services resource provider is registered
+func checkProvidersRegistered(states map[string]string) error {
+	problems := []string{}
+	for _, namespace := range arcResourceProviders {
+		state, found := states[namespace]
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s is missing", namespace))
+		} else if state != "Registered" {
+			problems = append(problems, fmt.Sprintf("%s is %s", namespace, state))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("resource providers are not registered: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Reads the registration state of the Arc Data Services resource providers in the test subscription
+func getResourceProvidersWithARM(t *testing.T, ctx context.Context) map[string]string {
+	authorizer, err := azure.NewAuthorizer()
+	require.NoError(t, err)
+
+	client := resources.NewProvidersClient(os.Getenv("AZURE_SUBSCRIPTION_ID"))
+	client.Authorizer = *authorizer
+
+	states, err := getProviderRegistrationStates(ctx, client, arcResourceProviders)
+	require.NoError(t, err)
+	return states
+}
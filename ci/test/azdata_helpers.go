@@ -0,0 +1,64 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/stretchr/testify/require"
+)
+
+// AZDATA password policy and the Secret a generated password is kept in - the same rules install-arc-data-services.sh checks
+
+// Name of the Secret the installer keeps a generated AZDATA_PASSWORD in - lives in STATUS_NAMESPACE
+const azdataSecret = "azure-arc-data-services-azdata"
+
+// Fails with every rule of the Data Controller's password policy that password breaks for username
+func checkAzdataPassword(username, password string) error {
+	upper, lower, digit, symbol := 0, 0, 0, 0
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		case !unicode.IsLetter(r):
+			symbol = 1
+		}
+	}
+
+	problems := []string{}
+	if length := utf8.RuneCountInString(password); length < 8 || length > 128 {
+		problems = append(problems, "be 8 to 128 characters long")
+	}
+	if upper+lower+digit+symbol < 3 {
+		problems = append(problems, "contain characters from three of: uppercase letters, lowercase letters, digits and symbols")
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		problems = append(problems, "not contain AZDATA_USERNAME")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("AZDATA_PASSWORD must %s", strings.Join(problems, "; must "))
+	}
+	return nil
+}
+
+// Reads the credentials the installer generated from the cluster - both empty if there is no Secret
+func getAzdataSecretWithK8s(t *testing.T, options *k8s.KubectlOptions) (string, string) {
+	output, err := k8s.RunKubectlAndGetOutputE(t, options, "get", "secret", azdataSecret, "-n", statusNamespace, "--ignore-not-found", "-o=jsonpath={.data}")
+	require.NoError(t, err)
+	if output == "" {
+		return "", ""
+	}
+
+	data := map[string][]byte{}
+	require.NoError(t, json.Unmarshal([]byte(output), &data))
+	return string(data["AZDATA_USERNAME"]), string(data["AZDATA_PASSWORD"])
+}
@@ -0,0 +1,176 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Cluster capacity preflight - the same requirements install-arc-data-services.sh checks before onboarding
+
+// What the cluster's Ready amd64/linux nodes must have between them - CAPACITY_MIN_* of the installer
+type capacityMinimums struct {
+	Nodes     int
+	CPU       int64 // Allocatable cores
+	MemoryGiB int64 // Allocatable memory
+}
+
+// The installer's defaults
+var defaultCapacityMinimums = capacityMinimums{Nodes: 3, CPU: 4, MemoryGiB: 16}
+
+// One line of the capacity table
+type capacityRow struct {
+	Requirement string
+	Required    string
+	Found       string
+	OK          bool
+}
+
+// Succeeds if the Data Controller can run on node - Ready, schedulable and matching the Job's amd64/linux nodeSelector
+func nodeEligible(node corev1.Node) bool {
+	if node.Labels[corev1.LabelArchStable] != "amd64" || node.Labels[corev1.LabelOSStable] != "linux" || node.Spec.Unschedulable {
+		return false
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// Succeeds if class is the cluster's default storage class
+func defaultStorageClass(class storagev1.StorageClass) bool {
+	return class.Annotations["storageclass.kubernetes.io/is-default-class"] == "true" ||
+		class.Annotations["storageclass.beta.kubernetes.io/is-default-class"] == "true"
+}
+
+// Checks the cluster against minimums and the storage classes control.json names, in the installer's table order
+func checkClusterCapacity(nodes []corev1.Node, classes []storagev1.StorageClass, storageClassNames map[string]string, minimums capacityMinimums) []capacityRow {
+	eligible := 0
+	cpu := resource.Quantity{}
+	memory := resource.Quantity{}
+	for _, node := range nodes {
+		if !nodeEligible(node) {
+			continue
+		}
+		eligible++
+		cpu.Add(*node.Status.Allocatable.Cpu())
+		memory.Add(*node.Status.Allocatable.Memory())
+	}
+	cores := float64(cpu.MilliValue()) / 1000
+	gib := float64(memory.Value()) / (1 << 30)
+
+	rows := []capacityRow{
+		{"Ready amd64/linux nodes", fmt.Sprint(minimums.Nodes), fmt.Sprint(eligible), eligible >= minimums.Nodes},
+		{"Allocatable CPU cores", fmt.Sprint(minimums.CPU), fmt.Sprint(roundCapacity(cores)), cpu.MilliValue() >= minimums.CPU*1000},
+		{"Allocatable memory GiB", fmt.Sprint(minimums.MemoryGiB), fmt.Sprint(roundCapacity(gib)), memory.Value() >= minimums.MemoryGiB<<30},
+	}
+
+	names := map[string]bool{}
+	defaults := []string{}
+	for _, class := range classes {
+		names[class.Name] = true
+		if defaultStorageClass(class) {
+			defaults = append(defaults, class.Name)
+		}
+	}
+	for _, use := range []string{"Data", "Logs"} {
+		name := storageClassNames[strings.ToLower(use)]
+		if name == "" {
+			continue
+		}
+		found := "missing"
+		if names[name] {
+			found = "found"
+		}
+		rows = append(rows, capacityRow{use + " storage class", name, found, names[name]})
+	}
+	found := "none"
+	if len(defaults) > 0 {
+		found = strings.Join(defaults, ",")
+	}
+	return append(rows, capacityRow{"Default storage class", "any", found, len(defaults) > 0})
+}
+
+// Rounds to one decimal, as the installer reports cores and GiB
+func roundCapacity(value float64) float64 {
+	return float64(int64(value*10+0.5)) / 10
+}
+
+// Fails with every requirement the cluster doesn't meet
+func checkCapacityRows(rows []capacityRow) error {
+	problems := []string{}
+	for _, row := range rows {
+		if !row.OK {
+			problems = append(problems, fmt.Sprintf("%s: need %s, found %s", row.Requirement, row.Required, row.Found))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("cluster does not meet capacity requirements: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Renders rows as the installer's capacity table, without the log prefix
+func formatCapacityTable(rows []capacityRow) string {
+	table := fmt.Sprintf("%-24s %-18s %-18s %s\n", "REQUIREMENT", "REQUIRED", "FOUND", "RESULT")
+	for _, row := range rows {
+		result := "OK"
+		if !row.OK {
+			result = "FAIL"
+		}
+		table += fmt.Sprintf("%-24s %-18s %-18s %s\n", row.Requirement, row.Required, row.Found, result)
+	}
+	return table
+}
+
+// Reads the storage classes a control.json names, by use - data and logs
+func readControlJsonStorageClasses(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	control := struct {
+		Spec struct {
+			Storage map[string]struct {
+				ClassName string `json:"className"`
+			} `json:"storage"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(content, &control); err != nil {
+		return nil, fmt.Errorf("%s is not a control.json: %w", path, err)
+	}
+
+	classes := map[string]string{}
+	for use, storage := range control.Spec.Storage {
+		classes[use] = storage.ClassName
+	}
+	return classes, nil
+}
+
+// Reads the cluster's nodes and storage classes with kubectl, as the installer does
+func getClusterCapacityWithK8s(t *testing.T, options *k8s.KubectlOptions) ([]corev1.Node, []storagev1.StorageClass) {
+	output, err := k8s.RunKubectlAndGetOutputE(t, options, "get", "nodes", "-o", "json")
+	require.NoError(t, err)
+	nodes := corev1.NodeList{}
+	require.NoError(t, json.Unmarshal([]byte(output), &nodes))
+
+	output, err = k8s.RunKubectlAndGetOutputE(t, options, "get", "storageclass", "-o", "json")
+	require.NoError(t, err)
+	classes := storagev1.StorageClassList{}
+	require.NoError(t, json.Unmarshal([]byte(output), &classes))
+
+	return nodes.Items, classes.Items
+}
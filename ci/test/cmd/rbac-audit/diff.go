@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/kangarookube/kube-arc-data-services-installer-job/rbac"
+)
+
+// Compares the requests the installer's service account made, read from a Kubernetes audit log, with a ClusterRole
+//
+// Record the log while the Job runs with cluster-admin, so nothing is denied, through every ACTION - e.g. with the
+// API server's --audit-log-path on kind, or exported from the kube-audit diagnostic logs on AKS. Fails if the installer
+// made a request the role doesn't allow.
+//
+// Examples:
+//
+//	rbac-audit diff -audit-log /tmp/kube-apiserver-audit.log
+//	rbac-audit diff -audit-log audit.log -role /tmp/clusterrole.yaml -output report.md
+func runDiff(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+
+	auditLog := fs.String("audit-log", "", "Kubernetes audit log, one JSON Event per line")
+	rolePath := fs.String("role", defaultRolePath, "ClusterRole YAML to compare with")
+	username := fs.String("username", rbac.InstallerUsername, "user whose requests are compared")
+	output := fs.String("output", "", "write the report to this file instead of stdout")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *auditLog == "" {
+		return fmt.Errorf("-audit-log is required")
+	}
+
+	content, err := ioutil.ReadFile(*rolePath)
+	if err != nil {
+		return err
+	}
+	role, err := rbac.UnmarshalClusterRole(content)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *rolePath, err)
+	}
+
+	file, err := os.Open(*auditLog)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	requests, err := rbac.ReadAuditLog(file, *username)
+	if err != nil {
+		return err
+	}
+	if len(requests) == 0 {
+		return fmt.Errorf("%s has no requests from %s", *auditLog, *username)
+	}
+
+	report := rbac.Compare(role.Rules, requests)
+	if *output != "" {
+		err = ioutil.WriteFile(*output, []byte(report.Format(role.Name)), 0644)
+	} else {
+		_, err = fmt.Fprint(stdout, report.Format(role.Name))
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(report.Missing) > 0 {
+		return fmt.Errorf("ClusterRole %s doesn't allow %d of the installer's requests", role.Name, len(report.Missing))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/kangarookube/kube-arc-data-services-installer-job/rbac"
+)
+
+// Writes the ClusterRole of the least-privilege component from rbac.InstallerRules
+//
+// Examples:
+//
+//	rbac-audit generate                  # rewrites the component's clusterrole.yaml
+//	rbac-audit generate -output -        # prints it instead
+func runGenerate(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+
+	output := fs.String("output", defaultRolePath, "file to write the ClusterRole to, - for stdout")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	content, err := rbac.MarshalClusterRole(rbac.InstallerClusterRole())
+	if err != nil {
+		return err
+	}
+
+	if *output == "-" {
+		_, err = stdout.Write(content)
+		return err
+	}
+	if err := ioutil.WriteFile(*output, content, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "INFO | Wrote ClusterRole %s to %s\n", rbac.InstallerName, *output)
+	return nil
+}
@@ -0,0 +1,54 @@
+// Tooling for the least-privilege kustomize component's ClusterRole
+//
+// Usage:
+//
+//	go run ./cmd/rbac-audit generate
+//	go run ./cmd/rbac-audit diff -audit-log /tmp/kube-apiserver-audit.log
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Relative path from ci/test to the component's generated ClusterRole
+const defaultRolePath = "../../kustomize/components/least-privilege/clusterrole.yaml"
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR | %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// Dispatches to the requested subcommand
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		printUsage(stdout)
+		return fmt.Errorf("no subcommand given")
+	}
+
+	switch args[0] {
+	case "generate":
+		return runGenerate(args[1:], stdout)
+	case "diff":
+		return runDiff(args[1:], stdout)
+	case "help", "-h", "--help":
+		printUsage(stdout)
+		return nil
+	default:
+		printUsage(stdout)
+		return fmt.Errorf("unknown subcommand '%s'", args[0])
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: rbac-audit <subcommand> [flags]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Subcommands:")
+	fmt.Fprintln(w, "   generate  - Write the least-privilege ClusterRole from the installer's rules")
+	fmt.Fprintln(w, "   diff      - Compare the API calls the installer made, from an audit log, with the ClusterRole")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Run 'rbac-audit <subcommand> -h' for flags.")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+
+	"github.com/kangarookube/kube-arc-data-services-installer-job/release"
+)
+
+// Prints a Markdown changelog between two release env files, or between two git revisions of one
+//
+// Examples:
+//
+//	release-env changelog -old /tmp/release.preview.env -new ../../release/release.preview.env
+//	release-env changelog -train preview -from HEAD~1           # HEAD~1 vs working tree
+//	release-env changelog -train stable -from v0.1.0 -to v0.2.0
+func runChangelog(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("changelog", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+
+	oldPath := fs.String("old", "", "old release env file")
+	newPath := fs.String("new", "", "new release env file")
+	train := fs.String("train", "", "release train whose env file is compared across git revisions")
+	fromRev := fs.String("from", "", "git revision of the old env file")
+	toRev := fs.String("to", "", "git revision of the new env file, defaults to the working tree")
+	releaseFolder := fs.String("release-folder", defaultReleaseFolder, "folder containing release.<train>.env files")
+	output := fs.String("output", "", "write the changelog to this file instead of stdout")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var oldEnv, newEnv release.Env
+	var title string
+	var err error
+
+	switch {
+	case *oldPath != "" && *newPath != "":
+		if oldEnv, err = release.ReadEnv(*oldPath); err != nil {
+			return err
+		}
+		if newEnv, err = release.ReadEnv(*newPath); err != nil {
+			return err
+		}
+		title = fmt.Sprintf("%s -> %s", *oldPath, *newPath)
+
+	case *train != "" && *fromRev != "":
+		envFilePath := release.EnvFilePath(*releaseFolder, *train)
+		if oldEnv, err = readEnvAtRevision(*fromRev, envFilePath); err != nil {
+			return err
+		}
+		toLabel := "working tree"
+		if *toRev != "" {
+			toLabel = *toRev
+			newEnv, err = readEnvAtRevision(*toRev, envFilePath)
+		} else {
+			newEnv, err = release.ReadEnv(envFilePath)
+		}
+		if err != nil {
+			return err
+		}
+		title = fmt.Sprintf("%s: %s -> %s", release.EnvFileName(*train), *fromRev, toLabel)
+
+	default:
+		return fmt.Errorf("pass either -old and -new, or -train and -from")
+	}
+
+	changelog := release.Changelog(title, oldEnv, newEnv)
+
+	if *output != "" {
+		return ioutil.WriteFile(*output, []byte(changelog), 0644)
+	}
+	fmt.Fprint(stdout, changelog)
+	return nil
+}
+
+// Reads a release env file as it was at a git revision - path is relative to the current directory
+func readEnvAtRevision(rev, envFilePath string) (release.Env, error) {
+	content, err := exec.Command("git", "show", fmt.Sprintf("%s:./%s", rev, envFilePath)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s at revision '%s': %w", envFilePath, rev, err)
+	}
+	return release.ParseEnv(content)
+}
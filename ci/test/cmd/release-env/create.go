@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/kangarookube/kube-arc-data-services-installer-job/release"
+)
+
+// Writes release.<train>.env without prompting, so CI and scripted release bumps can run without a TTY
+//
+// Arc Data coordinates are resolved in order of precedence: flags > JSON input > environment variables > existing env file.
+// Base artifact versions come from -base (e.g. the output of get-latest-versions.sh) or, if not given, the existing env file.
+// Values taken from the environment are logged, and -dry-run previews the diff without downloading the wheel to pin it.
+func runCreate(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+
+	train := fs.String("train", "", "ARC_DATA_RELEASE_TRAIN - test, preview or stable")
+	extVersion := fs.String("ext-version", "", "ARC_DATA_EXT_VERSION - e.g. 1.2.20381002")
+	controllerVersion := fs.String("controller-version", "", "ARC_DATA_CONTROLLER_VERSION - e.g. v1.10.0_2022-08-09")
+	whlUrl := fs.String("whl-url", "", "ARC_DATA_WHL_URL - direct https link to the az arcdata wheel file")
+	whlSha256 := fs.String("whl-sha256", "", "ARC_DATA_WHL_SHA256 - computed by downloading the wheel if not given")
+	input := fs.String("input", "", "JSON file with ARC_DATA_* keys, '-' reads from stdin")
+	base := fs.String("base", "", "env file with base artifact versions, defaults to the existing release env file")
+	releaseFolder := fs.String("release-folder", defaultReleaseFolder, "folder containing release.<train>.env files")
+	output := fs.String("output", "", "env file to write, defaults to <release-folder>/release.<train>.env")
+	dryRun := fs.Bool("dry-run", false, "print the diff preview without writing the file")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// 1. Arc Data artifacts supplied for this release, and where each came from
+	arcData := release.Env{}
+	sources := map[string]string{}
+	for _, key := range release.ArcDataArtifactKeys {
+		if value := os.Getenv(key); value != "" {
+			arcData[key] = value
+			sources[key] = "environment"
+		}
+	}
+
+	if *input != "" {
+		inputValues, err := readJsonInput(*input, stdin)
+		if err != nil {
+			return err
+		}
+		for _, key := range release.ArcDataArtifactKeys {
+			if value := inputValues[key]; value != "" {
+				arcData[key] = value
+				sources[key] = "input"
+			}
+		}
+	}
+
+	flagValues := map[string]string{
+		"ARC_DATA_RELEASE_TRAIN":      *train,
+		"ARC_DATA_EXT_VERSION":        *extVersion,
+		"ARC_DATA_CONTROLLER_VERSION": *controllerVersion,
+		"ARC_DATA_WHL_URL":            *whlUrl,
+		"ARC_DATA_WHL_SHA256":         *whlSha256,
+	}
+	for key, value := range flagValues {
+		if value != "" {
+			arcData[key] = value
+			sources[key] = "flag"
+		}
+	}
+
+	// Ambient variables are easy to forget, so say which ones made it into the file
+	for _, key := range release.ArcDataArtifactKeys {
+		if sources[key] == "environment" {
+			fmt.Fprintf(stdout, "INFO | %s=%s from the environment\n", key, arcData[key])
+		}
+	}
+
+	releaseTrain := arcData["ARC_DATA_RELEASE_TRAIN"]
+	if releaseTrain == "" {
+		return fmt.Errorf("ARC_DATA_RELEASE_TRAIN is required - pass -train, set it in -input or the environment")
+	}
+
+	outputPath := *output
+	if outputPath == "" {
+		outputPath = release.EnvFilePath(*releaseFolder, releaseTrain)
+	}
+
+	// 2. Existing env file - fills in anything not supplied above, and is the baseline for the diff preview
+	existing := release.Env{}
+	if _, err := os.Stat(outputPath); err == nil {
+		existing, err = release.ReadEnv(outputPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 3. Base artifacts
+	baseValues := existing
+	if *base != "" {
+		var err error
+		baseValues, err = release.ReadEnv(*base)
+		if err != nil {
+			return err
+		}
+	} else if len(existing) == 0 {
+		return fmt.Errorf("no base artifact versions found - pass -base or create from an existing %s", outputPath)
+	}
+
+	newEnv := release.Env{}
+	for _, key := range release.BaseArtifactKeys {
+		newEnv[key] = baseValues[key]
+	}
+	for _, key := range release.ArcDataArtifactKeys {
+		if value, ok := arcData[key]; ok {
+			newEnv[key] = value
+		} else if value, ok := existing[key]; ok {
+			newEnv[key] = value
+		}
+	}
+
+	if err := newEnv.Validate(); err != nil {
+		return err
+	}
+
+	// 4. Wheel pin - a new wheel URL is always re-pinned, an unchanged one keeps its pin so a republished artifact is caught at build time.
+	// A dry run doesn't download the wheel, the preview leaves the pin out instead.
+	if arcData["ARC_DATA_WHL_SHA256"] == "" && (newEnv["ARC_DATA_WHL_URL"] != existing["ARC_DATA_WHL_URL"] || existing["ARC_DATA_WHL_SHA256"] == "") {
+		if *dryRun {
+			fmt.Fprintf(stdout, "INFO | Dry run, would pin the sha256 of %s\n", newEnv["ARC_DATA_WHL_URL"])
+			delete(newEnv, "ARC_DATA_WHL_SHA256")
+		} else if err := pinWheel(newEnv, stdout); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(stdout, "INFO | Changes to %s:\n", outputPath)
+	fmt.Fprint(stdout, release.FormatDiff(release.Diff(existing, newEnv)))
+
+	if *dryRun {
+		fmt.Fprintln(stdout, "INFO | Dry run, not writing file")
+		return nil
+	}
+
+	if err := newEnv.Write(outputPath); err != nil {
+		return fmt.Errorf("writing %s: %w", outputPath, err)
+	}
+	fmt.Fprintf(stdout, "INFO | Wrote %s\n", outputPath)
+
+	return nil
+}
+
+// Downloads the wheel and records its digest in ARC_DATA_WHL_SHA256
+func pinWheel(env release.Env, stdout io.Writer) error {
+	fmt.Fprintf(stdout, "INFO | Computing sha256 of %s\n", env["ARC_DATA_WHL_URL"])
+	checksum, err := release.WheelSha256(env["ARC_DATA_WHL_URL"])
+	if err != nil {
+		return err
+	}
+	env["ARC_DATA_WHL_SHA256"] = checksum
+	return nil
+}
+
+// Reads a flat JSON object of string values from a file, or stdin if path is '-'
+func readJsonInput(path string, stdin io.Reader) (map[string]string, error) {
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = ioutil.ReadAll(stdin)
+	} else {
+		content, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading JSON input '%s': %w", path, err)
+	}
+
+	values := map[string]string{}
+	if err := json.Unmarshal(content, &values); err != nil {
+		return nil, fmt.Errorf("parsing JSON input '%s': %w", path, err)
+	}
+	return values, nil
+}
@@ -0,0 +1,178 @@
+//go:build unit
+
+package main
+
+import (
+	// Native
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kangarookube/kube-arc-data-services-installer-job/release"
+)
+
+const (
+	existingWhlUrl    = "https://azurearcdatacli.blob.core.windows.net/cli-extensions/arcdata-1.4.5-py2.py3-none-any.whl"
+	existingWhlSha256 = "5f1c1cb2b3e5c1d1e0a08b16a3ad4e5ed1b1e7ff0ee3c8e2a7f5e1e4b0f3c9d2"
+	newWhlUrl         = "https://azurearcdatacli.blob.core.windows.net/cli-extensions/arcdata-1.4.6-py2.py3-none-any.whl"
+	newWhlSha256      = "0b6b1e7a3c0e0e0f9f2f1d8c7b6a5f4e3d2c1b0a99887766554433221100ffee"
+)
+
+// The release.preview.env a create run starts from - its wheel is already pinned, so nothing is downloaded
+func existingEnv() release.Env {
+	return release.Env{
+		"HELM_VERSION":                   "3.9.2-1",
+		"KUBECTL_VERSION":                "1.24.3-00",
+		"AZCLI_VERSION":                  "2.39.0-1~jammy",
+		"EXT_K8S_CONFIGURATION_VERSION":  "1.6.0",
+		"EXT_K8S_EXTENSION_VERSION":      "1.2.6",
+		"EXT_K8S_CONNECTEDK8S_VERSION":   "1.2.11",
+		"EXT_K8S_CUSTOMLOCATION_VERSION": "0.1.3",
+		"ARC_DATA_RELEASE_TRAIN":         "preview",
+		"ARC_DATA_EXT_VERSION":           "1.2.20381002",
+		"ARC_DATA_CONTROLLER_VERSION":    "v1.10.0_2022-08-09",
+		"ARC_DATA_WHL_URL":               existingWhlUrl,
+		"ARC_DATA_WHL_SHA256":            existingWhlSha256,
+	}
+}
+
+func TestRunCreate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		env      map[string]string // ARC_DATA_* variables set while running
+		stdin    string            // JSON read with -input -
+		args     []string          // -release-folder and, if base is set, -base are added
+		noFile   bool              // Start without release.preview.env
+		base     release.Env       // Written to a file passed as -base
+		expected release.Env       // Values written to release.preview.env, on top of existingEnv
+		output   []string
+		missing  []string // Not in the output
+		err      string
+	}{
+		{
+			name:     "existing_file_only",
+			args:     []string{"-train", "preview"},
+			expected: release.Env{},
+			output:   []string{"No changes"},
+			missing:  []string{"from the environment"},
+		},
+		{
+			name:     "environment_over_existing_file",
+			env:      map[string]string{"ARC_DATA_EXT_VERSION": "1.2.3"},
+			args:     []string{"-train", "preview"},
+			expected: release.Env{"ARC_DATA_EXT_VERSION": "1.2.3"},
+			output:   []string{"INFO | ARC_DATA_EXT_VERSION=1.2.3 from the environment", "+ ARC_DATA_EXT_VERSION=1.2.3"},
+		},
+		{
+			name:     "environment_sets_the_train",
+			env:      map[string]string{"ARC_DATA_RELEASE_TRAIN": "preview"},
+			expected: release.Env{},
+			output:   []string{"INFO | ARC_DATA_RELEASE_TRAIN=preview from the environment"},
+		},
+		{
+			name:     "json_over_environment",
+			env:      map[string]string{"ARC_DATA_EXT_VERSION": "1.2.3", "ARC_DATA_CONTROLLER_VERSION": "v1.11.0_2022-09-13"},
+			stdin:    `{"ARC_DATA_EXT_VERSION": "1.2.4"}`,
+			args:     []string{"-train", "preview", "-input", "-"},
+			expected: release.Env{"ARC_DATA_EXT_VERSION": "1.2.4", "ARC_DATA_CONTROLLER_VERSION": "v1.11.0_2022-09-13"},
+			output:   []string{"INFO | ARC_DATA_CONTROLLER_VERSION=v1.11.0_2022-09-13 from the environment"},
+			missing:  []string{"ARC_DATA_EXT_VERSION=1.2.3 from the environment"},
+		},
+		{
+			name:     "flags_over_json",
+			env:      map[string]string{"ARC_DATA_EXT_VERSION": "1.2.3"},
+			stdin:    `{"ARC_DATA_EXT_VERSION": "1.2.4"}`,
+			args:     []string{"-train", "preview", "-input", "-", "-ext-version", "1.2.5"},
+			expected: release.Env{"ARC_DATA_EXT_VERSION": "1.2.5"},
+			missing:  []string{"from the environment"},
+		},
+		{
+			name:     "base_over_existing_file",
+			args:     []string{"-train", "preview"},
+			base:     func() release.Env { env := existingEnv(); env["HELM_VERSION"] = "3.10.0-1"; return env }(),
+			expected: release.Env{"HELM_VERSION": "3.10.0-1"},
+			output:   []string{"- HELM_VERSION=3.9.2-1", "+ HELM_VERSION=3.10.0-1"},
+		},
+		{
+			name:   "base_required_without_existing_file",
+			args:   []string{"-train", "preview", "-ext-version", "1.2.5"},
+			noFile: true,
+			err:    "no base artifact versions found",
+		},
+		{
+			name:   "train_required",
+			noFile: true,
+			err:    "ARC_DATA_RELEASE_TRAIN is required",
+		},
+		{
+			name:     "new_wheel_with_its_digest",
+			args:     []string{"-train", "preview", "-whl-url", newWhlUrl, "-whl-sha256", newWhlSha256},
+			expected: release.Env{"ARC_DATA_WHL_URL": newWhlUrl, "ARC_DATA_WHL_SHA256": newWhlSha256},
+			missing:  []string{"Computing sha256"},
+		},
+		{
+			name:     "dry_run_writes_nothing",
+			args:     []string{"-train", "preview", "-ext-version", "1.2.5", "-dry-run"},
+			expected: release.Env{},
+			output:   []string{"+ ARC_DATA_EXT_VERSION=1.2.5", "INFO | Dry run, not writing file"},
+		},
+		{
+			// The wheel is not downloaded - without network access pinning would fail the run
+			name:     "dry_run_does_not_pin",
+			args:     []string{"-train", "preview", "-whl-url", newWhlUrl, "-dry-run"},
+			expected: release.Env{},
+			output:   []string{"INFO | Dry run, would pin the sha256 of " + newWhlUrl, "- ARC_DATA_WHL_SHA256=" + existingWhlSha256},
+			missing:  []string{"Computing sha256"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, key := range release.ArcDataArtifactKeys {
+				t.Setenv(key, tc.env[key])
+			}
+
+			folder := t.TempDir()
+			envFilePath := release.EnvFilePath(folder, "preview")
+			if !tc.noFile {
+				require.NoError(t, existingEnv().Write(envFilePath))
+			}
+
+			args := append([]string{"-release-folder", folder}, tc.args...)
+			if tc.base != nil {
+				basePath := filepath.Join(folder, "release.env.tmp")
+				require.NoError(t, tc.base.Write(basePath))
+				args = append(args, "-base", basePath)
+			}
+
+			var stdout bytes.Buffer
+			err := runCreate(args, strings.NewReader(tc.stdin), &stdout)
+
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err, stdout.String())
+			for _, line := range tc.output {
+				assert.Contains(t, stdout.String(), line)
+			}
+			for _, line := range tc.missing {
+				assert.NotContains(t, stdout.String(), line)
+			}
+
+			expected := existingEnv()
+			for key, value := range tc.expected {
+				expected[key] = value
+			}
+			written, err := release.ReadEnv(envFilePath)
+			require.NoError(t, err)
+			assert.Equal(t, expected, written)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+// Non-interactive tooling for authoring the release.<train>.env files under /release
+//
+// Usage:
+//
+//	go run ./cmd/release-env create -train preview -ext-version 1.2.20381002 \
+//	    -controller-version v1.10.0_2022-08-09 \
+//	    -whl-url https://azurearcdatacli.blob.core.windows.net/cli-extensions/arcdata-1.4.5-py2.py3-none-any.whl
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Relative path from ci/test to the release folder - same as the test harness
+const defaultReleaseFolder = "../../release"
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR | %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// Dispatches to the requested subcommand
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		printUsage(stdout)
+		return fmt.Errorf("no subcommand given")
+	}
+
+	switch args[0] {
+	case "create":
+		return runCreate(args[1:], stdin, stdout)
+	case "changelog":
+		return runChangelog(args[1:], stdout)
+	case "pin-wheel":
+		return runPinWheel(args[1:], stdout)
+	case "help", "-h", "--help":
+		printUsage(stdout)
+		return nil
+	default:
+		printUsage(stdout)
+		return fmt.Errorf("unknown subcommand '%s'", args[0])
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: release-env <subcommand> [flags]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Subcommands:")
+	fmt.Fprintln(w, "   create     - Validate and write release.<train>.env from flags, JSON input or environment variables")
+	fmt.Fprintln(w, "   changelog  - Markdown changelog between two release env files or two git revisions of one")
+	fmt.Fprintln(w, "   pin-wheel  - Download the az arcdata wheel and record ARC_DATA_WHL_SHA256")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Run 'release-env <subcommand> -h' for flags.")
+}
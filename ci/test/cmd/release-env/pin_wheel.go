@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/kangarookube/kube-arc-data-services-installer-job/release"
+)
+
+// Downloads the wheel for each release train and records or refreshes ARC_DATA_WHL_SHA256 in its env file
+//
+// Use this to pin env files written before wheel pinning, or to deliberately accept a republished wheel after review.
+func runPinWheel(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("pin-wheel", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+
+	train := fs.String("train", "", "comma separated release trains to pin, empty for every release.<train>.env file")
+	releaseFolder := fs.String("release-folder", defaultReleaseFolder, "folder containing release.<train>.env files")
+	dryRun := fs.Bool("dry-run", false, "print the diff preview without writing the files")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	trains, err := release.ResolveTrains(*train, *releaseFolder)
+	if err != nil {
+		return err
+	}
+
+	for _, releaseTrain := range trains {
+		envFilePath := release.EnvFilePath(*releaseFolder, releaseTrain)
+		existing, err := release.ReadEnv(envFilePath)
+		if err != nil {
+			return err
+		}
+
+		pinned := release.Env{}
+		for key, value := range existing {
+			pinned[key] = value
+		}
+		if err := pinWheel(pinned, stdout); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "INFO | Changes to %s:\n", envFilePath)
+		fmt.Fprint(stdout, release.FormatDiff(release.Diff(existing, pinned)))
+
+		if *dryRun {
+			continue
+		}
+		if err := pinned.Write(envFilePath); err != nil {
+			return fmt.Errorf("writing %s: %w", envFilePath, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,368 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"fmt"
+	"strings"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ACTION modes of install-arc-data-services.sh - onboard, offboard, upgrade, status and repair - see script_helpers.go
+
+// Matches the provisioning state check of the Bootstrapper extension, not the --query id/identity lookups
+const extensionStatePattern = "az k8s-extension show *--name arc-data-bootstrapper"
+
+func TestInstallerActionValidation(t *testing.T) {
+	testCases := []struct {
+		name       string
+		deleteFlag string
+		action     string
+		expected   string // ACTION reported by validation, empty if validation fails
+		output     string
+	}{
+		{"defaults_to_onboard", "false", "", "onboard", "INFO | ACTION is not set, defaulting to onboard from DELETE_FLAG=false"},
+		{"delete_flag_means_offboard", "true", "", "offboard", "INFO | ACTION is not set, defaulting to offboard from DELETE_FLAG=true"},
+		{"delete_flag_agrees", "true", "offboard", "offboard", "INFO | Starting Arc + Data Services offboard process"},
+		{"upgrade", "false", "upgrade", "upgrade", "INFO | Starting Arc + Data Services upgrade process"},
+		{"status", "false", "status", "status", "INFO | Starting Arc + Data Services status process"},
+		{"repair", "false", "repair", "repair", "INFO | Starting Arc + Data Services repair process"},
+		{"unknown", "false", "destroy", "", "ERROR | variable ACTION must be one of onboard, offboard, upgrade, status or repair, got 'destroy'"},
+		{"delete_flag_conflicts", "true", "onboard", "", "ERROR | DELETE_FLAG=true conflicts with ACTION=onboard, unset DELETE_FLAG or set ACTION=offboard"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			env := defaultInstallerEnv()
+			env["DELETE_FLAG"] = tc.deleteFlag
+			env["ACTION"] = tc.action
+
+			run, values := runInstallerValidation(t, env)
+
+			assert.Contains(t, run.Output, tc.output)
+			assertNoRemoteCalls(t, run)
+			if tc.expected == "" {
+				assert.Equal(t, 1, run.ExitCode)
+				require.NotNil(t, run.Result)
+				assert.Equal(t, "validation", run.Result.FailedStep)
+				return
+			}
+			require.Equal(t, 0, run.ExitCode, run.Output)
+			assert.Equal(t, tc.expected, values["ACTION"])
+		})
+	}
+}
+
+// DELETE_FLAG=true and ACTION=offboard run the exact same commands
+func TestInstallerActionOffboardMatchesDeleteFlag(t *testing.T) {
+	deleteFlag := defaultInstallerEnv()
+	deleteFlag["DELETE_FLAG"] = "true"
+	action := defaultInstallerEnv()
+	action["ACTION"] = "offboard"
+
+	legacy := runInstallerScript(t, installerScenario(deleteFlag, allExist))
+	run := runInstallerScript(t, installerScenario(action, allExist))
+
+	require.Equal(t, 0, legacy.ExitCode, legacy.Output)
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, legacy.commandNames(), run.commandNames())
+	assert.Equal(t, legacy.commandLinesWithPrefix("az ", "kubectl delete"), run.commandLinesWithPrefix("az ", "kubectl delete"))
+	assert.Equal(t, "offboard", run.Result.Mode)
+}
+
+// Status only reads - safe to run at any time, against any state
+func TestInstallerActionStatus(t *testing.T) {
+	for _, state := range reachableArcResourceStates() {
+		state := state
+		t.Run(state.String(), func(t *testing.T) {
+			env := defaultInstallerEnv()
+			env["ACTION"] = "status"
+
+			run := runInstallerScript(t, installerScenario(env, state))
+
+			require.Equal(t, 0, run.ExitCode, run.Output)
+			assert.Empty(t, run.mutatingCommandNames())
+			assert.Contains(t, run.Output, "INFO | Status complete, nothing was changed.")
+			assert.Len(t, run.commandLinesWithPrefix("kubectl get configmap "+installationStatusConfigMap), 1, "recorded status is read")
+
+			require.NotNil(t, run.Result)
+			assert.Equal(t, "status", run.Result.Mode)
+			expected := map[string]string{
+				"connectedClusterResourceGroup": map[bool]string{true: "Exists", false: "Absent"}[state.ConnectedClusterResourceGroup],
+				"arcDataResourceGroup":          map[bool]string{true: "Exists", false: "Absent"}[state.ArcDataResourceGroup],
+				"connectedCluster":              map[bool]string{true: "Succeeded", false: "Absent"}[state.ConnectedCluster],
+				"extension":                     map[bool]string{true: "Succeeded", false: "Absent"}[state.Extension],
+				"customLocation":                map[bool]string{true: "Succeeded", false: "Absent"}[state.CustomLocation],
+				"dataController":                map[bool]string{true: "Ready", false: "Absent"}[state.DataController],
+			}
+			for key, status := range expected {
+				assert.Equal(t, status, run.Result.Resources[key].Status, key)
+			}
+		})
+	}
+
+	// Unhealthy resources are reported, not fixed
+	env := defaultInstallerEnv()
+	env["ACTION"] = "status"
+	run := runInstallerScript(t, installerScenario(env, allExist,
+		stubAnswer(extensionStatePattern, `{"provisioningState": "Failed"}`),
+	))
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Empty(t, run.mutatingCommandNames())
+	assert.Equal(t, "Failed", run.Result.Resources["extension"].Status)
+	assert.Contains(t, run.Output, "INFO |    3. ARC_DATA_EXT_STATE? Failed")
+}
+
+// Installed before the upgrade - the release env's versions are the targets
+const (
+	installedExtensionVersion  = "1.2.20031002"
+	installedControllerVersion = "v1.9.0_2022-07-12"
+)
+
+// Stub answers for an installation running older versions, which report the release env's versions once upgraded
+func outdatedInstallationRules(env map[string]string) []stubRule {
+	return []stubRule{
+		{Pattern: extensionStatePattern, Responses: []stubResponse{
+			{Stdout: extensionShowJson(env, "Succeeded", installedExtensionVersion)},
+			{Stdout: extensionShowJson(env, "Succeeded", env["ARC_DATA_EXT_VERSION"])},
+		}},
+		{Pattern: "az arcdata dc status show *", Responses: []stubResponse{
+			{Stdout: dataControllerStatusJson(env, "Ready", installedControllerVersion)},
+			{Stdout: dataControllerStatusJson(env, "Ready", env["ARC_DATA_CONTROLLER_VERSION"])},
+		}},
+	}
+}
+
+func TestInstallerActionUpgrade(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ACTION"] = "upgrade"
+
+	t.Run("outdated", func(t *testing.T) {
+		scenario := installerScenario(env, allExist, outdatedInstallationRules(env)...)
+
+		run := runInstallerScript(t, scenario)
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Equal(t, []string{
+			"az k8s-extension update",
+			"az arcdata dc upgrade",
+			"kubectl patch configmap " + installationStatusConfigMap,
+		}, run.mutatingCommandNames())
+		assert.Contains(t, run.Output, fmt.Sprintf("INFO | Upgrading Bootstrapper extension arc-data-bootstrapper from %s to %s", installedExtensionVersion, env["ARC_DATA_EXT_VERSION"]))
+		assert.Contains(t, run.Output, fmt.Sprintf("INFO | Upgrading Data Controller azure-arc-data-controller from %s to %s", installedControllerVersion, env["ARC_DATA_CONTROLLER_VERSION"]))
+
+		extensionUpdate := run.commandLinesWithPrefix("az k8s-extension update")
+		require.Len(t, extensionUpdate, 1)
+		assert.Contains(t, extensionUpdate[0], fmt.Sprintf("--version %s", env["ARC_DATA_EXT_VERSION"]))
+		assert.Contains(t, extensionUpdate[0], "--auto-upgrade false")
+		assert.Contains(t, run.commandLinesWithPrefix("az arcdata dc upgrade")[0], fmt.Sprintf("--desired-version %s", env["ARC_DATA_CONTROLLER_VERSION"]))
+
+		require.NotNil(t, run.Result)
+		assert.Equal(t, "upgrade", run.Result.Mode)
+		assert.Equal(t, "Upgraded", run.Result.Resources["extension"].Status)
+		assert.Equal(t, "Upgraded", run.Result.Resources["dataController"].Status)
+		assert.NotContains(t, strings.Join(run.Result.Warnings, "\n"), "did not reach Ready state")
+
+		status, err := run.installationStatus(installationStatus{"lastOnboardTime": "2022-09-01T10:00:00Z"})
+		require.NoError(t, err)
+		require.NoError(t, status.validate("Onboarded"))
+		assert.NotEmpty(t, status["lastUpgradeTime"])
+		assert.Equal(t, "2022-09-01T10:00:00Z", status["lastOnboardTime"], "upgrade keeps the onboarding time")
+		assert.Equal(t, env["ARC_DATA_CONTROLLER_VERSION"], status["controllerVersion"])
+
+		assertPlanMatchesExecution(t, scenario, "upgrade")
+	})
+
+	// Nothing runs an older version, so nothing is upgraded
+	t.Run("current", func(t *testing.T) {
+		scenario := installerScenario(env, allExist)
+
+		run := runInstallerScript(t, scenario)
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Equal(t, []string{"kubectl patch configmap " + installationStatusConfigMap}, run.mutatingCommandNames())
+		assert.Equal(t, "Current", run.Result.Resources["extension"].Status)
+		assert.Equal(t, "Current", run.Result.Resources["dataController"].Status)
+
+		assertPlanMatchesExecution(t, scenario, "upgrade")
+	})
+
+	// Only the Data Controller is behind
+	t.Run("data_controller_only", func(t *testing.T) {
+		scenario := installerScenario(env, allExist, outdatedInstallationRules(env)[1])
+
+		run := runInstallerScript(t, scenario)
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Equal(t, []string{"az arcdata dc upgrade", "kubectl patch configmap " + installationStatusConfigMap}, run.mutatingCommandNames())
+		assert.Equal(t, "Current", run.Result.Resources["extension"].Status)
+
+		assertPlanMatchesExecution(t, scenario, "upgrade")
+	})
+
+	// The Data Controller waits for the extension, then for its own new image tag to be Ready
+	t.Run("waits_for_readiness", func(t *testing.T) {
+		run := runInstallerScript(t, installerScenario(env, allExist,
+			stubRule{Pattern: extensionStatePattern, Responses: []stubResponse{
+				{Stdout: extensionShowJson(env, "Succeeded", installedExtensionVersion)},
+				{Stdout: extensionShowJson(env, "Updating", installedExtensionVersion)},
+				{Stdout: extensionShowJson(env, "Succeeded", env["ARC_DATA_EXT_VERSION"])},
+			}},
+			stubRule{Pattern: "az arcdata dc status show *", Responses: []stubResponse{
+				{Stdout: dataControllerStatusJson(env, "Ready", installedControllerVersion)},
+				{Stdout: dataControllerStatusJson(env, "Ready", installedControllerVersion)},
+				{Stdout: dataControllerStatusJson(env, "Upgrading", env["ARC_DATA_CONTROLLER_VERSION"])},
+				{Stdout: dataControllerStatusJson(env, "Ready", env["ARC_DATA_CONTROLLER_VERSION"])},
+			}},
+		))
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Len(t, run.commandLinesWithPrefix("sleep"), 3)
+		assert.Equal(t, "Upgraded", run.Result.Resources["dataController"].Status)
+
+		// Ordering - the Data Controller upgrade starts after the extension reports Succeeded
+		lines := run.commandNames()
+		extensionDone, controllerUpgrade := -1, -1
+		for i, name := range lines {
+			if name == "az k8s-extension show" {
+				extensionDone = i
+			}
+			if name == "az arcdata dc upgrade" {
+				controllerUpgrade = i
+			}
+		}
+		assert.Less(t, extensionDone, controllerUpgrade)
+	})
+
+	t.Run("extension_fails", func(t *testing.T) {
+		run := runInstallerScript(t, installerScenario(env, allExist,
+			stubRule{Pattern: extensionStatePattern, Responses: []stubResponse{
+				{Stdout: extensionShowJson(env, "Succeeded", installedExtensionVersion)},
+				{Stdout: extensionShowJson(env, "Failed", installedExtensionVersion)},
+			}},
+		))
+
+		assert.Equal(t, 1, run.ExitCode)
+		assert.Contains(t, run.Output, "ERROR | Bootstrapper extension arc-data-bootstrapper upgrade status is Failed, manual intervention is required")
+		assert.Empty(t, run.commandLinesWithPrefix("az arcdata dc upgrade", "kubectl patch"))
+		require.NotNil(t, run.Result)
+		assert.Equal(t, "extension", run.Result.FailedStep)
+	})
+
+	// Still on the old image tag when the wait runs out - the upgrade fails and the old versions stay recorded
+	t.Run("data_controller_not_ready", func(t *testing.T) {
+		run := runInstallerScript(t, installerScenario(env, allExist,
+			stubAnswer("az arcdata dc status show *", dataControllerStatusJson(env, "Ready", installedControllerVersion)),
+		))
+
+		assert.Equal(t, 1, run.ExitCode)
+		assert.Len(t, run.commandLinesWithPrefix("sleep"), 20)
+		assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Data Controller azure-arc-data-controller did not reach Ready state on %s in time, last status is Ready on %s, manual intervention is required", env["ARC_DATA_CONTROLLER_VERSION"], installedControllerVersion))
+		assert.Empty(t, run.commandLinesWithPrefix("kubectl patch"), "the target versions are not recorded as installed")
+
+		require.NotNil(t, run.Result)
+		assert.Equal(t, "Failed", run.Result.Status)
+		assert.Equal(t, "dataController", run.Result.FailedStep)
+		assert.Equal(t, "Ready", run.Result.Resources["dataController"].Status)
+	})
+
+	// Nothing to upgrade before onboarding
+	for _, state := range []arcResourceState{nothingExists, {ConnectedClusterResourceGroup: true, ArcDataResourceGroup: true, ConnectedCluster: true, Extension: true}} {
+		run := runInstallerScript(t, installerScenario(env, state))
+		assert.Equal(t, 1, run.ExitCode, state.String())
+		assert.Contains(t, run.Output, "ERROR | ACTION=upgrade needs an onboarded Connected Cluster, Bootstrapper extension and Data Controller, run ACTION=onboard first")
+		assert.Empty(t, run.mutatingCommandNames(), state.String())
+	}
+}
+
+func TestInstallerActionRepair(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ACTION"] = "repair"
+
+	// Healthy installations are left as onboarding would leave them
+	t.Run("healthy", func(t *testing.T) {
+		repair := runInstallerScript(t, installerScenario(env, allExist))
+		onboard := runInstallerScript(t, installerScenario(defaultInstallerEnv(), allExist))
+
+		require.Equal(t, 0, repair.ExitCode, repair.Output)
+		assert.Equal(t, onboard.mutatingCommandNames(), repair.mutatingCommandNames())
+	})
+
+	testCases := []struct {
+		name     string
+		failed   stubRule
+		expected []string
+	}{
+		{
+			name:   "data_controller",
+			failed: stubRule{Pattern: "az arcdata dc status show *", Responses: []stubResponse{{Stdout: `{"properties": {"k8SRaw": {"status": {"state": "Failed"}}}}`}, {Stdout: `{"properties": {"k8SRaw": {"status": {"state": "Ready"}}}}`}}},
+			expected: []string{
+				"az arcdata dc delete",
+				"az connectedk8s enable-features",
+				"az arcdata dc create",
+				"az tag update",
+				"kubectl patch configmap " + installationStatusConfigMap,
+			},
+		},
+		{
+			// The Custom Location and Data Controller are built on the extension, so they are recreated too
+			name:   "extension",
+			failed: stubRule{Pattern: extensionStatePattern, Responses: []stubResponse{{Stdout: `{"provisioningState": "Failed"}`}, {Stdout: `{"provisioningState": "Succeeded"}`}}},
+			expected: []string{
+				"az arcdata dc delete",
+				"az customlocation delete",
+				"az k8s-extension delete",
+				"az connectedk8s enable-features",
+				"az k8s-extension create",
+				"az role assignment create",
+				"az role assignment create",
+				"az customlocation create",
+				"az tag update",
+				"az arcdata dc create",
+				"az tag update",
+				"kubectl patch configmap " + installationStatusConfigMap,
+			},
+		},
+		{
+			name:   "connected_cluster",
+			failed: stubRule{Pattern: "az connectedk8s show *--name replay-aks --resource-group replay-arc", Responses: []stubResponse{{Stdout: `{"provisioningState": "Failed"}`}}},
+			expected: []string{
+				"az arcdata dc delete",
+				"az customlocation delete",
+				"az k8s-extension delete",
+				"az connectedk8s delete",
+				"az connectedk8s connect",
+				"az k8s-extension create",
+				"az role assignment create",
+				"az role assignment create",
+				"az customlocation create",
+				"az tag update",
+				"az arcdata dc create",
+				"az tag update",
+				"kubectl patch configmap " + installationStatusConfigMap,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			scenario := installerScenario(env, allExist, tc.failed)
+
+			run := runInstallerScript(t, scenario)
+
+			require.Equal(t, 0, run.ExitCode, run.Output)
+			assert.Equal(t, tc.expected, run.mutatingCommandNames())
+			require.NotNil(t, run.Result)
+			assert.Equal(t, "repair", run.Result.Mode)
+
+			assertPlanMatchesExecution(t, scenario, "repair")
+		})
+	}
+}
@@ -0,0 +1,231 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// AZDATA_PASSWORD and GENERATE_AZDATA_PASSWORD of install-arc-data-services.sh - the Data Controller's password policy
+
+const azdataSecretLookup = "kubectl get secret " + azdataSecret + " -n " + statusNamespace + " *"
+
+// Secret the installer piped into kubectl create
+type azdataSecretManifest struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"metadata"`
+	StringData map[string]string `json:"stringData"`
+}
+
+// Environment that leaves the password to the installer
+func generatedPasswordEnv() map[string]string {
+	env := defaultInstallerEnv()
+	env["AZDATA_PASSWORD"] = ""
+	env["GENERATE_AZDATA_PASSWORD"] = "true"
+	return env
+}
+
+func TestInstallerAzdataPasswordValidation(t *testing.T) {
+	run, values := runInstallerValidation(t, defaultInstallerEnv())
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, "false", values["GENERATE_AZDATA_PASSWORD"])
+	assert.Contains(t, run.Output, "INFO | GENERATE_AZDATA_PASSWORD is not set, defaulting to false")
+
+	env := defaultInstallerEnv()
+	env["GENERATE_AZDATA_PASSWORD"] = "yes"
+	run, _ = runInstallerValidation(t, env)
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Contains(t, run.Output, "ERROR | variable GENERATE_AZDATA_PASSWORD must be true or false, got 'yes'")
+	assertNoRemoteCalls(t, run)
+}
+
+// Passwords the dashboards would reject fail validation, with the same rules as checkAzdataPassword
+func TestInstallerAzdataPasswordPolicy(t *testing.T) {
+	testCases := []struct {
+		password string
+		problems []string
+	}{
+		{"Ab1!", []string{"be 8 to 128 characters long"}},
+		{"Ab1!" + strings.Repeat("x", 125), []string{"be 8 to 128 characters long"}},
+		{"abcdefgh", []string{"contain characters from three of: uppercase letters, lowercase letters, digits and symbols"}},
+		{"abcdefg1", []string{"contain characters from three of: uppercase letters, lowercase letters, digits and symbols"}},
+		{"ABCD1234", []string{"contain characters from three of: uppercase letters, lowercase letters, digits and symbols"}},
+		{"Password-BOOR-1", []string{"not contain AZDATA_USERNAME"}},
+		{"boor", []string{"be 8 to 128 characters long", "contain characters from three of: uppercase letters, lowercase letters, digits and symbols", "not contain AZDATA_USERNAME"}},
+	}
+
+	for _, tc := range testCases {
+		env := defaultInstallerEnv()
+		env["AZDATA_PASSWORD"] = tc.password
+
+		run, _ := runInstallerValidation(t, env)
+
+		assert.Equal(t, 1, run.ExitCode, tc.password)
+		for _, problem := range tc.problems {
+			assert.Contains(t, run.Output, "ERROR | variable AZDATA_PASSWORD must "+problem+"\n", tc.password)
+		}
+		assert.Equal(t, len(tc.problems), strings.Count(run.Output, "ERROR | variable AZDATA_PASSWORD must "), tc.password)
+		assert.NotContains(t, run.Output, tc.password)
+		assertNoRemoteCalls(t, run)
+
+		err := checkAzdataPassword(env["AZDATA_USERNAME"], tc.password)
+		require.Error(t, err, tc.password)
+		assert.Equal(t, "AZDATA_PASSWORD must "+strings.Join(tc.problems, "; must "), err.Error())
+	}
+
+	// Right at the limits, and with characters from exactly three classes
+	for _, password := range []string{"Abcdef1!", "Ab1!" + strings.Repeat("x", 124), "abcdefg1!", "ABCDEFG1!", "Abcdefgh!", "acntorPRESTO!"} {
+		env := defaultInstallerEnv()
+		env["AZDATA_PASSWORD"] = password
+
+		run, _ := runInstallerValidation(t, env)
+
+		assert.Equal(t, 0, run.ExitCode, password)
+		assert.NoError(t, checkAzdataPassword(env["AZDATA_USERNAME"], password))
+	}
+}
+
+func TestInstallerAzdataPasswordRequired(t *testing.T) {
+	env := defaultInstallerEnv()
+	delete(env, "AZDATA_PASSWORD")
+
+	run, _ := runInstallerValidation(t, env)
+
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Contains(t, run.Output, "ERROR | variable AZDATA_PASSWORD is required.\n")
+	assert.Contains(t, run.Output, "ERROR | Set it, or set GENERATE_AZDATA_PASSWORD=true to have the Job generate one and keep it in Secret "+azdataSecret)
+
+	run, _ = runInstallerValidation(t, generatedPasswordEnv())
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Contains(t, run.Output, "INFO | variable AZDATA_PASSWORD is not set, onboarding and repair read it from Secret "+azdataSecret+" or generate it")
+	assertNoRemoteCalls(t, run)
+}
+
+// The first onboarding generates a password and stores it before anything is created
+func TestInstallerAzdataPasswordGenerated(t *testing.T) {
+	env := generatedPasswordEnv()
+	scenario := installerScenario(env, nothingExists)
+
+	run := runInstallerScript(t, scenario)
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | Generated AZDATA_PASSWORD, it is stored in Secret %s/%s before anything is created", statusNamespace, azdataSecret))
+
+	created := -1
+	for i, name := range run.commandNames() {
+		if name == "kubectl create" {
+			created = i
+		}
+	}
+	require.NotEqual(t, -1, created, "no Secret created")
+	manifest := azdataSecretManifest{}
+	require.NoError(t, json.Unmarshal([]byte(run.Stdin[created]), &manifest))
+	assert.Equal(t, "Secret", manifest.Kind)
+	assert.Equal(t, azdataSecret, manifest.Metadata.Name)
+	assert.Equal(t, statusNamespace, manifest.Metadata.Namespace)
+	assert.Equal(t, "kube-arc-data-services-installer-job", manifest.Metadata.Labels["app.kubernetes.io/managed-by"])
+	assert.Equal(t, env["AZDATA_USERNAME"], manifest.StringData["AZDATA_USERNAME"])
+
+	password := manifest.StringData["AZDATA_PASSWORD"]
+	assert.Len(t, password, 24)
+	assert.NoError(t, checkAzdataPassword(env["AZDATA_USERNAME"], password))
+
+	// Never printed or passed on a command line
+	assert.NotContains(t, run.Output, password)
+	for _, line := range run.commandLines() {
+		assert.NotContains(t, line, password)
+	}
+
+	// Stored before anything is created
+	mutating := run.mutatingCommandNames()
+	require.NotEmpty(t, mutating)
+	assert.Equal(t, "kubectl create", mutating[0])
+
+	assertPlanMatchesExecution(t, scenario, "onboard")
+}
+
+// A DRY_RUN plans the Secret but doesn't create it
+func TestInstallerAzdataPasswordPlan(t *testing.T) {
+	run, plan := runInstallerPlan(t, installerScenario(generatedPasswordEnv(), nothingExists))
+
+	require.NotEmpty(t, plan.Actions)
+	assert.Equal(t, planAction{Step: "0", Resource: "Secret", Name: azdataSecret, Action: "create"}, plan.Actions[0])
+	assert.Empty(t, run.commandLinesWithPrefix("kubectl create"))
+}
+
+// Later runs use the password the Data Controller was created with
+func TestInstallerAzdataPasswordReused(t *testing.T) {
+	for _, action := range []string{"onboard", "repair"} {
+		env := generatedPasswordEnv()
+		env["ACTION"] = action
+
+		run := runInstallerScript(t, installerScenario(env, allExist,
+			stubAnswer(azdataSecretLookup, base64.StdEncoding.EncodeToString([]byte("Stored-Passw0rd"))),
+		))
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Len(t, run.commandLinesWithPrefix("kubectl get secret "+azdataSecret), 1, action)
+		assert.Contains(t, run.Output, fmt.Sprintf("INFO | Using AZDATA_PASSWORD from Secret %s/%s", statusNamespace, azdataSecret))
+		assert.NotContains(t, run.Output, "Stored-Passw0rd")
+		assert.Empty(t, run.commandLinesWithPrefix("kubectl create"), action)
+	}
+}
+
+// A stored password that was edited to break the policy fails before anything is changed
+func TestInstallerAzdataPasswordStoredWeak(t *testing.T) {
+	run := runInstallerScript(t, installerScenario(generatedPasswordEnv(), nothingExists,
+		stubAnswer(azdataSecretLookup, base64.StdEncoding.EncodeToString([]byte("weak"))),
+	))
+
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Contains(t, run.Output, fmt.Sprintf("ERROR | AZDATA_PASSWORD in Secret %s/%s must be 8 to 128 characters long", statusNamespace, azdataSecret))
+	assert.Empty(t, run.commandLinesWithPrefix("az login"))
+	assert.Empty(t, run.mutatingCommandNames())
+	require.NotNil(t, run.Result)
+	assert.Equal(t, "azdataPassword", run.Result.FailedStep)
+}
+
+// A password that is set always wins, and only onboarding and repair create the Data Controller
+func TestInstallerAzdataPasswordOnlyWhenNeeded(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["GENERATE_AZDATA_PASSWORD"] = "true"
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Empty(t, run.commandLinesWithPrefix("kubectl get secret", "kubectl create"))
+
+	for _, action := range []string{"offboard", "status", "upgrade"} {
+		env := generatedPasswordEnv()
+		env["ACTION"] = action
+
+		run := runInstallerScript(t, installerScenario(env, allExist))
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Empty(t, run.commandLinesWithPrefix("kubectl get secret", "kubectl create"), action)
+	}
+}
+
+func TestCheckAzdataPassword(t *testing.T) {
+	assert.NoError(t, checkAzdataPassword("boor", "acntorPRESTO!"))
+	assert.NoError(t, checkAzdataPassword("admin", "Ünïcödé-Pässwört"))
+
+	err := checkAzdataPassword("Admin", "admin")
+	require.Error(t, err)
+	assert.Equal(t, "AZDATA_PASSWORD must be 8 to 128 characters long; must contain characters from three of: uppercase letters, "+
+		"lowercase letters, digits and symbols; must not contain AZDATA_USERNAME", err.Error())
+}
@@ -0,0 +1,182 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"fmt"
+	"strings"
+	"testing"
+
+	// Kubernetes
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Cluster capacity preflight of install-arc-data-services.sh - the Data Controller must fit before anything is changed
+
+// A cluster that fails every requirement - only two of its nodes can run the Data Controller
+func undersizedCluster() ([]corev1.Node, []storagev1.StorageClass) {
+	notReady := replayNode("aks-agentpool-2", "amd64", "linux", "3860m", "12897604Ki")
+	notReady.Status.Conditions[0].Status = corev1.ConditionFalse
+	cordoned := replayNode("aks-agentpool-3", "amd64", "linux", "3860m", "12897604Ki")
+	cordoned.Spec.Unschedulable = true
+
+	nodes := []corev1.Node{
+		replayNode("aks-agentpool-0", "amd64", "linux", "1900m", "5Gi"),
+		replayNode("aks-agentpool-1", "amd64", "linux", "1", "4096Mi"),
+		notReady,
+		cordoned,
+		replayNode("aks-armpool-0", "arm64", "linux", "8", "32Gi"),
+		replayNode("akswin000000", "amd64", "windows", "8", "32Gi"),
+	}
+	return nodes, []storagev1.StorageClass{{ObjectMeta: metav1.ObjectMeta{Name: "azurefile"}}}
+}
+
+// Lines of the capacity table in the installer's output, without the log prefix
+func capacityTableLines(output string) []string {
+	lines := []string{}
+	inTable := false
+	for _, line := range strings.Split(output, "\n") {
+		if line == "INFO | Cluster capacity for Arc Data Services:" {
+			inTable = true
+			continue
+		}
+		if inTable {
+			if !strings.HasPrefix(line, "INFO | ") || strings.HasPrefix(line, "INFO | Cluster meets") {
+				break
+			}
+			lines = append(lines, strings.TrimPrefix(line, "INFO | "))
+		}
+	}
+	return lines
+}
+
+func TestInstallerCapacityValidation(t *testing.T) {
+	run, values := runInstallerValidation(t, defaultInstallerEnv())
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, "3", values["CAPACITY_MIN_NODES"])
+	assert.Equal(t, "4", values["CAPACITY_MIN_CPU"])
+	assert.Equal(t, "16", values["CAPACITY_MIN_MEMORY_GIB"])
+	assert.Contains(t, run.Output, "INFO | CAPACITY_MIN_NODES is not set, defaulting to 3")
+
+	for _, key := range []string{"CAPACITY_MIN_NODES", "CAPACITY_MIN_CPU", "CAPACITY_MIN_MEMORY_GIB"} {
+		for _, value := range []string{"three", "-1", "1.5"} {
+			env := defaultInstallerEnv()
+			env[key] = value
+
+			run, _ := runInstallerValidation(t, env)
+
+			assert.Equal(t, 1, run.ExitCode, key)
+			assert.Contains(t, run.Output, fmt.Sprintf("ERROR | variable %s must be a whole number, got '%s'", key, value))
+			assertNoRemoteCalls(t, run)
+		}
+	}
+}
+
+func TestInstallerCapacityPasses(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Contains(t, run.Output, "INFO | aks-agentpool-0                          amd64/linux    true   true        3.9       12.3\n")
+	assert.Equal(t, strings.Split(strings.TrimSuffix(formatCapacityTable(checkClusterCapacity(replayNodes(), replayStorageClasses(), map[string]string{"data": "managed-premium", "logs": "managed-premium"}, defaultCapacityMinimums)), "\n"), "\n"), capacityTableLines(run.Output))
+	assert.Contains(t, run.Output, "INFO | Cluster meets every capacity requirement for Arc Data Services")
+}
+
+// Every unmet requirement is reported before logging in to Azure
+func TestInstallerCapacityFails(t *testing.T) {
+	env := defaultInstallerEnv()
+	nodes, classes := undersizedCluster()
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists,
+		stubAnswer("kubectl get nodes -o json", nodesJson(nodes)),
+		stubAnswer("kubectl get storageclass -o json", storageClassesJson(classes)),
+	))
+
+	assert.Equal(t, 1, run.ExitCode)
+	for _, failure := range []string{
+		"Ready amd64/linux nodes: need 3, found 2",
+		"Allocatable CPU cores: need 4, found 2.9",
+		"Allocatable memory GiB: need 16, found 9",
+		"Data storage class: need managed-premium, found missing",
+		"Logs storage class: need managed-premium, found missing",
+		"Default storage class: need any, found none",
+	} {
+		assert.Contains(t, run.Output, "ERROR | Cluster capacity - "+failure)
+	}
+	assert.Contains(t, run.Output, "ERROR | Cluster does not meet 6 capacity requirement(s) for Arc Data Services, nothing was changed")
+	assert.Empty(t, run.commandLinesWithPrefix("az login"))
+	assert.Empty(t, run.mutatingCommandNames())
+	require.NotNil(t, run.Result)
+	assert.Equal(t, "capacity", run.Result.FailedStep)
+
+	// The harness reports the same table
+	expected := checkClusterCapacity(nodes, classes, map[string]string{"data": "managed-premium", "logs": "managed-premium"}, defaultCapacityMinimums)
+	assert.Equal(t, strings.Split(strings.TrimSuffix(formatCapacityTable(expected), "\n"), "\n"), capacityTableLines(run.Output))
+}
+
+// Smaller clusters pass with lower minimums
+func TestInstallerCapacityMinimums(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["CAPACITY_MIN_NODES"] = "1"
+	env["CAPACITY_MIN_CPU"] = "2"
+	env["CAPACITY_MIN_MEMORY_GIB"] = "8"
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists,
+		stubAnswer("kubectl get nodes -o json", nodesJson([]corev1.Node{replayNode("kind-control-plane", "amd64", "linux", "2", "8Gi")})),
+		stubAnswer("kubectl get storageclass -o json", storageClassesJson([]storagev1.StorageClass{
+			{ObjectMeta: metav1.ObjectMeta{Name: "managed-premium", Annotations: map[string]string{"storageclass.beta.kubernetes.io/is-default-class": "true"}}},
+		})),
+	))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Contains(t, run.Output, "INFO | Cluster meets every capacity requirement for Arc Data Services")
+}
+
+// Only onboarding and repair create the Data Controller
+func TestInstallerCapacityOnlyWhenOnboarding(t *testing.T) {
+	for _, action := range []string{"offboard", "status", "upgrade"} {
+		env := defaultInstallerEnv()
+		env["ACTION"] = action
+
+		run := runInstallerScript(t, installerScenario(env, allExist,
+			stubAnswer("kubectl get nodes -o json", nodesJson(nil)),
+		))
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Empty(t, run.commandLinesWithPrefix("kubectl get nodes", "kubectl get storageclass"), action)
+	}
+}
+
+func TestCheckClusterCapacity(t *testing.T) {
+	classNames := map[string]string{"data": "managed-premium", "logs": "managed-premium"}
+
+	rows := checkClusterCapacity(replayNodes(), replayStorageClasses(), classNames, defaultCapacityMinimums)
+	assert.NoError(t, checkCapacityRows(rows))
+	assert.Equal(t, capacityRow{"Allocatable CPU cores", "4", "11.6", true}, rows[1])
+	assert.Equal(t, capacityRow{"Allocatable memory GiB", "16", "36.9", true}, rows[2])
+
+	// A class control.json doesn't name isn't checked
+	rows = checkClusterCapacity(replayNodes(), replayStorageClasses(), map[string]string{"data": "managed-premium"}, defaultCapacityMinimums)
+	assert.Len(t, rows, 5)
+
+	nodes, classes := undersizedCluster()
+	err := checkCapacityRows(checkClusterCapacity(nodes, classes, classNames, defaultCapacityMinimums))
+	require.Error(t, err)
+	assert.Equal(t, "cluster does not meet capacity requirements: Ready amd64/linux nodes: need 3, found 2; Allocatable CPU cores: need 4, found 2.9; "+
+		"Allocatable memory GiB: need 16, found 9; Data storage class: need managed-premium, found missing; "+
+		"Logs storage class: need managed-premium, found missing; Default storage class: need any, found none", err.Error())
+}
+
+func TestReadControlJsonStorageClasses(t *testing.T) {
+	classes, err := readControlJsonStorageClasses(aksControlJsonPath)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"data": "managed-premium", "logs": "managed-premium"}, classes)
+}
@@ -0,0 +1,109 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"fmt"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// CUSTOM_LOCATION_OID of install-arc-data-services.sh - the Custom Locations resource provider's object ID in the tenant
+
+const (
+	customLocationAppId       = "bc313c14-388c-4e7d-a58e-70017303ee3b"
+	replayCustomLocationOid   = "00000000-0000-0000-0000-00000000000c"
+	customLocationOidLookup   = "az ad sp show --id " + customLocationAppId + " --query id --output tsv"
+	customLocationOidResolved = "INFO | CUSTOM_LOCATION_OID is not set, resolved " + replayCustomLocationOid + " from Microsoft Graph"
+)
+
+func TestInstallerCustomLocationOidValidation(t *testing.T) {
+	for _, oid := range []string{"not-a-guid", "51dfe1e8-70c6-4de5-a08e-e18aff23d81", "{51dfe1e8-70c6-4de5-a08e-e18aff23d815}"} {
+		env := defaultInstallerEnv()
+		env["CUSTOM_LOCATION_OID"] = oid
+
+		run, _ := runInstallerValidation(t, env)
+
+		assert.Equal(t, 1, run.ExitCode, oid)
+		assert.Contains(t, run.Output, fmt.Sprintf("ERROR | variable CUSTOM_LOCATION_OID must be an object ID, e.g. 00000000-0000-0000-0000-000000000000, got '%s'", oid))
+		assertNoRemoteCalls(t, run)
+	}
+}
+
+// A CUSTOM_LOCATION_OID that is set is used as is
+func TestInstallerCustomLocationOidFromEnvironment(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | Using CUSTOM_LOCATION_OID %s from the environment", env["CUSTOM_LOCATION_OID"]))
+	assert.Empty(t, run.commandLinesWithPrefix("az ad sp show"))
+}
+
+func TestInstallerCustomLocationOidResolved(t *testing.T) {
+	for _, tc := range []struct {
+		state   arcResourceState
+		command string
+	}{
+		{nothingExists, "az connectedk8s connect"},
+		{allExist, "az connectedk8s enable-features"},
+	} {
+		env := defaultInstallerEnv()
+		delete(env, "CUSTOM_LOCATION_OID")
+
+		run := runInstallerScript(t, installerScenario(env, tc.state,
+			stubAnswer(customLocationOidLookup, replayCustomLocationOid+"\n"),
+		))
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Equal(t, []string{customLocationOidLookup}, run.commandLinesWithPrefix("az ad sp show"))
+		assert.Contains(t, run.Output, customLocationOidResolved)
+
+		commands := run.commandLinesWithPrefix(tc.command)
+		require.Len(t, commands, 1, tc.command)
+		assert.Contains(t, commands[0], "--custom-locations-oid "+replayCustomLocationOid)
+	}
+}
+
+// Nothing is changed when the object ID can't be looked up, e.g. the service principal can't read Microsoft Graph
+func TestInstallerCustomLocationOidUnresolved(t *testing.T) {
+	for name, response := range map[string]stubResponse{
+		"graph_denied": {ExitCode: 1},
+		"not_found":    {Stdout: ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			env := defaultInstallerEnv()
+			delete(env, "CUSTOM_LOCATION_OID")
+
+			run := runInstallerScript(t, installerScenario(env, nothingExists,
+				stubRule{Pattern: customLocationOidLookup, Responses: []stubResponse{response}},
+			))
+
+			assert.Equal(t, 1, run.ExitCode)
+			assert.Contains(t, run.Output, fmt.Sprintf("ERROR | CUSTOM_LOCATION_OID is not set and service principal %s could not read the Custom Locations resource provider's service principal (application ID %s) from Microsoft Graph", env["CLIENT_ID"], customLocationAppId))
+			assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Allow it to read service principals, or set CUSTOM_LOCATION_OID to the output of '%s'", customLocationOidLookup))
+			assert.Empty(t, run.mutatingCommandNames())
+			require.NotNil(t, run.Result)
+			assert.Equal(t, "customLocationOid", run.Result.FailedStep)
+		})
+	}
+}
+
+// Only onboarding and repair enable custom-locations, the other actions don't need the object ID
+func TestInstallerCustomLocationOidOnlyWhenOnboarding(t *testing.T) {
+	for _, action := range []string{"offboard", "status", "upgrade"} {
+		env := defaultInstallerEnv()
+		env["ACTION"] = action
+		delete(env, "CUSTOM_LOCATION_OID")
+
+		run := runInstallerScript(t, installerScenario(env, allExist))
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Empty(t, run.commandLinesWithPrefix("az ad sp show"), action)
+	}
+}
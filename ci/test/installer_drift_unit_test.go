@@ -0,0 +1,120 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"fmt"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Drift between the installed extension and Data Controller and the image's release env - see "Installed Version Check"
+// in install-arc-data-services.sh
+
+// Stub answers for an installation that differs from the release env in every compared setting
+func driftedInstallationRules(env map[string]string) []stubRule {
+	return []stubRule{
+		stubAnswer(extensionStatePattern, `{"provisioningState": "Succeeded", "version": "1.2.20031002", "releaseTrain": "Stable", "autoUpgradeMinorVersion": true}`),
+		stubAnswer("az arcdata dc status show *", `{"properties": {"k8SRaw": {"spec": {"docker": {"repository": "arcdata", "imageTag": "v1.9.0_2022-07-12"}}, "status": {"state": "Ready"}}}}`),
+	}
+}
+
+func TestInstallerDriftNone(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	for _, action := range []string{"onboard", "status", "repair", "upgrade"} {
+		env["ACTION"] = action
+		run := runInstallerScript(t, installerScenario(env, allExist))
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		require.NotNil(t, run.Result, action)
+		assert.Empty(t, run.Result.Drift, action)
+		assert.NotContains(t, run.Output, "WARNING | Drift |", action)
+		assert.NoError(t, checkNoDrift(run.Result.Drift), action)
+	}
+}
+
+func TestInstallerDriftReported(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ACTION"] = "status"
+
+	run := runInstallerScript(t, installerScenario(env, allExist, driftedInstallationRules(env)...))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Empty(t, run.mutatingCommandNames())
+	require.NotNil(t, run.Result)
+	assert.Equal(t, []jobResultDrift{
+		{Resource: "extension", Field: "version", Installed: "1.2.20031002", Expected: env["ARC_DATA_EXT_VERSION"]},
+		{Resource: "extension", Field: "releaseTrain", Installed: "stable", Expected: "preview"},
+		{Resource: "extension", Field: "autoUpgrade", Installed: "true", Expected: "false"},
+		{Resource: "dataController", Field: "imageTag", Installed: "v1.9.0_2022-07-12", Expected: env["ARC_DATA_CONTROLLER_VERSION"]},
+		{Resource: "dataController", Field: "repository", Installed: "arcdata", Expected: "arcdata/preview"},
+	}, run.Result.Drift)
+	assert.Contains(t, run.Output, fmt.Sprintf("WARNING | Drift | extension version is '1.2.20031002', release env has '%s'", env["ARC_DATA_EXT_VERSION"]))
+	assert.Contains(t, run.Output, "WARNING | Drift | dataController repository is 'arcdata', release env has 'arcdata/preview'")
+
+	err := checkNoDrift(run.Result.Drift)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `extension autoUpgrade is "true", release env has "false"`)
+	assert.Contains(t, err.Error(), `dataController imageTag is "v1.9.0_2022-07-12"`)
+}
+
+// Onboarding skips existing resources, so their drift remains and is reported
+func TestInstallerDriftOnboardingKeepsExisting(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run := runInstallerScript(t, installerScenario(env, allExist, driftedInstallationRules(env)...))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Empty(t, run.commandLinesWithPrefix("az k8s-extension update", "az arcdata dc upgrade"))
+	require.NotNil(t, run.Result)
+	assert.Len(t, run.Result.Drift, 5)
+	assert.Error(t, checkNoDrift(run.Result.Drift))
+
+	// Offboarding does not look at what is installed
+	env["ACTION"] = "offboard"
+	run = runInstallerScript(t, installerScenario(env, allExist, driftedInstallationRules(env)...))
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Empty(t, run.Result.Drift)
+}
+
+func TestInstallerDriftUpgrade(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ACTION"] = "upgrade"
+
+	// The extension update also sets the release train and auto-upgrade, so settings drift alone is upgraded
+	run := runInstallerScript(t, installerScenario(env, allExist,
+		stubAnswer(extensionStatePattern, fmt.Sprintf(`{"provisioningState": "Succeeded", "version": "%s", "releaseTrain": "preview", "autoUpgradeMinorVersion": true}`, env["ARC_DATA_EXT_VERSION"])),
+	))
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Len(t, run.commandLinesWithPrefix("az k8s-extension update"), 1)
+	assert.Empty(t, run.commandLinesWithPrefix("az arcdata dc upgrade"))
+
+	// az arcdata dc upgrade only moves the image tag, so a drifted repository alone is left as reported
+	run = runInstallerScript(t, installerScenario(env, allExist,
+		stubAnswer("az arcdata dc status show *", fmt.Sprintf(`{"properties": {"k8SRaw": {"spec": {"docker": {"repository": "arcdata", "imageTag": "%s"}}, "status": {"state": "Ready"}}}}`, env["ARC_DATA_CONTROLLER_VERSION"])),
+	))
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{"kubectl patch configmap " + installationStatusConfigMap}, run.mutatingCommandNames())
+	assert.Equal(t, []jobResultDrift{{Resource: "dataController", Field: "repository", Installed: "arcdata", Expected: "arcdata/preview"}}, run.Result.Drift)
+}
+
+// Settings a resource does not report can't be compared, so they are not drift
+func TestInstallerDriftUnknown(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ACTION"] = "status"
+
+	run := runInstallerScript(t, installerScenario(env, allExist,
+		stubAnswer(extensionStatePattern, `{"provisioningState": "Succeeded"}`),
+		stubAnswer("az arcdata dc status show *", `{"properties": {"k8SRaw": {"status": {"state": "Ready"}}}}`),
+	))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Empty(t, run.Result.Drift)
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | extension version is unknown, release env has '%s'", env["ARC_DATA_EXT_VERSION"]))
+	assert.Contains(t, run.Output, "INFO | dataController repository is unknown, release env has 'arcdata/preview'")
+}
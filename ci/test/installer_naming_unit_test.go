@@ -0,0 +1,109 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"strings"
+	"testing"
+
+	// Naming rules
+	"github.com/kangarookube/kube-arc-data-services-installer-job/naming"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Names and locations of install-arc-data-services.sh - rejected before any Azure call, as the naming package does
+
+func TestInstallerNamingPasses(t *testing.T) {
+	for name, overrides := range map[string]map[string]string{
+		"longest":     {"CONNECTED_CLUSTER_RESOURCE_GROUP": strings.Repeat("r", 89) + ")", "CONNECTED_CLUSTER": strings.Repeat("c", 63), "ARC_DATA_EXT": strings.Repeat("e", 53), "ARC_DATA_NAMESPACE": strings.Repeat("n", 63), "ARC_DATA_CONTROLLER": strings.Repeat("d", 63)},
+		"shortest":    {"ARC_DATA_RESOURCE_GROUP": "r", "CONNECTED_CLUSTER": "c", "ARC_DATA_EXT": "e", "ARC_DATA_NAMESPACE": "n", "ARC_DATA_CONTROLLER": "d"},
+		"punctuation": {"ARC_DATA_RESOURCE_GROUP": "Arc_Data.(prod)-1", "CONNECTED_CLUSTER": "My_Cluster-1", "ARC_DATA_EXT": "arc.data-1"},
+		"location":    {"CONNECTED_CLUSTER_LOCATION": "EastUS", "ARC_DATA_LOCATION": "westindia", "ARC_DATA_CONTROLLER_LOCATION": "westus3"},
+	} {
+		overrides := overrides
+		t.Run(name, func(t *testing.T) {
+			env := defaultInstallerEnv()
+			for key, value := range overrides {
+				env[key] = value
+			}
+			require.NoError(t, naming.Validate(env))
+
+			run, values := runInstallerValidation(t, env)
+
+			require.Equal(t, 0, run.ExitCode, run.Output)
+			for key, value := range overrides {
+				assert.Equal(t, value, values[key], key)
+			}
+		})
+	}
+}
+
+// Each invalid value is reported with the same message as the naming package
+func TestInstallerNamingFails(t *testing.T) {
+	testCases := map[string][]string{
+		"CONNECTED_CLUSTER_RESOURCE_GROUP": {"arc.", "arc rg", "arc/rg", strings.Repeat("r", 91)},
+		"ARC_DATA_RESOURCE_GROUP":          {"arc-data.", "arc#data"},
+		"CONNECTED_CLUSTER":                {"-aks", "aks_", "my.aks", strings.Repeat("c", 64)},
+		"ARC_DATA_EXT":                     {"Arc-Data-Bootstrapper", "arc_data", "arc-data-", strings.Repeat("e", 54)},
+		"ARC_DATA_NAMESPACE":               {"Azure-Arc-Data", "azure.arc.data", "azure-arc-data-", strings.Repeat("n", 64)},
+		"ARC_DATA_CONTROLLER":              {"Azure-Arc-Data-Controller", "arc_dc", strings.Repeat("d", 64)},
+		"ARC_DATA_LOCATION":                {"mars", "East US", "eastus eastus2"},
+		"ARC_DATA_CONTROLLER_LOCATION":     {"westus", "northcentralus", "westindia"},
+	}
+
+	for key, values := range testCases {
+		for _, value := range values {
+			env := defaultInstallerEnv()
+			env[key] = value
+			err := naming.Validate(env)
+			require.Error(t, err, value)
+
+			run, _ := runInstallerValidation(t, env)
+
+			assert.Equal(t, 1, run.ExitCode, value)
+			assert.Contains(t, run.Output, "ERROR | "+strings.TrimPrefix(err.Error(), "invalid names or locations:\n - ")+"\n", value)
+			assert.Contains(t, run.Output, "ERROR | 1 name(s) or location(s) would be rejected by Azure or Kubernetes, nothing was changed")
+			assertNoRemoteCalls(t, run)
+		}
+	}
+}
+
+// The Custom Location is created in CONNECTED_CLUSTER_LOCATION, so it must support both resource types
+func TestInstallerNamingConnectedClusterLocation(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["CONNECTED_CLUSTER_LOCATION"] = "canadaeast"
+
+	run, _ := runInstallerValidation(t, env)
+
+	assert.Equal(t, 1, run.ExitCode)
+	assert.NotContains(t, run.Output, "Arc-enabled Kubernetes clusters are available in")
+	assert.Contains(t, run.Output, "ERROR | variable CONNECTED_CLUSTER_LOCATION must be a region Custom Locations are available in, e.g. eastus, got 'canadaeast'")
+}
+
+// Every problem is reported at once, in the naming package's order, and before logging in to Azure
+func TestInstallerNamingReportsEverything(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["CONNECTED_CLUSTER"] = "aks_"
+	env["ARC_DATA_NAMESPACE"] = "Azure_Arc_Data"
+	env["CONNECTED_CLUSTER_LOCATION"] = "westindia"
+	err := naming.Validate(env)
+	require.Error(t, err)
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	assert.Equal(t, 1, run.ExitCode)
+	problems := []string{}
+	for _, line := range strings.Split(run.Output, "\n") {
+		if strings.HasPrefix(line, "ERROR | variable ") {
+			problems = append(problems, strings.TrimPrefix(line, "ERROR | "))
+		}
+	}
+	assert.Equal(t, strings.Split(strings.TrimPrefix(err.Error(), "invalid names or locations:\n - "), "\n - "), problems)
+	assert.Contains(t, run.Output, "ERROR | 4 name(s) or location(s) would be rejected by Azure or Kubernetes, nothing was changed")
+	assert.Empty(t, run.commandLinesWithPrefix("az login"))
+	assert.Empty(t, run.mutatingCommandNames())
+}
@@ -0,0 +1,115 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"fmt"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// OFFBOARD_SCOPE of install-arc-data-services.sh - data-services-only, keep-connected-cluster and full
+
+func TestInstallerOffboardScope(t *testing.T) {
+	dataServices := []string{
+		"az arcdata dc delete",
+		"az customlocation delete",
+		"az k8s-extension delete",
+		"kubectl delete crd",
+		"kubectl delete mutatingwebhookconfiguration arcdata.microsoft.com-webhook-azure-arc-data",
+	}
+	testCases := []struct {
+		scope   string
+		deleted []string // Mutating calls between deleting the data services and their namespace
+		kept    []string // Result keys reported Kept
+	}{
+		{"data-services-only", nil, []string{"connectedCluster", "arcDataResourceGroup", "connectedClusterResourceGroup"}},
+		{"keep-connected-cluster", []string{"az group delete"}, []string{"connectedCluster", "connectedClusterResourceGroup"}},
+		{"full", []string{"az connectedk8s delete", "az group delete", "az group delete"}, nil},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.scope, func(t *testing.T) {
+			env := defaultInstallerEnv()
+			env["ACTION"] = "offboard"
+			env["OFFBOARD_SCOPE"] = tc.scope
+			scenario := installerScenario(env, allExist)
+
+			run := runInstallerScript(t, scenario)
+
+			require.Equal(t, 0, run.ExitCode, run.Output)
+			expected := append(append(append([]string{}, dataServices...), tc.deleted...), "kubectl delete", "kubectl patch configmap "+installationStatusConfigMap)
+			assert.Equal(t, expected, run.mutatingCommandNames())
+
+			kept := map[string]bool{}
+			for _, key := range tc.kept {
+				kept[key] = true
+			}
+			require.NotNil(t, run.Result)
+			for key, resource := range run.Result.Resources {
+				if kept[key] {
+					assert.Equal(t, "Kept", resource.Status, key)
+					assert.NotEmpty(t, resource.ID, key)
+				} else {
+					assert.Equal(t, "Deleted", resource.Status, key)
+				}
+			}
+
+			status, err := run.installationStatus(installationStatus{"connectedClusterId": "/subscriptions/x", "dataControllerId": "/subscriptions/x"})
+			require.NoError(t, err)
+			require.NoError(t, status.validate("Offboarded"))
+			assert.Equal(t, tc.scope, status["offboardScope"])
+			assert.NotContains(t, status, "dataControllerId")
+			if kept["connectedCluster"] {
+				assert.Equal(t, "/subscriptions/x", status["connectedClusterId"])
+			} else {
+				assert.NotContains(t, status, "connectedClusterId")
+			}
+
+			assertPlanMatchesExecution(t, scenario, "offboard")
+		})
+	}
+}
+
+// A resource group shared with the Connected Cluster is only deleted with it
+func TestInstallerOffboardScopeSharedResourceGroup(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ACTION"] = "offboard"
+	env["OFFBOARD_SCOPE"] = "keep-connected-cluster"
+	env["ARC_DATA_RESOURCE_GROUP"] = env["CONNECTED_CLUSTER_RESOURCE_GROUP"]
+
+	run := runInstallerScript(t, installerScenario(env, allExist))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | Arc Data Services Resource Group %s also holds the Connected Cluster, keeping it", env["ARC_DATA_RESOURCE_GROUP"]))
+	assert.Empty(t, run.commandLinesWithPrefix("az group delete", "az connectedk8s delete"))
+	assert.Equal(t, "Kept", run.Result.Resources["arcDataResourceGroup"].Status)
+}
+
+func TestInstallerOffboardScopeValidation(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run, values := runInstallerValidation(t, env)
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Contains(t, run.Output, "INFO | OFFBOARD_SCOPE is not set, defaulting to full")
+	assert.Equal(t, "full", values["OFFBOARD_SCOPE"])
+
+	env["OFFBOARD_SCOPE"] = "cluster-only"
+	run, _ = runInstallerValidation(t, env)
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Contains(t, run.Output, "ERROR | variable OFFBOARD_SCOPE must be one of data-services-only, keep-connected-cluster or full, got 'cluster-only'")
+	assertNoRemoteCalls(t, run)
+
+	// Onboarding ignores the scope and clears the last recorded one
+	env["OFFBOARD_SCOPE"] = "data-services-only"
+	run = runInstallerScript(t, installerScenario(env, allExist))
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	status, err := run.installationStatus(installationStatus{"offboardScope": "data-services-only"})
+	require.NoError(t, err)
+	assert.NotContains(t, status, "offboardScope")
+}
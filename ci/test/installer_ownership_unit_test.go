@@ -0,0 +1,206 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"fmt"
+	"strings"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Ownership tags of install-arc-data-services.sh - everything created is tagged, offboarding only deletes what is tagged
+
+func TestInstallerOwnershipTags(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["INSTALLER_RUN_ID"] = "replay-run"
+	tags := fmt.Sprintf("--tags %s=%s %s=%s %s=replay-run", ownerTagManagedBy, ownerManagedBy, ownerTagCluster, env["CONNECTED_CLUSTER"], ownerTagRunID)
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	created := run.commandLinesWithPrefix("az group create", "az connectedk8s connect")
+	require.Len(t, created, 3)
+	for _, line := range created {
+		assert.Contains(t, line, tags)
+	}
+
+	// The CLI can't tag Custom Locations and Data Controllers on create
+	arcDataRg := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", env["SUBSCRIPTION_ID"], env["ARC_DATA_RESOURCE_GROUP"])
+	assert.Equal(t, []string{
+		fmt.Sprintf("az tag update --resource-id %s/providers/Microsoft.ExtendedLocation/customLocations/%s --operation Merge %s", arcDataRg, env["ARC_DATA_NAMESPACE"], tags),
+		fmt.Sprintf("az tag update --resource-id %s/providers/Microsoft.AzureArcData/dataControllers/%s --operation Merge %s", arcDataRg, env["ARC_DATA_CONTROLLER"], tags),
+	}, run.commandLinesWithPrefix("az tag update"))
+
+	// Nothing is tagged again on a re-run
+	run = runInstallerScript(t, installerScenario(env, allExist))
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Empty(t, run.commandLinesWithPrefix("az tag update"))
+}
+
+// Resources another cluster's installer owns are kept, along with the resource groups still holding them
+func TestInstallerOffboardKeepsUnownedResources(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ACTION"] = "offboard"
+	scenario := installerScenario(env, allExist,
+		stubAnswer("az tag list --resource-id */dataControllers/*", ownershipTagsJson(env["CONNECTED_CLUSTER"])),
+		stubAnswer("az tag list *", ownershipTagsJson("another-cluster")),
+		stubAnswer("az resource list --resource-group * --query *", "3\n"),
+	)
+
+	run := runInstallerScript(t, scenario)
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{"az arcdata dc delete", "kubectl patch configmap " + installationStatusConfigMap}, run.mutatingCommandNames())
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | Keeping Connected Cluster %s, it does not carry this installer's ownership tags for %s", env["CONNECTED_CLUSTER"], env["CONNECTED_CLUSTER"]))
+
+	require.NotNil(t, run.Result)
+	assert.Equal(t, "Deleted", run.Result.Resources["dataController"].Status)
+	for _, key := range []string{"customLocation", "extension", "connectedCluster", "arcDataResourceGroup", "connectedClusterResourceGroup"} {
+		assert.Equal(t, "Kept", run.Result.Resources[key].Status, key)
+	}
+	for _, group := range []string{env["ARC_DATA_RESOURCE_GROUP"], env["CONNECTED_CLUSTER_RESOURCE_GROUP"]} {
+		assert.Contains(t, run.Result.Warnings, fmt.Sprintf("Resource Group %s was not created by this installer and still holds 3 resources, refusing to delete it", group))
+	}
+
+	onboarded := installationStatus{}
+	for _, key := range installationStatusIDKeys {
+		onboarded[key] = "/subscriptions/x"
+	}
+	status, err := run.installationStatus(onboarded)
+	require.NoError(t, err)
+	require.NoError(t, status.validate("Offboarded"))
+	assert.Equal(t, "/subscriptions/x", status["connectedClusterId"])
+
+	assertPlanMatchesExecution(t, scenario, "offboard")
+}
+
+// Resources from an installer version before ownership tags would silently be kept, offboarding refuses instead
+func TestInstallerOffboardRefusesWhenNothingIsOwned(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ACTION"] = "offboard"
+
+	for _, tags := range []string{"{}", ownershipTagsJson("another-cluster")} {
+		run := runInstallerScript(t, installerScenario(env, allExist, stubAnswer("az tag list *", tags)))
+
+		require.Equal(t, 1, run.ExitCode, run.Output)
+		assert.Empty(t, run.mutatingCommandNames())
+		assert.Contains(t, run.Output, fmt.Sprintf("ERROR | None of the existing resources carry this installer's ownership tags for %s, nothing was deleted", env["CONNECTED_CLUSTER"]))
+		assert.Contains(t, run.Output, "ADOPT_EXISTING_RESOURCES=true")
+	}
+
+	// Nothing left to offboard is not an error
+	run := runInstallerScript(t, installerScenario(env, nothingExists, stubAnswer("az tag list *", "{}")))
+	require.Equal(t, 0, run.ExitCode, run.Output)
+}
+
+// An owned resource group still holding resources the installer doesn't own is kept, what the installer owns in it is not
+func TestInstallerOffboardKeepsResourceGroupWithUnownedContents(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ACTION"] = "offboard"
+	contents := fmt.Sprintf(`[{"name": "shared-vnet", "tags": {}}, {"name": "%s", "tags": {"%s": "%s", "%s": "%s"}}]`,
+		env["ARC_DATA_CONTROLLER"], ownerTagManagedBy, ownerManagedBy, ownerTagCluster, env["CONNECTED_CLUSTER"])
+	scenario := installerScenario(env, allExist,
+		stubAnswer(fmt.Sprintf("az resource list --resource-group %s --output json", env["ARC_DATA_RESOURCE_GROUP"]), contents),
+		stubAnswer(fmt.Sprintf("az resource list --resource-group %s --query *", env["ARC_DATA_RESOURCE_GROUP"]), "1\n"),
+	)
+
+	run := runInstallerScript(t, scenario)
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{fmt.Sprintf("az group delete --resource-group %s --yes", env["CONNECTED_CLUSTER_RESOURCE_GROUP"])}, run.commandLinesWithPrefix("az group delete"))
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | Keeping Arc Data Services Resource Group %s, it holds resources this installer does not own for %s: shared-vnet", env["ARC_DATA_RESOURCE_GROUP"], env["CONNECTED_CLUSTER"]))
+
+	require.NotNil(t, run.Result)
+	assert.Equal(t, "Kept", run.Result.Resources["arcDataResourceGroup"].Status)
+	for _, key := range []string{"dataController", "customLocation", "extension", "connectedCluster", "connectedClusterResourceGroup"} {
+		assert.Equal(t, "Deleted", run.Result.Resources[key].Status, key)
+	}
+	assert.Contains(t, run.Result.Warnings, fmt.Sprintf("Resource Group %s holds resources this installer does not own (shared-vnet), refusing to delete it", env["ARC_DATA_RESOURCE_GROUP"]))
+
+	assertPlanMatchesExecution(t, scenario, "offboard")
+}
+
+// ADOPT_EXISTING_RESOURCES stamps the ownership tags onto existing resources carrying no installer's tags
+func TestInstallerAdoptsExistingResources(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["INSTALLER_RUN_ID"] = "replay-run"
+	tags := fmt.Sprintf("--tags %s=%s %s=%s %s=replay-run", ownerTagManagedBy, ownerManagedBy, ownerTagCluster, env["CONNECTED_CLUSTER"], ownerTagRunID)
+	connectedClusterRg := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", env["SUBSCRIPTION_ID"], env["CONNECTED_CLUSTER_RESOURCE_GROUP"])
+	overrides := []stubRule{
+		stubAnswer("az tag list --resource-id "+connectedClusterRg, "{}"),
+		stubAnswer("az tag list --resource-id */connectedClusters/*", "{}"),
+		// Owned by another cluster's installer, never adopted
+		stubAnswer("az tag list --resource-id */customLocations/*", ownershipTagsJson("another-cluster")),
+	}
+
+	run := runInstallerScript(t, installerScenario(env, allExist, overrides...))
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Empty(t, run.commandLinesWithPrefix("az tag update"), "nothing is adopted by default")
+
+	env["ADOPT_EXISTING_RESOURCES"] = "true"
+	scenario := installerScenario(env, allExist, overrides...)
+	run = runInstallerScript(t, scenario)
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{
+		fmt.Sprintf("az tag update --resource-id %s --operation Merge %s", connectedClusterRg, tags),
+		fmt.Sprintf("az tag update --resource-id %s/providers/Microsoft.Kubernetes/connectedClusters/%s --operation Merge %s", connectedClusterRg, env["CONNECTED_CLUSTER"], tags),
+	}, run.commandLinesWithPrefix("az tag update"))
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | Adopting Connected Cluster %s", env["CONNECTED_CLUSTER"]))
+
+	assertPlanMatchesExecution(t, scenario, "onboard")
+
+	delete(env, "ADOPT_EXISTING_RESOURCES")
+	run, values := runInstallerValidation(t, env)
+	assert.Equal(t, "false", values["ADOPT_EXISTING_RESOURCES"])
+
+	env["ADOPT_EXISTING_RESOURCES"] = "yes"
+	run, _ = runInstallerValidation(t, env)
+	require.Equal(t, 1, run.ExitCode, run.Output)
+	assert.Contains(t, run.Output, "ERROR | variable ADOPT_EXISTING_RESOURCES must be true or false, got 'yes'")
+}
+
+// An empty resource group the installer didn't create is kept without a warning
+func TestInstallerOffboardKeepsEmptyUnownedResourceGroup(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ACTION"] = "offboard"
+	connectedClusterRg := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", env["SUBSCRIPTION_ID"], env["CONNECTED_CLUSTER_RESOURCE_GROUP"])
+
+	run := runInstallerScript(t, installerScenario(env, allExist,
+		stubAnswer("az tag list --resource-id "+connectedClusterRg, "{}"),
+	))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{fmt.Sprintf("az group delete --resource-group %s --yes", env["ARC_DATA_RESOURCE_GROUP"])}, run.commandLinesWithPrefix("az group delete"))
+	assert.Equal(t, "Kept", run.Result.Resources["connectedClusterResourceGroup"].Status)
+	assert.Equal(t, "Deleted", run.Result.Resources["connectedCluster"].Status)
+	assert.NotContains(t, strings.Join(run.Result.Warnings, "\n"), "refusing to delete")
+}
+
+// Whatever an unowned resource is built on is kept with it, though the extension follows the Connected Cluster's tags
+func TestInstallerOffboardKeepsWhatUnownedResourcesDependOn(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ACTION"] = "offboard"
+	scenario := installerScenario(env, allExist,
+		stubAnswer("az tag list --resource-id */customLocations/*", "{}"),
+	)
+
+	run := runInstallerScript(t, scenario)
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{"az arcdata dc delete", "kubectl patch configmap " + installationStatusConfigMap}, run.mutatingCommandNames())
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | Keeping Bootstrapper Extension %s, a kept resource depends on it", env["ARC_DATA_EXT"]))
+
+	require.NotNil(t, run.Result)
+	assert.Equal(t, "Deleted", run.Result.Resources["dataController"].Status)
+	for _, key := range []string{"customLocation", "extension", "connectedCluster", "arcDataResourceGroup", "connectedClusterResourceGroup"} {
+		assert.Equal(t, "Kept", run.Result.Resources[key].Status, key)
+	}
+
+	assertPlanMatchesExecution(t, scenario, "offboard")
+}
@@ -0,0 +1,127 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"fmt"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Azure permission preflight of install-arc-data-services.sh - every missing permission is reported before anything changes
+
+const permissionsPattern = "az rest --method get --url %s/providers/Microsoft.Authorization/permissions*"
+
+func TestInstallerPermissionPreflightPasses(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | Service principal %s has every Azure permission this run needs", env["CLIENT_ID"]))
+
+	// Neither resource group exists yet, so everything is checked on the subscription, once
+	assert.Equal(t, []string{
+		fmt.Sprintf("az rest --method get --url /subscriptions/%s/providers/Microsoft.Authorization/permissions?api-version=2015-07-01 --output json", env["SUBSCRIPTION_ID"]),
+	}, run.commandLinesWithPrefix("az rest"))
+}
+
+// Contributor can create everything but can't assign the extension's roles
+func TestInstallerPermissionPreflightContributor(t *testing.T) {
+	env := defaultInstallerEnv()
+	arcDataRg := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", env["SUBSCRIPTION_ID"], env["ARC_DATA_RESOURCE_GROUP"])
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists,
+		stubAnswer(fmt.Sprintf(permissionsPattern, "*"), permissionsJson([]string{"*"}, []string{"Microsoft.Authorization/*/Delete", "Microsoft.Authorization/*/Write"})),
+	))
+
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Missing Azure permission Microsoft.Authorization/roleAssignments/write on %s - assign role Contributor to Bootstrapper extension %s", arcDataRg, env["ARC_DATA_EXT"]))
+	assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Missing Azure permission Microsoft.Authorization/roleAssignments/write on %s - assign role Monitoring Metrics Publisher to Bootstrapper extension %s", arcDataRg, env["ARC_DATA_EXT"]))
+	assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Service principal %s is missing 2 Azure permission(s), nothing was changed", env["CLIENT_ID"]))
+
+	assert.Empty(t, run.mutatingCommandNames())
+	require.NotNil(t, run.Result)
+	assert.Equal(t, "permissions", run.Result.FailedStep)
+}
+
+// Every missing permission is reported at once, each on the scope it is needed on
+func TestInstallerPermissionPreflightReportsEverything(t *testing.T) {
+	env := defaultInstallerEnv()
+	state := arcResourceState{ConnectedClusterResourceGroup: true, ArcDataResourceGroup: true}
+	connectedClusterRg := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", env["SUBSCRIPTION_ID"], env["CONNECTED_CLUSTER_RESOURCE_GROUP"])
+	arcDataRg := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", env["SUBSCRIPTION_ID"], env["ARC_DATA_RESOURCE_GROUP"])
+
+	run := runInstallerScript(t, installerScenario(env, state,
+		stubAnswer(fmt.Sprintf(permissionsPattern, connectedClusterRg), permissionsJson([]string{"Microsoft.Kubernetes/*", "Microsoft.KubernetesConfiguration/*"}, nil)),
+		stubAnswer(fmt.Sprintf(permissionsPattern, arcDataRg), permissionsJson([]string{"*/read"}, nil)),
+	))
+
+	assert.Equal(t, 1, run.ExitCode)
+	for _, missing := range []string{
+		fmt.Sprintf("Microsoft.ExtendedLocation/customLocations/write on %s/providers/Microsoft.ExtendedLocation/customLocations/%s - create Custom Location %s", arcDataRg, env["ARC_DATA_NAMESPACE"], env["ARC_DATA_NAMESPACE"]),
+		fmt.Sprintf("Microsoft.AzureArcData/dataControllers/write on %s/providers/Microsoft.AzureArcData/dataControllers/%s - create Data Controller %s", arcDataRg, env["ARC_DATA_CONTROLLER"], env["ARC_DATA_CONTROLLER"]),
+		fmt.Sprintf("Microsoft.Resources/tags/write on %s/providers/Microsoft.ExtendedLocation/customLocations/%s - tag Custom Location %s", arcDataRg, env["ARC_DATA_NAMESPACE"], env["ARC_DATA_NAMESPACE"]),
+		fmt.Sprintf("Microsoft.Resources/tags/write on %s/providers/Microsoft.AzureArcData/dataControllers/%s - tag Data Controller %s", arcDataRg, env["ARC_DATA_CONTROLLER"], env["ARC_DATA_CONTROLLER"]),
+		fmt.Sprintf("Microsoft.Authorization/roleAssignments/write on %s - assign role Contributor to Bootstrapper extension %s", arcDataRg, env["ARC_DATA_EXT"]),
+		fmt.Sprintf("Microsoft.Authorization/roleAssignments/write on %s - assign role Monitoring Metrics Publisher to Bootstrapper extension %s", arcDataRg, env["ARC_DATA_EXT"]),
+	} {
+		assert.Contains(t, run.Output, "ERROR | Missing Azure permission "+missing)
+	}
+	assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Service principal %s is missing 6 Azure permission(s), nothing was changed", env["CLIENT_ID"]))
+	assert.NotContains(t, run.Output, "Microsoft.Kubernetes/connectedClusters/write")
+	assert.Len(t, run.commandLinesWithPrefix("az rest"), 2)
+	assert.Empty(t, run.mutatingCommandNames())
+}
+
+// Repair also needs to delete what failed
+func TestInstallerPermissionPreflightRepair(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ACTION"] = "repair"
+	arcDataRg := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", env["SUBSCRIPTION_ID"], env["ARC_DATA_RESOURCE_GROUP"])
+
+	run := runInstallerScript(t, installerScenario(env, allExist,
+		stubRule{Pattern: "az arcdata dc status show *", Responses: []stubResponse{{Stdout: `{"properties": {"k8SRaw": {"status": {"state": "Failed"}}}}`}}},
+		stubAnswer(fmt.Sprintf(permissionsPattern, "*"), permissionsJson([]string{"*/read", "Microsoft.Kubernetes/connectedClusters/write", "Microsoft.AzureArcData/dataControllers/write", "Microsoft.Resources/tags/write"}, nil)),
+	))
+
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Missing Azure permission Microsoft.AzureArcData/dataControllers/delete on %s/providers/Microsoft.AzureArcData/dataControllers/%s - repair Data Controller %s", arcDataRg, env["ARC_DATA_CONTROLLER"], env["ARC_DATA_CONTROLLER"]))
+	assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Service principal %s is missing 1 Azure permission(s), nothing was changed", env["CLIENT_ID"]))
+	assert.Empty(t, run.mutatingCommandNames())
+}
+
+// Enabling Cluster-Connect and Custom-Locations on an existing Connected Cluster writes it
+func TestInstallerPermissionPreflightEnableFeatures(t *testing.T) {
+	env := defaultInstallerEnv()
+	connectedCluster := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Kubernetes/connectedClusters/%s", env["SUBSCRIPTION_ID"], env["CONNECTED_CLUSTER_RESOURCE_GROUP"], env["CONNECTED_CLUSTER"])
+
+	run := runInstallerScript(t, installerScenario(env, allExist,
+		stubAnswer(fmt.Sprintf(permissionsPattern, "*"), permissionsJson([]string{"*"}, []string{"Microsoft.Kubernetes/connectedClusters/write"})),
+	))
+
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Missing Azure permission Microsoft.Kubernetes/connectedClusters/write on %s - enable Cluster-Connect and Custom-Locations on Connected Cluster %s", connectedCluster, env["CONNECTED_CLUSTER"]))
+	assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Service principal %s is missing 1 Azure permission(s), nothing was changed", env["CLIENT_ID"]))
+	assert.Empty(t, run.commandLinesWithPrefix("az connectedk8s enable-features"))
+	assert.Empty(t, run.mutatingCommandNames())
+}
+
+// Nothing is created by the other actions, so they don't check
+func TestInstallerPermissionPreflightOnlyWhenCreating(t *testing.T) {
+	for _, action := range []string{"offboard", "status", "upgrade"} {
+		env := defaultInstallerEnv()
+		env["ACTION"] = action
+
+		run := runInstallerScript(t, installerScenario(env, allExist,
+			stubAnswer(fmt.Sprintf(permissionsPattern, "*"), permissionsJson(nil, nil)),
+		))
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Empty(t, run.commandLinesWithPrefix("az rest"), action)
+	}
+}
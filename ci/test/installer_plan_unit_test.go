@@ -0,0 +1,186 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// DRY_RUN plan mode of install-arc-data-services.sh - see script_helpers.go
+
+// Command each planned change runs when the installer is not in DRY_RUN, as a prefix of the recorded command name
+var plannedChangeCommands = map[string]string{
+	"create Secret":                       "kubectl create",
+	"register ResourceProvider":           "az provider register",
+	"apply OpenShiftSCC":                  "kubectl apply",
+	"create ResourceGroup":                "az group create",
+	"tag ResourceGroup":                   "az tag update",
+	"adopt ResourceGroup":                 "az tag update",
+	"create ConnectedCluster":             "az connectedk8s connect",
+	"tag ConnectedCluster":                "az tag update",
+	"adopt ConnectedCluster":              "az tag update",
+	"enable-features ConnectedCluster":    "az connectedk8s enable-features",
+	"create Extension":                    "az k8s-extension create",
+	"role-assignment RoleAssignment":      "az role assignment create",
+	"create CustomLocation":               "az customlocation create",
+	"tag CustomLocation":                  "az tag update",
+	"adopt CustomLocation":                "az tag update",
+	"create DataController":               "az arcdata dc create",
+	"tag DataController":                  "az tag update",
+	"adopt DataController":                "az tag update",
+	"apply OpenShiftRoutes":               "kubectl apply",
+	"delete DataController":               "az arcdata dc delete",
+	"delete CustomLocation":               "az customlocation delete",
+	"delete Extension":                    "az k8s-extension delete",
+	"delete CustomResourceDefinitions":    "kubectl delete crd",
+	"delete MutatingWebhookConfiguration": "kubectl delete mutatingwebhookconfiguration",
+	"delete ConnectedCluster":             "az connectedk8s delete",
+	"delete ResourceGroup":                "az group delete",
+	"delete OpenShiftRoutes":              "kubectl delete",
+	"delete OpenShiftSCC":                 "kubectl delete",
+	"delete Namespace":                    "kubectl delete",
+	"update StatusConfigMap":              "kubectl patch configmap",
+	"upgrade Extension":                   "az k8s-extension update",
+	"upgrade DataController":              "az arcdata dc upgrade",
+}
+
+func TestInstallerPlanFreshOnboarding(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run, plan := runInstallerPlan(t, installerScenario(env, nothingExists))
+
+	assert.Equal(t, installerPlan{
+		Mode: "onboard",
+		Actions: []planAction{
+			{Step: "1", Resource: "ResourceGroup", Name: env["CONNECTED_CLUSTER_RESOURCE_GROUP"], Action: "create"},
+			{Step: "1", Resource: "ResourceGroup", Name: env["ARC_DATA_RESOURCE_GROUP"], Action: "create"},
+			{Step: "2", Resource: "ConnectedCluster", Name: env["CONNECTED_CLUSTER"], Action: "create"},
+			{Step: "3", Resource: "Extension", Name: env["ARC_DATA_EXT"], Action: "create"},
+			{Step: "3a", Resource: "RoleAssignment", Name: "Contributor", Action: "role-assignment"},
+			{Step: "3a", Resource: "RoleAssignment", Name: "Monitoring Metrics Publisher", Action: "role-assignment"},
+			{Step: "4", Resource: "CustomLocation", Name: env["ARC_DATA_NAMESPACE"], Action: "create"},
+			{Step: "4", Resource: "CustomLocation", Name: env["ARC_DATA_NAMESPACE"], Action: "tag"},
+			{Step: "5", Resource: "DataController", Name: env["ARC_DATA_CONTROLLER"], Action: "create"},
+			{Step: "5", Resource: "DataController", Name: env["ARC_DATA_CONTROLLER"], Action: "tag"},
+			{Step: "status", Resource: "StatusConfigMap", Name: "azure-arc-data-services-status", Action: "update"},
+		},
+	}, plan)
+
+	// Authenticates and runs the Central Status Check, but changes nothing
+	assert.NotEmpty(t, run.commandLinesWithPrefix("az login"))
+	assert.NotEmpty(t, run.commandLinesWithPrefix("az group list"))
+	assert.Empty(t, run.mutatingCommandNames())
+	assert.Contains(t, run.Output, "INFO | PLAN | 5. create DataController "+env["ARC_DATA_CONTROLLER"])
+	assert.Contains(t, run.Output, "INFO | Dry run complete, nothing was changed.")
+
+	// The plan file holds the same plan as the log
+	content, err := ioutil.ReadFile(run.PlanFile)
+	require.NoError(t, err)
+	planFromFile := installerPlan{}
+	require.NoError(t, json.Unmarshal(content, &planFromFile))
+	assert.Equal(t, plan, planFromFile)
+}
+
+func TestInstallerPlanWhenEverythingExists(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	t.Run("onboard", func(t *testing.T) {
+		run, plan := runInstallerPlan(t, installerScenario(env, allExist))
+
+		assert.Equal(t, "onboard", plan.Mode)
+		assert.Equal(t, []string{"enable-features ConnectedCluster", "update StatusConfigMap"}, plan.changes())
+		assert.Equal(t, []string{"skip", "enable-features"}, plan.actionsFor(env["CONNECTED_CLUSTER"]))
+		assert.Empty(t, plan.actionsFor("Monitoring Metrics Publisher"))
+		assert.Empty(t, run.mutatingCommandNames())
+	})
+
+	t.Run("offboard", func(t *testing.T) {
+		offboardEnv := copyEnv(env)
+		offboardEnv["DELETE_FLAG"] = "true"
+
+		run, plan := runInstallerPlan(t, installerScenario(offboardEnv, allExist))
+
+		assert.Equal(t, "offboard", plan.Mode)
+		assert.Equal(t, []string{
+			"delete DataController",
+			"delete CustomLocation",
+			"delete Extension",
+			"delete CustomResourceDefinitions",
+			"delete MutatingWebhookConfiguration",
+			"delete ConnectedCluster",
+			"delete ResourceGroup",
+			"delete ResourceGroup",
+			"delete Namespace",
+			"update StatusConfigMap",
+		}, plan.changes())
+		assert.Empty(t, run.mutatingCommandNames())
+	})
+}
+
+// The plan is a copy of the script's decisions - replaying every state with and without DRY_RUN catches the two drifting apart
+func TestInstallerPlanMatchesExecution(t *testing.T) {
+	for _, state := range reachableArcResourceStates() {
+		for _, mode := range []string{"onboard", "offboard", "status", "repair"} {
+			for _, openshift := range []string{"false", "true"} {
+				state, mode, openshift := state, mode, openshift
+				name := mode + "_" + state.String()
+				if openshift == "true" {
+					name += "_openshift"
+				}
+
+				t.Run(name, func(t *testing.T) {
+					t.Parallel()
+
+					env := defaultInstallerEnv()
+					env["ACTION"] = mode
+					env["OPENSHIFT"] = openshift
+					scenario := installerScenario(env, state,
+						stubAnswer("kubectl get crd*", "datacontrollers.arcdata.microsoft.com   2022-08-09T00:00:00Z\n"),
+					)
+
+					assertPlanMatchesExecution(t, scenario, mode)
+				})
+			}
+		}
+	}
+}
+
+// Replays a scenario with and without DRY_RUN and checks every planned change was executed, in order, and nothing else
+func assertPlanMatchesExecution(t *testing.T, scenario scriptScenario, mode string) {
+	_, plan := runInstallerPlan(t, scenario)
+	assert.Equal(t, mode, plan.Mode)
+
+	run := runInstallerScript(t, scenario)
+	require.Equal(t, 0, run.ExitCode, run.Output)
+
+	executed := run.mutatingCommandNames()
+	planned := plan.changes()
+	require.Len(t, executed, len(planned), "planned %v, executed %v", planned, executed)
+	for i, change := range planned {
+		command, ok := plannedChangeCommands[change]
+		require.True(t, ok, "no command known for planned change '%s'", change)
+		assert.True(t, strings.HasPrefix(executed[i], command), "planned '%s' as step %d, executed '%s'", change, i, executed[i])
+	}
+}
+
+func TestParseInstallerPlan(t *testing.T) {
+	logs := "INFO | Central Status Check\n" +
+		`INFO | Plan: {"mode":"offboard","actions":[{"step":"0","resource":"Namespace","name":"azure-arc-data","action":"delete"}]}` + "\n" +
+		"INFO | Dry run complete, nothing was changed.\n"
+
+	plan, err := parseInstallerPlan(logs)
+	require.NoError(t, err)
+	assert.Equal(t, installerPlan{Mode: "offboard", Actions: []planAction{{Step: "0", Resource: "Namespace", Name: "azure-arc-data", Action: "delete"}}}, plan)
+
+	_, err = parseInstallerPlan("INFO | Arc Data Services installer script complete\n")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "DRY_RUN=true")
+}
@@ -0,0 +1,198 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	// Azure
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2020-10-01/resources"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// REGISTER_RESOURCE_PROVIDERS of install-arc-data-services.sh - resource providers the subscription must have registered
+
+func TestInstallerResourceProvidersValidation(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run, values := runInstallerValidation(t, env)
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, "false", values["REGISTER_RESOURCE_PROVIDERS"])
+
+	env["REGISTER_RESOURCE_PROVIDERS"] = "yes"
+	run, _ = runInstallerValidation(t, env)
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Contains(t, run.Output, "ERROR | variable REGISTER_RESOURCE_PROVIDERS must be true or false, got 'yes'")
+	assertNoRemoteCalls(t, run)
+}
+
+func TestInstallerResourceProvidersRegistered(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	for _, provider := range arcResourceProviders {
+		assert.Contains(t, run.commandLinesWithPrefix("az provider show"), fmt.Sprintf("az provider show --namespace %s --query registrationState --output tsv", provider))
+		assert.Contains(t, run.Output, fmt.Sprintf("INFO | Resource provider %s is registered", provider))
+	}
+	assert.Empty(t, run.commandLinesWithPrefix("az provider register"))
+}
+
+// Without REGISTER_RESOURCE_PROVIDERS nothing is changed, the providers are only reported
+func TestInstallerResourceProvidersMissing(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists,
+		stubAnswer("az provider show --namespace Microsoft.ExtendedLocation *", "NotRegistered\n"),
+		stubAnswer("az provider show --namespace Microsoft.AzureArcData *", ""),
+	))
+
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Contains(t, run.Output, "INFO | Resource provider Microsoft.ExtendedLocation is NotRegistered")
+	assert.Contains(t, run.Output, "INFO | Resource provider Microsoft.AzureArcData is NotRegistered")
+	assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Subscription %s has not registered resource provider(s) Microsoft.ExtendedLocation Microsoft.AzureArcData, register them or set REGISTER_RESOURCE_PROVIDERS=true, nothing was changed", env["SUBSCRIPTION_ID"]))
+	assert.Empty(t, run.mutatingCommandNames())
+	require.NotNil(t, run.Result)
+	assert.Equal(t, "resourceProviders", run.Result.FailedStep)
+}
+
+// Missing providers are registered first, and onboarding waits until they are
+func TestInstallerResourceProvidersRegister(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["REGISTER_RESOURCE_PROVIDERS"] = "true"
+	scenario := installerScenario(env, nothingExists,
+		stubRule{Pattern: "az provider show --namespace Microsoft.ExtendedLocation *", Responses: []stubResponse{{Stdout: "NotRegistered\n"}, {Stdout: "Registering\n"}, {Stdout: "Registered\n"}}},
+	)
+
+	run := runInstallerScript(t, scenario)
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{"az provider register --namespace Microsoft.ExtendedLocation"}, run.commandLinesWithPrefix("az provider register"))
+	assert.Equal(t, []string{"sleep 30"}, run.commandLinesWithPrefix("sleep"))
+	assert.Contains(t, run.Output, "INFO | Waiting for resource provider(s) Microsoft.ExtendedLocation to register (attempt 1 of 20)...")
+	assert.Contains(t, run.Output, "INFO | Resource provider(s) Microsoft.ExtendedLocation registered")
+
+	// Registered before anything is created
+	mutating := run.mutatingCommandNames()
+	require.NotEmpty(t, mutating)
+	assert.Equal(t, "az provider register", mutating[0])
+
+	assertPlanMatchesExecution(t, scenario, "onboard")
+}
+
+// Registering needs its own permission on the subscription
+func TestInstallerResourceProvidersRegisterPermission(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["REGISTER_RESOURCE_PROVIDERS"] = "true"
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists,
+		stubAnswer("az provider show --namespace Microsoft.AzureArcData *", "NotRegistered\n"),
+		stubAnswer(fmt.Sprintf(permissionsPattern, "*"), permissionsJson([]string{"*"}, []string{"*/register/action"})),
+	))
+
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Missing Azure permission Microsoft.AzureArcData/register/action on /subscriptions/%s - register resource provider Microsoft.AzureArcData", env["SUBSCRIPTION_ID"]))
+	assert.Empty(t, run.mutatingCommandNames())
+}
+
+func TestInstallerResourceProvidersGiveUp(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["REGISTER_RESOURCE_PROVIDERS"] = "true"
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists,
+		stubAnswer("az provider show --namespace Microsoft.Kubernetes *", "Registering\n"),
+	))
+
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Len(t, run.commandLinesWithPrefix("az provider show --namespace Microsoft.Kubernetes "), 21)
+	assert.Len(t, run.commandLinesWithPrefix("sleep"), 19)
+	assert.Contains(t, run.Output, "ERROR | Resource provider(s) Microsoft.Kubernetes did not finish registering, manual intervention is required")
+	assert.Empty(t, run.commandLinesWithPrefix("az group create"))
+	require.NotNil(t, run.Result)
+	assert.Equal(t, "resourceProviders", run.Result.FailedStep)
+}
+
+// Nothing is created by the other actions, so they don't check
+func TestInstallerResourceProvidersOnlyWhenCreating(t *testing.T) {
+	for _, action := range []string{"offboard", "status", "upgrade"} {
+		env := defaultInstallerEnv()
+		env["ACTION"] = action
+
+		run := runInstallerScript(t, installerScenario(env, allExist,
+			stubAnswer("az provider show *", "NotRegistered\n"),
+		))
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Empty(t, run.commandLinesWithPrefix("az provider"), action)
+	}
+}
+
+// Fake ARM endpoint serving the registration state of each provider namespace in states
+func fakeProvidersServer(t *testing.T, subscriptionID string, states map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace := strings.TrimPrefix(r.URL.Path, fmt.Sprintf("/subscriptions/%s/providers/", subscriptionID))
+		state, found := states[namespace]
+		if !found || r.Method != http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error": {"code": "InvalidResourceNamespace", "message": "The resource namespace '%s' is invalid."}}`, namespace)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id": "/subscriptions/%s/providers/%s", "namespace": "%s", "registrationState": "%s"}`, subscriptionID, namespace, namespace, state)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetProviderRegistrationStates(t *testing.T) {
+	subscriptionID := "00000000-0000-0000-0000-000000000001"
+	server := fakeProvidersServer(t, subscriptionID, map[string]string{
+		"Microsoft.Kubernetes":              "Registered",
+		"Microsoft.KubernetesConfiguration": "Registered",
+		"Microsoft.ExtendedLocation":        "Registering",
+		"Microsoft.AzureArcData":            "NotRegistered",
+	})
+	client := resources.NewProvidersClientWithBaseURI(server.URL, subscriptionID)
+
+	states, err := getProviderRegistrationStates(context.Background(), client, arcResourceProviders)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"Microsoft.Kubernetes":              "Registered",
+		"Microsoft.KubernetesConfiguration": "Registered",
+		"Microsoft.ExtendedLocation":        "Registering",
+		"Microsoft.AzureArcData":            "NotRegistered",
+	}, states)
+
+	_, err = getProviderRegistrationStates(context.Background(), client, []string{"Microsoft.Unknown"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reading resource provider Microsoft.Unknown")
+}
+
+func TestCheckProvidersRegistered(t *testing.T) {
+	assert.NoError(t, checkProvidersRegistered(map[string]string{
+		"Microsoft.Kubernetes":              "Registered",
+		"Microsoft.KubernetesConfiguration": "Registered",
+		"Microsoft.ExtendedLocation":        "Registered",
+		"Microsoft.AzureArcData":            "Registered",
+		"Microsoft.Compute":                 "NotRegistered",
+	}))
+
+	err := checkProvidersRegistered(map[string]string{
+		"Microsoft.Kubernetes":              "Registered",
+		"Microsoft.KubernetesConfiguration": "Registered",
+		"Microsoft.ExtendedLocation":        "Registering",
+	})
+	require.Error(t, err)
+	assert.Equal(t, "resource providers are not registered: Microsoft.ExtendedLocation is Registering; Microsoft.AzureArcData is missing", err.Error())
+}
@@ -0,0 +1,180 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"fmt"
+	"strings"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Job result written to the termination message by install-arc-data-services.sh - see script_helpers.go
+
+func TestInstallerResultFreshOnboarding(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	require.NotNil(t, run.Result)
+	result := *run.Result
+
+	assert.Equal(t, "Succeeded", result.Status)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Equal(t, "onboard", result.Mode)
+	assert.Empty(t, result.FailedStep)
+	assert.Equal(t, map[string]string{
+		"releaseTrain":      env["ARC_DATA_RELEASE_TRAIN"],
+		"extensionVersion":  env["ARC_DATA_EXT_VERSION"],
+		"controllerVersion": env["ARC_DATA_CONTROLLER_VERSION"],
+	}, result.Versions)
+
+	resourceGroupId := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", env["SUBSCRIPTION_ID"], env["ARC_DATA_RESOURCE_GROUP"])
+	connectedClusterId := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Kubernetes/connectedClusters/%s", env["SUBSCRIPTION_ID"], env["CONNECTED_CLUSTER_RESOURCE_GROUP"], env["CONNECTED_CLUSTER"])
+	expected := map[string]jobResultResource{
+		"connectedClusterResourceGroup": {Name: env["CONNECTED_CLUSTER_RESOURCE_GROUP"], Status: "Created", ID: fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", env["SUBSCRIPTION_ID"], env["CONNECTED_CLUSTER_RESOURCE_GROUP"])},
+		"arcDataResourceGroup":          {Name: env["ARC_DATA_RESOURCE_GROUP"], Status: "Created", ID: resourceGroupId},
+		"connectedCluster":              {Name: env["CONNECTED_CLUSTER"], Status: "Created", ID: connectedClusterId},
+		"extension":                     {Name: env["ARC_DATA_EXT"], Status: "Created", ID: connectedClusterId + "/providers/Microsoft.KubernetesConfiguration/extensions/" + env["ARC_DATA_EXT"]},
+		"customLocation":                {Name: env["ARC_DATA_NAMESPACE"], Status: "Created", ID: resourceGroupId + "/providers/Microsoft.ExtendedLocation/customLocations/" + env["ARC_DATA_NAMESPACE"]},
+		"dataController":                {Name: env["ARC_DATA_CONTROLLER"], Status: "Ready", ID: resourceGroupId + "/providers/Microsoft.AzureArcData/dataControllers/" + env["ARC_DATA_CONTROLLER"]},
+	}
+	require.Len(t, result.Resources, len(expected))
+	for key, resource := range expected {
+		actual := result.Resources[key]
+		assert.GreaterOrEqual(t, actual.DurationSeconds, 0, key)
+		actual.DurationSeconds = 0
+		assert.Equal(t, resource, actual, key)
+	}
+	assert.GreaterOrEqual(t, result.DurationSeconds, 0)
+
+	// control.json mismatches are kept as warnings
+	env["ARC_DATA_CONTROLLER_VERSION"] = "v9.9.9_2099-01-01"
+	run = runInstallerScript(t, installerScenario(env, allExist))
+	require.NotNil(t, run.Result)
+	warnings := strings.Join(run.Result.Warnings, "\n")
+	assert.Contains(t, warnings, "variable ARC_DATA_CONTROLLER_VERSION = 'v9.9.9_2099-01-01' does not match control.json's spec.docker.imageTag")
+	assert.Contains(t, run.Output, "WARNING | variable ARC_DATA_CONTROLLER_VERSION = 'v9.9.9_2099-01-01'")
+}
+
+func TestInstallerResultExistingResources(t *testing.T) {
+	run := runInstallerScript(t, installerScenario(defaultInstallerEnv(), allExist))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	require.NotNil(t, run.Result)
+	for key, resource := range run.Result.Resources {
+		assert.Equal(t, "Exists", resource.Status, key)
+		assert.NotEmpty(t, resource.ID, key)
+	}
+	assert.Len(t, run.Result.Resources, 6)
+}
+
+func TestInstallerResultOffboarding(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["DELETE_FLAG"] = "true"
+	state := arcResourceState{ConnectedClusterResourceGroup: true, ArcDataResourceGroup: true, ConnectedCluster: true}
+
+	run := runInstallerScript(t, installerScenario(env, state))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	require.NotNil(t, run.Result)
+	assert.Equal(t, "offboard", run.Result.Mode)
+
+	statuses := map[string]string{}
+	for key, resource := range run.Result.Resources {
+		statuses[key] = resource.Status
+		assert.Empty(t, resource.ID, "deleted or absent resources have no ID")
+	}
+	assert.Equal(t, map[string]string{
+		"connectedClusterResourceGroup": "Deleted",
+		"arcDataResourceGroup":          "Deleted",
+		"connectedCluster":              "Deleted",
+		"extension":                     "Absent",
+		"customLocation":                "Absent",
+		"dataController":                "Absent",
+	}, statuses)
+}
+
+func TestInstallerResultFailures(t *testing.T) {
+	t.Run("failed_provisioning", func(t *testing.T) {
+		run := runInstallerScript(t, installerScenario(defaultInstallerEnv(), nothingExists,
+			stubAnswer("az customlocation show *", `{"provisioningState": "Failed"}`),
+		))
+
+		assert.Equal(t, 1, run.ExitCode)
+		require.NotNil(t, run.Result)
+		assert.Equal(t, "Failed", run.Result.Status)
+		assert.Equal(t, 1, run.Result.ExitCode)
+		assert.Equal(t, "customLocation", run.Result.FailedStep)
+		assert.Equal(t, "Failed", run.Result.Resources["customLocation"].Status)
+		assert.Equal(t, "Created", run.Result.Resources["extension"].Status)
+		assert.NotContains(t, run.Result.Resources, "dataController")
+	})
+
+	t.Run("failing_az_command", func(t *testing.T) {
+		run := runInstallerScript(t, installerScenario(defaultInstallerEnv(), nothingExists,
+			stubRule{Pattern: "az connectedk8s connect *", Responses: []stubResponse{{ExitCode: 2}}},
+		))
+
+		assert.Equal(t, 2, run.ExitCode)
+		require.NotNil(t, run.Result)
+		assert.Equal(t, "Failed", run.Result.Status)
+		assert.Equal(t, 2, run.Result.ExitCode)
+		assert.Equal(t, "connectedCluster", run.Result.FailedStep)
+		assert.NotContains(t, run.Result.Resources, "connectedCluster")
+	})
+
+	t.Run("invalid_input", func(t *testing.T) {
+		env := defaultInstallerEnv()
+		delete(env, "TENANT_ID")
+
+		run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+		assert.Equal(t, 1, run.ExitCode)
+		require.NotNil(t, run.Result)
+		assert.Equal(t, "validation", run.Result.FailedStep)
+		assert.Empty(t, run.Result.Resources)
+	})
+}
+
+func TestInstallerResultDataControllerNotReady(t *testing.T) {
+	run := runInstallerScript(t, installerScenario(defaultInstallerEnv(), nothingExists,
+		stubAnswer("az arcdata dc status show *", `{"properties": {"k8SRaw": {"status": {"state": "DeployingMonitoring"}}}}`),
+	))
+
+	// The script gives up waiting without failing - the result says so
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	require.NotNil(t, run.Result)
+	assert.Equal(t, "DeployingMonitoring", run.Result.Resources["dataController"].Status)
+	assert.Contains(t, run.Result.Warnings, "Data Controller azure-arc-data-controller did not reach Ready state in time, last status is DeployingMonitoring")
+	assert.Len(t, run.commandLinesWithPrefix("sleep"), 20)
+}
+
+func TestInstallerResultPlanAndValidateModes(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run, _ := runInstallerPlan(t, installerScenario(env, nothingExists))
+	require.NotNil(t, run.Result)
+	assert.Equal(t, "plan", run.Result.Mode)
+	assert.Empty(t, run.Result.Resources)
+
+	run, _ = runInstallerValidation(t, env)
+	require.NotNil(t, run.Result)
+	assert.Equal(t, "validate", run.Result.Mode)
+	assert.Equal(t, "Succeeded", run.Result.Status)
+}
+
+func TestParseJobResult(t *testing.T) {
+	result, err := parseJobResult(`{"status":"Succeeded","exitCode":0,"mode":"onboard","versions":{"releaseTrain":"preview"},"durationSeconds":12,"resources":{"dataController":{"name":"dc","status":"Ready","durationSeconds":3}},"warnings":[]}`)
+	require.NoError(t, err)
+	assert.Equal(t, "Ready", result.Resources["dataController"].Status)
+	assert.Equal(t, 12, result.DurationSeconds)
+
+	_, err = parseJobResult("")
+	assert.Error(t, err)
+}
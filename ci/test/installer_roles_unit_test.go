@@ -0,0 +1,152 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"fmt"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ARC_DATA_EXT_ROLE_ASSIGNMENTS of install-arc-data-services.sh - roles of the Bootstrapper extension's managed identity
+
+const replayExtensionPrincipalId = "00000000-0000-0000-0000-00000000000a"
+
+func TestInstallerRoleAssignmentsValidation(t *testing.T) {
+	env := defaultInstallerEnv()
+	arcDataRg := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", env["SUBSCRIPTION_ID"], env["ARC_DATA_RESOURCE_GROUP"])
+
+	valid := []struct {
+		assignments string
+		expected    string
+	}{
+		{"", "Contributor@" + arcDataRg + ",Monitoring Metrics Publisher@" + arcDataRg},
+		{"Contributor", "Contributor@" + arcDataRg},
+		{" Reader @ /subscriptions/x , Contributor,", "Reader@/subscriptions/x,Contributor@" + arcDataRg},
+	}
+	for _, tc := range valid {
+		env := copyEnv(env)
+		env["ARC_DATA_EXT_ROLE_ASSIGNMENTS"] = tc.assignments
+
+		run, values := runInstallerValidation(t, env)
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Equal(t, tc.expected, values["ARC_DATA_EXT_ROLE_ASSIGNMENTS"], tc.assignments)
+	}
+
+	for _, assignments := range []string{",", "@/subscriptions/x", "Reader@resourceGroups/x", "Reader@/subscriptions/x@/subscriptions/y"} {
+		env := copyEnv(env)
+		env["ARC_DATA_EXT_ROLE_ASSIGNMENTS"] = assignments
+
+		run, _ := runInstallerValidation(t, env)
+
+		assert.Equal(t, 1, run.ExitCode, assignments)
+		assert.Contains(t, run.Output, fmt.Sprintf("ERROR | variable ARC_DATA_EXT_ROLE_ASSIGNMENTS must be comma separated role names, each optionally followed by @<scope ARM ID>, got '%s'", assignments))
+		assertNoRemoteCalls(t, run)
+	}
+}
+
+// Only the roles an existing extension's identity is missing are assigned, on their own scopes
+func TestInstallerRoleAssignmentsReconciled(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["ARC_DATA_EXT_ROLE_ASSIGNMENTS"] = "Contributor,Reader@/subscriptions/" + env["SUBSCRIPTION_ID"]
+	scenario := installerScenario(env, allExist,
+		stubAnswer("az role assignment list *--role Reader *", "0\n"),
+	)
+
+	run := runInstallerScript(t, scenario)
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{
+		fmt.Sprintf("az role assignment create --assignee-object-id %s --assignee-principal-type ServicePrincipal --role Reader --scope /subscriptions/%s", replayExtensionPrincipalId, env["SUBSCRIPTION_ID"]),
+	}, run.commandLinesWithPrefix("az role assignment create"))
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | Bootstrapper extension %s already has role Contributor on /subscriptions/%s/resourceGroups/%s", env["ARC_DATA_EXT"], env["SUBSCRIPTION_ID"], env["ARC_DATA_RESOURCE_GROUP"]))
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | Bootstrapper extension %s is missing role Reader on /subscriptions/%s", env["ARC_DATA_EXT"], env["SUBSCRIPTION_ID"]))
+
+	assertPlanMatchesExecution(t, scenario, "onboard")
+}
+
+// A new extension's identity takes a while to show up and to be assignable
+func TestInstallerRoleAssignmentsWaitForIdentity(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists,
+		stubRule{Pattern: "az k8s-extension show *--query identity.principalId*", Responses: []stubResponse{{Stdout: ""}, {Stdout: replayExtensionPrincipalId + "\n"}}},
+		stubRule{Pattern: "az role assignment create *--role Contributor *", Responses: []stubResponse{{ExitCode: 1}, {ExitCode: 1}, {}}},
+	))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Len(t, run.commandLinesWithPrefix("az role assignment create"), 4)
+	assert.Equal(t, []string{"sleep 30", "sleep 30", "sleep 30"}, run.commandLinesWithPrefix("sleep"))
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | Waiting for Bootstrapper extension %s's managed identity (attempt 1 of 10)...", env["ARC_DATA_EXT"]))
+	assert.Contains(t, run.Output, "INFO | Role assignment failed, the managed identity may still be propagating (attempt 2 of 10)...")
+}
+
+func TestInstallerRoleAssignmentsGiveUp(t *testing.T) {
+	t.Run("no_identity", func(t *testing.T) {
+		env := defaultInstallerEnv()
+
+		run := runInstallerScript(t, installerScenario(env, nothingExists,
+			stubAnswer("az k8s-extension show *--query identity.principalId*", ""),
+		))
+
+		assert.Equal(t, 1, run.ExitCode)
+		assert.Len(t, run.commandLinesWithPrefix("sleep"), 10)
+		assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Bootstrapper extension %s has no managed identity, manual intervention is required", env["ARC_DATA_EXT"]))
+		assert.Empty(t, run.commandLinesWithPrefix("az role assignment create", "az customlocation create"))
+		require.NotNil(t, run.Result)
+		assert.Equal(t, "roleAssignments", run.Result.FailedStep)
+	})
+
+	t.Run("assignment_fails", func(t *testing.T) {
+		env := defaultInstallerEnv()
+
+		run := runInstallerScript(t, installerScenario(env, nothingExists,
+			stubRule{Pattern: "az role assignment create *", Responses: []stubResponse{{ExitCode: 1}}},
+		))
+
+		assert.Equal(t, 1, run.ExitCode)
+		assert.Len(t, run.commandLinesWithPrefix("az role assignment create"), 10)
+		assert.Len(t, run.commandLinesWithPrefix("sleep"), 9)
+		assert.Contains(t, run.Output, fmt.Sprintf("ERROR | Could not assign role Contributor on /subscriptions/%s/resourceGroups/%s to Bootstrapper extension %s, manual intervention is required", env["SUBSCRIPTION_ID"], env["ARC_DATA_RESOURCE_GROUP"], env["ARC_DATA_EXT"]))
+		assert.Empty(t, run.commandLinesWithPrefix("az customlocation create"))
+		require.NotNil(t, run.Result)
+		assert.Equal(t, "roleAssignments", run.Result.FailedStep)
+	})
+}
+
+func TestParseRoleAssignments(t *testing.T) {
+	assignments, err := parseRoleAssignments(" Reader @ /subscriptions/x , Contributor,", "/subscriptions/x/resourceGroups/y")
+	require.NoError(t, err)
+	assert.Equal(t, []roleAssignment{
+		{Role: "Reader", Scope: "/subscriptions/x"},
+		{Role: "Contributor", Scope: "/subscriptions/x/resourceGroups/y"},
+	}, assignments)
+
+	for _, value := range []string{"", ",", "@/subscriptions/x", "Reader@resourceGroups/x", "Reader@/subscriptions/x@/subscriptions/y"} {
+		_, err := parseRoleAssignments(value, "/subscriptions/x/resourceGroups/y")
+		assert.Error(t, err, value)
+	}
+}
+
+func TestCheckRoleAssignments(t *testing.T) {
+	expected := []roleAssignment{
+		{Role: "Contributor", Scope: "/subscriptions/x/resourceGroups/y"},
+		{Role: "Monitoring Metrics Publisher", Scope: "/subscriptions/x/resourceGroups/y"},
+	}
+
+	// ARM IDs come back in whatever case ARM stores them
+	assert.NoError(t, checkRoleAssignments([]roleAssignment{
+		{Role: "Monitoring Metrics Publisher", Scope: "/subscriptions/x/resourcegroups/y"},
+		{Role: "Contributor", Scope: "/subscriptions/x/resourceGroups/y"},
+		{Role: "Reader", Scope: "/subscriptions/x"},
+	}, expected))
+
+	err := checkRoleAssignments([]roleAssignment{{Role: "Contributor", Scope: "/subscriptions/x"}}, expected)
+	require.Error(t, err)
+	assert.Equal(t, "extension roles are not assigned: Contributor on /subscriptions/x/resourceGroups/y is missing; Monitoring Metrics Publisher on /subscriptions/x/resourceGroups/y is missing", err.Error())
+}
@@ -0,0 +1,189 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"fmt"
+	"strings"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Installation status ConfigMap written by install-arc-data-services.sh - see status_helpers.go
+
+func TestInstallerStatusOnboarding(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	status, err := run.installationStatus(nil)
+	require.NoError(t, err)
+	require.NoError(t, status.validate("Onboarded"))
+
+	resourceGroupId := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", env["SUBSCRIPTION_ID"], env["ARC_DATA_RESOURCE_GROUP"])
+	assert.Equal(t, env["ARC_DATA_RELEASE_TRAIN"], status["releaseTrain"])
+	assert.Equal(t, env["ARC_DATA_EXT_VERSION"], status["extensionVersion"])
+	assert.Equal(t, env["ARC_DATA_CONTROLLER_VERSION"], status["controllerVersion"])
+	assert.Equal(t, env["SUBSCRIPTION_ID"], status["subscriptionId"])
+	assert.Equal(t, env["CONNECTED_CLUSTER_RESOURCE_GROUP"], status["connectedClusterResourceGroup"])
+	assert.Equal(t, env["ARC_DATA_RESOURCE_GROUP"], status["arcDataResourceGroup"])
+	assert.Equal(t, resourceGroupId+"/providers/Microsoft.AzureArcData/dataControllers/"+env["ARC_DATA_CONTROLLER"], status["dataControllerId"])
+	assert.Equal(t, "Ready", status["dataControllerState"])
+
+	// Status is kept in STATUS_NAMESPACE, not the Job's namespace that is deleted with the Job's manifest
+	patches := run.commandLinesWithPrefix("kubectl patch configmap")
+	require.Len(t, patches, 1)
+	assert.Contains(t, patches[0], fmt.Sprintf("-n %s --type merge", statusNamespace))
+	assert.Contains(t, run.Output, "INFO | STATUS_NAMESPACE is not set, defaulting to "+statusNamespace)
+}
+
+func TestInstallerStatusNamespace(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["STATUS_NAMESPACE"] = "platform-status"
+
+	run, values := runInstallerValidation(t, env)
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, "platform-status", values["STATUS_NAMESPACE"])
+
+	// Missing namespace is created before the ConfigMap
+	run = runInstallerScript(t, installerScenario(env, allExist,
+		stubRule{Pattern: "kubectl get namespace platform-status*", Responses: []stubResponse{{ExitCode: 1}}},
+		stubRule{Pattern: "kubectl get configmap " + installationStatusConfigMap + "*", Responses: []stubResponse{{ExitCode: 1}}},
+	))
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{
+		"kubectl create namespace platform-status",
+		"kubectl label namespace platform-status app.kubernetes.io/managed-by=kube-arc-data-services-installer-job",
+		"kubectl create configmap " + installationStatusConfigMap,
+		"kubectl patch configmap " + installationStatusConfigMap,
+	}, run.mutatingCommandNames()[len(run.mutatingCommandNames())-4:])
+
+	// Namespaces offboarding deletes would take the status with them
+	for _, namespace := range []string{"azure-arc", env["ARC_DATA_NAMESPACE"]} {
+		env["STATUS_NAMESPACE"] = namespace
+		run, _ = runInstallerValidation(t, env)
+		assert.Equal(t, 1, run.ExitCode, namespace)
+		assert.Contains(t, run.Output, fmt.Sprintf("ERROR | variable STATUS_NAMESPACE must not be %s, offboarding deletes it", namespace))
+	}
+
+	env["STATUS_NAMESPACE"] = "Platform_Status"
+	run, _ = runInstallerValidation(t, env)
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Contains(t, run.Output, "ERROR | variable STATUS_NAMESPACE must be a Kubernetes namespace name")
+	assertNoRemoteCalls(t, run)
+}
+
+func TestInstallerStatusCreatesConfigMap(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	// Existing ConfigMap is only patched
+	run := runInstallerScript(t, installerScenario(env, allExist))
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Empty(t, run.commandLinesWithPrefix("kubectl create configmap"))
+
+	// Missing ConfigMap is created before it is patched
+	run = runInstallerScript(t, installerScenario(env, allExist,
+		stubRule{Pattern: "kubectl get configmap " + installationStatusConfigMap + "*", Responses: []stubResponse{{ExitCode: 1}}},
+	))
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{
+		"kubectl create configmap " + installationStatusConfigMap,
+		"kubectl patch configmap " + installationStatusConfigMap,
+	}, run.mutatingCommandNames()[len(run.mutatingCommandNames())-2:])
+}
+
+func TestInstallerStatusOffboarding(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["DELETE_FLAG"] = "true"
+
+	run := runInstallerScript(t, installerScenario(env, allExist))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+
+	// Versions of the last onboarding are kept, IDs of deleted resources are dropped
+	onboarded := installationStatus{
+		"state": "Onboarded", "lastOnboardTime": "2022-09-01T10:00:00Z", "releaseTrain": "preview",
+		"connectedClusterId": "/subscriptions/x", "extensionId": "/subscriptions/x", "customLocationId": "/subscriptions/x",
+		"dataControllerId": "/subscriptions/x", "dataControllerState": "Ready",
+	}
+	status, err := run.installationStatus(onboarded)
+	require.NoError(t, err)
+	require.NoError(t, status.validate("Offboarded"))
+	assert.Equal(t, "2022-09-01T10:00:00Z", status["lastOnboardTime"])
+	assert.Equal(t, "preview", status["releaseTrain"])
+	assert.NotContains(t, status, "dataControllerState")
+}
+
+func TestInstallerStatusNotRecorded(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	// Failed onboarding leaves the last recorded status alone
+	run := runInstallerScript(t, installerScenario(env, nothingExists,
+		stubAnswer("az customlocation show *", `{"provisioningState": "Failed"}`),
+	))
+	require.NotEqual(t, 0, run.ExitCode)
+	assert.Empty(t, run.commandLinesWithPrefix("kubectl patch configmap"))
+
+	// Plans and validation change nothing
+	plan := copyEnv(env)
+	plan["DRY_RUN"] = "true"
+	run = runInstallerScript(t, installerScenario(plan, nothingExists))
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Empty(t, run.commandLinesWithPrefix("kubectl get configmap", "kubectl patch configmap"))
+
+	run, _ = runInstallerValidation(t, env)
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assertNoRemoteCalls(t, run)
+}
+
+func TestInstallationStatusValidate(t *testing.T) {
+	onboarded := installationStatus{"state": "Onboarded", "lastOnboardTime": "2022-09-01T10:00:00Z"}
+	for _, key := range installationStatusFactKeys {
+		onboarded[key] = "value"
+	}
+	for _, key := range installationStatusIDKeys {
+		onboarded[key] = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg"
+	}
+	assert.NoError(t, onboarded.validate("Onboarded"))
+
+	offboarded := installationStatus{"state": "Offboarded", "lastOffboardTime": "2022-09-02T10:00:00Z", "releaseTrain": "preview"}
+	assert.NoError(t, offboarded.validate("Offboarded"))
+
+	// Resources an offboarding kept keep their IDs
+	partial, err := offboarded.applyPatch(`{"data": {"kept": "connectedCluster,connectedClusterResourceGroup", "connectedClusterId": "/subscriptions/x"}}`)
+	require.NoError(t, err)
+	assert.NoError(t, partial.validate("Offboarded"))
+	partial["connectedClusterId"] = ""
+	assert.ErrorContains(t, partial.validate("Offboarded"), "the offboarding kept the connectedCluster")
+	delete(partial, "kept")
+	assert.ErrorContains(t, partial.validate("Offboarded"), "connectedClusterId \"\" is still recorded")
+
+	// Every problem is reported at once
+	broken, err := onboarded.applyPatch(`{"data": {"lastOnboardTime": "yesterday", "extensionId": "", "controllerVersion": null}}`)
+	require.NoError(t, err)
+	err = broken.validate("Onboarded")
+	require.Error(t, err)
+	for _, problem := range []string{`lastOnboardTime "yesterday" is not RFC 3339`, `extensionId "" is not an ARM ID`, "controllerVersion is empty"} {
+		assert.Contains(t, err.Error(), problem)
+	}
+
+	err = onboarded.validate("Offboarded")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `state is "Onboarded", expected "Offboarded"`)
+	assert.Contains(t, err.Error(), "lastOffboardTime is empty")
+	assert.Equal(t, len(installationStatusIDKeys), strings.Count(err.Error(), "is still recorded"))
+
+	upgraded, err := onboarded.applyPatch(`{"data": {"lastOnboardTime": null, "lastUpgradeTime": "2022-09-03T10:00:00Z"}}`)
+	require.NoError(t, err)
+	assert.NoError(t, upgraded.validate("Onboarded"))
+
+	assert.Error(t, installationStatus{}.validate("Upgraded"))
+
+	_, err = onboarded.applyPatch("not json")
+	assert.Error(t, err)
+}
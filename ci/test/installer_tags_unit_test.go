@@ -0,0 +1,139 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"fmt"
+	"strings"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// AZURE_TAGS of install-arc-data-services.sh - user-defined tags on everything created, merged into what exists
+
+const replayAzureTags = `{"costCenter":"1234","environment":"dev"}`
+
+func TestInstallerAzureTagsValidation(t *testing.T) {
+	valid := []struct {
+		tags     string
+		expected string
+	}{
+		{"", ""},
+		{"costCenter=1234,environment=dev", replayAzureTags},
+		{" costCenter = 1234 , environment=dev,", replayAzureTags},
+		{`{"costCenter": "1234", "environment": "dev"}`, replayAzureTags},
+		{"owner=Platform Team,empty=", `{"owner":"Platform Team","empty":""}`},
+	}
+	for _, tc := range valid {
+		env := defaultInstallerEnv()
+		env["AZURE_TAGS"] = tc.tags
+
+		run, values := runInstallerValidation(t, env)
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Equal(t, tc.expected, values["AZURE_TAGS"], tc.tags)
+	}
+
+	invalid := []struct {
+		tags     string
+		expected string
+	}{
+		{"costCenter", "ERROR | variable AZURE_TAGS must be comma separated key=value pairs or a JSON object of strings, got 'costCenter'"},
+		{"=1234", "ERROR | variable AZURE_TAGS must be comma separated key=value pairs or a JSON object of strings, got '=1234'"},
+		{`{"costCenter": 1234}`, `ERROR | variable AZURE_TAGS must be comma separated key=value pairs or a JSON object of strings, got '{"costCenter": 1234}'`},
+		{"arc-installer-cluster=other", "ERROR | variable AZURE_TAGS can't set arc-installer-cluster, arc-installer- tags are reserved for ownership"},
+		{"cost/center=1234", `ERROR | variable AZURE_TAGS keys can't contain any of <>%&\?/, got cost/center`},
+	}
+	for _, tc := range invalid {
+		env := defaultInstallerEnv()
+		env["AZURE_TAGS"] = tc.tags
+
+		run, _ := runInstallerValidation(t, env)
+
+		assert.Equal(t, 1, run.ExitCode, tc.tags)
+		assert.Contains(t, run.Output, tc.expected)
+		assertNoRemoteCalls(t, run)
+	}
+
+	tooMany := []string{}
+	for i := 0; i < 48; i++ {
+		tooMany = append(tooMany, fmt.Sprintf("tag%d=%d", i, i))
+	}
+	env := defaultInstallerEnv()
+	env["AZURE_TAGS"] = strings.Join(tooMany, ",")
+	run, _ := runInstallerValidation(t, env)
+	assert.Equal(t, 1, run.ExitCode)
+	assert.Contains(t, run.Output, "ERROR | variable AZURE_TAGS can have at most 47 tags, got 48")
+}
+
+func TestInstallerAzureTagsOnCreate(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["AZURE_TAGS"] = "costCenter=1234,environment=dev"
+	env["INSTALLER_RUN_ID"] = "replay-run"
+	tags := fmt.Sprintf("--tags %s=%s %s=%s %s=replay-run costCenter=1234 environment=dev", ownerTagManagedBy, ownerManagedBy, ownerTagCluster, env["CONNECTED_CLUSTER"], ownerTagRunID)
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	tagged := run.commandLinesWithPrefix("az group create", "az connectedk8s connect", "az tag update")
+	require.Len(t, tagged, 5)
+	for _, line := range tagged {
+		assert.True(t, strings.HasSuffix(line, tags) || strings.Contains(line, tags+" "), line)
+	}
+
+	// Nothing existed, so there was nothing to reconcile
+	assert.Empty(t, run.commandLinesWithPrefix("az tag list"))
+}
+
+func TestInstallerAzureTagsReconciled(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["AZURE_TAGS"] = "costCenter=1234,environment=dev"
+	// Existing resources carry the ownership tags, but not AZURE_TAGS
+	scenario := installerScenario(env, allExist)
+
+	run := runInstallerScript(t, scenario)
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	updates := run.commandLinesWithPrefix("az tag update")
+	require.Len(t, updates, 5)
+	for _, line := range updates {
+		assert.True(t, strings.HasSuffix(line, "--operation Merge --tags costCenter=1234 environment=dev"), line)
+	}
+	assert.Contains(t, run.Output, fmt.Sprintf("INFO | Tags | Connected Cluster %s differs from AZURE_TAGS on costCenter, environment", env["CONNECTED_CLUSTER"]))
+	assertPlanMatchesExecution(t, scenario, "onboard")
+
+	// Only resources missing a tag, or with another value, are updated
+	withTags := fmt.Sprintf(`{"properties": {"tags": {"%s": "%s", "%s": "%s", "costCenter": "1234", "environment": "dev"}}}`, ownerTagManagedBy, ownerManagedBy, ownerTagCluster, env["CONNECTED_CLUSTER"])
+	scenario = installerScenario(env, allExist,
+		stubAnswer("az tag list --resource-id */dataControllers/*", strings.Replace(withTags, `"dev"`, `"prod"`, 1)),
+		stubAnswer("az tag list *", withTags),
+	)
+	run = runInstallerScript(t, scenario)
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{
+		fmt.Sprintf("az tag update --resource-id /subscriptions/%s/resourceGroups/%s/providers/Microsoft.AzureArcData/dataControllers/%s --operation Merge --tags costCenter=1234 environment=dev", env["SUBSCRIPTION_ID"], env["ARC_DATA_RESOURCE_GROUP"], env["ARC_DATA_CONTROLLER"]),
+	}, run.commandLinesWithPrefix("az tag update"))
+	assertPlanMatchesExecution(t, scenario, "onboard")
+
+	// Offboarding and status leave tags alone
+	for _, action := range []string{"offboard", "status"} {
+		env["ACTION"] = action
+		run = runInstallerScript(t, installerScenario(env, allExist))
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Empty(t, run.commandLinesWithPrefix("az tag update"), action)
+	}
+}
+
+func TestCheckAzureTags(t *testing.T) {
+	expected := map[string]string{"costCenter": "1234", "environment": "dev"}
+
+	assert.NoError(t, checkAzureTags(map[string]string{"costCenter": "1234", "environment": "dev", ownerTagCluster: "replay-aks"}, expected))
+
+	err := checkAzureTags(map[string]string{"environment": "prod"}, expected)
+	require.Error(t, err)
+	assert.Equal(t, `AZURE_TAGS are not applied: costCenter is missing; environment is "prod", expected "dev"`, err.Error())
+}
@@ -0,0 +1,436 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"fmt"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Replays install-arc-data-services.sh offline against stub az/kubectl - see script_helpers.go
+
+var (
+	nothingExists = arcResourceState{}
+	allExist      = arcResourceState{
+		ConnectedClusterResourceGroup: true,
+		ArcDataResourceGroup:          true,
+		ConnectedCluster:              true,
+		Extension:                     true,
+		CustomLocation:                true,
+		DataController:                true,
+	}
+)
+
+// Scenario where every status check answers from state, everything created provisions successfully and every existing
+// resource was created by the installer
+func installerScenario(env map[string]string, state arcResourceState, overrides ...stubRule) scriptScenario {
+	rules := append([]stubRule{}, overrides...)
+	rules = append(rules, centralStatusCheckRules(env, state)...)
+	rules = append(rules, provisioningSucceededRules(env)...)
+	rules = append(rules, ownershipTagRules(env)...)
+	rules = append(rules, clusterCapacityRules()...)
+	rules = append(rules, registeredProviderRules()...)
+	rules = append(rules, ownerPermissionRules()...)
+	return scriptScenario{Env: env, Rules: rules}
+}
+
+func TestInstallerFreshOnboarding(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["INSTALLER_RUN_ID"] = "replay-run"
+	tags := fmt.Sprintf("--tags %s=%s %s=%s %s=replay-run", ownerTagManagedBy, ownerManagedBy, ownerTagCluster, env["CONNECTED_CLUSTER"], ownerTagRunID)
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{
+		"az config set core.only_show_errors=true",
+		"az arcdata dc config replace",
+		"az arcdata dc config replace",
+		"kubectl config set-cluster azure-arc-kubernetes-bootstrap",
+		"kubectl config set-credentials azure-arc-kubernetes-bootstrap",
+		"kubectl config set-context azure-arc-kubernetes-bootstrap",
+		"kubectl config use-context azure-arc-kubernetes-bootstrap",
+		"kubectl cluster-info",
+		"kubectl config view",
+		"kubectl get nodes",
+		"kubectl get storageclass",
+		"az",
+		"az login",
+		"az account set",
+		"az account show",
+		"az group list",
+		"az group list",
+		"az provider show",
+		"az provider show",
+		"az provider show",
+		"az provider show",
+		"az rest",
+		"az group create",
+		"az group create",
+		"az connectedk8s connect",
+		"az k8s-extension create",
+		"az k8s-extension show",
+		"az k8s-extension show",
+		"az role assignment create",
+		"az role assignment create",
+		"az connectedk8s show",
+		"az k8s-extension show",
+		"az customlocation create",
+		"az tag update",
+		"az customlocation show",
+		"az arcdata dc create",
+		"az tag update",
+		"az arcdata dc status show",
+		"az arcdata dc status show",
+		"kubectl get namespace azure-arc-data-services-installer",
+		"kubectl get configmap azure-arc-data-services-status",
+		"kubectl patch configmap azure-arc-data-services-status",
+	}, run.commandNames())
+
+	// Resources are created in the right Resource Group and location, with the release pinned on the extension
+	assert.Equal(t, []string{
+		fmt.Sprintf("az group create --resource-group %s --location %s %s", env["CONNECTED_CLUSTER_RESOURCE_GROUP"], env["CONNECTED_CLUSTER_LOCATION"], tags),
+		fmt.Sprintf("az group create --resource-group %s --location %s %s", env["ARC_DATA_RESOURCE_GROUP"], env["ARC_DATA_LOCATION"], tags),
+	}, run.commandLinesWithPrefix("az group create"))
+
+	extensionCreate := run.commandLinesWithPrefix("az k8s-extension create")
+	require.Len(t, extensionCreate, 1)
+	assert.Contains(t, extensionCreate[0], "--release-train preview --version 1.2.20381002 --auto-upgrade false")
+	assert.Contains(t, extensionCreate[0], `systemDefaultValues.image="mcr.microsoft.com/arcdata/preview/arc-bootstrapper:v1.10.0_2022-08-09"`)
+
+	connect := run.commandLinesWithPrefix("az connectedk8s connect")
+	require.Len(t, connect, 1)
+	assert.Contains(t, connect[0], "--custom-locations-oid "+env["CUSTOM_LOCATION_OID"])
+	assert.Contains(t, connect[0], "--onboarding-timeout 1200")
+
+	// The new extension's identity gets its roles on the Arc Data Services Resource Group
+	scope := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", env["SUBSCRIPTION_ID"], env["ARC_DATA_RESOURCE_GROUP"])
+	assert.Equal(t, []string{
+		"az role assignment create --assignee-object-id 00000000-0000-0000-0000-00000000000a --assignee-principal-type ServicePrincipal --role Contributor --scope " + scope,
+		"az role assignment create --assignee-object-id 00000000-0000-0000-0000-00000000000a --assignee-principal-type ServicePrincipal --role Monitoring Metrics Publisher --scope " + scope,
+	}, run.commandLinesWithPrefix("az role assignment create"))
+
+	// Custom Location is bound to the ids returned by the show commands
+	customLocationCreate := run.commandLinesWithPrefix("az customlocation create")
+	require.Len(t, customLocationCreate, 1)
+	assert.Contains(t, customLocationCreate[0], "--host-resource-id /subscriptions/"+env["SUBSCRIPTION_ID"])
+	assert.Contains(t, customLocationCreate[0], "--cluster-extension-ids /subscriptions/")
+
+	assert.Empty(t, run.commandLinesWithPrefix("sleep"))
+	assert.Contains(t, run.Output, "INFO | Arc Data Services installer script complete")
+}
+
+func TestInstallerOnboardingWhenEverythingExists(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run := runInstallerScript(t, installerScenario(env, allExist))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+
+	// Only the idempotent steps run against existing resources, the extension's identity already has its roles
+	assert.Equal(t, []string{
+		"az connectedk8s enable-features",
+		"kubectl patch configmap azure-arc-data-services-status",
+	}, run.mutatingCommandNames())
+	assert.Len(t, run.commandLinesWithPrefix("az role assignment list"), 2)
+
+	for _, resource := range []string{"Connected Cluster Resource Group", "Arc Data Services Resource Group", "Connected Cluster", "Bootstrapper extension", "Custom Location", "Data Controller"} {
+		assert.Contains(t, run.Output, "INFO | "+resource+" ")
+	}
+	assert.Contains(t, run.Output, "already exists, skipping create")
+}
+
+func TestInstallerOnboardingResumesAfterPartialRun(t *testing.T) {
+	env := defaultInstallerEnv()
+	state := arcResourceState{
+		ConnectedClusterResourceGroup: true,
+		ArcDataResourceGroup:          true,
+		ConnectedCluster:              true,
+		Extension:                     true,
+	}
+
+	run := runInstallerScript(t, installerScenario(env, state))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{
+		"az connectedk8s enable-features",
+		"az customlocation create",
+		"az tag update",
+		"az arcdata dc create",
+		"az tag update",
+		"kubectl patch configmap azure-arc-data-services-status",
+	}, run.mutatingCommandNames())
+}
+
+func TestInstallerOffboardingDeletesInReverseOrder(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["DELETE_FLAG"] = "true"
+
+	run := runInstallerScript(t, installerScenario(env, allExist,
+		stubAnswer("kubectl get crd*", "datacontrollers.arcdata.microsoft.com   2022-08-09T00:00:00Z\nsqlmanagedinstances.sql.arcdata.microsoft.com   2022-08-09T00:00:00Z\n"),
+	))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, []string{
+		fmt.Sprintf("az arcdata dc delete --name %s --subscription %s --resource-group %s --yes", env["ARC_DATA_CONTROLLER"], env["SUBSCRIPTION_ID"], env["ARC_DATA_RESOURCE_GROUP"]),
+		fmt.Sprintf("az customlocation delete --name %s --resource-group %s --yes", env["ARC_DATA_NAMESPACE"], env["ARC_DATA_RESOURCE_GROUP"]),
+		fmt.Sprintf("az k8s-extension delete --name %s --cluster-type connectedClusters --cluster-name %s --resource-group %s --yes", env["ARC_DATA_EXT"], env["CONNECTED_CLUSTER"], env["CONNECTED_CLUSTER_RESOURCE_GROUP"]),
+		"kubectl delete crd datacontrollers.arcdata.microsoft.com sqlmanagedinstances.sql.arcdata.microsoft.com --ignore-not-found=true",
+		fmt.Sprintf("kubectl delete mutatingwebhookconfiguration arcdata.microsoft.com-webhook-%s --ignore-not-found=true", env["ARC_DATA_NAMESPACE"]),
+		fmt.Sprintf("az connectedk8s delete --name %s --resource-group %s --yes", env["CONNECTED_CLUSTER"], env["CONNECTED_CLUSTER_RESOURCE_GROUP"]),
+		fmt.Sprintf("az group delete --resource-group %s --yes", env["ARC_DATA_RESOURCE_GROUP"]),
+		fmt.Sprintf("az group delete --resource-group %s --yes", env["CONNECTED_CLUSTER_RESOURCE_GROUP"]),
+		fmt.Sprintf("kubectl delete --ignore-not-found=true namespace %s", env["ARC_DATA_NAMESPACE"]),
+	}, run.commandLinesWithPrefix("az arcdata dc delete", "az customlocation delete", "az k8s-extension delete", "kubectl delete", "az connectedk8s delete", "az group delete"))
+
+	// Offboarding never creates anything
+	assert.Empty(t, run.commandLinesWithPrefix("az group create", "az connectedk8s connect", "az k8s-extension create", "az customlocation create", "az arcdata dc create"))
+	assert.Contains(t, run.Output, "INFO | Destruction complete.")
+}
+
+func TestInstallerOffboardingWhenNothingExists(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["DELETE_FLAG"] = "true"
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+
+	// Only the namespace cleanup runs - it is safe against a missing namespace
+	assert.Equal(t, []string{
+		fmt.Sprintf("kubectl delete --ignore-not-found=true namespace %s", env["ARC_DATA_NAMESPACE"]),
+	}, run.commandLinesWithPrefix("az arcdata dc delete", "az customlocation delete", "az k8s-extension delete", "kubectl delete", "az connectedk8s delete", "az group delete"))
+
+	// Without the Resource Groups the Central Status Check stops at step 1
+	assert.Empty(t, run.commandLinesWithPrefix("az resource list", "az k8s-extension list"))
+}
+
+func TestInstallerOpenShift(t *testing.T) {
+	t.Run("onboarding_applies_scc_first_and_routes_last", func(t *testing.T) {
+		env := defaultInstallerEnv()
+		env["OPENSHIFT"] = "true"
+
+		run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		mutating := run.mutatingCommandNames()
+		require.NotEmpty(t, mutating)
+		assert.Equal(t, "kubectl apply", mutating[0])
+		assert.Equal(t, "kubectl apply", mutating[len(mutating)-2], "routes are applied after the Data Controller, before the status is recorded")
+		assert.Equal(t, []string{
+			"kubectl apply -f ./openshift/arc-data-scc.yaml",
+			fmt.Sprintf("kubectl apply -n %s -f ./openshift/arc-data-routes.yaml", env["ARC_DATA_NAMESPACE"]),
+		}, run.commandLinesWithPrefix("kubectl apply"))
+	})
+
+	t.Run("offboarding_removes_routes_and_scc_before_namespace", func(t *testing.T) {
+		env := defaultInstallerEnv()
+		env["OPENSHIFT"] = "true"
+		env["DELETE_FLAG"] = "true"
+
+		run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Equal(t, []string{
+			fmt.Sprintf("kubectl delete --ignore-not-found=true -n %s -f ./openshift/arc-data-routes.yaml", env["ARC_DATA_NAMESPACE"]),
+			fmt.Sprintf("kubectl delete --ignore-not-found=true -n %s -f ./openshift/arc-data-scc.yaml", env["ARC_DATA_NAMESPACE"]),
+			fmt.Sprintf("kubectl delete --ignore-not-found=true namespace %s", env["ARC_DATA_NAMESPACE"]),
+		}, run.commandLinesWithPrefix("kubectl delete"))
+	})
+
+	t.Run("not_applied_outside_openshift", func(t *testing.T) {
+		run := runInstallerScript(t, installerScenario(defaultInstallerEnv(), nothingExists))
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Empty(t, run.commandLinesWithPrefix("kubectl apply"))
+	})
+}
+
+func TestInstallerStopsOnFailedProvisioning(t *testing.T) {
+	t.Run("bootstrapper_extension", func(t *testing.T) {
+		env := defaultInstallerEnv()
+
+		run := runInstallerScript(t, installerScenario(env, nothingExists,
+			stubAnswer("az k8s-extension show *", `{"provisioningState": "Failed"}`),
+		))
+
+		assert.Equal(t, 1, run.ExitCode)
+		assert.Contains(t, run.Output, "ERROR | Bootstrapper extension arc-data-bootstrapper provisioning status is Failed, manual intervention is required")
+		assert.Empty(t, run.commandLinesWithPrefix("az customlocation create", "az arcdata dc create"))
+	})
+
+	t.Run("custom_location", func(t *testing.T) {
+		env := defaultInstallerEnv()
+
+		run := runInstallerScript(t, installerScenario(env, nothingExists,
+			stubAnswer("az customlocation show *", `{"provisioningState": "Failed"}`),
+		))
+
+		assert.Equal(t, 1, run.ExitCode)
+		assert.Contains(t, run.Output, "ERROR | Custom Location azure-arc-data provisioning status is Failed, manual intervention is required")
+		assert.Empty(t, run.commandLinesWithPrefix("az arcdata dc create"))
+	})
+
+	t.Run("data_controller", func(t *testing.T) {
+		env := defaultInstallerEnv()
+
+		run := runInstallerScript(t, installerScenario(env, nothingExists,
+			stubAnswer("az arcdata dc status show *", `{"properties": {"k8SRaw": {"status": {"state": "Failed"}}}}`),
+		))
+
+		assert.Equal(t, 1, run.ExitCode)
+		assert.Contains(t, run.Output, "ERROR | Data Controller azure-arc-data-controller provisioning status is Failed, manual intervention is required")
+	})
+
+	t.Run("failing_az_command", func(t *testing.T) {
+		env := defaultInstallerEnv()
+
+		run := runInstallerScript(t, installerScenario(env, nothingExists,
+			stubRule{Pattern: "az connectedk8s connect *", Responses: []stubResponse{{ExitCode: 2}}},
+		))
+
+		// set -e stops the script at the failing command
+		assert.Equal(t, 2, run.ExitCode)
+		assert.Equal(t, "az connectedk8s connect", run.commandNames()[len(run.Commands)-1])
+	})
+}
+
+func TestInstallerWaitsForDataControllerReady(t *testing.T) {
+	env := defaultInstallerEnv()
+	pending := stubResponse{Stdout: `{"properties": {"k8SRaw": {"status": {"state": "DeployingMonitoring"}}}}`}
+	ready := stubResponse{Stdout: `{"properties": {"k8SRaw": {"status": {"state": "Ready"}}}}`}
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists,
+		stubRule{Pattern: "az arcdata dc status show *", Responses: []stubResponse{pending, pending, pending, ready}},
+	))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Len(t, run.commandLinesWithPrefix("az arcdata dc status show"), 4)
+	assert.Equal(t, []string{"sleep 30", "sleep 30"}, run.commandLinesWithPrefix("sleep"))
+	assert.Contains(t, run.Output, "INFO | Waiting for Data Controller azure-arc-data-controller to go to ready state (attempt 3 of 20)...")
+	assert.NotContains(t, run.Output, "(attempt 4 of 20)")
+}
+
+func TestInstallerVerbose(t *testing.T) {
+	env := defaultInstallerEnv()
+	env["VERBOSE"] = "true"
+
+	run := runInstallerScript(t, installerScenario(env, nothingExists))
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Empty(t, run.commandLinesWithPrefix("az config set"))
+	for _, line := range run.commandLinesWithPrefix("az group create", "az connectedk8s connect", "az k8s-extension create", "az customlocation create", "az arcdata dc create") {
+		assert.Contains(t, line, "--debug --verbose")
+	}
+}
+
+// Onboarding creates exactly what is missing, in dependency order, and re-applies the idempotent steps to what exists
+//
+// A new extension's identity has no roles yet, an existing one already holds them.
+func expectedOnboardingCommands(state arcResourceState) []string {
+	expected := []string{}
+	if !state.ConnectedClusterResourceGroup {
+		expected = append(expected, "az group create")
+	}
+	if !state.ArcDataResourceGroup {
+		expected = append(expected, "az group create")
+	}
+	if state.ConnectedCluster {
+		expected = append(expected, "az connectedk8s enable-features")
+	} else {
+		expected = append(expected, "az connectedk8s connect")
+	}
+	if !state.Extension {
+		expected = append(expected, "az k8s-extension create", "az role assignment create", "az role assignment create")
+	}
+	if !state.CustomLocation {
+		expected = append(expected, "az customlocation create", "az tag update")
+	}
+	if !state.DataController {
+		expected = append(expected, "az arcdata dc create", "az tag update")
+	}
+	return append(expected, "kubectl patch configmap azure-arc-data-services-status")
+}
+
+// Offboarding deletes exactly what exists, in reverse dependency order - CRDs and webhooks only go with the extension
+func expectedOffboardingCommands(env map[string]string, state arcResourceState) []string {
+	expected := []string{}
+	if state.DataController {
+		expected = append(expected, "az arcdata dc delete")
+	}
+	if state.CustomLocation {
+		expected = append(expected, "az customlocation delete")
+	}
+	if state.Extension {
+		expected = append(expected, "az k8s-extension delete", "kubectl delete crd datacontrollers.arcdata.microsoft.com", "kubectl delete mutatingwebhookconfiguration arcdata.microsoft.com-webhook-"+env["ARC_DATA_NAMESPACE"])
+	}
+	if state.ConnectedCluster {
+		expected = append(expected, "az connectedk8s delete")
+	}
+	if state.ArcDataResourceGroup {
+		expected = append(expected, "az group delete")
+	}
+	if state.ConnectedClusterResourceGroup {
+		expected = append(expected, "az group delete")
+	}
+	// Namespace cleanup always runs, with --ignore-not-found, then the status is recorded
+	return append(expected, "kubectl delete", "kubectl patch configmap azure-arc-data-services-status")
+}
+
+func TestInstallerExistenceCombinations(t *testing.T) {
+	states := reachableArcResourceStates()
+	require.Len(t, states, 10)
+
+	for _, state := range states {
+		state := state
+
+		t.Run("onboard_"+state.String(), func(t *testing.T) {
+			env := defaultInstallerEnv()
+
+			run := runInstallerScript(t, installerScenario(env, state))
+
+			require.Equal(t, 0, run.ExitCode, run.Output)
+			assert.Equal(t, expectedOnboardingCommands(state), run.mutatingCommandNames())
+
+			// Children are only queried below an existing parent
+			queries := 0
+			if state.ConnectedClusterResourceGroup && state.ArcDataResourceGroup {
+				queries++
+				if state.ConnectedCluster {
+					queries++
+					if state.CustomLocation {
+						queries++
+					}
+				}
+			}
+			assert.Len(t, run.commandLinesWithPrefix("az resource list"), queries)
+		})
+
+		t.Run("offboard_"+state.String(), func(t *testing.T) {
+			env := defaultInstallerEnv()
+			env["DELETE_FLAG"] = "true"
+
+			run := runInstallerScript(t, installerScenario(env, state,
+				stubAnswer("kubectl get crd*", "datacontrollers.arcdata.microsoft.com   2022-08-09T00:00:00Z\n"),
+			))
+
+			require.Equal(t, 0, run.ExitCode, run.Output)
+			assert.Equal(t, expectedOffboardingCommands(env, state), run.mutatingCommandNames())
+
+			// Resource Groups are deleted by name, Arc Data first
+			groupDeletes := []string{}
+			if state.ArcDataResourceGroup {
+				groupDeletes = append(groupDeletes, fmt.Sprintf("az group delete --resource-group %s --yes", env["ARC_DATA_RESOURCE_GROUP"]))
+			}
+			if state.ConnectedClusterResourceGroup {
+				groupDeletes = append(groupDeletes, fmt.Sprintf("az group delete --resource-group %s --yes", env["CONNECTED_CLUSTER_RESOURCE_GROUP"]))
+			}
+			assert.Equal(t, groupDeletes, run.commandLinesWithPrefix("az group delete"))
+		})
+	}
+}
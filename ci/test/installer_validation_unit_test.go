@@ -0,0 +1,218 @@
+//go:build unit
+
+package test
+
+import (
+	// Native
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Input validation contract of install-arc-data-services.sh, run with VALIDATE_ONLY=true - see script_helpers.go
+
+// Fails if the run touched Azure or the cluster - only local az configuration is allowed during validation
+func assertNoRemoteCalls(t *testing.T, run *scriptRun) {
+	for _, name := range run.commandNames() {
+		assert.Contains(t, []string{"az config set core.only_show_errors=true", "az arcdata dc config replace"}, name, "unexpected call during validation")
+	}
+}
+
+func TestInstallerValidationRequiredVariables(t *testing.T) {
+	testCases := []struct {
+		key      string
+		expected string
+	}{
+		{"ARC_DATA_RELEASE_TRAIN", "ERROR | variable ARC_DATA_RELEASE_TRAIN is required for onboarding, please pass in through container image's release.env"},
+		{"ARC_DATA_EXT_VERSION", "ERROR | variable ARC_DATA_EXT_VERSION is required for onboarding, please pass in through container image's release.env"},
+		{"CONNECTED_CLUSTER_LOCATION", "ERROR | variable CONNECTED_CLUSTER_LOCATION is required"},
+		{"TENANT_ID", "ERROR | variable TENANT_ID is required."},
+		{"SUBSCRIPTION_ID", "ERROR | variable SUBSCRIPTION_ID is required."},
+		{"CLIENT_ID", "ERROR | variable CLIENT_ID is required."},
+		{"CLIENT_SECRET", "ERROR | variable CLIENT_SECRET is required."},
+		{"CONNECTED_CLUSTER_RESOURCE_GROUP", "ERROR | variable CONNECTED_CLUSTER_RESOURCE_GROUP is required."},
+		{"CONNECTED_CLUSTER", "ERROR | variable CONNECTED_CLUSTER is required."},
+		{"ARC_DATA_RESOURCE_GROUP", "ERROR | variable ARC_DATA_RESOURCE_GROUP is required."},
+		{"ARC_DATA_NAMESPACE", "ERROR | variable ARC_DATA_NAMESPACE is required."},
+		{"ARC_DATA_CONTROLLER", "ERROR | variable ARC_DATA_CONTROLLER is required."},
+		{"AZDATA_USERNAME", "ERROR | variable AZDATA_USERNAME is required."},
+		{"AZDATA_PASSWORD", "ERROR | variable AZDATA_PASSWORD is required."},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.key+"_missing", func(t *testing.T) {
+			env := defaultInstallerEnv()
+			delete(env, tc.key)
+
+			run, values := runInstallerValidation(t, env)
+
+			assert.Equal(t, 1, run.ExitCode)
+			assert.Contains(t, run.Output, tc.expected+"\n")
+			assert.Empty(t, values)
+			assertNoRemoteCalls(t, run)
+		})
+
+		// An empty ConfigMap or Secret value is as good as missing
+		t.Run(tc.key+"_empty", func(t *testing.T) {
+			env := defaultInstallerEnv()
+			env[tc.key] = ""
+
+			run, _ := runInstallerValidation(t, env)
+
+			assert.Equal(t, 1, run.ExitCode)
+			assert.Contains(t, run.Output, tc.expected+"\n")
+		})
+	}
+}
+
+func TestInstallerValidationPasses(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run, values := runInstallerValidation(t, env)
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Contains(t, run.Output, "INFO | Validation complete.")
+	assertNoRemoteCalls(t, run)
+
+	// Given values are passed through untouched
+	for _, key := range []string{"ARC_DATA_RELEASE_TRAIN", "ARC_DATA_EXT_VERSION", "ARC_DATA_CONTROLLER_VERSION", "TENANT_ID", "SUBSCRIPTION_ID", "CLIENT_ID", "CONNECTED_CLUSTER_RESOURCE_GROUP", "CONNECTED_CLUSTER_LOCATION", "CONNECTED_CLUSTER", "ARC_DATA_RESOURCE_GROUP", "ARC_DATA_LOCATION", "ARC_DATA_EXT", "ARC_DATA_NAMESPACE", "ARC_DATA_CONTROLLER", "ARC_DATA_CONTROLLER_LOCATION", "AZDATA_USERNAME", "CUSTOM_LOCATION_OID", "ONBOARDING_TIMEOUT", "DELETE_FLAG", "OPENSHIFT"} {
+		assert.Equal(t, env[key], values[key], key)
+	}
+
+	// Secrets are never printed
+	assert.NotContains(t, run.Output, env["CLIENT_SECRET"])
+	assert.NotContains(t, run.Output, env["AZDATA_PASSWORD"])
+}
+
+func TestInstallerValidationDefaults(t *testing.T) {
+	testCases := []struct {
+		name     string
+		unset    []string
+		expected map[string]string
+		output   string
+	}{
+		{
+			name:     "arc_data_location_from_connected_cluster_location",
+			unset:    []string{"ARC_DATA_LOCATION"},
+			expected: map[string]string{"ARC_DATA_LOCATION": "eastus", "ARC_DATA_CONTROLLER_LOCATION": "southeastasia"},
+			output:   "INFO | variable ARC_DATA_LOCATION is not set, defaulting to CONNECTED_CLUSTER_LOCATION",
+		},
+		{
+			name:     "controller_location_from_arc_data_location",
+			unset:    []string{"ARC_DATA_CONTROLLER_LOCATION"},
+			expected: map[string]string{"ARC_DATA_CONTROLLER_LOCATION": "westeurope"},
+			output:   "INFO | variable ARC_DATA_CONTROLLER_LOCATION is not set, defaulting to ARC_DATA_LOCATION",
+		},
+		{
+			name:     "controller_location_chains_to_connected_cluster_location",
+			unset:    []string{"ARC_DATA_LOCATION", "ARC_DATA_CONTROLLER_LOCATION"},
+			expected: map[string]string{"ARC_DATA_LOCATION": "eastus", "ARC_DATA_CONTROLLER_LOCATION": "eastus"},
+		},
+		{
+			name:     "extension_name",
+			unset:    []string{"ARC_DATA_EXT"},
+			expected: map[string]string{"ARC_DATA_EXT": "arc-data-bootstrapper"},
+			output:   "INFO | variable ARC_DATA_EXT is not set, defaulting to arc-data-bootstrapper",
+		},
+		{
+			name:     "delete_flag",
+			unset:    []string{"DELETE_FLAG"},
+			expected: map[string]string{"DELETE_FLAG": "false"},
+			output:   "INFO | DELETE_FLAG is not set, defaulting to false",
+		},
+		{
+			name:     "openshift",
+			unset:    []string{"OPENSHIFT"},
+			expected: map[string]string{"OPENSHIFT": "false"},
+			output:   "INFO | OPENSHIFT is not set, defaulting to false",
+		},
+		{
+			name:     "optional_custom_location_oid_and_timeout",
+			unset:    []string{"CUSTOM_LOCATION_OID", "ONBOARDING_TIMEOUT"},
+			expected: map[string]string{"CUSTOM_LOCATION_OID": "", "ONBOARDING_TIMEOUT": ""},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			env := defaultInstallerEnv()
+			env["ARC_DATA_LOCATION"] = "westeurope"
+			env["ARC_DATA_CONTROLLER_LOCATION"] = "southeastasia"
+			for _, key := range tc.unset {
+				delete(env, key)
+			}
+
+			run, values := runInstallerValidation(t, env)
+
+			require.Equal(t, 0, run.ExitCode, run.Output)
+			for key, expected := range tc.expected {
+				assert.Equal(t, expected, values[key], key)
+			}
+			assert.Contains(t, run.Output, tc.output)
+		})
+	}
+}
+
+func TestInstallerValidationMirrorsAzdataCredentials(t *testing.T) {
+	env := defaultInstallerEnv()
+
+	run, values := runInstallerValidation(t, env)
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, env["AZDATA_USERNAME"], values["AZDATA_LOGSUI_USERNAME"])
+	assert.Equal(t, env["AZDATA_USERNAME"], values["AZDATA_METRICSUI_USERNAME"])
+	assert.Equal(t, "<same as AZDATA_PASSWORD>", values["AZDATA_LOGSUI_PASSWORD"])
+	assert.Equal(t, "<same as AZDATA_PASSWORD>", values["AZDATA_METRICSUI_PASSWORD"])
+
+	// Mirrored values always win over ones passed in
+	env["AZDATA_LOGSUI_USERNAME"] = "someone-else"
+	env["AZDATA_METRICSUI_PASSWORD"] = "another-password"
+
+	run, values = runInstallerValidation(t, env)
+
+	require.Equal(t, 0, run.ExitCode, run.Output)
+	assert.Equal(t, env["AZDATA_USERNAME"], values["AZDATA_LOGSUI_USERNAME"])
+	assert.Equal(t, "<same as AZDATA_PASSWORD>", values["AZDATA_METRICSUI_PASSWORD"])
+}
+
+func TestInstallerValidationControllerRepository(t *testing.T) {
+	for train, repository := range map[string]string{"stable": "arcdata", "preview": "arcdata/preview"} {
+		train, repository := train, repository
+		t.Run(train, func(t *testing.T) {
+			env := defaultInstallerEnv()
+			env["ARC_DATA_RELEASE_TRAIN"] = train
+
+			run, values := runInstallerValidation(t, env)
+
+			require.Equal(t, 0, run.ExitCode, run.Output)
+			assert.Equal(t, repository, values["ARC_DATA_CONTROLLER_DESIRED_REPO"])
+			assert.Contains(t, run.commandLines(), "az arcdata dc config replace --path /tmp/custom/control.json --json-values spec.docker.repository="+repository)
+			assert.Contains(t, run.commandLines(), "az arcdata dc config replace --path /tmp/custom/control.json --json-values spec.docker.imageTag="+env["ARC_DATA_CONTROLLER_VERSION"])
+		})
+	}
+}
+
+func TestInstallerValidationVerbose(t *testing.T) {
+	t.Run("unset_defaults_to_verbose", func(t *testing.T) {
+		env := defaultInstallerEnv()
+		delete(env, "VERBOSE")
+
+		run, _ := runInstallerValidation(t, env)
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Contains(t, run.Output, "INFO | Verbose flag not set, defaulting to true")
+		assert.Empty(t, run.commandLinesWithPrefix("az config set"))
+	})
+
+	t.Run("false_silences_az", func(t *testing.T) {
+		run, _ := runInstallerValidation(t, defaultInstallerEnv())
+
+		require.Equal(t, 0, run.ExitCode, run.Output)
+		assert.Equal(t, []string{"az config set core.only_show_errors=true --only-show-errors"}, run.commandLinesWithPrefix("az config set"))
+	})
+}
@@ -12,7 +12,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gruntwork-io/terratest/modules/files"
 	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/logger"
 	"github.com/stretchr/testify/require"
 )
 
@@ -51,6 +53,22 @@ func generateKustomizedManifest(t *testing.T, kustomizePath, payloadPath string)
 	return payloadPath
 }
 
+// Copies the repo's Kustomize folder into a workspace and returns the path to the copy
+// workspaceDir - e.g. the release train's Terraform module folder, so the copy lives and dies with the rest of its test data
+func copyKustomizeToWorkspace(t *testing.T, workspaceDir string) string {
+	kustomizeRoot, err := filepath.Abs(filepath.Join(workspaceDir, ".test-data", "kustomize"))
+	require.NoError(t, err)
+
+	// Always start from the repo's current Kustomize files
+	deleteDir(t, kustomizeRoot)
+	createDirIfNotExist(t, kustomizeRoot)
+
+	err = files.CopyFolderContents(k8sPayloadRootDir, kustomizeRoot)
+	require.NoError(t, err)
+
+	return kustomizeRoot
+}
+
 // If directory does not exist, create it
 func createDirIfNotExist(t *testing.T, dir string) {
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
@@ -103,3 +121,19 @@ func getAllMicrosoftCrdApiGroups(t *testing.T, options *k8s.KubectlOptions) []st
 
 	return microsoftApiGroups
 }
+
+// Reads the installer's job result from the termination message of the Job's most recent Pod - nil if there is none
+func getJobResultWithK8s(t *testing.T, options *k8s.KubectlOptions, jobName string) *jobResult {
+	jsonPathQuery := "{.items[-1:].status.containerStatuses[0].state.terminated.message}"
+	message, err := k8s.RunKubectlAndGetOutputE(t, options, "get", "pods", "-l", fmt.Sprintf("job-name=%s", jobName), "--sort-by=.metadata.creationTimestamp", fmt.Sprintf("-o=jsonpath=%s", jsonPathQuery))
+	require.NoError(t, err)
+
+	result, err := parseJobResult(message)
+	if err != nil {
+		logger.Logf(t, "No job result for Job %s: %s", jobName, err)
+		return nil
+	}
+	logger.Logf(t, "Job Result: %s", message)
+
+	return &result
+}
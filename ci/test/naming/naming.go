@@ -0,0 +1,147 @@
+package naming
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Naming rules and regions of install-arc-data-services.sh - the installer checks the same before any Azure call, and
+// TestInstallerScriptInSync fails when its copy differs from this one
+
+// A naming rule for one of the installer's variables
+type Rule struct {
+	Variable    string
+	Pattern     *regexp.Regexp
+	MaxLength   int
+	Description string
+}
+
+var (
+	resourceGroupPattern    = regexp.MustCompile(`^[-_.()a-zA-Z0-9]*[-_()a-zA-Z0-9]$`)
+	connectedClusterPattern = regexp.MustCompile(`^[a-zA-Z0-9]([-_a-zA-Z0-9]*[a-zA-Z0-9])?$`)
+	extensionPattern        = regexp.MustCompile(`^[a-z0-9]([-.a-z0-9]*[a-z0-9])?$`)
+	dnsLabelPattern         = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// Rules for every name the installer creates, in the order it checks them
+var Rules = []Rule{
+	{"CONNECTED_CLUSTER_RESOURCE_GROUP", resourceGroupPattern, 90, "at most 90 letters, digits, '-', '_', '.', '(' or ')', not ending in '.'"},
+	{"ARC_DATA_RESOURCE_GROUP", resourceGroupPattern, 90, "at most 90 letters, digits, '-', '_', '.', '(' or ')', not ending in '.'"},
+	{"CONNECTED_CLUSTER", connectedClusterPattern, 63, "at most 63 letters, digits, '-' or '_', starting and ending with a letter or digit"},
+	// Also the Helm release name of the extension
+	{"ARC_DATA_EXT", extensionPattern, 53, "at most 53 lowercase letters, digits, '-' or '.', starting and ending with a letter or digit"},
+	// Names both the Custom Location and its Kubernetes namespace
+	{"ARC_DATA_NAMESPACE", dnsLabelPattern, 63, "a Kubernetes namespace name, at most 63 lowercase letters, digits or '-', starting and ending with a letter or digit"},
+	{"ARC_DATA_CONTROLLER", dnsLabelPattern, 63, "at most 63 lowercase letters, digits or '-', starting and ending with a letter or digit"},
+}
+
+// Resource types the installer creates, with the regions each is available in
+type ResourceType struct {
+	Name    string
+	Regions []string
+}
+
+var (
+	ResourceGroups = ResourceType{"resource groups", []string{
+		"australiacentral", "australiacentral2", "australiaeast", "australiasoutheast", "brazilsouth", "brazilsoutheast",
+		"canadacentral", "canadaeast", "centralindia", "centralus", "eastasia", "eastus", "eastus2", "francecentral", "francesouth",
+		"germanynorth", "germanywestcentral", "japaneast", "japanwest", "jioindiacentral", "jioindiawest", "koreacentral",
+		"koreasouth", "northcentralus", "northeurope", "norwayeast", "norwaywest", "qatarcentral", "southafricanorth",
+		"southafricawest", "southcentralus", "southeastasia", "southindia", "swedencentral", "switzerlandnorth",
+		"switzerlandwest", "uaecentral", "uaenorth", "uksouth", "ukwest", "westcentralus", "westeurope", "westindia", "westus",
+		"westus2", "westus3",
+	}}
+	ConnectedClusters = ResourceType{"Arc-enabled Kubernetes clusters", []string{
+		"australiaeast", "brazilsouth", "canadacentral", "canadaeast", "centralindia", "centralus", "eastasia", "eastus",
+		"eastus2", "francecentral", "germanywestcentral", "japaneast", "koreacentral", "northcentralus", "northeurope",
+		"norwayeast", "southafricanorth", "southcentralus", "southeastasia", "swedencentral", "switzerlandnorth",
+		"uaenorth", "uksouth", "ukwest", "westcentralus", "westeurope", "westus", "westus2", "westus3",
+	}}
+	CustomLocations = ResourceType{"Custom Locations", []string{
+		"australiaeast", "brazilsouth", "canadacentral", "centralindia", "centralus", "eastasia", "eastus", "eastus2",
+		"francecentral", "germanywestcentral", "japaneast", "koreacentral", "northcentralus", "northeurope", "norwayeast",
+		"southafricanorth", "southcentralus", "southeastasia", "swedencentral", "switzerlandnorth", "uaenorth", "uksouth",
+		"westcentralus", "westeurope", "westus", "westus2", "westus3",
+	}}
+	DataControllers = ResourceType{"Arc Data Controllers", []string{
+		"australiaeast", "brazilsouth", "canadacentral", "centralindia", "centralus", "eastasia", "eastus", "eastus2",
+		"francecentral", "germanywestcentral", "japaneast", "koreacentral", "northeurope", "norwayeast", "southafricanorth",
+		"southcentralus", "southeastasia", "swedencentral", "uksouth", "westeurope", "westus2", "westus3",
+	}}
+)
+
+// Which resource types each location variable must support, in the order the installer checks them - the Custom
+// Location is created in CONNECTED_CLUSTER_LOCATION, ARC_DATA_LOCATION is only used for the Arc Data resource group
+var LocationRules = []struct {
+	Variable string
+	Type     ResourceType
+}{
+	{"CONNECTED_CLUSTER_LOCATION", ConnectedClusters},
+	{"CONNECTED_CLUSTER_LOCATION", CustomLocations},
+	{"ARC_DATA_LOCATION", ResourceGroups},
+	{"ARC_DATA_CONTROLLER_LOCATION", DataControllers},
+}
+
+// Fails if value breaks the rule, with the installer's message
+func (r Rule) Check(value string) error {
+	if !r.Pattern.MatchString(value) || len(value) > r.MaxLength {
+		return fmt.Errorf("variable %s must be %s, got '%s'", r.Variable, r.Description, value)
+	}
+	return nil
+}
+
+// Returns true if location is one of the resource type's regions, ignoring case
+func (r ResourceType) Supports(location string) bool {
+	for _, region := range r.Regions {
+		if strings.EqualFold(region, location) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fails if the resource type isn't available in the location variable's value, with the installer's message
+func (r ResourceType) Check(variable, location string) error {
+	if !r.Supports(location) {
+		return fmt.Errorf("variable %s must be a region %s are available in, e.g. eastus, got '%s'", variable, r.Name, location)
+	}
+	return nil
+}
+
+// Every variable Validate reads, names first
+func Variables() []string {
+	variables := []string{}
+	for _, rule := range Rules {
+		variables = append(variables, rule.Variable)
+	}
+	for _, rule := range LocationRules {
+		if variables[len(variables)-1] != rule.Variable {
+			variables = append(variables, rule.Variable)
+		}
+	}
+	return variables
+}
+
+// Checks every name and location in env the installer creates resources with, reporting all problems at once
+func Validate(env map[string]string) error {
+	problems := []string{}
+
+	for _, rule := range Rules {
+		if err := rule.Check(env[rule.Variable]); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	for _, rule := range LocationRules {
+		if err := rule.Type.Check(rule.Variable, env[rule.Variable]); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid names or locations:\n - %s", strings.Join(problems, "\n - "))
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+//go:build unit
+
+package naming
+
+import (
+	// Native
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Relative path from this package to the installer
+const installerScript = "../../../src/scripts/install-arc-data-services.sh"
+
+func validEnv() map[string]string {
+	return map[string]string{
+		"CONNECTED_CLUSTER_RESOURCE_GROUP": "replay-arc",
+		"ARC_DATA_RESOURCE_GROUP":          "replay-arc-data",
+		"CONNECTED_CLUSTER":                "replay-aks",
+		"ARC_DATA_EXT":                     "arc-data-bootstrapper",
+		"ARC_DATA_NAMESPACE":               "azure-arc-data",
+		"ARC_DATA_CONTROLLER":              "azure-arc-data-controller",
+		"CONNECTED_CLUSTER_LOCATION":       "eastus",
+		"ARC_DATA_LOCATION":                "eastus",
+		"ARC_DATA_CONTROLLER_LOCATION":     "eastus",
+	}
+}
+
+func keys(env map[string]string) []string {
+	keys := []string{}
+	for key := range env {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func rule(t *testing.T, variable string) Rule {
+	for _, rule := range Rules {
+		if rule.Variable == variable {
+			return rule
+		}
+	}
+	t.Fatalf("no naming rule for %s", variable)
+	return Rule{}
+}
+
+func TestRules(t *testing.T) {
+	testCases := []struct {
+		variable string
+		valid    []string
+		invalid  []string
+	}{
+		{
+			variable: "CONNECTED_CLUSTER_RESOURCE_GROUP",
+			valid:    []string{"a", "rg", "My_RG-(test).1", "rg.)", strings.Repeat("r", 90)},
+			invalid:  []string{"", "rg.", "my rg", "rg/1", "rg#", "rgé", strings.Repeat("r", 91)},
+		},
+		{
+			variable: "CONNECTED_CLUSTER",
+			valid:    []string{"a", "1", "MyCluster", "my_cluster-01", strings.Repeat("c", 63)},
+			invalid:  []string{"", "-cluster", "cluster-", "_cluster", "cluster_", "my.cluster", "my cluster", strings.Repeat("c", 64)},
+		},
+		{
+			variable: "ARC_DATA_EXT",
+			valid:    []string{"a", "arc-data-bootstrapper", "arc.data.1", strings.Repeat("e", 53)},
+			invalid:  []string{"", "Arc-Data", "-ext", "ext-", ".ext", "ext.", "arc_data", strings.Repeat("e", 54)},
+		},
+		{
+			variable: "ARC_DATA_NAMESPACE",
+			valid:    []string{"a", "0", "azure-arc-data", "arc1", strings.Repeat("n", 63)},
+			invalid:  []string{"", "Azure-Arc-Data", "-arc", "arc-", "arc.data", "arc_data", "arc data", strings.Repeat("n", 64)},
+		},
+		{
+			variable: "ARC_DATA_CONTROLLER",
+			valid:    []string{"a", "azure-arc-data-controller", strings.Repeat("d", 63)},
+			invalid:  []string{"", "Controller", "-dc", "dc-", "arc.dc", strings.Repeat("d", 64)},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.variable, func(t *testing.T) {
+			rule := rule(t, tc.variable)
+			for _, value := range tc.valid {
+				assert.NoError(t, rule.Check(value), value)
+			}
+			for _, value := range tc.invalid {
+				err := rule.Check(value)
+				require.Error(t, err, value)
+				assert.Equal(t, "variable "+tc.variable+" must be "+rule.Description+", got '"+value+"'", err.Error())
+			}
+		})
+	}
+}
+
+func TestResourceTypeSupports(t *testing.T) {
+	assert.True(t, DataControllers.Supports("eastus"))
+	assert.True(t, DataControllers.Supports("EastUS"))
+	assert.False(t, DataControllers.Supports("East US"))
+	assert.False(t, DataControllers.Supports(""))
+	assert.False(t, DataControllers.Supports("eastus eastus2"))
+	assert.False(t, DataControllers.Supports("westus"))
+	assert.True(t, ResourceGroups.Supports("westus"))
+
+	// Every resource type is available where the installer's own examples put it
+	for _, resourceType := range []ResourceType{ResourceGroups, ConnectedClusters, CustomLocations, DataControllers} {
+		for _, region := range []string{"eastus", "eastasia", "southeastasia", "westeurope", "canadacentral"} {
+			assert.True(t, resourceType.Supports(region), "%s in %s", resourceType.Name, region)
+		}
+	}
+
+	// Anything a narrower resource type is available in, a resource group can be created in
+	for _, resourceType := range []ResourceType{ConnectedClusters, CustomLocations, DataControllers} {
+		for _, region := range resourceType.Regions {
+			assert.True(t, ResourceGroups.Supports(region), "%s lists %s", resourceType.Name, region)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(validEnv()))
+
+	env := validEnv()
+	env["ARC_DATA_RESOURCE_GROUP"] = "arc-data."
+	env["ARC_DATA_NAMESPACE"] = "Azure_Arc_Data"
+	env["CONNECTED_CLUSTER_LOCATION"] = "westindia"
+	env["ARC_DATA_CONTROLLER_LOCATION"] = "mars"
+
+	err := Validate(env)
+	require.Error(t, err)
+	assert.Equal(t, "invalid names or locations:\n"+
+		" - variable ARC_DATA_RESOURCE_GROUP must be at most 90 letters, digits, '-', '_', '.', '(' or ')', not ending in '.', got 'arc-data.'\n"+
+		" - variable ARC_DATA_NAMESPACE must be a Kubernetes namespace name, at most 63 lowercase letters, digits or '-', starting and ending with a letter or digit, got 'Azure_Arc_Data'\n"+
+		" - variable CONNECTED_CLUSTER_LOCATION must be a region Arc-enabled Kubernetes clusters are available in, e.g. eastus, got 'westindia'\n"+
+		" - variable CONNECTED_CLUSTER_LOCATION must be a region Custom Locations are available in, e.g. eastus, got 'westindia'\n"+
+		" - variable ARC_DATA_CONTROLLER_LOCATION must be a region Arc Data Controllers are available in, e.g. eastus, got 'mars'", err.Error())
+
+	assert.ElementsMatch(t, keys(validEnv()), Variables())
+
+	// Missing values are problems too
+	err = Validate(map[string]string{})
+	require.Error(t, err)
+	assert.Equal(t, len(Rules)+len(LocationRules), strings.Count(err.Error(), "\n - "))
+}
+
+// The installer keeps its own copy of the rules and regions, which must match this package both ways - nothing
+// missing, changed or extra on either side
+func TestInstallerScriptInSync(t *testing.T) {
+	content, err := ioutil.ReadFile(installerScript)
+	require.NoError(t, err)
+	script := string(content)
+
+	for _, rule := range Rules {
+		assert.Contains(t, script, fmt.Sprintf("check_name %s '%s' %d \\\n  \"%s\"\n", rule.Variable, rule.Pattern, rule.MaxLength, rule.Description), rule.Variable)
+	}
+	assert.Len(t, regexp.MustCompile(`(?m)^check_name `).FindAllString(script, -1), len(Rules), "check_name calls without a rule")
+
+	arrays := map[string]ResourceType{
+		"RESOURCE_GROUP_REGIONS":    ResourceGroups,
+		"CONNECTED_CLUSTER_REGIONS": ConnectedClusters,
+		"CUSTOM_LOCATION_REGIONS":   CustomLocations,
+		"DATA_CONTROLLER_REGIONS":   DataControllers,
+	}
+	for array, resourceType := range arrays {
+		match := regexp.MustCompile(`(?m)^` + array + `=\(([^)]*)\)`).FindStringSubmatch(script)
+		require.NotNil(t, match, array)
+		assert.Equal(t, resourceType.Regions, strings.Fields(match[1]), array)
+	}
+	for _, match := range regexp.MustCompile(`(?m)^([A-Z_]+_REGIONS)=\(`).FindAllStringSubmatch(script, -1) {
+		assert.Contains(t, arrays, match[1], "region array without a resource type")
+	}
+
+	for _, rule := range LocationRules {
+		for array, resourceType := range arrays {
+			if resourceType.Name == rule.Type.Name {
+				assert.Contains(t, script, "check_location "+rule.Variable+" '"+rule.Type.Name+"' "+array+"\n")
+			}
+		}
+	}
+	assert.Len(t, regexp.MustCompile(`(?m)^check_location `).FindAllString(script, -1), len(LocationRules), "check_location calls without a rule")
+}
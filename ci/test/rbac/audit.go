@@ -0,0 +1,110 @@
+package rbac
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// One kind of API call - what RBAC authorizes, without the object's name
+type Request struct {
+	Verb           string
+	APIGroup       string
+	Resource       string // With its subresource, e.g. pods/log
+	NonResourceURL string // Set instead of APIGroup and Resource for e.g. /version
+}
+
+func (r Request) String() string {
+	return fmt.Sprintf("%s %s", r.Verb, r.target())
+}
+
+// What the request is made on - e.g. /version, pods/log or apps/deployments
+func (r Request) target() string {
+	if r.NonResourceURL != "" {
+		return r.NonResourceURL
+	}
+	if r.APIGroup == "" {
+		return r.Resource
+	}
+	return r.APIGroup + "/" + r.Resource
+}
+
+// The fields of an audit.k8s.io/v1 Event RBAC cares about
+type auditEvent struct {
+	Kind  string `json:"kind"`
+	Stage string `json:"stage"`
+	Verb  string `json:"verb"`
+	User  struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	RequestURI string `json:"requestURI"`
+	ObjectRef  *struct {
+		APIGroup    string `json:"apiGroup"`
+		Resource    string `json:"resource"`
+		Subresource string `json:"subresource"`
+	} `json:"objectRef"`
+}
+
+// Reads the distinct requests username made from a Kubernetes audit log - one JSON Event per line, as the API server's
+// log backend writes them
+//
+// Only one stage of each request is counted, so the log may be written at any stage or level.
+func ReadAuditLog(r io.Reader, username string) ([]Request, error) {
+	seen := map[Request]bool{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		event := auditEvent{}
+		if err := json.Unmarshal([]byte(text), &event); err != nil {
+			return nil, fmt.Errorf("audit log line %d is not JSON: %w", line, err)
+		}
+		if event.Kind != "Event" {
+			return nil, fmt.Errorf("audit log line %d is a '%s', expected an audit Event", line, event.Kind)
+		}
+		if event.User.Username != username || (event.Stage != "" && event.Stage != "ResponseComplete" && event.Stage != "Panic") {
+			continue
+		}
+
+		request := Request{Verb: event.Verb}
+		if event.ObjectRef == nil {
+			request.NonResourceURL = strings.SplitN(event.RequestURI, "?", 2)[0]
+		} else {
+			request.APIGroup = event.ObjectRef.APIGroup
+			request.Resource = event.ObjectRef.Resource
+			if event.ObjectRef.Subresource != "" {
+				request.Resource += "/" + event.ObjectRef.Subresource
+			}
+		}
+		seen[request] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	requests := []Request{}
+	for request := range seen {
+		requests = append(requests, request)
+	}
+	sortRequests(requests)
+	return requests, nil
+}
+
+// Sorts requests by what they are made on, then by verb
+func sortRequests(requests []Request) {
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.target() != b.target() {
+			return a.target() < b.target()
+		}
+		return a.Verb < b.Verb
+	})
+}
@@ -0,0 +1,41 @@
+//go:build unit
+
+package rbac
+
+import (
+	// Native
+	"os"
+	"strings"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAuditLog(t *testing.T) {
+	file, err := os.Open("testdata/audit.log")
+	require.NoError(t, err)
+	defer file.Close()
+
+	requests, err := ReadAuditLog(file, InstallerUsername)
+
+	require.NoError(t, err)
+	// Each request once, whatever its stages, and nothing from other users
+	assert.Equal(t, []Request{
+		{Verb: "get", NonResourceURL: "/version"},
+		{Verb: "create", APIGroup: "apps", Resource: "deployments"},
+		{Verb: "create", Resource: "configmaps"},
+		{Verb: "get", Resource: "configmaps"},
+		{Verb: "get", Resource: "pods/log"},
+		{Verb: "list", APIGroup: "storage.k8s.io", Resource: "storageclasses"},
+	}, requests)
+}
+
+func TestReadAuditLogRejectsOtherFormats(t *testing.T) {
+	_, err := ReadAuditLog(strings.NewReader("I0101 00:00:00.000000 kube-apiserver started\n"), InstallerUsername)
+	assert.EqualError(t, err, "audit log line 1 is not JSON: invalid character 'I' looking for beginning of value")
+
+	_, err = ReadAuditLog(strings.NewReader(`{"kind":"EventList","items":[]}`), InstallerUsername)
+	assert.EqualError(t, err, "audit log line 1 is a 'EventList', expected an audit Event")
+}
@@ -0,0 +1,150 @@
+package rbac
+
+import (
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// Outcome of comparing the requests the installer made with a role
+type Report struct {
+	Missing []Request // Made but not allowed by the role - the installer fails on these
+	Unused  []Request // Allowed by the role but never made - candidates for removal, wildcards are kept as written
+}
+
+// Compares requests with rules
+//
+// Rules restricted to resourceNames are treated as allowing the whole resource, as requests aren't told apart by name.
+func Compare(rules []rbacv1.PolicyRule, requests []Request) Report {
+	report := Report{Missing: []Request{}, Unused: []Request{}}
+
+	for _, request := range requests {
+		if !Allows(rules, request) {
+			report.Missing = append(report.Missing, request)
+		}
+	}
+
+	for _, grant := range expandRules(rules) {
+		used := false
+		for _, request := range requests {
+			if ruleAllows(grant.rule(), request) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			report.Unused = append(report.Unused, grant)
+		}
+	}
+
+	sortRequests(report.Missing)
+	sortRequests(report.Unused)
+	return report
+}
+
+// Whether any of rules allows request
+func Allows(rules []rbacv1.PolicyRule, request Request) bool {
+	for _, rule := range rules {
+		if ruleAllows(rule, request) {
+			return true
+		}
+	}
+	return false
+}
+
+// Same matching as the RBAC authorizer, including its * wildcards
+func ruleAllows(rule rbacv1.PolicyRule, request Request) bool {
+	if !matchesAny(rule.Verbs, request.Verb) {
+		return false
+	}
+
+	if request.NonResourceURL != "" {
+		for _, url := range rule.NonResourceURLs {
+			if url == "*" || url == request.NonResourceURL || (strings.HasSuffix(url, "*") && strings.HasPrefix(request.NonResourceURL, strings.TrimSuffix(url, "*"))) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !matchesAny(rule.APIGroups, request.APIGroup) {
+		return false
+	}
+	for _, resource := range rule.Resources {
+		if resource == "*" || resource == request.Resource {
+			return true
+		}
+		if strings.HasPrefix(resource, "*/") && strings.HasSuffix(request.Resource, strings.TrimPrefix(resource, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Single verb, group and resource - or URL - rule for a grant
+func (r Request) rule() rbacv1.PolicyRule {
+	if r.NonResourceURL != "" {
+		return rbacv1.PolicyRule{Verbs: []string{r.Verb}, NonResourceURLs: []string{r.NonResourceURL}}
+	}
+	return rbacv1.PolicyRule{Verbs: []string{r.Verb}, APIGroups: []string{r.APIGroup}, Resources: []string{r.Resource}}
+}
+
+// Every verb, group and resource - or URL - combination rules grant, as requests
+func expandRules(rules []rbacv1.PolicyRule) []Request {
+	seen := map[Request]bool{}
+	grants := []Request{}
+	add := func(grant Request) {
+		if !seen[grant] {
+			seen[grant] = true
+			grants = append(grants, grant)
+		}
+	}
+
+	for _, rule := range rules {
+		for _, verb := range rule.Verbs {
+			for _, url := range rule.NonResourceURLs {
+				add(Request{Verb: verb, NonResourceURL: url})
+			}
+			for _, group := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					add(Request{Verb: verb, APIGroup: group, Resource: resource})
+				}
+			}
+		}
+	}
+	return grants
+}
+
+// Formats report as Markdown
+func (r Report) Format(roleName string) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "## ClusterRole %s\n\n", roleName)
+	fmt.Fprintf(&sb, "### Missing (%d)\n\n", len(r.Missing))
+	if len(r.Missing) == 0 {
+		sb.WriteString("Every request the installer made is allowed.\n")
+	}
+	for _, request := range r.Missing {
+		fmt.Fprintf(&sb, "- `%s`\n", request)
+	}
+
+	fmt.Fprintf(&sb, "\n### Unused (%d)\n\n", len(r.Unused))
+	if len(r.Unused) == 0 {
+		sb.WriteString("Every verb the role grants was used.\n")
+	}
+	for _, grant := range r.Unused {
+		fmt.Fprintf(&sb, "- `%s`\n", grant)
+	}
+
+	return sb.String()
+}
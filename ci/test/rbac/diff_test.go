@@ -0,0 +1,55 @@
+//go:build unit
+
+package rbac
+
+import (
+	// Native
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestCompare(t *testing.T) {
+	rules := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "create", "delete"}},
+		{APIGroups: []string{"clusterconfig.azure.com"}, Resources: []string{"*"}, Verbs: []string{"list"}},
+		{NonResourceURLs: []string{"/version", "/apis/*"}, Verbs: []string{"get"}},
+	}
+	requests := []Request{
+		{Verb: "get", Resource: "configmaps"},
+		{Verb: "create", Resource: "configmaps"},
+		{Verb: "get", Resource: "pods/log"},
+		{Verb: "list", APIGroup: "clusterconfig.azure.com", Resource: "extensionconfigs"},
+		{Verb: "get", NonResourceURL: "/apis/apps/v1"},
+		{Verb: "get", NonResourceURL: "/openapi/v2"},
+	}
+
+	report := Compare(rules, requests)
+
+	assert.Equal(t, []Request{
+		{Verb: "get", NonResourceURL: "/openapi/v2"},
+		{Verb: "get", Resource: "pods/log"},
+	}, report.Missing)
+	assert.Equal(t, []Request{
+		{Verb: "get", NonResourceURL: "/version"},
+		{Verb: "delete", Resource: "configmaps"},
+	}, report.Unused)
+
+	assert.Equal(t, "## ClusterRole replay\n\n"+
+		"### Missing (2)\n\n- `get /openapi/v2`\n- `get pods/log`\n\n"+
+		"### Unused (2)\n\n- `get /version`\n- `delete configmaps`\n", report.Format("replay"))
+}
+
+func TestAllowsWildcards(t *testing.T) {
+	rules := []rbacv1.PolicyRule{
+		{APIGroups: []string{"*"}, Resources: []string{"*/status"}, Verbs: []string{"*"}},
+		{NonResourceURLs: []string{"*"}, Verbs: []string{"get"}},
+	}
+
+	assert.True(t, Allows(rules, Request{Verb: "patch", APIGroup: "apps", Resource: "deployments/status"}))
+	assert.False(t, Allows(rules, Request{Verb: "patch", APIGroup: "apps", Resource: "deployments"}))
+	assert.True(t, Allows(rules, Request{Verb: "get", NonResourceURL: "/healthz"}))
+	assert.False(t, Allows(rules, Request{Verb: "post", NonResourceURL: "/healthz"}))
+}
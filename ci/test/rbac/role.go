@@ -0,0 +1,89 @@
+package rbac
+
+import (
+	"bytes"
+	"fmt"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Name of the ClusterRole, its binding and the installer's service account - all azure-arc-kubernetes-bootstrap
+const InstallerName = "azure-arc-kubernetes-bootstrap"
+
+// User the installer Job's requests show up as in the audit log
+const InstallerUsername = "system:serviceaccount:" + InstallerName + ":" + InstallerName
+
+// Header of the generated clusterrole.yaml
+const generatedHeader = "# Generated by 'go run ./cmd/rbac-audit generate' from ci/test/rbac/role.go - do not edit by hand\n"
+
+var (
+	readVerbs  = []string{"get", "list", "watch"}
+	writeVerbs = []string{"get", "list", "watch", "create", "update", "patch", "delete"}
+)
+
+// Everything install-arc-data-services.sh does against the cluster, including what az connectedk8s does through Helm
+//
+// The Arc agents' chart binds its own ClusterRoles, so the installer must be allowed to bind and escalate roles - this
+// keeps it from deleting workloads or reading secrets outside the namespaces it manages, not from granting access.
+// The OpenShift groups don't exist on other distributions, where their rules are inert.
+func InstallerRules() []rbacv1.PolicyRule {
+	return []rbacv1.PolicyRule{
+		// Namespaces of the Arc agents and the Data Controller, removed when offboarding, and STATUS_NAMESPACE
+		{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: writeVerbs},
+		// Status ConfigMap, the generated AZDATA password Secret, Helm release secrets and the Arc agents' chart
+		{APIGroups: []string{""}, Resources: []string{"configmaps", "secrets", "serviceaccounts", "services"}, Verbs: writeVerbs},
+		// Capacity preflight, az connectedk8s pre-checks and troubleshooting
+		{APIGroups: []string{""}, Resources: []string{"nodes", "pods", "pods/log", "events"}, Verbs: readVerbs},
+		{APIGroups: []string{"storage.k8s.io"}, Resources: []string{"storageclasses"}, Verbs: readVerbs},
+		// Arc agents
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments", "daemonsets", "replicasets", "statefulsets"}, Verbs: writeVerbs},
+		{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: writeVerbs},
+		{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"clusterroles", "clusterrolebindings", "roles", "rolebindings"}, Verbs: []string{"get", "list", "watch", "create", "update", "patch", "delete", "bind", "escalate"}},
+		{APIGroups: []string{"clusterconfig.azure.com", "arc.azure.com"}, Resources: []string{"*"}, Verbs: writeVerbs},
+		// Arc agent and Arc Data CRDs and webhooks, the Arc Data ones are removed when offboarding
+		{APIGroups: []string{"apiextensions.k8s.io"}, Resources: []string{"customresourcedefinitions"}, Verbs: writeVerbs},
+		{APIGroups: []string{"admissionregistration.k8s.io"}, Resources: []string{"mutatingwebhookconfigurations", "validatingwebhookconfigurations"}, Verbs: writeVerbs},
+		// az connectedk8s checks its own permissions before installing
+		{APIGroups: []string{"authorization.k8s.io"}, Resources: []string{"selfsubjectaccessreviews", "selfsubjectrulesreviews"}, Verbs: []string{"create"}},
+		// OpenShift - SCCs for the Arc agents and metricsdc, Routes for the Grafana and Kibana dashboards
+		{APIGroups: []string{"security.openshift.io"}, Resources: []string{"securitycontextconstraints"}, Verbs: []string{"get", "list", "watch", "create", "update", "patch", "delete", "use"}},
+		{APIGroups: []string{"route.openshift.io"}, Resources: []string{"routes", "routes/custom-host"}, Verbs: writeVerbs},
+		// kubectl cluster-info and Helm's API discovery
+		{NonResourceURLs: []string{"/version", "/api", "/api/*", "/apis", "/apis/*", "/openapi/*"}, Verbs: []string{"get"}},
+	}
+}
+
+// ClusterRole of the least-privilege kustomize component
+func InstallerClusterRole() rbacv1.ClusterRole {
+	return rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: InstallerName},
+		Rules:      InstallerRules(),
+	}
+}
+
+// Renders role as the generated clusterrole.yaml
+func MarshalClusterRole(role rbacv1.ClusterRole) ([]byte, error) {
+	content, err := yaml.Marshal(role)
+	if err != nil {
+		return nil, err
+	}
+
+	// metav1.ObjectMeta always renders its creationTimestamp
+	content = bytes.Replace(content, []byte("  creationTimestamp: null\n"), nil, 1)
+	return append([]byte(generatedHeader), content...), nil
+}
+
+// Reads a ClusterRole from YAML, e.g. the component's clusterrole.yaml
+func UnmarshalClusterRole(content []byte) (rbacv1.ClusterRole, error) {
+	role := rbacv1.ClusterRole{}
+	if err := yaml.UnmarshalStrict(content, &role); err != nil {
+		return role, fmt.Errorf("not a ClusterRole: %w", err)
+	}
+	if role.Kind != "ClusterRole" {
+		return role, fmt.Errorf("kind is '%s', expected ClusterRole", role.Kind)
+	}
+	return role, nil
+}
@@ -0,0 +1,68 @@
+//go:build unit
+
+package rbac
+
+import (
+	// Native
+	"io/ioutil"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Relative path from this package to the least-privilege component's ClusterRole
+const componentRolePath = "../../../kustomize/components/least-privilege/clusterrole.yaml"
+
+// The component ships what InstallerRules generates - run 'go run ./cmd/rbac-audit generate' from ci/test after a change
+func TestComponentClusterRoleIsGenerated(t *testing.T) {
+	committed, err := ioutil.ReadFile(componentRolePath)
+	require.NoError(t, err)
+
+	generated, err := MarshalClusterRole(InstallerClusterRole())
+	require.NoError(t, err)
+
+	assert.Equal(t, string(generated), string(committed))
+}
+
+func TestClusterRoleRoundTrip(t *testing.T) {
+	content, err := MarshalClusterRole(InstallerClusterRole())
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "creationTimestamp")
+
+	role, err := UnmarshalClusterRole(content)
+	require.NoError(t, err)
+	assert.Equal(t, InstallerClusterRole(), role)
+
+	_, err = UnmarshalClusterRole([]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: x\n"))
+	assert.EqualError(t, err, "kind is 'ConfigMap', expected ClusterRole")
+}
+
+// The installer's own kubectl calls are all allowed
+func TestInstallerRulesAllowTheScript(t *testing.T) {
+	rules := InstallerRules()
+
+	for _, request := range []Request{
+		{Verb: "get", Resource: "configmaps"},
+		{Verb: "create", Resource: "configmaps"},
+		{Verb: "patch", Resource: "configmaps"},
+		{Verb: "delete", Resource: "namespaces"},
+		{Verb: "list", APIGroup: "apiextensions.k8s.io", Resource: "customresourcedefinitions"},
+		{Verb: "delete", APIGroup: "apiextensions.k8s.io", Resource: "customresourcedefinitions"},
+		{Verb: "delete", APIGroup: "admissionregistration.k8s.io", Resource: "mutatingwebhookconfigurations"},
+		{Verb: "create", APIGroup: "security.openshift.io", Resource: "securitycontextconstraints"},
+		{Verb: "create", APIGroup: "rbac.authorization.k8s.io", Resource: "clusterrolebindings"},
+		{Verb: "create", APIGroup: "route.openshift.io", Resource: "routes"},
+		{Verb: "get", NonResourceURL: "/version"},
+		{Verb: "list", Resource: "nodes"},
+		{Verb: "list", APIGroup: "storage.k8s.io", Resource: "storageclasses"},
+	} {
+		assert.True(t, Allows(rules, request), request.String())
+	}
+
+	// Unlike cluster-admin
+	assert.False(t, Allows(rules, Request{Verb: "delete", APIGroup: "apps", Resource: "deployments/scale"}))
+	assert.False(t, Allows(rules, Request{Verb: "create", Resource: "pods/exec"}))
+	assert.False(t, Allows(rules, Request{Verb: "delete", APIGroup: "storage.k8s.io", Resource: "storageclasses"}))
+}
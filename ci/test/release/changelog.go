@@ -0,0 +1,171 @@
+package release
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// How far a version moved between two release env files
+type Bump string
+
+const (
+	BumpMajor   Bump = "major"
+	BumpMinor   Bump = "minor"
+	BumpPatch   Bump = "patch"
+	BumpChanged Bump = "changed" // Value changed but could not be compared as a version, or moved backwards
+	BumpAdded   Bump = "added"
+	BumpRemoved Bump = "removed"
+)
+
+// First dotted number in a value - covers "3.9.2-1", "2.39.0-1~jammy", "v1.10.0_2022-08-09" and wheel file names like "arcdata-1.4.5-py2..."
+var versionPattern = regexp.MustCompile(`[0-9]+(\.[0-9]+)+`)
+
+// Parses release env content held in memory, e.g. from "git show"
+func ParseEnv(content []byte) (Env, error) {
+	env, err := godotenv.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("parsing release env: %w", err)
+	}
+	return Env(env), nil
+}
+
+// Classifies a change by comparing the leading dotted version numbers of the old and new values
+func ClassifyBump(change Change) Bump {
+	if change.Old == "" {
+		return BumpAdded
+	}
+	if change.New == "" {
+		return BumpRemoved
+	}
+
+	oldVersion := parseVersion(change.Old)
+	newVersion := parseVersion(change.New)
+	if oldVersion == nil || newVersion == nil {
+		return BumpChanged
+	}
+
+	for i := 0; i < len(oldVersion) && i < len(newVersion); i++ {
+		if newVersion[i] == oldVersion[i] {
+			continue
+		}
+		if newVersion[i] < oldVersion[i] {
+			return BumpChanged
+		}
+		switch i {
+		case 0:
+			return BumpMajor
+		case 1:
+			return BumpMinor
+		default:
+			return BumpPatch
+		}
+	}
+
+	// Same numeric version, suffix changed - e.g. build date or distro
+	return BumpPatch
+}
+
+// Extracts the numeric components of the first dotted version in a value, nil if there is none
+func parseVersion(value string) []int {
+	match := versionPattern.FindString(value)
+	if match == "" {
+		return nil
+	}
+
+	parts := strings.Split(match, ".")
+	version := make([]int, 0, len(parts))
+	for _, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+		version = append(version, number)
+	}
+	return version
+}
+
+// Renders a Markdown changelog between two release env files
+//
+// Changes are grouped into base and Arc Data artifacts, major/minor bumps are emphasized and a release train switch is called out at the top.
+func Changelog(title string, oldEnv, newEnv Env) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "## %s\n\n", title)
+
+	changes := Diff(oldEnv, newEnv)
+	if len(changes) == 0 {
+		sb.WriteString("_No changes_\n")
+		return sb.String()
+	}
+
+	if oldEnv["ARC_DATA_RELEASE_TRAIN"] != newEnv["ARC_DATA_RELEASE_TRAIN"] {
+		fmt.Fprintf(&sb, "> **Release train switch:** `%s` -> `%s`\n\n", oldEnv["ARC_DATA_RELEASE_TRAIN"], newEnv["ARC_DATA_RELEASE_TRAIN"])
+	}
+
+	groups := []struct {
+		heading string
+		keys    []string
+	}{
+		{"Base artifacts", BaseArtifactKeys},
+		{"Arc Data artifacts", ArcDataArtifactKeys},
+		{"Additional artifacts", nil},
+	}
+
+	grouped := map[string][]Change{}
+	for _, change := range changes {
+		heading := "Additional artifacts"
+		for _, group := range groups {
+			if containsKey(group.keys, change.Key) {
+				heading = group.heading
+				break
+			}
+		}
+		grouped[heading] = append(grouped[heading], change)
+	}
+
+	for _, group := range groups {
+		groupChanges := grouped[group.heading]
+		if len(groupChanges) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&sb, "### %s\n\n", group.heading)
+		sb.WriteString("| Artifact | From | To | Bump |\n")
+		sb.WriteString("|---|---|---|---|\n")
+		for _, change := range groupChanges {
+			fmt.Fprintf(&sb, "| %s | %s | %s | %s |\n", change.Key, markdownValue(change.Old), markdownValue(change.New), markdownBump(ClassifyBump(change)))
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func markdownValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return fmt.Sprintf("`%s`", value)
+}
+
+// Major and minor bumps are the ones reviewers need to look at
+func markdownBump(bump Bump) string {
+	if bump == BumpMajor || bump == BumpMinor {
+		return fmt.Sprintf("**%s**", bump)
+	}
+	return string(bump)
+}
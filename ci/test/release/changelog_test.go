@@ -0,0 +1,95 @@
+//go:build unit
+
+package release
+
+import (
+	// Native
+	"path/filepath"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFixture(t *testing.T, name string) Env {
+	env, err := ReadEnv(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return env
+}
+
+func TestClassifyBump(t *testing.T) {
+	testCases := []struct {
+		old, new string
+		expected Bump
+	}{
+		{"3.9.2-1", "4.0.0-1", BumpMajor},
+		{"2.39.0-1~jammy", "2.40.0-1~jammy", BumpMinor},
+		{"1.2.6", "1.2.7", BumpPatch},
+		{"v1.10.0_2022-08-09", "v1.11.0_2022-09-13", BumpMinor},
+		{"v1.10.0_2022-08-09", "v1.10.0_2022-08-10", BumpPatch},
+		{"1.2.20381002", "1.2.20601003", BumpPatch},
+		{"https://x/arcdata-1.4.5-py2.py3-none-any.whl", "https://x/arcdata-2.0.0-py2.py3-none-any.whl", BumpMajor},
+		{"1.3.0", "1.2.0", BumpChanged},
+		{"preview", "stable", BumpChanged},
+		{"", "1.0.0", BumpAdded},
+		{"1.0.0", "", BumpRemoved},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.old+"_to_"+tc.new, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ClassifyBump(Change{Key: "KEY", Old: tc.old, New: tc.new}))
+		})
+	}
+}
+
+func TestChangelogNoChanges(t *testing.T) {
+	base := readFixture(t, "release.base.env")
+
+	changelog := Changelog("preview", base, base)
+
+	assert.Equal(t, "## preview\n\n_No changes_\n", changelog)
+}
+
+func TestChangelogBumps(t *testing.T) {
+	changelog := Changelog("preview", readFixture(t, "release.base.env"), readFixture(t, "release.bump.env"))
+
+	expected := "## preview\n\n" +
+		"### Base artifacts\n\n" +
+		"| Artifact | From | To | Bump |\n" +
+		"|---|---|---|---|\n" +
+		"| HELM_VERSION | `3.9.2-1` | `3.9.4-1` | patch |\n" +
+		"| AZCLI_VERSION | `2.39.0-1~jammy` | `2.40.0-1~jammy` | **minor** |\n" +
+		"\n" +
+		"### Arc Data artifacts\n\n" +
+		"| Artifact | From | To | Bump |\n" +
+		"|---|---|---|---|\n" +
+		"| ARC_DATA_EXT_VERSION | `1.2.20381002` | `1.3.20601003` | **minor** |\n" +
+		"| ARC_DATA_CONTROLLER_VERSION | `v1.10.0_2022-08-09` | `v1.11.0_2022-09-13` | **minor** |\n" +
+		"| ARC_DATA_WHL_URL | `https://azurearcdatacli.blob.core.windows.net/cli-extensions/arcdata-1.4.5-py2.py3-none-any.whl` | `https://azurearcdatacli.blob.core.windows.net/cli-extensions/arcdata-1.4.6-py2.py3-none-any.whl` | patch |\n" +
+		"\n"
+
+	assert.Equal(t, expected, changelog)
+	assert.NotContains(t, changelog, "Release train switch")
+}
+
+func TestChangelogTrainSwitch(t *testing.T) {
+	changelog := Changelog("preview to stable", readFixture(t, "release.base.env"), readFixture(t, "release.switch.env"))
+
+	assert.Contains(t, changelog, "> **Release train switch:** `preview` -> `stable`")
+	assert.Contains(t, changelog, "| KUBECTL_VERSION | `1.24.3-00` | `2.0.0-00` | **major** |")
+	assert.Contains(t, changelog, "| ARC_DATA_RELEASE_TRAIN | `preview` | `stable` | changed |")
+}
+
+func TestChangelogAddedAndRemovedKeys(t *testing.T) {
+	oldEnv := readFixture(t, "release.base.env")
+	newEnv := readFixture(t, "release.base.env")
+	delete(newEnv, "EXT_K8S_CUSTOMLOCATION_VERSION")
+	newEnv["EXTRA_TOOL_VERSION"] = "1.0.0"
+
+	changelog := Changelog("preview", oldEnv, newEnv)
+
+	assert.Contains(t, changelog, "### Additional artifacts")
+	assert.Contains(t, changelog, "| EXTRA_TOOL_VERSION | - | `1.0.0` | added |")
+	assert.Contains(t, changelog, "| EXT_K8S_CUSTOMLOCATION_VERSION | `0.1.3` | - | removed |")
+}
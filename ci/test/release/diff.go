@@ -0,0 +1,50 @@
+package release
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A single key that differs between two release env files
+type Change struct {
+	Key string
+	Old string // Empty if the key was added
+	New string // Empty if the key was removed
+}
+
+// Compares two release env files key by key, in render order of the new file followed by removed keys
+func Diff(oldEnv, newEnv Env) []Change {
+	changes := []Change{}
+
+	for _, key := range newEnv.Keys() {
+		if oldEnv[key] != newEnv[key] {
+			changes = append(changes, Change{Key: key, Old: oldEnv[key], New: newEnv[key]})
+		}
+	}
+
+	for _, key := range oldEnv.Keys() {
+		if _, ok := newEnv[key]; !ok {
+			changes = append(changes, Change{Key: key, Old: oldEnv[key]})
+		}
+	}
+
+	return changes
+}
+
+// Formats changes as a unified-diff style preview
+func FormatDiff(changes []Change) string {
+	if len(changes) == 0 {
+		return "No changes\n"
+	}
+
+	var sb strings.Builder
+	for _, change := range changes {
+		if change.Old != "" {
+			fmt.Fprintf(&sb, "- %s=%s\n", change.Key, change.Old)
+		}
+		if change.New != "" {
+			fmt.Fprintf(&sb, "+ %s=%s\n", change.Key, change.New)
+		}
+	}
+	return sb.String()
+}
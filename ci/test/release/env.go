@@ -0,0 +1,276 @@
+package release
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Base artifacts - tool versions baked into the installer image, sourced from get-latest-versions.sh
+var BaseArtifactKeys = []string{
+	"HELM_VERSION",
+	"KUBECTL_VERSION",
+	"AZCLI_VERSION",
+	"EXT_K8S_CONFIGURATION_VERSION",
+	"EXT_K8S_EXTENSION_VERSION",
+	"EXT_K8S_CONNECTEDK8S_VERSION",
+	"EXT_K8S_CUSTOMLOCATION_VERSION",
+}
+
+// Arc Data artifacts - 4 hierarchial, interdependent artifacts that uniquely localize a deployment:
+//
+//	└── 1. Extension release train: ARC_DATA_RELEASE_TRAIN
+//	    └── 2. Extension version: ARC_DATA_EXT_VERSION
+//	        └── 3. Data Controller image tag: ARC_DATA_CONTROLLER_VERSION
+//	            └── 4. CLI Extension downoad URL for az arcdata wheel file: ARC_DATA_WHL_URL
+var ArcDataArtifactKeys = []string{
+	"ARC_DATA_RELEASE_TRAIN",
+	"ARC_DATA_EXT_VERSION",
+	"ARC_DATA_CONTROLLER_VERSION",
+	"ARC_DATA_WHL_URL",
+	"ARC_DATA_WHL_SHA256",
+}
+
+// Keys that may be absent - they are only rendered and validated when set
+//
+// ARC_DATA_WHL_SHA256 is optional so env files written before wheel pinning stay valid, run "release-env pin-wheel" to add it.
+var optionalKeys = map[string]bool{
+	"ARC_DATA_WHL_SHA256": true,
+}
+
+// Release trains supported by the Arc Data bootstrapper extension
+var ReleaseTrains = []string{"test", "preview", "stable"}
+
+var (
+	extVersionPattern        = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+	controllerVersionPattern = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+_[0-9]{4}-[0-9]{2}-[0-9]{2}$`)
+	sha256Pattern            = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// Env is the parsed content of a release.<train>.env file
+type Env map[string]string
+
+// Reads a release env file from disk
+func ReadEnv(envFilePath string) (Env, error) {
+	env, err := godotenv.Read(envFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading release env file '%s': %w", envFilePath, err)
+	}
+	return Env(env), nil
+}
+
+// Returns the conventional file name for a release train - e.g. release.preview.env
+func EnvFileName(releaseTrain string) string {
+	return fmt.Sprintf("release.%s.env", releaseTrain)
+}
+
+// Returns the path to a release train's env file inside the release folder
+func EnvFilePath(releaseFolder, releaseTrain string) string {
+	return filepath.Join(releaseFolder, EnvFileName(releaseTrain))
+}
+
+// Discovers the release trains that have a release.<train>.env file in the release folder, sorted
+func DiscoverTrains(releaseFolder string) ([]string, error) {
+	envFilePaths, err := filepath.Glob(filepath.Join(releaseFolder, EnvFileName("*")))
+	if err != nil {
+		return nil, err
+	}
+
+	trains := []string{}
+	for _, envFilePath := range envFilePaths {
+		name := filepath.Base(envFilePath)
+		trains = append(trains, strings.TrimSuffix(strings.TrimPrefix(name, "release."), ".env"))
+	}
+	sort.Strings(trains)
+
+	return trains, nil
+}
+
+// Resolves a comma separated list of release trains against the env files in the release folder
+//
+// An empty list resolves to every discovered train. Unknown trains and env files that fail validation are all reported
+// in a single error, so a caller can refuse to start before creating anything.
+func ResolveTrains(requested string, releaseFolder string) ([]string, error) {
+	available, err := DiscoverTrains(releaseFolder)
+	if err != nil {
+		return nil, err
+	}
+	if len(available) == 0 {
+		return nil, fmt.Errorf("no %s files found in release folder '%s'", EnvFileName("<train>"), releaseFolder)
+	}
+
+	trains := []string{}
+	for _, train := range strings.Split(requested, ",") {
+		if train = strings.TrimSpace(train); train != "" {
+			trains = append(trains, train)
+		}
+	}
+	if len(trains) == 0 {
+		trains = available
+	}
+
+	problems := []string{}
+	for _, train := range trains {
+		if !containsKey(available, train) {
+			problems = append(problems, fmt.Sprintf("release train '%s' has no %s - valid release trains: %s", train, EnvFileName(train), strings.Join(available, ", ")))
+			continue
+		}
+
+		env, err := ReadEnv(EnvFilePath(releaseFolder, train))
+		if err == nil {
+			err = env.Validate()
+		}
+		if err == nil && env["ARC_DATA_RELEASE_TRAIN"] != train {
+			err = fmt.Errorf("ARC_DATA_RELEASE_TRAIN is '%s', expected '%s'", env["ARC_DATA_RELEASE_TRAIN"], train)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", EnvFileName(train), err.Error()))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid release train input:\n - %s", strings.Join(problems, "\n - "))
+	}
+
+	return trains, nil
+}
+
+// Checks every required artifact is present and well-formed, reporting all problems at once
+func (e Env) Validate() error {
+	problems := []string{}
+
+	for _, key := range knownKeys() {
+		if !optionalKeys[key] && strings.TrimSpace(e[key]) == "" {
+			problems = append(problems, fmt.Sprintf("%s is required", key))
+		}
+	}
+
+	if train := e["ARC_DATA_RELEASE_TRAIN"]; train != "" && !IsReleaseTrain(train) {
+		problems = append(problems, fmt.Sprintf("ARC_DATA_RELEASE_TRAIN '%s' must be one of: %s", train, strings.Join(ReleaseTrains, ", ")))
+	}
+
+	if version := e["ARC_DATA_EXT_VERSION"]; version != "" && !extVersionPattern.MatchString(version) {
+		problems = append(problems, fmt.Sprintf("ARC_DATA_EXT_VERSION '%s' must look like 1.2.20381002", version))
+	}
+
+	if version := e["ARC_DATA_CONTROLLER_VERSION"]; version != "" && !controllerVersionPattern.MatchString(version) {
+		problems = append(problems, fmt.Sprintf("ARC_DATA_CONTROLLER_VERSION '%s' must look like v1.10.0_2022-08-09", version))
+	}
+
+	if whlUrl := e["ARC_DATA_WHL_URL"]; whlUrl != "" {
+		if err := validateWheelUrl(whlUrl); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if checksum, ok := e["ARC_DATA_WHL_SHA256"]; ok && !sha256Pattern.MatchString(checksum) {
+		problems = append(problems, fmt.Sprintf("ARC_DATA_WHL_SHA256 '%s' must be a lowercase hex sha256 digest", checksum))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid release env:\n - %s", strings.Join(problems, "\n - "))
+	}
+
+	return nil
+}
+
+// The wheel is installed with "az extension add --source", which needs a direct https link to a .whl file
+func validateWheelUrl(whlUrl string) error {
+	parsed, err := url.Parse(whlUrl)
+	if err != nil {
+		return fmt.Errorf("ARC_DATA_WHL_URL '%s' is not a valid URL: %s", whlUrl, err.Error())
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("ARC_DATA_WHL_URL '%s' must be an https URL", whlUrl)
+	}
+	if path.Ext(parsed.Path) != ".whl" {
+		return fmt.Errorf("ARC_DATA_WHL_URL '%s' must point at a .whl file", whlUrl)
+	}
+	return nil
+}
+
+// Returns true if the value is a known release train
+func IsReleaseTrain(releaseTrain string) bool {
+	for _, train := range ReleaseTrains {
+		if train == releaseTrain {
+			return true
+		}
+	}
+	return false
+}
+
+// Renders the env file in the layout the release folder uses, so regenerating an unchanged release produces no diff
+//
+// Known keys are always written in the same order under their section headers - any extra keys are appended sorted.
+func (e Env) Render() []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("# Base artifacts:\n")
+	for _, key := range BaseArtifactKeys {
+		fmt.Fprintf(&buf, "%s=%s\n", key, e[key])
+	}
+
+	buf.WriteString("# Arc Data artifacts:\n")
+	for _, key := range ArcDataArtifactKeys {
+		if _, ok := e[key]; !ok && optionalKeys[key] {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s=%s\n", key, e[key])
+	}
+
+	extraKeys := e.extraKeys()
+	if len(extraKeys) > 0 {
+		buf.WriteString("# Additional artifacts:\n")
+		for _, key := range extraKeys {
+			fmt.Fprintf(&buf, "%s=%s\n", key, e[key])
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// Writes the rendered env file to disk
+func (e Env) Write(envFilePath string) error {
+	return ioutil.WriteFile(envFilePath, e.Render(), 0644)
+}
+
+// Returns all keys in render order
+func (e Env) Keys() []string {
+	keys := []string{}
+	for _, key := range knownKeys() {
+		if _, ok := e[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	return append(keys, e.extraKeys()...)
+}
+
+// Base and Arc Data artifact keys in render order
+func knownKeys() []string {
+	return append(append([]string{}, BaseArtifactKeys...), ArcDataArtifactKeys...)
+}
+
+// Keys that are not part of the known artifact list, sorted
+func (e Env) extraKeys() []string {
+	known := map[string]bool{}
+	for _, key := range knownKeys() {
+		known[key] = true
+	}
+
+	extra := []string{}
+	for key := range e {
+		if !known[key] {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+	return extra
+}
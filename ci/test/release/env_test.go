@@ -0,0 +1,199 @@
+//go:build unit
+
+package release
+
+import (
+	// Native
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Relative path from this package to the release folder
+const releaseFolder = "../../../release"
+
+func validEnv() Env {
+	return Env{
+		"HELM_VERSION":                   "3.9.2-1",
+		"KUBECTL_VERSION":                "1.24.3-00",
+		"AZCLI_VERSION":                  "2.39.0-1~jammy",
+		"EXT_K8S_CONFIGURATION_VERSION":  "1.6.0",
+		"EXT_K8S_EXTENSION_VERSION":      "1.2.6",
+		"EXT_K8S_CONNECTEDK8S_VERSION":   "1.2.11",
+		"EXT_K8S_CUSTOMLOCATION_VERSION": "0.1.3",
+		"ARC_DATA_RELEASE_TRAIN":         "preview",
+		"ARC_DATA_EXT_VERSION":           "1.2.20381002",
+		"ARC_DATA_CONTROLLER_VERSION":    "v1.10.0_2022-08-09",
+		"ARC_DATA_WHL_URL":               "https://azurearcdatacli.blob.core.windows.net/cli-extensions/arcdata-1.4.5-py2.py3-none-any.whl",
+	}
+}
+
+func TestCommittedReleaseEnvFilesAreValidAndCanonical(t *testing.T) {
+	envFilePaths, err := filepath.Glob(filepath.Join(releaseFolder, "release.*.env"))
+	require.NoError(t, err)
+	require.NotEmpty(t, envFilePaths)
+
+	for _, envFilePath := range envFilePaths {
+		envFilePath := envFilePath
+		t.Run(filepath.Base(envFilePath), func(t *testing.T) {
+			env, err := ReadEnv(envFilePath)
+			require.NoError(t, err)
+			assert.NoError(t, env.Validate())
+
+			// Re-rendering a committed file must be a no-op, otherwise the tooling would produce noisy diffs
+			content, err := ioutil.ReadFile(envFilePath)
+			require.NoError(t, err)
+			assert.Equal(t, string(content), string(env.Render()))
+		})
+	}
+}
+
+func TestDiscoverTrains(t *testing.T) {
+	t.Run("committed_release_folder", func(t *testing.T) {
+		trains, err := DiscoverTrains(releaseFolder)
+		require.NoError(t, err)
+		assert.Subset(t, trains, []string{"preview", "stable"})
+	})
+
+	t.Run("new_env_file_adds_a_train", func(t *testing.T) {
+		folder := t.TempDir()
+		for _, name := range []string{"release.stable.env", "release.test.env", "release.preview.env", "README.md"} {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(folder, name), []byte{}, 0644))
+		}
+
+		trains, err := DiscoverTrains(folder)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"preview", "stable", "test"}, trains)
+	})
+}
+
+func TestResolveTrains(t *testing.T) {
+	folder := t.TempDir()
+	for _, train := range []string{"preview", "stable"} {
+		env := validEnv()
+		env["ARC_DATA_RELEASE_TRAIN"] = train
+		require.NoError(t, env.Write(EnvFilePath(folder, train)))
+	}
+
+	t.Run("empty_resolves_to_all_discovered", func(t *testing.T) {
+		trains, err := ResolveTrains("", folder)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"preview", "stable"}, trains)
+	})
+
+	t.Run("comma_separated_list", func(t *testing.T) {
+		trains, err := ResolveTrains(" stable, preview ", folder)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"stable", "preview"}, trains)
+	})
+
+	t.Run("unknown_train_lists_valid_trains", func(t *testing.T) {
+		_, err := ResolveTrains("preview,nightly", folder)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "release train 'nightly' has no release.nightly.env - valid release trains: preview, stable")
+	})
+
+	t.Run("invalid_env_file", func(t *testing.T) {
+		env := validEnv()
+		env["ARC_DATA_RELEASE_TRAIN"] = "test"
+		delete(env, "ARC_DATA_WHL_URL")
+		require.NoError(t, env.Write(EnvFilePath(folder, "test")))
+		defer os.Remove(EnvFilePath(folder, "test"))
+
+		_, err := ResolveTrains("test", folder)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "release.test.env: invalid release env")
+		assert.Contains(t, err.Error(), "ARC_DATA_WHL_URL is required")
+	})
+
+	t.Run("env_file_for_another_train", func(t *testing.T) {
+		require.NoError(t, validEnv().Write(EnvFilePath(folder, "test"))) // ARC_DATA_RELEASE_TRAIN=preview
+		defer os.Remove(EnvFilePath(folder, "test"))
+
+		_, err := ResolveTrains("test", folder)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "ARC_DATA_RELEASE_TRAIN is 'preview', expected 'test'")
+	})
+
+	t.Run("empty_release_folder", func(t *testing.T) {
+		_, err := ResolveTrains("", t.TempDir())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no release.<train>.env files found")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mutate   func(Env)
+		expected string
+	}{
+		{"missing_base_artifact", func(e Env) { delete(e, "HELM_VERSION") }, "HELM_VERSION is required"},
+		{"blank_arc_artifact", func(e Env) { e["ARC_DATA_EXT_VERSION"] = " " }, "ARC_DATA_EXT_VERSION"},
+		{"unknown_release_train", func(e Env) { e["ARC_DATA_RELEASE_TRAIN"] = "nightly" }, "must be one of: test, preview, stable"},
+		{"malformed_ext_version", func(e Env) { e["ARC_DATA_EXT_VERSION"] = "1.2" }, "ARC_DATA_EXT_VERSION '1.2'"},
+		{"malformed_controller_version", func(e Env) { e["ARC_DATA_CONTROLLER_VERSION"] = "v1.10.0" }, "ARC_DATA_CONTROLLER_VERSION 'v1.10.0'"},
+		{"http_wheel_url", func(e Env) { e["ARC_DATA_WHL_URL"] = "http://example.com/arcdata.whl" }, "must be an https URL"},
+		{"non_wheel_url", func(e Env) { e["ARC_DATA_WHL_URL"] = "https://example.com/arcdata.zip" }, "must point at a .whl file"},
+	}
+
+	t.Run("valid_env", func(t *testing.T) {
+		assert.NoError(t, validEnv().Validate())
+	})
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			env := validEnv()
+			tc.mutate(env)
+			err := env.Validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expected)
+		})
+	}
+
+	t.Run("reports_all_problems_at_once", func(t *testing.T) {
+		err := Env{}.Validate()
+		require.Error(t, err)
+		for _, key := range knownKeys() {
+			if !optionalKeys[key] {
+				assert.Contains(t, err.Error(), key)
+			}
+		}
+	})
+}
+
+func TestRenderIsDeterministic(t *testing.T) {
+	env := validEnv()
+	env["ZZ_EXTRA"] = "2"
+	env["AA_EXTRA"] = "1"
+
+	first := string(env.Render())
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, first, string(env.Render()))
+	}
+	assert.Contains(t, first, "# Additional artifacts:\nAA_EXTRA=1\nZZ_EXTRA=2\n")
+}
+
+func TestDiff(t *testing.T) {
+	oldEnv := validEnv()
+	newEnv := validEnv()
+	newEnv["ARC_DATA_CONTROLLER_VERSION"] = "v1.11.0_2022-09-13"
+	newEnv["EXTRA"] = "added"
+	delete(newEnv, "HELM_VERSION")
+
+	changes := Diff(oldEnv, newEnv)
+
+	assert.Equal(t, []Change{
+		{Key: "ARC_DATA_CONTROLLER_VERSION", Old: "v1.10.0_2022-08-09", New: "v1.11.0_2022-09-13"},
+		{Key: "EXTRA", New: "added"},
+		{Key: "HELM_VERSION", Old: "3.9.2-1"},
+	}, changes)
+
+	assert.Equal(t, "No changes\n", FormatDiff(Diff(oldEnv, oldEnv)))
+}
@@ -0,0 +1,75 @@
+package release
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Downloads the az arcdata wheel and returns its sha256 digest
+//
+// Supports https/http URLs and file:// URLs, so the checksum logic can be exercised offline.
+func WheelSha256(whlUrl string) (string, error) {
+	body, err := openWheel(whlUrl)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return "", fmt.Errorf("reading wheel '%s': %w", whlUrl, err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Checks the wheel behind the URL still matches the pinned digest - fails if the artifact changed under the same URL
+func VerifyWheel(whlUrl, expectedSha256 string) error {
+	actualSha256, err := WheelSha256(whlUrl)
+	if err != nil {
+		return err
+	}
+
+	if actualSha256 != expectedSha256 {
+		return fmt.Errorf("wheel '%s' has sha256 %s, but ARC_DATA_WHL_SHA256 pins %s - the artifact changed under the same URL", whlUrl, actualSha256, expectedSha256)
+	}
+
+	return nil
+}
+
+func openWheel(whlUrl string) (io.ReadCloser, error) {
+	parsed, err := url.Parse(whlUrl)
+	if err != nil {
+		return nil, fmt.Errorf("parsing wheel URL '%s': %w", whlUrl, err)
+	}
+
+	switch parsed.Scheme {
+	case "file":
+		file, err := os.Open(parsed.Path)
+		if err != nil {
+			return nil, fmt.Errorf("opening wheel '%s': %w", whlUrl, err)
+		}
+		return file, nil
+
+	case "http", "https":
+		client := &http.Client{Timeout: 5 * time.Minute}
+		resp, err := client.Get(whlUrl)
+		if err != nil {
+			return nil, fmt.Errorf("downloading wheel '%s': %w", whlUrl, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("downloading wheel '%s': unexpected status %s", whlUrl, resp.Status)
+		}
+		return resp.Body, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported wheel URL scheme '%s' in '%s'", parsed.Scheme, whlUrl)
+	}
+}
@@ -0,0 +1,87 @@
+//go:build unit
+
+package release
+
+import (
+	// Native
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	// Testing
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var wheelContent = []byte("not really a wheel, but bytes are bytes")
+
+func wheelContentSha256() string {
+	sum := sha256.Sum256(wheelContent)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestWheelSha256FromFile(t *testing.T) {
+	wheelPath := filepath.Join(t.TempDir(), "arcdata-1.4.5-py2.py3-none-any.whl")
+	require.NoError(t, ioutil.WriteFile(wheelPath, wheelContent, 0644))
+
+	checksum, err := WheelSha256("file://" + wheelPath)
+	require.NoError(t, err)
+	assert.Equal(t, wheelContentSha256(), checksum)
+
+	_, err = WheelSha256("file://" + filepath.Join(t.TempDir(), "missing.whl"))
+	assert.Error(t, err)
+}
+
+func TestVerifyWheelFromHttp(t *testing.T) {
+	served := wheelContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cli-extensions/arcdata-1.4.5-py2.py3-none-any.whl" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(served)
+	}))
+	defer server.Close()
+
+	whlUrl := server.URL + "/cli-extensions/arcdata-1.4.5-py2.py3-none-any.whl"
+
+	t.Run("matching_pin", func(t *testing.T) {
+		assert.NoError(t, VerifyWheel(whlUrl, wheelContentSha256()))
+	})
+
+	t.Run("artifact_changed_under_same_url", func(t *testing.T) {
+		served = []byte("republished wheel")
+		defer func() { served = wheelContent }()
+
+		err := VerifyWheel(whlUrl, wheelContentSha256())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "the artifact changed under the same URL")
+	})
+
+	t.Run("missing_artifact", func(t *testing.T) {
+		err := VerifyWheel(server.URL+"/cli-extensions/gone.whl", wheelContentSha256())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "404")
+	})
+}
+
+func TestValidateWheelSha256(t *testing.T) {
+	env := validEnv()
+	env["ARC_DATA_WHL_SHA256"] = wheelContentSha256()
+	assert.NoError(t, env.Validate())
+	assert.Contains(t, string(env.Render()), "ARC_DATA_WHL_URL=https://azurearcdatacli.blob.core.windows.net/cli-extensions/arcdata-1.4.5-py2.py3-none-any.whl\nARC_DATA_WHL_SHA256="+wheelContentSha256()+"\n")
+
+	env["ARC_DATA_WHL_SHA256"] = "ABC123"
+	err := env.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ARC_DATA_WHL_SHA256 'ABC123' must be a lowercase hex sha256 digest")
+
+	// Unpinned env files stay valid and render without the key
+	delete(env, "ARC_DATA_WHL_SHA256")
+	assert.NoError(t, env.Validate())
+	assert.NotContains(t, string(env.Render()), "ARC_DATA_WHL_SHA256")
+}
@@ -0,0 +1,688 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/files"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Offline replay harness for install-arc-data-services.sh
+//
+// The script runs under bash with stub az, kubectl and sleep executables first on PATH. Every invocation is recorded,
+// and the stubs answer from scripted rules - jq is the real one, so stub answers are the JSON the real CLIs return.
+// Nothing talks to Azure or a Kubernetes API server.
+
+const (
+	installerScriptPath = "../../src/scripts/install-arc-data-services.sh"
+	aksControlJsonPath  = "../../kustomize/overlays/aks/configs/control.json"
+	ocpConfigsDir       = "../../kustomize/overlays/ocp/configs"
+
+	// Separates arguments of one invocation in the stub log - arguments can contain spaces and quotes
+	stubArgSeparator = "\x1f"
+)
+
+// Commands replaced with stubs
+var stubbedCommands = []string{"az", "kubectl", "sleep"}
+
+// One scripted answer of a stubbed command
+type stubResponse struct {
+	Stdout   string
+	ExitCode int
+}
+
+// Answers invocations whose space-joined command line matches Pattern, a bash glob - e.g. "az group list*"
+//
+// Responses are returned in order on repeated matches, the last one is repeated once the list is exhausted.
+// Rules are evaluated in order and the first match wins, so put specific patterns before general ones.
+// Unmatched invocations print nothing and succeed.
+type stubRule struct {
+	Pattern   string
+	Responses []stubResponse
+}
+
+// Shorthand for a rule that always prints stdout and succeeds
+func stubAnswer(pattern, stdout string) stubRule {
+	return stubRule{Pattern: pattern, Responses: []stubResponse{{Stdout: stdout}}}
+}
+
+// Inputs for one replay of the installer script
+type scriptScenario struct {
+	Env   map[string]string // Complete environment of the script, apart from PATH and HOME
+	Rules []stubRule
+}
+
+// Outcome of one replay
+type scriptRun struct {
+	ExitCode int
+	Output   string     // Combined stdout and stderr
+	Commands [][]string // Every stubbed invocation in order, command name first
+	Stdin    []string   // What each invocation in Commands read with "-f -", empty for the rest
+	WorkDir  string     // Working directory the script ran in
+	PlanFile string     // Where a DRY_RUN writes its plan
+	Result   *jobResult // What the script wrote as its termination message, nil if it wrote nothing
+}
+
+// Variables the job receives in a standard onboarding - tests copy and modify this
+func defaultInstallerEnv() map[string]string {
+	return map[string]string{
+		"VERBOSE":                          "false",
+		"ARC_DATA_RELEASE_TRAIN":           "preview",
+		"ARC_DATA_EXT_VERSION":             "1.2.20381002",
+		"ARC_DATA_CONTROLLER_VERSION":      "v1.10.0_2022-08-09",
+		"ARC_DATA_WHL_URL":                 "https://azurearcdatacli.blob.core.windows.net/cli-extensions/arcdata-1.4.5-py2.py3-none-any.whl",
+		"TENANT_ID":                        "00000000-0000-0000-0000-000000000001",
+		"SUBSCRIPTION_ID":                  "00000000-0000-0000-0000-000000000002",
+		"CLIENT_ID":                        "00000000-0000-0000-0000-000000000003",
+		"CLIENT_SECRET":                    "client-secret",
+		"AZDATA_USERNAME":                  "boor",
+		"AZDATA_PASSWORD":                  "acntorPRESTO!",
+		"CONNECTED_CLUSTER_RESOURCE_GROUP": "replay-arc",
+		"CONNECTED_CLUSTER_LOCATION":       "eastus",
+		"CONNECTED_CLUSTER":                "replay-aks",
+		"ARC_DATA_RESOURCE_GROUP":          "replay-arc-data",
+		"ARC_DATA_LOCATION":                "eastus",
+		"ARC_DATA_EXT":                     "arc-data-bootstrapper",
+		"ARC_DATA_NAMESPACE":               "azure-arc-data",
+		"ARC_DATA_CONTROLLER":              "azure-arc-data-controller",
+		"ARC_DATA_CONTROLLER_LOCATION":     "eastus",
+		"CUSTOM_LOCATION_OID":              "51dfe1e8-70c6-4de5-a08e-e18aff23d815",
+		"ONBOARDING_TIMEOUT":               "1200",
+		"OPENSHIFT":                        "false",
+		"DELETE_FLAG":                      "false",
+	}
+}
+
+// Which Azure resources the Central Status Check finds
+type arcResourceState struct {
+	ConnectedClusterResourceGroup bool
+	ArcDataResourceGroup          bool
+	ConnectedCluster              bool
+	Extension                     bool
+	CustomLocation                bool
+	DataController                bool
+}
+
+// Short name for subtests, listing the resources that exist - e.g. "cluster-rg+data-rg+cluster"
+func (s arcResourceState) String() string {
+	names := []string{}
+	for _, resource := range []struct {
+		exists bool
+		name   string
+	}{
+		{s.ConnectedClusterResourceGroup, "cluster-rg"},
+		{s.ArcDataResourceGroup, "data-rg"},
+		{s.ConnectedCluster, "cluster"},
+		{s.Extension, "extension"},
+		{s.CustomLocation, "custom-location"},
+		{s.DataController, "data-controller"},
+	} {
+		if resource.exists {
+			names = append(names, resource.name)
+		}
+	}
+	if len(names) == 0 {
+		return "nothing"
+	}
+	return strings.Join(names, "+")
+}
+
+// Every distinct state the Central Status Check can observe
+//
+// The check is hierarchical: children are only queried when both Resource Groups exist, the extension and Custom Location
+// only when the Connected Cluster exists, and the Data Controller only when the Custom Location exists. Everything below
+// a missing parent is reported as missing, so of the 64 combinations only these are reachable.
+func reachableArcResourceStates() []arcResourceState {
+	seen := map[arcResourceState]bool{}
+	states := []arcResourceState{}
+	for bits := 0; bits < 64; bits++ {
+		state := arcResourceState{
+			ConnectedClusterResourceGroup: bits&1 != 0,
+			ArcDataResourceGroup:          bits&2 != 0,
+			ConnectedCluster:              bits&4 != 0,
+			Extension:                     bits&8 != 0,
+			CustomLocation:                bits&16 != 0,
+			DataController:                bits&32 != 0,
+		}
+		if !state.ConnectedClusterResourceGroup || !state.ArcDataResourceGroup {
+			state.ConnectedCluster = false
+		}
+		if !state.ConnectedCluster {
+			state.Extension, state.CustomLocation = false, false
+		}
+		if !state.CustomLocation {
+			state.DataController = false
+		}
+		if !seen[state] {
+			seen[state] = true
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+// Stub answers for the Central Status Check queries, given which resources exist
+func centralStatusCheckRules(env map[string]string, state arcResourceState) []stubRule {
+	groups := []string{}
+	if state.ConnectedClusterResourceGroup {
+		groups = append(groups, fmt.Sprintf(`{"name": "%s"}`, env["CONNECTED_CLUSTER_RESOURCE_GROUP"]))
+	}
+	if state.ArcDataResourceGroup {
+		groups = append(groups, fmt.Sprintf(`{"name": "%s"}`, env["ARC_DATA_RESOURCE_GROUP"]))
+	}
+
+	extensions := "[]"
+	if state.Extension {
+		extensions = fmt.Sprintf(`[{"name": "%s", "extensionType": "microsoft.arcdataservices"}]`, env["ARC_DATA_EXT"])
+	}
+
+	return []stubRule{
+		stubAnswer("az group list*", fmt.Sprintf("[%s]", strings.Join(groups, ", "))),
+		stubAnswer(fmt.Sprintf("az resource list --name %s *", env["CONNECTED_CLUSTER"]), nameIf(state.ConnectedCluster, env["CONNECTED_CLUSTER"])),
+		stubAnswer("az k8s-extension list *", extensions),
+		stubAnswer(fmt.Sprintf("az resource list --name %s *", env["ARC_DATA_NAMESPACE"]), nameIf(state.CustomLocation, env["ARC_DATA_NAMESPACE"])),
+		stubAnswer(fmt.Sprintf("az resource list --name %s *", env["ARC_DATA_CONTROLLER"]), nameIf(state.DataController, env["ARC_DATA_CONTROLLER"])),
+	}
+}
+
+func nameIf(exists bool, name string) string {
+	if exists {
+		return name + "\n"
+	}
+	return ""
+}
+
+// Stub answers for the show/status commands that follow a create, with everything provisioning successfully and the
+// extension's identity already holding its roles
+//
+// Shown resources run the release env's versions, so there is nothing to upgrade.
+func provisioningSucceededRules(env map[string]string) []stubRule {
+	connectedClusterId := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Kubernetes/connectedClusters/%s", env["SUBSCRIPTION_ID"], env["CONNECTED_CLUSTER_RESOURCE_GROUP"], env["CONNECTED_CLUSTER"])
+
+	return []stubRule{
+		stubAnswer("az account show*", "replay-subscription\n"),
+		stubAnswer("az connectedk8s show *--query id --output*", connectedClusterId+"\n"),
+		stubAnswer("az connectedk8s show *", `{"provisioningState": "Succeeded"}`),
+		stubAnswer("az k8s-extension show *--query id --output*", fmt.Sprintf("%s/providers/Microsoft.KubernetesConfiguration/extensions/%s\n", connectedClusterId, env["ARC_DATA_EXT"])),
+		stubAnswer("az k8s-extension show *--query identity.principalId*", "00000000-0000-0000-0000-00000000000a\n"),
+		stubAnswer("az role assignment list *", "1\n"),
+		stubAnswer("az k8s-extension show *", extensionShowJson(env, "Succeeded", env["ARC_DATA_EXT_VERSION"])),
+		stubAnswer("az customlocation show *", `{"provisioningState": "Succeeded"}`),
+		stubAnswer("az arcdata dc status show *", dataControllerStatusJson(env, "Ready", env["ARC_DATA_CONTROLLER_VERSION"])),
+	}
+}
+
+// Stub answers for the ownership checks before offboarding, with every resource tagged by the installer for the env's
+// cluster, resource groups holding nothing else and left empty once their resources are deleted
+func ownershipTagRules(env map[string]string) []stubRule {
+	return []stubRule{
+		stubAnswer("az tag list *", ownershipTagsJson(env["CONNECTED_CLUSTER"])),
+		stubAnswer("az resource list --resource-group * --output json", "[]"),
+		stubAnswer("az resource list --resource-group *", "0\n"),
+	}
+}
+
+// A Ready, schedulable node as kubectl get nodes reports it - cpu and memory are allocatable quantities, e.g. 3860m
+func replayNode(name, arch, os, cpu, memory string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{corev1.LabelArchStable: arch, corev1.LabelOSStable: os}},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(memory)},
+			Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+// Three Standard_D4s_v3 AKS nodes - 11.6 cores and 36.9 GiB allocatable between them
+func replayNodes() []corev1.Node {
+	return []corev1.Node{
+		replayNode("aks-agentpool-0", "amd64", "linux", "3860m", "12897604Ki"),
+		replayNode("aks-agentpool-1", "amd64", "linux", "3860m", "12897604Ki"),
+		replayNode("aks-agentpool-2", "amd64", "linux", "3860m", "12897604Ki"),
+	}
+}
+
+// AKS' built-in storage classes, with default as the default
+func replayStorageClasses() []storagev1.StorageClass {
+	return []storagev1.StorageClass{
+		{ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: map[string]string{"storageclass.kubernetes.io/is-default-class": "true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "managed-premium"}},
+	}
+}
+
+// kubectl get nodes -o json output
+func nodesJson(nodes []corev1.Node) string {
+	content, _ := json.Marshal(corev1.NodeList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "List"}, Items: nodes})
+	return string(content)
+}
+
+// kubectl get storageclass -o json output
+func storageClassesJson(classes []storagev1.StorageClass) string {
+	content, _ := json.Marshal(storagev1.StorageClassList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "List"}, Items: classes})
+	return string(content)
+}
+
+// Stub answers for the capacity preflight, with a cluster that meets the installer's default minimums
+func clusterCapacityRules() []stubRule {
+	return []stubRule{
+		stubAnswer("kubectl get nodes -o json", nodesJson(replayNodes())),
+		stubAnswer("kubectl get storageclass -o json", storageClassesJson(replayStorageClasses())),
+	}
+}
+
+// Stub answer for the resource provider check, with every provider registered in the subscription
+func registeredProviderRules() []stubRule {
+	return []stubRule{
+		stubAnswer("az provider show *", "Registered\n"),
+	}
+}
+
+// Stub answer for the permission preflight, with the service principal an Owner of every scope
+func ownerPermissionRules() []stubRule {
+	return []stubRule{
+		stubAnswer("az rest --method get --url */providers/Microsoft.Authorization/permissions*", permissionsJson([]string{"*"}, nil)),
+	}
+}
+
+// ARM's permissions list for a caller holding one role with actions and notActions
+func permissionsJson(actions, notActions []string) string {
+	content, _ := json.Marshal(map[string]interface{}{
+		"value": []map[string][]string{{"actions": actions, "notActions": append([]string{}, notActions...)}},
+	})
+	return string(content)
+}
+
+// az tag list output for a resource the installer created for cluster
+func ownershipTagsJson(cluster string) string {
+	return fmt.Sprintf(`{"properties": {"tags": {"%s": "%s", "%s": "%s", "%s": "replay-run"}}}`,
+		ownerTagManagedBy, ownerManagedBy, ownerTagCluster, cluster, ownerTagRunID)
+}
+
+// Data Controller image repository the installer uses for each release train - release trains not listed are not compared
+var controllerRepositories = map[string]string{"stable": "arcdata", "preview": "arcdata/preview"}
+
+// az k8s-extension show output for the Bootstrapper extension, on the env's release train with auto-upgrade off
+func extensionShowJson(env map[string]string, provisioningState, version string) string {
+	return fmt.Sprintf(`{"provisioningState": "%s", "version": "%s", "releaseTrain": "%s", "autoUpgradeMinorVersion": false}`, provisioningState, version, env["ARC_DATA_RELEASE_TRAIN"])
+}
+
+// az arcdata dc status show output, with the datacontroller CR's state, image tag and the env's release train repository
+func dataControllerStatusJson(env map[string]string, state, imageTag string) string {
+	return fmt.Sprintf(`{"properties": {"k8SRaw": {"spec": {"docker": {"repository": "%s", "imageTag": "%s"}}, "status": {"state": "%s"}}}}`,
+		controllerRepositories[env["ARC_DATA_RELEASE_TRAIN"]], imageTag, state)
+}
+
+// Runs install-arc-data-services.sh against the stubs and returns what it did
+func runInstallerScript(t *testing.T, scenario scriptScenario) *scriptRun {
+	if _, err := exec.LookPath("jq"); err != nil {
+		t.Skip("jq is required to replay install-arc-data-services.sh")
+	}
+
+	scriptPath, err := filepath.Abs(installerScriptPath)
+	require.NoError(t, err)
+
+	root := t.TempDir()
+	workDir := filepath.Join(root, "work")
+	stubDir := filepath.Join(root, "stubs")
+	rulesDir := filepath.Join(stubDir, "rules")
+	serviceAccountDir := filepath.Join(root, "serviceaccount")
+	stubLog := filepath.Join(root, "stub.log")
+	planFile := filepath.Join(root, "plan.json")
+	resultFile := filepath.Join(root, "termination-log")
+
+	// Mounted ConfigMaps and service account, as the Job would see them
+	createDirIfNotExist(t, filepath.Join(workDir, "custom"))
+	require.NoError(t, files.CopyFile(aksControlJsonPath, filepath.Join(workDir, "custom", "control.json")))
+	createDirIfNotExist(t, filepath.Join(workDir, "openshift"))
+	require.NoError(t, files.CopyFolderContents(ocpConfigsDir, filepath.Join(workDir, "openshift")))
+	createDirIfNotExist(t, serviceAccountDir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(serviceAccountDir, "token"), []byte("replay-token"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(serviceAccountDir, "ca.crt"), []byte("replay-ca"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(serviceAccountDir, "namespace"), []byte(jobNamespace), 0644))
+
+	writeStubs(t, stubDir, rulesDir, scenario.Rules)
+
+	env := []string{
+		fmt.Sprintf("PATH=%s:%s", stubDir, os.Getenv("PATH")),
+		fmt.Sprintf("HOME=%s", root),
+		fmt.Sprintf("STUB_LOG=%s", stubLog),
+		fmt.Sprintf("STUB_RULES=%s", rulesDir),
+		fmt.Sprintf("SERVICE_ACCOUNT_DIR=%s", serviceAccountDir),
+		fmt.Sprintf("PLAN_FILE=%s", planFile),
+		fmt.Sprintf("RESULT_FILE=%s", resultFile),
+	}
+	for key, value := range scenario.Env {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	cmd := exec.Command("bash", scriptPath)
+	cmd.Dir = workDir
+	cmd.Env = env
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	run := &scriptRun{WorkDir: workDir, PlanFile: planFile}
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		require.True(t, ok, "running installer script: %s", err)
+		run.ExitCode = exitErr.ExitCode()
+	}
+	run.Output = output.String()
+	run.Commands = readStubLog(t, stubLog)
+	run.Stdin = readStubStdin(t, stubLog, len(run.Commands))
+
+	if message, err := ioutil.ReadFile(resultFile); err == nil {
+		result, err := parseJobResult(string(message))
+		require.NoError(t, err, "parsing job result: %s", message)
+		run.Result = &result
+	}
+
+	return run
+}
+
+// Writes one stub executable per stubbed command, plus the scenario's rules as files the stubs read
+func writeStubs(t *testing.T, stubDir, rulesDir string, rules []stubRule) {
+	createDirIfNotExist(t, rulesDir)
+
+	for i, rule := range rules {
+		base := filepath.Join(rulesDir, fmt.Sprintf("%03d", i))
+		require.NoError(t, ioutil.WriteFile(base+".pattern", []byte(rule.Pattern), 0644))
+		for j, response := range rule.Responses {
+			require.NoError(t, ioutil.WriteFile(fmt.Sprintf("%s.%d.stdout", base, j), []byte(response.Stdout), 0644))
+			require.NoError(t, ioutil.WriteFile(fmt.Sprintf("%s.%d.exit", base, j), []byte(fmt.Sprint(response.ExitCode)), 0644))
+		}
+		require.NoError(t, ioutil.WriteFile(base+".count", []byte(fmt.Sprint(len(rule.Responses))), 0644))
+	}
+
+	for _, command := range stubbedCommands {
+		stub := fmt.Sprintf(stubTemplate, command)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(stubDir, command), []byte(stub), 0755))
+	}
+}
+
+// Records the invocation, then answers from the first matching rule - %[1]s is the command name
+const stubTemplate = `#!/bin/bash
+{
+  printf '%%s' '%[1]s'
+  for arg in "$@"; do printf '\x1f%%s' "$arg"; done
+  printf '\n'
+} >> "${STUB_LOG}"
+
+# Manifests piped in with "-f -" are kept next to the log, by invocation
+if [[ " $* " == *" -f - "* ]]; then
+  cat > "${STUB_LOG}.$(wc -l < "${STUB_LOG}").stdin"
+fi
+
+line="%[1]s $*"
+for pattern_file in "${STUB_RULES}"/*.pattern; do
+  [ -e "${pattern_file}" ] || break
+  pattern=$(cat "${pattern_file}")
+  if [[ "${line}" == ${pattern} ]]; then
+    base="${pattern_file%%.pattern}"
+    calls=$(cat "${base}.calls" 2>/dev/null || echo 0)
+    echo $((calls + 1)) > "${base}.calls"
+    count=$(cat "${base}.count")
+    index=$(( calls < count ? calls : count - 1 ))
+    cat "${base}.${index}.stdout"
+    exit "$(cat "${base}.${index}.exit")"
+  fi
+done
+exit 0
+`
+
+func readStubLog(t *testing.T, stubLog string) [][]string {
+	content, err := ioutil.ReadFile(stubLog)
+	if os.IsNotExist(err) {
+		return [][]string{}
+	}
+	require.NoError(t, err)
+
+	commands := [][]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		if line != "" {
+			commands = append(commands, strings.Split(line, stubArgSeparator))
+		}
+	}
+	return commands
+}
+
+// Reads what the stubs kept of each of count invocations' stdin
+func readStubStdin(t *testing.T, stubLog string, count int) []string {
+	stdin := make([]string, count)
+	for i := range stdin {
+		content, err := ioutil.ReadFile(fmt.Sprintf("%s.%d.stdin", stubLog, i+1))
+		if os.IsNotExist(err) {
+			continue
+		}
+		require.NoError(t, err)
+		stdin[i] = string(content)
+	}
+	return stdin
+}
+
+// Every recorded invocation as a space-joined command line
+func (r *scriptRun) commandLines() []string {
+	lines := []string{}
+	for _, command := range r.Commands {
+		lines = append(lines, strings.Join(command, " "))
+	}
+	return lines
+}
+
+// Recorded command lines starting with any of the prefixes, in order - e.g. "az group create", "kubectl delete"
+func (r *scriptRun) commandLinesWithPrefix(prefixes ...string) []string {
+	lines := []string{}
+	for _, line := range r.commandLines() {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(line, prefix) {
+				lines = append(lines, line)
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// Recorded invocations reduced to their leading words, up to the first flag - e.g. "az group create"
+func (r *scriptRun) commandNames() []string {
+	names := []string{}
+	for _, command := range r.Commands {
+		words := []string{}
+		for _, word := range command {
+			if strings.HasPrefix(word, "-") {
+				break
+			}
+			words = append(words, word)
+		}
+		names = append(names, strings.Join(words, " "))
+	}
+	return names
+}
+
+// Verbs of commands that change Azure or the cluster
+var mutatingVerbs = map[string]bool{
+	"create": true, "delete": true, "connect": true, "enable-features": true, "apply": true,
+	"upgrade": true, "update": true, "register": true, "patch": true, "label": true,
+}
+
+// Commands that change Azure or the cluster, in order - what a reviewer cares about in a replay
+func (r *scriptRun) mutatingCommandNames() []string {
+	mutating := []string{}
+	for _, name := range r.commandNames() {
+		words := strings.Fields(name)
+		for _, word := range words[1:] {
+			if mutatingVerbs[word] {
+				mutating = append(mutating, name)
+				break
+			}
+		}
+	}
+	return mutating
+}
+
+// Copies an environment map so scenarios can modify it
+func copyEnv(env map[string]string) map[string]string {
+	copied := make(map[string]string, len(env))
+	for key, value := range env {
+		copied[key] = value
+	}
+	return copied
+}
+
+// Runs only the installer's input validation (VALIDATE_ONLY=true) and returns the run and the values it reports
+//
+// Values are parsed from the "INFO | KEY=value" lines printed once validation passes - empty if it failed.
+func runInstallerValidation(t *testing.T, env map[string]string) (*scriptRun, map[string]string) {
+	env = copyEnv(env)
+	env["VALIDATE_ONLY"] = "true"
+
+	run := runInstallerScript(t, scriptScenario{Env: env})
+
+	values := map[string]string{}
+	reporting := false
+	for _, line := range strings.Split(run.Output, "\n") {
+		if strings.HasPrefix(line, "INFO | VALIDATE_ONLY is set") {
+			reporting = true
+			continue
+		}
+		if !reporting || !strings.HasPrefix(line, "INFO | ") {
+			continue
+		}
+		if key, value, found := strings.Cut(strings.TrimPrefix(line, "INFO | "), "="); found {
+			values[key] = value
+		}
+	}
+
+	return run, values
+}
+
+// One step of the installer's DRY_RUN plan
+type planAction struct {
+	Step     string `json:"step"`     // Step of the Central Status Check tree - e.g. "2a"
+	Resource string `json:"resource"` // e.g. ResourceGroup, ConnectedCluster, Extension, RoleAssignment
+	Name     string `json:"name"`
+	Action   string `json:"action"` // create, skip, keep, delete, apply, enable-features, role-assignment, tag, upgrade or update
+}
+
+// What the installer would do, as printed in DRY_RUN mode
+type installerPlan struct {
+	Mode    string       `json:"mode"` // The ACTION planned - onboard, offboard, upgrade, status or repair
+	Actions []planAction `json:"actions"`
+}
+
+// Prefix of the log line carrying the plan as JSON
+const planLogPrefix = "INFO | Plan: "
+
+// Reads the DRY_RUN plan from the installer's output - works on replay output and on Job logs alike
+func parseInstallerPlan(logs string) (installerPlan, error) {
+	plan := installerPlan{}
+	lines := strings.Split(logs, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(lines[i], planLogPrefix) {
+			err := json.Unmarshal([]byte(strings.TrimPrefix(lines[i], planLogPrefix)), &plan)
+			return plan, err
+		}
+	}
+	return plan, fmt.Errorf("no line starting with '%s' found - was the installer run with DRY_RUN=true?", planLogPrefix)
+}
+
+// Actions that change something, i.e. everything but skips, as "<action> <resource>" - e.g. "create ResourceGroup"
+func (p installerPlan) changes() []string {
+	changes := []string{}
+	for _, action := range p.Actions {
+		if action.Action != "skip" && action.Action != "keep" {
+			changes = append(changes, fmt.Sprintf("%s %s", action.Action, action.Resource))
+		}
+	}
+	return changes
+}
+
+// Actions for one resource name, in plan order
+func (p installerPlan) actionsFor(name string) []string {
+	actions := []string{}
+	for _, action := range p.Actions {
+		if action.Name == name {
+			actions = append(actions, action.Action)
+		}
+	}
+	return actions
+}
+
+// Replays the installer with DRY_RUN=true and returns the run and its parsed plan
+func runInstallerPlan(t *testing.T, scenario scriptScenario) (*scriptRun, installerPlan) {
+	scenario.Env = copyEnv(scenario.Env)
+	scenario.Env["DRY_RUN"] = "true"
+
+	run := runInstallerScript(t, scenario)
+	require.Equal(t, 0, run.ExitCode, run.Output)
+
+	plan, err := parseInstallerPlan(run.Output)
+	require.NoError(t, err)
+
+	return run, plan
+}
+
+// Final status of one resource in the job result
+type jobResultResource struct {
+	Name            string `json:"name"`
+	Status          string `json:"status"` // Created, Exists, Ready, Deleted, Kept, Absent or the failed provisioning state
+	ID              string `json:"id,omitempty"`
+	DurationSeconds int    `json:"durationSeconds"`
+}
+
+// What the installer writes to its termination message - see "Job Result" in install-arc-data-services.sh
+type jobResult struct {
+	Status          string                       `json:"status"` // Succeeded or Failed
+	ExitCode        int                          `json:"exitCode"`
+	Mode            string                       `json:"mode"`                 // onboard, offboard, plan or validate
+	FailedStep      string                       `json:"failedStep,omitempty"` // Step running when the script failed
+	Versions        map[string]string            `json:"versions"`
+	DurationSeconds int                          `json:"durationSeconds"`
+	Resources       map[string]jobResultResource `json:"resources"` // Keyed by step - e.g. connectedCluster, dataController
+	Warnings        []string                     `json:"warnings"`
+	Drift           []jobResultDrift             `json:"drift"` // What is installed but differs from the image's release env
+}
+
+// Installed setting that differs from the image's release env
+type jobResultDrift struct {
+	Resource  string `json:"resource"` // Result key - extension or dataController
+	Field     string `json:"field"`    // version, releaseTrain, autoUpgrade, imageTag or repository
+	Installed string `json:"installed"`
+	Expected  string `json:"expected"`
+}
+
+// Fails with every drifted setting, so the harness can require an installation to match its release env
+func checkNoDrift(drift []jobResultDrift) error {
+	if len(drift) == 0 {
+		return nil
+	}
+	problems := []string{}
+	for _, d := range drift {
+		problems = append(problems, fmt.Sprintf("%s %s is %q, release env has %q", d.Resource, d.Field, d.Installed, d.Expected))
+	}
+	return fmt.Errorf("installation drifted from the release env: %s", strings.Join(problems, "; "))
+}
+
+// Parses the installer's termination message
+func parseJobResult(message string) (jobResult, error) {
+	result := jobResult{}
+	if strings.TrimSpace(message) == "" {
+		return result, fmt.Errorf("empty termination message - the installer did not write a job result")
+	}
+	err := json.Unmarshal([]byte(message), &result)
+	return result, err
+}
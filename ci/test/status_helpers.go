@@ -0,0 +1,170 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/stretchr/testify/require"
+)
+
+// Name of the ConfigMap the installer records its status in
+const installationStatusConfigMap = "azure-arc-data-services-status"
+
+// Default STATUS_NAMESPACE - holds the status ConfigMap and the generated AZDATA password Secret, and outlives the Job's
+// manifest being deleted
+const statusNamespace = "azure-arc-data-services-installer"
+
+// Installation status as recorded by the installer - the ConfigMap's data
+type installationStatus map[string]string
+
+// ARM IDs recorded while onboarded, removed when offboarded
+var installationStatusIDKeys = []string{"connectedClusterId", "extensionId", "customLocationId", "dataControllerId"}
+
+// Job result key of the resource each ID belongs to - IDs of resources an offboarding kept stay recorded
+var installationStatusIDResources = map[string]string{
+	"connectedClusterId": "connectedCluster",
+	"extensionId":        "extension",
+	"customLocationId":   "customLocation",
+	"dataControllerId":   "dataController",
+}
+
+// Facts recorded alongside the IDs while onboarded
+var installationStatusFactKeys = []string{
+	"releaseTrain", "extensionVersion", "controllerRepository", "controllerVersion",
+	"subscriptionId", "connectedClusterResourceGroup", "arcDataResourceGroup", "dataControllerState",
+}
+
+// Checks the status is consistent with the expected state - Onboarded or Offboarded
+//
+// Returns every problem found, not just the first one.
+func (s installationStatus) validate(expectedState string) error {
+	problems := []string{}
+	if s["state"] != expectedState {
+		problems = append(problems, fmt.Sprintf("state is %q, expected %q", s["state"], expectedState))
+	}
+
+	switch expectedState {
+	case "Onboarded":
+		// An installation upgraded before its status was first recorded only has the upgrade time
+		if _, upgraded := s["lastUpgradeTime"]; upgraded {
+			problems = append(problems, checkStatusTime(s, "lastUpgradeTime")...)
+		} else {
+			problems = append(problems, checkStatusTime(s, "lastOnboardTime")...)
+		}
+		for _, key := range installationStatusFactKeys {
+			if s[key] == "" {
+				problems = append(problems, fmt.Sprintf("%s is empty", key))
+			}
+		}
+		for _, key := range installationStatusIDKeys {
+			if !strings.HasPrefix(s[key], "/subscriptions/") {
+				problems = append(problems, fmt.Sprintf("%s %q is not an ARM ID", key, s[key]))
+			}
+		}
+	case "Offboarded":
+		problems = append(problems, checkStatusTime(s, "lastOffboardTime")...)
+		kept := map[string]bool{}
+		for _, resource := range strings.Split(s["kept"], ",") {
+			kept[resource] = true
+		}
+		for _, key := range installationStatusIDKeys {
+			if resource := installationStatusIDResources[key]; kept[resource] {
+				if !strings.HasPrefix(s[key], "/subscriptions/") {
+					problems = append(problems, fmt.Sprintf("%s %q is not an ARM ID, the offboarding kept the %s", key, s[key], resource))
+				}
+				continue
+			}
+			if value, found := s[key]; found {
+				problems = append(problems, fmt.Sprintf("%s %q is still recorded", key, value))
+			}
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown expected state %q", expectedState))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("installation status is invalid: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Checks a timestamp key is present and in RFC 3339
+func checkStatusTime(s installationStatus, key string) []string {
+	if s[key] == "" {
+		return []string{fmt.Sprintf("%s is empty", key)}
+	}
+	if _, err := time.Parse(time.RFC3339, s[key]); err != nil {
+		return []string{fmt.Sprintf("%s %q is not RFC 3339", key, s[key])}
+	}
+	return nil
+}
+
+// Applies a merge patch payload - {"data": {...}} as passed to kubectl patch -p - to the status
+//
+// Null values remove keys, as they do in a JSON merge patch.
+func (s installationStatus) applyPatch(payload string) (installationStatus, error) {
+	patch := struct {
+		Data map[string]*string `json:"data"`
+	}{}
+	if err := json.Unmarshal([]byte(payload), &patch); err != nil {
+		return nil, fmt.Errorf("status patch is not valid JSON: %w", err)
+	}
+
+	patched := installationStatus{}
+	for key, value := range s {
+		patched[key] = value
+	}
+	for key, value := range patch.Data {
+		if value == nil {
+			delete(patched, key)
+			continue
+		}
+		patched[key] = *value
+	}
+	return patched, nil
+}
+
+// Replays every status patch the installer made, in order, onto a starting status - nil if it never patched
+func (r *scriptRun) installationStatus(start installationStatus) (installationStatus, error) {
+	status := installationStatus(nil)
+	for _, command := range r.Commands {
+		if len(command) < 4 || command[0] != "kubectl" || command[1] != "patch" || command[3] != installationStatusConfigMap {
+			continue
+		}
+		payload := ""
+		for i, arg := range command {
+			if arg == "-p" && i+1 < len(command) {
+				payload = command[i+1]
+			}
+		}
+		if status == nil {
+			status = start
+		}
+		patched, err := status.applyPatch(payload)
+		if err != nil {
+			return nil, err
+		}
+		status = patched
+	}
+	return status, nil
+}
+
+// Reads the installation status ConfigMap from the cluster - nil if it does not exist
+func getInstallationStatusWithK8s(t *testing.T, options *k8s.KubectlOptions) installationStatus {
+	output, err := k8s.RunKubectlAndGetOutputE(t, options, "get", "configmap", installationStatusConfigMap, "-n", statusNamespace, "--ignore-not-found", "-o=jsonpath={.data}")
+	require.NoError(t, err)
+	if output == "" {
+		logger.Logf(t, "No installation status ConfigMap %s", installationStatusConfigMap)
+		return nil
+	}
+	logger.Logf(t, "Installation Status: %s", output)
+
+	status := installationStatus{}
+	require.NoError(t, json.Unmarshal([]byte(output), &status))
+	return status
+}
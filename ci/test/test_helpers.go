@@ -3,26 +3,33 @@ package test
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	// Terragrunt
+	"github.com/gruntwork-io/terratest/modules/files"
+	"github.com/gruntwork-io/terratest/modules/logger"
 	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
 
 	// Azure
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+
 	// Testing
+	"github.com/stretchr/testify/require"
+
+	// Release env files
+	"github.com/kangarookube/kube-arc-data-services-installer-job/release"
 )
 
 // To avoid wasting lots of time constantly creating and deleting Blob Storages for the tests that need to store state remotely, we created the Blob Storage ahead of time and pull as environment variables.
 // We declare these as constants to avoid any ambiguity in the code - they'll be fed in via env variables in Devcontainer or CI pipeline.
 const (
 	aksTfModuleDir          = "terraform/aks-rbac" // Relative path from ci root to the AKS terraform module
-	k8sBasePayloadDir       = "../../kustomize/base"
-	k8sAksOverlayPayloadDir = "../../kustomize/overlays/aks"
-	k8sPayloadTempDir       = "../../kustomize/.temp"
+	k8sPayloadRootDir       = "../../kustomize"
 	containerName           = "kube-arc-data-services-installer-job"
 	containerVersion        = "0.1.0" // Pass this in as an env variable with the Git commit hash instead
 	dockerFilePath          = "../../"
@@ -87,6 +94,47 @@ func createaksTfOpts(t *testing.T, terraformDir string) *terraform.Options {
 	}
 }
 
+// Resolves and validates the release trains to run before any stage starts
+//
+// Order of precedence: -releaseTrain flag > RELEASE_TRAIN environment variable (e.g. devcontainer.env) > every release.<train>.env
+// file in the release folder. Fails the test if any train has no env file or an invalid one - nothing gets deployed.
+func resolveReleaseTrains(t *testing.T, flagValue string) []string {
+	requested, source := flagValue, "-releaseTrain flag"
+	if strings.TrimSpace(requested) == "" {
+		requested, source = os.Getenv("RELEASE_TRAIN"), "RELEASE_TRAIN environment variable"
+	}
+	if strings.TrimSpace(requested) == "" {
+		source = fmt.Sprintf("env files discovered in %s", releaseEnvFolder)
+	}
+
+	trains, err := release.ResolveTrains(requested, releaseEnvFolder)
+	if err != nil {
+		t.Fatalf("Refusing to start any test stage, release train from %s is invalid: %s", source, err.Error())
+	}
+	logger.Logf(t, "Running release train(s) %s from %s", strings.Join(trains, ", "), source)
+
+	return trains
+}
+
+// Creates a copy of the ci folder for one release train and returns the path to the Terraform module inside it
+//
+// In CI this is a fresh random temp folder per run. When a SKIP_<stage> variable is set we're iterating locally, so the
+// folder is a stable /tmp/TestAksIntegrationWithStages-<train> that is reused between runs - each train still keeps its own state.
+func createReleaseTrainWorkspace(t *testing.T, releaseTrain string) string {
+	if !test_structure.SkipStageEnvVarSet() {
+		return test_structure.CopyTerraformFolderToTemp(t, "../", aksTfModuleDir)
+	}
+
+	workspaceRoot := filepath.Join(os.TempDir(), fmt.Sprintf("TestAksIntegrationWithStages-%s", releaseTrain))
+	if !files.IsExistingDir(workspaceRoot) {
+		createDirIfNotExist(t, workspaceRoot)
+		require.NoError(t, files.CopyFolderContents("../", workspaceRoot))
+	}
+	logger.Logf(t, "A SKIP_XXX environment variable is set. Using workspace %s for release train %s", workspaceRoot, releaseTrain)
+
+	return filepath.Join(workspaceRoot, aksTfModuleDir)
+}
+
 // Injects environment variables in expected naming for Azure and Terraform SDK authentication with Azure
 // https://docs.microsoft.com/en-us/azure/developer/go/azure-sdk-authentication?tabs=bash
 // https://registry.terraform.io/providers/hashicorp/azurerm/latest/docs/guides/service_principal_client_secret#configuring-the-service-principal-in-terraform
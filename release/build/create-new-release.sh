@@ -49,21 +49,14 @@ if [[ -z "${ARC_DATA_WHL_URL}" ]]; then
     read -p '4. Input ARC_DATA_WHL_URL                  | e.g. "https://arcdataazurecliextension.blob.core.windows.net/stage/arcdata-1.4.3-py2.py3-none-any.whl"): ' ARC_DATA_WHL_URL
 fi
 
-# output release details
+# output release details - validated and written by the release-env tool, base artifacts come from the image above
 echo -e "\nProducing release.${ARC_DATA_RELEASE_TRAIN}.env file:"
-echo "# Base artifacts:" > ../release.${ARC_DATA_RELEASE_TRAIN}.env
-echo "HELM_VERSION=${HELM_VERSION}" >> ../release.${ARC_DATA_RELEASE_TRAIN}.env
-echo "KUBECTL_VERSION=${KUBECTL_VERSION}" >> ../release.${ARC_DATA_RELEASE_TRAIN}.env
-echo "AZCLI_VERSION=${AZCLI_VERSION}" >> ../release.${ARC_DATA_RELEASE_TRAIN}.env
-echo "EXT_K8S_CONFIGURATION_VERSION=${EXT_K8S_CONFIGURATION_VERSION}" >> ../release.${ARC_DATA_RELEASE_TRAIN}.env
-echo "EXT_K8S_EXTENSION_VERSION=${EXT_K8S_EXTENSION_VERSION}" >> ../release.${ARC_DATA_RELEASE_TRAIN}.env
-echo "EXT_K8S_CONNECTEDK8S_VERSION=${EXT_K8S_CONNECTEDK8S_VERSION}" >> ../release.${ARC_DATA_RELEASE_TRAIN}.env
-echo "EXT_K8S_CUSTOMLOCATION_VERSION=${EXT_K8S_CUSTOMLOCATION_VERSION}" >> ../release.${ARC_DATA_RELEASE_TRAIN}.env
-echo "# Arc Data artifacts:" >> ../release.${ARC_DATA_RELEASE_TRAIN}.env
-echo "ARC_DATA_RELEASE_TRAIN=${ARC_DATA_RELEASE_TRAIN}" >> ../release.${ARC_DATA_RELEASE_TRAIN}.env
-echo "ARC_DATA_EXT_VERSION=${ARC_DATA_EXT_VERSION}" >> ../release.${ARC_DATA_RELEASE_TRAIN}.env
-echo "ARC_DATA_CONTROLLER_VERSION=${ARC_DATA_CONTROLLER_VERSION}" >> ../release.${ARC_DATA_RELEASE_TRAIN}.env
-echo "ARC_DATA_WHL_URL=${ARC_DATA_WHL_URL}" >> ../release.${ARC_DATA_RELEASE_TRAIN}.env
+(cd ../../ci/test && go run ./cmd/release-env create \
+    -base ../../release/build/release.env.tmp \
+    -train "${ARC_DATA_RELEASE_TRAIN}" \
+    -ext-version "${ARC_DATA_EXT_VERSION}" \
+    -controller-version "${ARC_DATA_CONTROLLER_VERSION}" \
+    -whl-url "${ARC_DATA_WHL_URL}")
 
 echo -e "\n$(cat ../release.${ARC_DATA_RELEASE_TRAIN}.env)"
 
@@ -34,7 +34,8 @@ DOCKER_BUILD_ARGS="--build-arg HELM_VERSION=${HELM_VERSION} \
 DOCKER_BUILD_ARGS+=" --build-arg ARC_DATA_RELEASE_TRAIN=${ARC_DATA_RELEASE_TRAIN} \
                      --build-arg ARC_DATA_EXT_VERSION=${ARC_DATA_EXT_VERSION} \
                      --build-arg ARC_DATA_CONTROLLER_VERSION=${ARC_DATA_CONTROLLER_VERSION} \
-                     --build-arg ARC_DATA_WHL_URL=${ARC_DATA_WHL_URL}"
+                     --build-arg ARC_DATA_WHL_URL=${ARC_DATA_WHL_URL} \
+                     --build-arg ARC_DATA_WHL_SHA256=${ARC_DATA_WHL_SHA256}"
 
 OPTIONS+="${DOCKER_BUILD_ARGS}"
 
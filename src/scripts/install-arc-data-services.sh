@@ -26,9 +26,93 @@ SOFTWARE.
 
 EOF
 
+# ==========
+# Job Result
+# ==========
+# Written as JSON to the container's termination message on exit, so callers can read the outcome from the Pod status
+# instead of scraping logs. Kubernetes caps the message at 4096 bytes, so keep it compact.
+RESULT_FILE="${RESULT_FILE:-/dev/termination-log}"
+RESULT_WARNINGS='[]'
+RESULT_DRIFT='[]'
+RESULT_RESOURCES='{}'
+RESULT_STEP=''
+RESULT_STEP_STARTED=${SECONDS}
+
+# Logs a warning and keeps it for the result
+function result_warning {
+  echo "WARNING | $1"
+  RESULT_WARNINGS=$(echo "${RESULT_WARNINGS}" | jq -c --arg warning "$1" '. + [$warning]')
+}
+
+# Records a difference between what is installed and the image's release env - $1 result key, $2 field, $3 installed, $4 release env
+#
+# Values the installed resource does not report are logged as unknown, not as drift.
+function result_drift {
+  if [ -z "$3" ]; then
+    echo "INFO | $1 $2 is unknown, release env has '$4'"
+  elif [ "$3" != "$4" ]; then
+    echo "WARNING | Drift | $1 $2 is '$3', release env has '$4'"
+    RESULT_DRIFT=$(echo "${RESULT_DRIFT}" | jq -c --arg resource "$1" --arg field "$2" --arg installed "$3" --arg expected "$4" \
+      '. + [{resource: $resource, field: $field, installed: $installed, expected: $expected}]')
+  fi
+}
+
+# Succeeds if drift was recorded for a resource - $1 result key, $2 field, or any field if empty
+function drift_found {
+  [ "$(echo "${RESULT_DRIFT}" | jq --arg resource "$1" --arg field "${2:-}" '[.[] | select(.resource == $resource and ($field == "" or .field == $field))] | length')" -gt 0 ]
+}
+
+# Starts timing a resource step - $1 resource key, reported as failedStep if the script fails before the next step
+function result_step {
+  RESULT_STEP="$1"
+  RESULT_STEP_STARTED=${SECONDS}
+}
+
+# Records a resource's final status for the current step - $1 name, $2 status, $3 ARM ID if the resource exists
+function result_resource {
+  RESULT_RESOURCES=$(echo "${RESULT_RESOURCES}" | jq -c \
+    --arg key "${RESULT_STEP}" \
+    --arg name "$1" \
+    --arg status "$2" \
+    --arg id "${3:-}" \
+    --argjson duration "$((SECONDS - RESULT_STEP_STARTED))" \
+    '.[$key] = ({name: $name, status: $status, durationSeconds: $duration} + (if $id == "" then {} else {id: $id} end))')
+}
+
+function write_result {
+  local exit_code=$?
+  local status='Succeeded'
+  local failed_step=''
+  if [ "${exit_code}" -ne 0 ]; then
+    status='Failed'
+    failed_step="${RESULT_STEP}"
+  fi
+
+  jq -cn \
+    --arg status "${status}" \
+    --argjson exitCode "${exit_code}" \
+    --arg mode "${RESULT_MODE}" \
+    --arg failedStep "${failed_step}" \
+    --arg releaseTrain "${ARC_DATA_RELEASE_TRAIN}" \
+    --arg extensionVersion "${ARC_DATA_EXT_VERSION}" \
+    --arg controllerVersion "${ARC_DATA_CONTROLLER_VERSION}" \
+    --argjson durationSeconds "${SECONDS}" \
+    --argjson resources "${RESULT_RESOURCES}" \
+    --argjson warnings "${RESULT_WARNINGS}" \
+    --argjson drift "${RESULT_DRIFT}" \
+    '{status: $status, exitCode: $exitCode, mode: $mode}
+     + (if $failedStep == "" then {} else {failedStep: $failedStep} end)
+     + {versions: {releaseTrain: $releaseTrain, extensionVersion: $extensionVersion, controllerVersion: $controllerVersion},
+        durationSeconds: $durationSeconds, resources: $resources, warnings: $warnings, drift: $drift}' \
+    > "${RESULT_FILE}" 2>/dev/null || echo "WARNING | Could not write job result to ${RESULT_FILE}"
+}
+
+trap write_result EXIT
+
 # ================
 # Input Validation
 # ================
+result_step validation
 
 if [[ -z "${VERBOSE}" ]]; then
   echo "INFO | Verbose flag not set, defaulting to true"
@@ -90,7 +174,7 @@ fi
 ARC_DATA_CONTROLLER_VERSION_CONTROL_JSON=$(cat "./custom/control.json" | jq -r .spec.docker.imageTag)
 
 if [[ "${ARC_DATA_CONTROLLER_VERSION}" != "${ARC_DATA_CONTROLLER_VERSION_CONTROL_JSON}" ]]; then
-  echo "WARNING | variable ARC_DATA_CONTROLLER_VERSION = '${ARC_DATA_CONTROLLER_VERSION}' does not match control.json's spec.docker.imageTag = '${ARC_DATA_CONTROLLER_VERSION_CONTROL_JSON}' - control.json will be replaced"
+  result_warning "variable ARC_DATA_CONTROLLER_VERSION = '${ARC_DATA_CONTROLLER_VERSION}' does not match control.json's spec.docker.imageTag = '${ARC_DATA_CONTROLLER_VERSION_CONTROL_JSON}' - control.json will be replaced"
 fi
 
 # Compare .spec.docker.repository in control.json with releaseTrain
@@ -103,7 +187,7 @@ elif [[ "${ARC_DATA_RELEASE_TRAIN}" == "preview" ]]; then
 fi
 
 if [[ "${ARC_DATA_CONTROLLER_REPO_CONTROL_JSON}" != "${ARC_DATA_CONTROLLER_DESIRED_REPO}" ]]; then
-  echo "WARNING | control.json's spec.docker.repository = '${ARC_DATA_CONTROLLER_REPO_CONTROL_JSON}' does not match desired for ${ARC_DATA_RELEASE_TRAIN} = '${ARC_DATA_CONTROLLER_DESIRED_REPO}' - control.json will be replaced"
+  result_warning "control.json's spec.docker.repository = '${ARC_DATA_CONTROLLER_REPO_CONTROL_JSON}' does not match desired for ${ARC_DATA_RELEASE_TRAIN} = '${ARC_DATA_CONTROLLER_DESIRED_REPO}' - control.json will be replaced"
 fi
 
 # Copy ./custom/control.json to /tmp/custom/control.json, because K8s ConfigMap is readOnly
@@ -120,6 +204,47 @@ if [[ -z "${DELETE_FLAG}" ]]; then
   export DELETE_FLAG='false'
 fi
 
+# ACTION - onboard, offboard, upgrade, status or repair
+# DELETE_FLAG='true' is kept for backward compatibility and means offboard
+if [[ -z "${ACTION}" ]]; then
+  if [ "${DELETE_FLAG}" = 'true' ]; then
+    export ACTION='offboard'
+  else
+    export ACTION='onboard'
+  fi
+  echo "INFO | ACTION is not set, defaulting to ${ACTION} from DELETE_FLAG=${DELETE_FLAG}"
+fi
+
+case "${ACTION}" in
+  onboard|offboard|upgrade|status|repair) ;;
+  *)
+    echo "ERROR | variable ACTION must be one of onboard, offboard, upgrade, status or repair, got '${ACTION}'"
+    exit 1
+    ;;
+esac
+
+if [ "${DELETE_FLAG}" = 'true' ] && [ "${ACTION}" != 'offboard' ]; then
+  echo "ERROR | DELETE_FLAG=true conflicts with ACTION=${ACTION}, unset DELETE_FLAG or set ACTION=offboard"
+  exit 1
+fi
+
+# OFFBOARD_SCOPE - what ACTION=offboard removes
+#   data-services-only     = Data Controller, Custom Location and Bootstrapper extension, the Connected Cluster stays for other extensions
+#   keep-connected-cluster = data-services-only plus the Arc Data Services resource group
+#   full                   = everything, including the Connected Cluster and its resource group
+if [[ -z "${OFFBOARD_SCOPE}" ]]; then
+  echo "INFO | OFFBOARD_SCOPE is not set, defaulting to full"
+  export OFFBOARD_SCOPE='full'
+fi
+
+case "${OFFBOARD_SCOPE}" in
+  data-services-only|keep-connected-cluster|full) ;;
+  *)
+    echo "ERROR | variable OFFBOARD_SCOPE must be one of data-services-only, keep-connected-cluster or full, got '${OFFBOARD_SCOPE}'"
+    exit 1
+    ;;
+esac
+
 if [[ -z "${OPENSHIFT}" ]]; then
   echo "INFO | OPENSHIFT is not set, defaulting to false"
   export OPENSHIFT='false'
@@ -129,8 +254,79 @@ if [ "${OPENSHIFT}" = 'true' ]; then
   echo "INFO | Onboarding will run in context for OpenShift"
 fi
 
-if [ "${DELETE_FLAG}" = 'false' ]; then
-  echo "INFO | Starting Arc + Data Services onboarding process"
+# REGISTER_RESOURCE_PROVIDERS - register the resource providers Arc Data Services needs if the subscription hasn't yet
+if [[ -z "${REGISTER_RESOURCE_PROVIDERS}" ]]; then
+  echo "INFO | REGISTER_RESOURCE_PROVIDERS is not set, defaulting to false"
+  export REGISTER_RESOURCE_PROVIDERS='false'
+fi
+
+case "${REGISTER_RESOURCE_PROVIDERS}" in
+  true|false) ;;
+  *)
+    echo "ERROR | variable REGISTER_RESOURCE_PROVIDERS must be true or false, got '${REGISTER_RESOURCE_PROVIDERS}'"
+    exit 1
+    ;;
+esac
+
+# ADOPT_EXISTING_RESOURCES - stamp the ownership tags onto existing resources that carry none, e.g. created by an
+# installer version before ownership tags, so offboarding can delete them
+if [[ -z "${ADOPT_EXISTING_RESOURCES}" ]]; then
+  echo "INFO | ADOPT_EXISTING_RESOURCES is not set, defaulting to false"
+  export ADOPT_EXISTING_RESOURCES='false'
+fi
+
+case "${ADOPT_EXISTING_RESOURCES}" in
+  true|false) ;;
+  *)
+    echo "ERROR | variable ADOPT_EXISTING_RESOURCES must be true or false, got '${ADOPT_EXISTING_RESOURCES}'"
+    exit 1
+    ;;
+esac
+
+if [[ -z "${GENERATE_AZDATA_PASSWORD}" ]]; then
+  echo "INFO | GENERATE_AZDATA_PASSWORD is not set, defaulting to false"
+  export GENERATE_AZDATA_PASSWORD='false'
+fi
+
+case "${GENERATE_AZDATA_PASSWORD}" in
+  true|false) ;;
+  *)
+    echo "ERROR | variable GENERATE_AZDATA_PASSWORD must be true or false, got '${GENERATE_AZDATA_PASSWORD}'"
+    exit 1
+    ;;
+esac
+
+# STATUS_NAMESPACE - namespace of the installation status ConfigMap and the generated AZDATA password Secret. Not the
+# Job's own namespace, which goes away when the Job's manifest is deleted, and not one onboarding or offboarding removes.
+if [[ -z "${STATUS_NAMESPACE}" ]]; then
+  echo "INFO | STATUS_NAMESPACE is not set, defaulting to azure-arc-data-services-installer"
+  export STATUS_NAMESPACE='azure-arc-data-services-installer'
+fi
+
+if ! [[ "${STATUS_NAMESPACE}" =~ ^[a-z0-9]([-a-z0-9]*[a-z0-9])?$ ]] || [ "${#STATUS_NAMESPACE}" -gt 63 ]; then
+  echo "ERROR | variable STATUS_NAMESPACE must be a Kubernetes namespace name, at most 63 lowercase letters, digits or '-', starting and ending with a letter or digit, got '${STATUS_NAMESPACE}'"
+  exit 1
+fi
+
+for namespace in azure-arc "${ARC_DATA_NAMESPACE}"; do
+  if [ "${STATUS_NAMESPACE}" = "${namespace}" ]; then
+    echo "ERROR | variable STATUS_NAMESPACE must not be ${namespace}, offboarding deletes it"
+    exit 1
+  fi
+done
+
+echo "INFO | Starting Arc + Data Services ${ACTION} process"
+
+if [ "${DRY_RUN}" = 'true' ]; then
+  echo "INFO | DRY_RUN is set, the plan will be printed and nothing will be created or deleted"
+fi
+
+if [ "${VALIDATE_ONLY}" = 'true' ]; then
+  RESULT_MODE='validate'
+elif [ "${DRY_RUN}" = 'true' ]; then
+  RESULT_MODE='plan'
+else
+  RESULT_MODE="${ACTION}"
 fi
 
 if [[ -z "${CONNECTED_CLUSTER_LOCATION}" ]]; then
@@ -173,11 +369,6 @@ if [[ -z "${ARC_DATA_RESOURCE_GROUP}" ]]; then
   exit 1
 fi
 
-if [[ -z "${ARC_DATA_RESOURCE_GROUP}" ]]; then
-  echo "ERROR | variable ARC_DATA_RESOURCE_GROUP is required."
-  exit 1
-fi
-
 if [[ -z "${ARC_DATA_LOCATION}" ]]; then
   echo "INFO | variable ARC_DATA_LOCATION is not set, defaulting to CONNECTED_CLUSTER_LOCATION"
   ARC_DATA_LOCATION=${CONNECTED_CLUSTER_LOCATION}
@@ -206,6 +397,76 @@ if [[ -z "${ARC_DATA_CONTROLLER}" ]]; then
   exit 1
 fi
 
+# Names and locations - checked against Azure's and Kubernetes' naming rules and the regions each resource type is
+# available in, so a bad value fails here instead of part way through onboarding. ci/test/naming holds the same rules
+# and regions, and its TestInstallerScriptInSync fails when the two differ - change both together.
+#
+# ARC_DATA_NAMESPACE names both the Custom Location and its Kubernetes namespace, and the Custom Location is created in
+# CONNECTED_CLUSTER_LOCATION. ARC_DATA_LOCATION is only used for the Arc Data resource group.
+RESOURCE_GROUP_REGIONS=(australiacentral australiacentral2 australiaeast australiasoutheast brazilsouth brazilsoutheast
+                        canadacentral canadaeast centralindia centralus eastasia eastus eastus2 francecentral francesouth
+                        germanynorth germanywestcentral japaneast japanwest jioindiacentral jioindiawest koreacentral
+                        koreasouth northcentralus northeurope norwayeast norwaywest qatarcentral southafricanorth
+                        southafricawest southcentralus southeastasia southindia swedencentral switzerlandnorth
+                        switzerlandwest uaecentral uaenorth uksouth ukwest westcentralus westeurope westindia westus
+                        westus2 westus3)
+CONNECTED_CLUSTER_REGIONS=(australiaeast brazilsouth canadacentral canadaeast centralindia centralus eastasia eastus
+                           eastus2 francecentral germanywestcentral japaneast koreacentral northcentralus northeurope
+                           norwayeast southafricanorth southcentralus southeastasia swedencentral switzerlandnorth
+                           uaenorth uksouth ukwest westcentralus westeurope westus westus2 westus3)
+CUSTOM_LOCATION_REGIONS=(australiaeast brazilsouth canadacentral centralindia centralus eastasia eastus eastus2
+                         francecentral germanywestcentral japaneast koreacentral northcentralus northeurope norwayeast
+                         southafricanorth southcentralus southeastasia swedencentral switzerlandnorth uaenorth uksouth
+                         westcentralus westeurope westus westus2 westus3)
+DATA_CONTROLLER_REGIONS=(australiaeast brazilsouth canadacentral centralindia centralus eastasia eastus eastus2
+                         francecentral germanywestcentral japaneast koreacentral northeurope norwayeast southafricanorth
+                         southcentralus southeastasia swedencentral uksouth westeurope westus2 westus3)
+
+NAMING_PROBLEMS=()
+
+# Records a problem unless variable $1 matches pattern $2 and is at most $3 characters - $4 describes the rule
+function check_name {
+  local name="${!1}"
+  if ! [[ "${name}" =~ $2 ]] || [ "${#name}" -gt "$3" ]; then
+    NAMING_PROBLEMS+=("variable $1 must be $4, got '${name}'")
+  fi
+}
+
+# Records a problem unless variable $1 is one of the regions in array $3, ignoring case - $2 names the resource type
+function check_location {
+  local -n regions=$3
+  local location="${!1,,}"
+  if [[ -z "${location}" ]] || [[ "${location}" == *" "* ]] || ! [[ " ${regions[*]} " == *" ${location} "* ]]; then
+    NAMING_PROBLEMS+=("variable $1 must be a region $2 are available in, e.g. eastus, got '${!1}'")
+  fi
+}
+
+check_name CONNECTED_CLUSTER_RESOURCE_GROUP '^[-_.()a-zA-Z0-9]*[-_()a-zA-Z0-9]$' 90 \
+  "at most 90 letters, digits, '-', '_', '.', '(' or ')', not ending in '.'"
+check_name ARC_DATA_RESOURCE_GROUP '^[-_.()a-zA-Z0-9]*[-_()a-zA-Z0-9]$' 90 \
+  "at most 90 letters, digits, '-', '_', '.', '(' or ')', not ending in '.'"
+check_name CONNECTED_CLUSTER '^[a-zA-Z0-9]([-_a-zA-Z0-9]*[a-zA-Z0-9])?$' 63 \
+  "at most 63 letters, digits, '-' or '_', starting and ending with a letter or digit"
+check_name ARC_DATA_EXT '^[a-z0-9]([-.a-z0-9]*[a-z0-9])?$' 53 \
+  "at most 53 lowercase letters, digits, '-' or '.', starting and ending with a letter or digit"
+check_name ARC_DATA_NAMESPACE '^[a-z0-9]([-a-z0-9]*[a-z0-9])?$' 63 \
+  "a Kubernetes namespace name, at most 63 lowercase letters, digits or '-', starting and ending with a letter or digit"
+check_name ARC_DATA_CONTROLLER '^[a-z0-9]([-a-z0-9]*[a-z0-9])?$' 63 \
+  "at most 63 lowercase letters, digits or '-', starting and ending with a letter or digit"
+
+check_location CONNECTED_CLUSTER_LOCATION 'Arc-enabled Kubernetes clusters' CONNECTED_CLUSTER_REGIONS
+check_location CONNECTED_CLUSTER_LOCATION 'Custom Locations' CUSTOM_LOCATION_REGIONS
+check_location ARC_DATA_LOCATION 'resource groups' RESOURCE_GROUP_REGIONS
+check_location ARC_DATA_CONTROLLER_LOCATION 'Arc Data Controllers' DATA_CONTROLLER_REGIONS
+
+if [ "${#NAMING_PROBLEMS[@]}" -gt 0 ]; then
+  for problem in "${NAMING_PROBLEMS[@]}"; do
+    echo "ERROR | ${problem}"
+  done
+  echo "ERROR | ${#NAMING_PROBLEMS[@]} name(s) or location(s) would be rejected by Azure or Kubernetes, nothing was changed"
+  exit 1
+fi
+
 if [[ -z "${AZDATA_USERNAME}" ]]; then
   echo "ERROR | variable AZDATA_USERNAME is required."
   exit 1
@@ -217,20 +478,79 @@ else
   export AZDATA_METRICSUI_USERNAME
 fi
 
-if [[ -z "${AZDATA_PASSWORD}" ]]; then
-  echo "ERROR | variable AZDATA_PASSWORD is required."
-  exit 1
-else
-  echo "INFO | variable AZDATA_PASSWORD is set, also defaulting AZDATA_LOGSUI_PASSWORD and AZDATA_METRICSUI_PASSWORD"
+# AZDATA_PASSWORD - also the password of the Data Controller's metrics and logs dashboards, which reject passwords
+# breaking this policy, but only once the Data Controller is being created
+#
+# Prints each rule password $1 breaks for username $2, one per line - nothing if it complies
+function azdata_password_problems {
+  local classes=0
+  for class in '[[:upper:]]' '[[:lower:]]' '[[:digit:]]' '[^[:alnum:]]'; do
+    if [[ "$1" =~ $class ]]; then
+      classes=$((classes + 1))
+    fi
+  done
+
+  if [ "${#1}" -lt 8 ] || [ "${#1}" -gt 128 ]; then
+    echo "be 8 to 128 characters long"
+  fi
+  if [ "${classes}" -lt 3 ]; then
+    echo "contain characters from three of: uppercase letters, lowercase letters, digits and symbols"
+  fi
+  if [[ -n "$2" ]] && [[ "${1,,}" == *"${2,,}"* ]]; then
+    echo "not contain AZDATA_USERNAME"
+  fi
+}
+
+# Prints a random 24 character password that meets the policy for username $1
+function generate_azdata_password {
+  local password=''
+  until [ "${#password}" -eq 24 ] && [[ -z "$(azdata_password_problems "${password}" "$1")" ]]; do
+    password=$(head -c 1024 /dev/urandom | LC_ALL=C tr -dc 'A-Za-z0-9!#%+:=?@^_~-')
+    password="${password:0:24}"
+  done
+  echo "${password}"
+}
+
+# Fails unless AZDATA_PASSWORD meets the policy, without printing it - $1 says where it came from
+function check_azdata_password {
+  local problems
+  mapfile -t problems < <(azdata_password_problems "${AZDATA_PASSWORD}" "${AZDATA_USERNAME}")
+  if [ "${#problems[@]}" -gt 0 ]; then
+    for problem in "${problems[@]}"; do
+      echo "ERROR | $1 must ${problem}"
+    done
+    exit 1
+  fi
+}
+
+function mirror_azdata_password {
   AZDATA_LOGSUI_PASSWORD=${AZDATA_PASSWORD}
   AZDATA_METRICSUI_PASSWORD=${AZDATA_PASSWORD}
   export AZDATA_LOGSUI_PASSWORD
   export AZDATA_METRICSUI_PASSWORD
+}
+
+# Where a generated AZDATA_PASSWORD is kept, in STATUS_NAMESPACE
+AZDATA_SECRET="${AZDATA_SECRET:-azure-arc-data-services-azdata}"
+
+if [[ -z "${AZDATA_PASSWORD}" ]]; then
+  if [ "${GENERATE_AZDATA_PASSWORD}" != 'true' ]; then
+    echo "ERROR | variable AZDATA_PASSWORD is required."
+    echo "ERROR | Set it, or set GENERATE_AZDATA_PASSWORD=true to have the Job generate one and keep it in Secret ${AZDATA_SECRET}"
+    exit 1
+  fi
+  echo "INFO | variable AZDATA_PASSWORD is not set, onboarding and repair read it from Secret ${AZDATA_SECRET} or generate it"
+else
+  check_azdata_password 'variable AZDATA_PASSWORD'
+  echo "INFO | variable AZDATA_PASSWORD is set, also defaulting AZDATA_LOGSUI_PASSWORD and AZDATA_METRICSUI_PASSWORD"
+  mirror_azdata_password
 fi
 
-custom_location_oid_param=()
-if [[ -n "${CUSTOM_LOCATION_OID}" ]]; then
-  custom_location_oid_param+=(--custom-locations-oid "${CUSTOM_LOCATION_OID}")
+# CUSTOM_LOCATION_OID - object ID of the Custom Locations resource provider's service principal in this tenant
+# Resolved from Microsoft Graph after logging in to Azure when it isn't set
+if [[ -n "${CUSTOM_LOCATION_OID}" ]] && ! [[ "${CUSTOM_LOCATION_OID}" =~ ^[0-9a-fA-F]{8}-([0-9a-fA-F]{4}-){3}[0-9a-fA-F]{12}$ ]]; then
+  echo "ERROR | variable CUSTOM_LOCATION_OID must be an object ID, e.g. 00000000-0000-0000-0000-000000000000, got '${CUSTOM_LOCATION_OID}'"
+  exit 1
 fi
 
 timeout_param=()
@@ -238,13 +558,123 @@ if [[ -n "${ONBOARDING_TIMEOUT}" ]]; then
   timeout_param+=(--onboarding-timeout "${ONBOARDING_TIMEOUT}")
 fi
 
+# CAPACITY_MIN_NODES, CAPACITY_MIN_CPU and CAPACITY_MIN_MEMORY_GIB - what the cluster's Ready amd64/linux nodes must
+# have between them for the Data Controller: node count, allocatable cores and allocatable GiB of memory
+declare -A CAPACITY_DEFAULTS=([CAPACITY_MIN_NODES]=3 [CAPACITY_MIN_CPU]=4 [CAPACITY_MIN_MEMORY_GIB]=16)
+for var in CAPACITY_MIN_NODES CAPACITY_MIN_CPU CAPACITY_MIN_MEMORY_GIB; do
+  if [[ -z "${!var}" ]]; then
+    echo "INFO | ${var} is not set, defaulting to ${CAPACITY_DEFAULTS[$var]}"
+    export "${var}=${CAPACITY_DEFAULTS[$var]}"
+  fi
+
+  if ! [[ "${!var}" =~ ^[0-9]+$ ]]; then
+    echo "ERROR | variable ${var} must be a whole number, got '${!var}'"
+    exit 1
+  fi
+done
+
+# AZURE_TAGS - tags for every resource the installer creates, e.g. cost center or environment
+# Comma separated key=value pairs, 'costCenter=1234,environment=dev', or a JSON object, '{"costCenter": "1234"}'
+user_tags=()
+if [[ -n "${AZURE_TAGS}" ]]; then
+  if ! AZURE_TAGS_JSON=$(jq -cn --arg tags "${AZURE_TAGS}" '
+      if ($tags | test("^\\s*\\{")) then ($tags | fromjson)
+      else [$tags | split(",")[] | select(test("\\S")) | (capture("^\\s*(?<key>[^=]*?)\\s*=\\s*(?<value>.*?)\\s*$") // error)] | from_entries
+      end
+      | if type == "object" and all(.[]; type == "string") and all(keys[]; length > 0) then . else error end' 2> /dev/null); then
+    echo "ERROR | variable AZURE_TAGS must be comma separated key=value pairs or a JSON object of strings, got '${AZURE_TAGS}'"
+    exit 1
+  fi
+
+  AZURE_TAGS_RESERVED=$(echo "${AZURE_TAGS_JSON}" | jq -r '[keys[] | select(startswith("arc-installer-"))] | join(", ")')
+  if [[ -n "${AZURE_TAGS_RESERVED}" ]]; then
+    echo "ERROR | variable AZURE_TAGS can't set ${AZURE_TAGS_RESERVED}, arc-installer- tags are reserved for ownership"
+    exit 1
+  fi
+
+  AZURE_TAGS_INVALID=$(echo "${AZURE_TAGS_JSON}" | jq -r '[keys[] | select(test("[<>%&\\\\?/]"))] | join(", ")')
+  if [[ -n "${AZURE_TAGS_INVALID}" ]]; then
+    echo "ERROR | variable AZURE_TAGS keys can't contain any of <>%&\\?/, got ${AZURE_TAGS_INVALID}"
+    exit 1
+  fi
+
+  # Azure allows 50 tags per resource, 3 are the installer's ownership tags
+  if [ "$(echo "${AZURE_TAGS_JSON}" | jq 'length')" -gt 47 ]; then
+    echo "ERROR | variable AZURE_TAGS can have at most 47 tags, got $(echo "${AZURE_TAGS_JSON}" | jq 'length')"
+    exit 1
+  fi
+
+  AZURE_TAGS="${AZURE_TAGS_JSON}"
+  export AZURE_TAGS
+  mapfile -t user_tags < <(echo "${AZURE_TAGS}" | jq -r 'to_entries[] | "\(.key)=\(.value)"')
+fi
+
+# ARC_DATA_EXT_ROLE_ASSIGNMENTS - roles for the Bootstrapper extension's managed identity, comma separated
+# Each role is assigned on the Arc Data Services resource group, or on the scope after an @, e.g. 'Reader@/subscriptions/<id>'
+if [[ -z "${ARC_DATA_EXT_ROLE_ASSIGNMENTS}" ]]; then
+  echo "INFO | ARC_DATA_EXT_ROLE_ASSIGNMENTS is not set, defaulting to Contributor,Monitoring Metrics Publisher"
+  export ARC_DATA_EXT_ROLE_ASSIGNMENTS='Contributor,Monitoring Metrics Publisher'
+fi
+
+if ! ARC_DATA_EXT_ROLE_ASSIGNMENTS_JSON=$(jq -cn --arg assignments "${ARC_DATA_EXT_ROLE_ASSIGNMENTS}" \
+    --arg defaultScope "/subscriptions/${SUBSCRIPTION_ID}/resourceGroups/${ARC_DATA_RESOURCE_GROUP}" '
+    [$assignments | split(",")[] | select(test("\\S"))
+     | (capture("^\\s*(?<role>[^@]*?)\\s*(@\\s*(?<scope>[^@\\s]+)\\s*)?$") // error)
+     | {role: .role, scope: (.scope // $defaultScope)}
+     | if .role == "" or (.scope | startswith("/subscriptions/") | not) then error else . end]
+    | if length > 0 then . else error end' 2> /dev/null); then
+  echo "ERROR | variable ARC_DATA_EXT_ROLE_ASSIGNMENTS must be comma separated role names, each optionally followed by @<scope ARM ID>, got '${ARC_DATA_EXT_ROLE_ASSIGNMENTS}'"
+  exit 1
+fi
+
+# Fully qualified, role@scope
+ARC_DATA_EXT_ROLE_ASSIGNMENTS=$(echo "${ARC_DATA_EXT_ROLE_ASSIGNMENTS_JSON}" | jq -r 'map("\(.role)@\(.scope)") | join(",")')
+export ARC_DATA_EXT_ROLE_ASSIGNMENTS
+mapfile -t ext_role_assignments < <(echo "${ARC_DATA_EXT_ROLE_ASSIGNMENTS_JSON}" | jq -r '.[] | "\(.role)\t\(.scope)"')
+
+# ===============================
+# Validate only - no Azure or K8s
+# ===============================
+# Prints the validated and derived inputs, then exits before authenticating anywhere
+if [ "${VALIDATE_ONLY}" = 'true' ]; then
+  echo ""
+  echo "INFO | VALIDATE_ONLY is set, input validation passed with the following values:"
+  for var in ARC_DATA_RELEASE_TRAIN ARC_DATA_EXT_VERSION ARC_DATA_CONTROLLER_VERSION ARC_DATA_CONTROLLER_DESIRED_REPO \
+             ACTION DELETE_FLAG OFFBOARD_SCOPE OPENSHIFT REGISTER_RESOURCE_PROVIDERS ADOPT_EXISTING_RESOURCES GENERATE_AZDATA_PASSWORD \
+             STATUS_NAMESPACE TENANT_ID SUBSCRIPTION_ID CLIENT_ID \
+             CONNECTED_CLUSTER_RESOURCE_GROUP CONNECTED_CLUSTER_LOCATION CONNECTED_CLUSTER \
+             ARC_DATA_RESOURCE_GROUP ARC_DATA_LOCATION ARC_DATA_EXT ARC_DATA_NAMESPACE ARC_DATA_CONTROLLER ARC_DATA_CONTROLLER_LOCATION \
+             AZDATA_USERNAME AZDATA_LOGSUI_USERNAME AZDATA_METRICSUI_USERNAME \
+             AZDATA_LOGSUI_PASSWORD AZDATA_METRICSUI_PASSWORD \
+             CUSTOM_LOCATION_OID ONBOARDING_TIMEOUT AZURE_TAGS ARC_DATA_EXT_ROLE_ASSIGNMENTS \
+             CAPACITY_MIN_NODES CAPACITY_MIN_CPU CAPACITY_MIN_MEMORY_GIB; do
+    value="${!var}"
+    # Never print secrets - only whether the mirrored passwords match AZDATA_PASSWORD
+    if [[ "${var}" == AZDATA_*_PASSWORD ]]; then
+      if [[ "${value}" == "${AZDATA_PASSWORD}" ]]; then
+        value='<same as AZDATA_PASSWORD>'
+      else
+        value='<redacted>'
+      fi
+    fi
+    echo "INFO | ${var}=${value}"
+  done
+  echo ""
+  echo "----------------------------------------------------------------------------------"
+  echo "INFO | Validation complete."
+  exit 0
+fi
+
 # ==========================
 # Authenticate to API Server
 # ==========================
+result_step authentication
 
 APISERVER=https://kubernetes.default.svc/
-TOKEN=$(cat /var/run/secrets/kubernetes.io/serviceaccount/token)
-cat /var/run/secrets/kubernetes.io/serviceaccount/ca.crt > ca.crt
+# Overridable so the script can be replayed outside a Pod
+SERVICE_ACCOUNT_DIR="${SERVICE_ACCOUNT_DIR:-/var/run/secrets/kubernetes.io/serviceaccount}"
+TOKEN=$(cat "${SERVICE_ACCOUNT_DIR}/token")
+cat "${SERVICE_ACCOUNT_DIR}/ca.crt" > ca.crt
 
 kubectl config set-cluster azure-arc-kubernetes-bootstrap \
   --embed-certs=true \
@@ -268,6 +698,155 @@ echo "INFO | Using following config:"
 kubectl config view
 echo ""
 
+# =============================
+# Installation Status ConfigMap
+# =============================
+# Durable in-cluster record of what is installed, for platform teams and downstream jobs.
+# Lives in STATUS_NAMESPACE, so deleting the Job's manifest keeps it.
+STATUS_CONFIGMAP="${STATUS_CONFIGMAP:-azure-arc-data-services-status}"
+
+# Creates STATUS_NAMESPACE if needed
+function ensure_status_namespace {
+  if ! kubectl get namespace "${STATUS_NAMESPACE}" > /dev/null 2>&1; then
+    kubectl create namespace "${STATUS_NAMESPACE}"
+    kubectl label namespace "${STATUS_NAMESPACE}" "app.kubernetes.io/managed-by=${OWNER_TAG_MANAGED_BY}"
+  fi
+}
+
+# Merges keys into the status ConfigMap, creating it if needed - $1 JSON object, null values remove keys
+function update_status_configmap {
+  ensure_status_namespace
+  if ! kubectl get configmap "${STATUS_CONFIGMAP}" -n "${STATUS_NAMESPACE}" > /dev/null 2>&1; then
+    kubectl create configmap "${STATUS_CONFIGMAP}" -n "${STATUS_NAMESPACE}"
+  fi
+  kubectl patch configmap "${STATUS_CONFIGMAP}" -n "${STATUS_NAMESPACE}" --type merge -p "$(echo "$1" | jq -c '{data: .}')"
+  echo "INFO | Updated status ConfigMap ${STATUS_NAMESPACE}/${STATUS_CONFIGMAP}"
+}
+
+# Records what is installed after onboarding, upgrade or repair - $1 timestamp key, e.g. lastOnboardTime
+function record_onboarded_status {
+  update_status_configmap "$(jq -cn \
+    --arg timeKey "$1" \
+    --arg time "$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+    --arg releaseTrain "${ARC_DATA_RELEASE_TRAIN}" \
+    --arg extensionVersion "${ARC_DATA_EXT_VERSION}" \
+    --arg controllerRepository "${ARC_DATA_CONTROLLER_DESIRED_REPO}" \
+    --arg controllerVersion "${ARC_DATA_CONTROLLER_VERSION}" \
+    --arg subscriptionId "${SUBSCRIPTION_ID}" \
+    --arg connectedClusterResourceGroup "${CONNECTED_CLUSTER_RESOURCE_GROUP}" \
+    --arg arcDataResourceGroup "${ARC_DATA_RESOURCE_GROUP}" \
+    --arg connectedClusterId "${CONNECTED_CLUSTER_ARM_ID}" \
+    --arg extensionId "${ARC_DATA_EXT_ARM_ID}" \
+    --arg customLocationId "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}" \
+    --arg dataControllerId "${ARC_DATA_CONTROLLER_ARM_ID}" \
+    --arg dataControllerState "$(echo "${RESULT_RESOURCES}" | jq -r '.dataController.status')" \
+    '{state: "Onboarded", ($timeKey): $time, offboardScope: null, kept: null,
+      releaseTrain: $releaseTrain, extensionVersion: $extensionVersion,
+      controllerRepository: $controllerRepository, controllerVersion: $controllerVersion,
+      subscriptionId: $subscriptionId, connectedClusterResourceGroup: $connectedClusterResourceGroup, arcDataResourceGroup: $arcDataResourceGroup,
+      connectedClusterId: $connectedClusterId, extensionId: $extensionId, customLocationId: $customLocationId,
+      dataControllerId: $dataControllerId, dataControllerState: $dataControllerState}')"
+}
+
+# ==========================
+# Cluster Capacity Preflight
+# ==========================
+# The Data Controller only runs on Ready amd64/linux nodes, like this Job, and claims volumes of control.json's storage
+# classes - a default storage class is needed for everything that doesn't name one. Onboarding and repair check the
+# cluster can hold it before changing anything, in Azure or in the cluster.
+if [ "${ACTION}" = 'onboard' ] || [ "${ACTION}" = 'repair' ]; then
+  result_step capacity
+  if ! CAPACITY_NODES=$(kubectl get nodes -o json) || ! CAPACITY_STORAGE_CLASSES=$(kubectl get storageclass -o json); then
+    echo "ERROR | Could not list the cluster's nodes and storage classes for the capacity preflight"
+    exit 1
+  fi
+
+  # One entry per node, with its allocatable cores and GiB of memory
+  CAPACITY_NODES=$(echo "${CAPACITY_NODES}" | jq -c '
+    def cores: if endswith("m") then (.[:-1] | tonumber) / 1000 else tonumber end;
+    def gib: capture("^(?<n>[0-9.]+)(?<unit>[A-Za-z]*)$")
+      | (.n | tonumber) * ({"": 1, "k": 1e3, "M": 1e6, "G": 1e9, "T": 1e12, "Ki": 1024, "Mi": 1048576, "Gi": 1073741824, "Ti": 1099511627776}[.unit] // 0) / 1073741824;
+    [.items[] | {
+      name: .metadata.name,
+      platform: "\(.metadata.labels["kubernetes.io/arch"] // "unknown")/\(.metadata.labels["kubernetes.io/os"] // "unknown")",
+      ready: any(.status.conditions[]?; .type == "Ready" and .status == "True"),
+      schedulable: (.spec.unschedulable != true),
+      cpu: (.status.allocatable.cpu // "0" | cores),
+      memory: (.status.allocatable.memory // "0" | gib)
+    } | .eligible = (.platform == "amd64/linux" and .ready and .schedulable)]')
+
+  CAPACITY=$(jq -cn \
+    --argjson nodes "${CAPACITY_NODES}" \
+    --argjson storageClasses "${CAPACITY_STORAGE_CLASSES}" \
+    --argjson minNodes "${CAPACITY_MIN_NODES}" \
+    --argjson minCpu "${CAPACITY_MIN_CPU}" \
+    --argjson minMemory "${CAPACITY_MIN_MEMORY_GIB}" \
+    --arg dataClass "$(jq -r '.spec.storage.data.className // empty' ./custom/control.json)" \
+    --arg logsClass "$(jq -r '.spec.storage.logs.className // empty' ./custom/control.json)" '
+    def row($requirement; $required; $found; $ok): {requirement: $requirement, required: ($required | tostring), found: ($found | tostring), ok: $ok};
+    def round1: . * 10 | round / 10;
+    [$nodes[] | select(.eligible)] as $eligible
+    | ($eligible | map(.cpu) | add // 0) as $cpu
+    | ($eligible | map(.memory) | add // 0) as $memory
+    | [$storageClasses.items[].metadata.name] as $classes
+    | [$storageClasses.items[] | select((.metadata.annotations // {}) | .["storageclass.kubernetes.io/is-default-class"] == "true" or .["storageclass.beta.kubernetes.io/is-default-class"] == "true") | .metadata.name] as $defaults
+    | [row("Ready amd64/linux nodes"; $minNodes; $eligible | length; ($eligible | length) >= $minNodes),
+       row("Allocatable CPU cores"; $minCpu; $cpu | round1; $cpu >= $minCpu),
+       row("Allocatable memory GiB"; $minMemory; $memory | round1; $memory >= $minMemory)]
+      + [["Data", $dataClass], ["Logs", $logsClass] | select(.[1] != "") as [$use, $class]
+         | row("\($use) storage class"; $class; if $classes | index($class) then "found" else "missing" end; $classes | index($class) != null)]
+      + [row("Default storage class"; "any"; if $defaults == [] then "none" else $defaults | join(",") end; $defaults != [])]')
+
+  echo "INFO | Cluster nodes:"
+  printf 'INFO | %-40s %-14s %-6s %-11s %-9s %s\n' NODE PLATFORM READY SCHEDULABLE CPU 'MEMORY GIB'
+  while IFS=$'\t' read -r name platform ready schedulable cpu memory; do
+    printf 'INFO | %-40s %-14s %-6s %-11s %-9s %s\n' "${name}" "${platform}" "${ready}" "${schedulable}" "${cpu}" "${memory}"
+  done < <(echo "${CAPACITY_NODES}" | jq -r '.[] | [.name, .platform, .ready, .schedulable, (.cpu * 10 | round / 10), (.memory * 10 | round / 10)] | @tsv')
+
+  echo "INFO | Cluster capacity for Arc Data Services:"
+  printf 'INFO | %-24s %-18s %-18s %s\n' REQUIREMENT REQUIRED FOUND RESULT
+  while IFS=$'\t' read -r requirement required found result; do
+    printf 'INFO | %-24s %-18s %-18s %s\n' "${requirement}" "${required}" "${found}" "${result}"
+  done < <(echo "${CAPACITY}" | jq -r '.[] | [.requirement, .required, .found, (if .ok then "OK" else "FAIL" end)] | @tsv')
+
+  CAPACITY_FAILURES=$(echo "${CAPACITY}" | jq '[.[] | select(.ok | not)] | length')
+  if [ "${CAPACITY_FAILURES}" -gt 0 ]; then
+    echo "${CAPACITY}" | jq -r '.[] | select(.ok | not) | "ERROR | Cluster capacity - \(.requirement): need \(.required), found \(.found)"'
+    echo "ERROR | Cluster does not meet ${CAPACITY_FAILURES} capacity requirement(s) for Arc Data Services, nothing was changed"
+    exit 1
+  fi
+  echo "INFO | Cluster meets every capacity requirement for Arc Data Services"
+  echo ""
+fi
+
+# ===============
+# AZDATA Password
+# ===============
+# With GENERATE_AZDATA_PASSWORD and no AZDATA_PASSWORD, the password is kept in a Secret in STATUS_NAMESPACE, so repair and
+# later onboarding use the one the Data Controller was created with. A password is only generated while the Secret
+# doesn't exist, and the Secret is created with the other changes - never by a DRY_RUN.
+AZDATA_PASSWORD_GENERATED='false'
+if [[ -z "${AZDATA_PASSWORD}" ]] && { [ "${ACTION}" = 'onboard' ] || [ "${ACTION}" = 'repair' ]; }; then
+  result_step azdataPassword
+  if ! AZDATA_SECRET_PASSWORD=$(kubectl get secret "${AZDATA_SECRET}" -n "${STATUS_NAMESPACE}" --ignore-not-found -o jsonpath='{.data.AZDATA_PASSWORD}'); then
+    echo "ERROR | Could not read Secret ${STATUS_NAMESPACE}/${AZDATA_SECRET} for AZDATA_PASSWORD"
+    exit 1
+  fi
+
+  if [[ -n "${AZDATA_SECRET_PASSWORD}" ]]; then
+    AZDATA_PASSWORD=$(echo "${AZDATA_SECRET_PASSWORD}" | base64 -d)
+    check_azdata_password "AZDATA_PASSWORD in Secret ${STATUS_NAMESPACE}/${AZDATA_SECRET}"
+    echo "INFO | Using AZDATA_PASSWORD from Secret ${STATUS_NAMESPACE}/${AZDATA_SECRET}"
+  else
+    AZDATA_PASSWORD=$(generate_azdata_password "${AZDATA_USERNAME}")
+    AZDATA_PASSWORD_GENERATED='true'
+    echo "INFO | Generated AZDATA_PASSWORD, it is stored in Secret ${STATUS_NAMESPACE}/${AZDATA_SECRET} before anything is created"
+  fi
+  export AZDATA_PASSWORD
+  mirror_azdata_password
+  echo ""
+fi
+
 # =====================
 # Authenticate to Azure
 # =====================
@@ -295,6 +874,76 @@ export AZ_CURRENT_ACCOUNT
 echo ""
 echo "INFO | Current subscription assigned $AZ_CURRENT_ACCOUNT"
 
+# ARM IDs are deterministic, so the job result can report them without extra calls
+CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID="/subscriptions/${SUBSCRIPTION_ID}/resourceGroups/${CONNECTED_CLUSTER_RESOURCE_GROUP}"
+ARC_DATA_RESOURCE_GROUP_ARM_ID="/subscriptions/${SUBSCRIPTION_ID}/resourceGroups/${ARC_DATA_RESOURCE_GROUP}"
+CONNECTED_CLUSTER_ARM_ID="${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID}/providers/Microsoft.Kubernetes/connectedClusters/${CONNECTED_CLUSTER}"
+ARC_DATA_EXT_ARM_ID="${CONNECTED_CLUSTER_ARM_ID}/providers/Microsoft.KubernetesConfiguration/extensions/${ARC_DATA_EXT}"
+ARC_DATA_CUSTOM_LOCATION_ARM_ID="${ARC_DATA_RESOURCE_GROUP_ARM_ID}/providers/Microsoft.ExtendedLocation/customLocations/${ARC_DATA_NAMESPACE}"
+ARC_DATA_CONTROLLER_ARM_ID="${ARC_DATA_RESOURCE_GROUP_ARM_ID}/providers/Microsoft.AzureArcData/dataControllers/${ARC_DATA_CONTROLLER}"
+
+# ====================
+# Custom Locations OID
+# ====================
+# Enabling custom-locations grants the Custom Locations resource provider access to the cluster through its service
+# principal, whose object ID differs per tenant. Onboarding and repair look it up by the provider's application ID,
+# which is the same everywhere, unless CUSTOM_LOCATION_OID is set.
+CUSTOM_LOCATION_APP_ID='bc313c14-388c-4e7d-a58e-70017303ee3b'
+custom_location_oid_param=()
+
+if [ "${ACTION}" = 'onboard' ] || [ "${ACTION}" = 'repair' ]; then
+  result_step customLocationOid
+  if [[ -n "${CUSTOM_LOCATION_OID}" ]]; then
+    echo "INFO | Using CUSTOM_LOCATION_OID ${CUSTOM_LOCATION_OID} from the environment"
+  else
+    CUSTOM_LOCATION_OID=$(az ad sp show --id "${CUSTOM_LOCATION_APP_ID}" --query id --output tsv) || CUSTOM_LOCATION_OID=''
+    if [[ -z "${CUSTOM_LOCATION_OID}" ]]; then
+      echo "ERROR | CUSTOM_LOCATION_OID is not set and service principal $CLIENT_ID could not read the Custom Locations resource provider's service principal (application ID ${CUSTOM_LOCATION_APP_ID}) from Microsoft Graph"
+      echo "ERROR | Allow it to read service principals, or set CUSTOM_LOCATION_OID to the output of 'az ad sp show --id ${CUSTOM_LOCATION_APP_ID} --query id --output tsv'"
+      exit 1
+    fi
+    export CUSTOM_LOCATION_OID
+    echo "INFO | CUSTOM_LOCATION_OID is not set, resolved ${CUSTOM_LOCATION_OID} from Microsoft Graph"
+  fi
+  custom_location_oid_param+=(--custom-locations-oid "${CUSTOM_LOCATION_OID}")
+  echo ""
+fi
+
+# ==============
+# Ownership Tags
+# ==============
+# Everything the installer creates is tagged with the installer, the cluster and the run, and offboarding only deletes
+# what carries the installer and cluster tags. The Bootstrapper extension can't carry ARM tags - it is owned when the
+# Connected Cluster it is installed on, or the Custom Location built on it, is.
+OWNER_TAG_MANAGED_BY='kube-arc-data-services-installer-job'
+INSTALLER_RUN_ID="${INSTALLER_RUN_ID:-${HOSTNAME:-local}-$(date -u +%Y%m%dT%H%M%SZ)}"
+owner_tags=("arc-installer-managed-by=${OWNER_TAG_MANAGED_BY}" "arc-installer-cluster=${CONNECTED_CLUSTER}" "arc-installer-run-id=${INSTALLER_RUN_ID}")
+echo "INFO | Resources created by this run are tagged ${owner_tags[*]}"
+
+# Ownership tags plus AZURE_TAGS, set on everything the installer creates
+create_tags=("${owner_tags[@]}" "${user_tags[@]}")
+
+# Tags a resource the CLI can't tag on create - $1 ARM ID
+function tag_owned_resource {
+  az tag update --resource-id "$1" --operation Merge --tags "${create_tags[@]}" > /dev/null
+}
+
+# Succeeds if a resource carries this installer's tags for this cluster - $1 ARM ID
+function owned_by_installer {
+  [ "$(az tag list --resource-id "$1" | jq -r --arg managedBy "${OWNER_TAG_MANAGED_BY}" --arg cluster "${CONNECTED_CLUSTER}" \
+    '(.properties.tags // {}) | .["arc-installer-managed-by"] == $managedBy and .["arc-installer-cluster"] == $cluster')" = 'true' ]
+}
+
+# Succeeds if a resource carries no installer's ownership tags at all - $1 ARM ID
+function unowned_by_any_installer {
+  [ "$(az tag list --resource-id "$1" | jq -r '(.properties.tags // {}) | has("arc-installer-managed-by") | not')" = 'true' ]
+}
+
+# Stamps the ownership tags onto an existing resource - $1 ARM ID
+function adopt_resource {
+  az tag update --resource-id "$1" --operation Merge --tags "${owner_tags[@]}" > /dev/null
+}
+
 # ====================
 # Central Status Check
 # ====================
@@ -307,6 +956,8 @@ echo "INFO | Current subscription assigned $AZ_CURRENT_ACCOUNT"
 #         └── 4. Custom Location
 #             └── 5. Data Controller
 
+result_step statusCheck
+
 # 1. Connected Cluster and Data Services RG
 CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS=$(az group list | jq -r ".[] | select(.name==\"$CONNECTED_CLUSTER_RESOURCE_GROUP\") |.name")
 export CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS
@@ -364,37 +1015,745 @@ echo "INFO |    4. ARC_DATA_CUSTOM_LOCATION_EXISTS? $ARC_DATA_CUSTOM_LOCATION_EX
 echo "INFO |      5. ARC_DATA_CONTROLLER_EXISTS? $ARC_DATA_CONTROLLER_EXISTS"
 echo ""
 
+# ========================
+# Provisioning State Check
+# ========================
+# status and repair also need to know whether existing resources are healthy - read-only
+CONNECTED_CLUSTER_STATE=''
+ARC_DATA_EXT_STATE=''
+ARC_DATA_EXT_SHOW=''
+ARC_DATA_CONTROLLER_STATUS_SHOW=''
+ARC_DATA_CUSTOM_LOCATION_STATE=''
+ARC_DATA_CONTROLLER_STATE=''
+
+if [ "${ACTION}" = 'status' ] || [ "${ACTION}" = 'repair' ]; then
+  if [ "$CONNECTED_CLUSTER_EXISTS" = 'true' ]; then
+    CONNECTED_CLUSTER_STATE=$(az connectedk8s show --name "$CONNECTED_CLUSTER" --resource-group "$CONNECTED_CLUSTER_RESOURCE_GROUP" | jq -r '.provisioningState')
+  fi
+  if [ "$ARC_DATA_EXT_EXISTS" = 'true' ]; then
+    ARC_DATA_EXT_SHOW=$(az k8s-extension show --cluster-name "$CONNECTED_CLUSTER" --resource-group "$CONNECTED_CLUSTER_RESOURCE_GROUP" --cluster-type connectedClusters --name "${ARC_DATA_EXT}")
+    ARC_DATA_EXT_STATE=$(echo "${ARC_DATA_EXT_SHOW}" | jq -r '.provisioningState')
+  fi
+  if [ "$ARC_DATA_CUSTOM_LOCATION_EXISTS" = 'true' ]; then
+    ARC_DATA_CUSTOM_LOCATION_STATE=$(az customlocation show --name "$ARC_DATA_NAMESPACE" --resource-group "$ARC_DATA_RESOURCE_GROUP" | jq -r '.provisioningState')
+  fi
+  if [ "$ARC_DATA_CONTROLLER_EXISTS" = 'true' ]; then
+    ARC_DATA_CONTROLLER_STATUS_SHOW=$(az arcdata dc status show --name "$ARC_DATA_CONTROLLER" --resource-group "$ARC_DATA_RESOURCE_GROUP")
+    ARC_DATA_CONTROLLER_STATE=$(echo "${ARC_DATA_CONTROLLER_STATUS_SHOW}" | jq -r '.properties.k8SRaw.status.state')
+  fi
+
+  echo "INFO |  2. CONNECTED_CLUSTER_STATE? ${CONNECTED_CLUSTER_STATE:-n/a}"
+  echo "INFO |    3. ARC_DATA_EXT_STATE? ${ARC_DATA_EXT_STATE:-n/a}"
+  echo "INFO |    4. ARC_DATA_CUSTOM_LOCATION_STATE? ${ARC_DATA_CUSTOM_LOCATION_STATE:-n/a}"
+  echo "INFO |      5. ARC_DATA_CONTROLLER_STATE? ${ARC_DATA_CONTROLLER_STATE:-n/a}"
+  echo ""
+fi
+
+# A Failed resource is recreated by repair, along with everything built on it:
+# Connected Cluster <- Extension <- Custom Location <- Data Controller
+REPAIR_CONNECTED_CLUSTER='false'
+REPAIR_ARC_DATA_EXT='false'
+REPAIR_ARC_DATA_CUSTOM_LOCATION='false'
+REPAIR_ARC_DATA_CONTROLLER='false'
+
+# Onboarding below recreates whatever repair deletes, so repaired resources are marked as not existing
+if [ "${ACTION}" = 'repair' ]; then
+  if [ "$CONNECTED_CLUSTER_STATE" = 'Failed' ]; then
+    REPAIR_CONNECTED_CLUSTER='true'
+    CONNECTED_CLUSTER_EXISTS='false'
+  fi
+  if [ "$ARC_DATA_EXT_EXISTS" = 'true' ] && { [ "$ARC_DATA_EXT_STATE" = 'Failed' ] || [ "$REPAIR_CONNECTED_CLUSTER" = 'true' ]; }; then
+    REPAIR_ARC_DATA_EXT='true'
+    ARC_DATA_EXT_EXISTS='false'
+  fi
+  if [ "$ARC_DATA_CUSTOM_LOCATION_EXISTS" = 'true' ] && { [ "$ARC_DATA_CUSTOM_LOCATION_STATE" = 'Failed' ] || [ "$REPAIR_CONNECTED_CLUSTER" = 'true' ] || [ "$REPAIR_ARC_DATA_EXT" = 'true' ]; }; then
+    REPAIR_ARC_DATA_CUSTOM_LOCATION='true'
+    ARC_DATA_CUSTOM_LOCATION_EXISTS='false'
+  fi
+  if [ "$ARC_DATA_CONTROLLER_EXISTS" = 'true' ] && { [ "$ARC_DATA_CONTROLLER_STATE" = 'Failed' ] || [ "$REPAIR_ARC_DATA_CUSTOM_LOCATION" = 'true' ]; }; then
+    REPAIR_ARC_DATA_CONTROLLER='true'
+    ARC_DATA_CONTROLLER_EXISTS='false'
+  fi
+fi
+
+# =======================
+# Installed Version Check
+# =======================
+# Compares what is running in the cluster with the image's release env - differences are reported as drift in the log and
+# job result. Onboarding and repair leave existing resources as they are, upgrade changes whatever has drifted.
+UPGRADE_ARC_DATA_EXT='false'
+UPGRADE_ARC_DATA_CONTROLLER='false'
+
+if [ "${ACTION}" = 'upgrade' ]; then
+  if [ "$CONNECTED_CLUSTER_EXISTS" != 'true' ] || [ "$ARC_DATA_EXT_EXISTS" != 'true' ] || [ "$ARC_DATA_CONTROLLER_EXISTS" != 'true' ]; then
+    echo "ERROR | ACTION=upgrade needs an onboarded Connected Cluster, Bootstrapper extension and Data Controller, run ACTION=onboard first"
+    exit 1
+  fi
+fi
+
+if [ "${ACTION}" != 'offboard' ]; then
+  if [ "$ARC_DATA_EXT_EXISTS" = 'true' ]; then
+    if [ -z "${ARC_DATA_EXT_SHOW}" ]; then
+      ARC_DATA_EXT_SHOW=$(az k8s-extension show --cluster-name "$CONNECTED_CLUSTER" --resource-group "$CONNECTED_CLUSTER_RESOURCE_GROUP" --cluster-type connectedClusters --name "${ARC_DATA_EXT}")
+    fi
+    ARC_DATA_EXT_INSTALLED_VERSION=$(echo "${ARC_DATA_EXT_SHOW}" | jq -r '.currentVersion // .version // empty')
+    ARC_DATA_EXT_INSTALLED_RELEASE_TRAIN=$(echo "${ARC_DATA_EXT_SHOW}" | jq -r '.releaseTrain // empty | ascii_downcase')
+    ARC_DATA_EXT_INSTALLED_AUTO_UPGRADE=$(echo "${ARC_DATA_EXT_SHOW}" | jq -r '.autoUpgradeMinorVersion | if . == null then empty else tostring end')
+
+    echo "INFO |    3. Bootstrapper extension version installed: ${ARC_DATA_EXT_INSTALLED_VERSION:-unknown}, release env: ${ARC_DATA_EXT_VERSION}"
+    result_drift extension version "${ARC_DATA_EXT_INSTALLED_VERSION}" "${ARC_DATA_EXT_VERSION}"
+    result_drift extension releaseTrain "${ARC_DATA_EXT_INSTALLED_RELEASE_TRAIN}" "${ARC_DATA_RELEASE_TRAIN}"
+    result_drift extension autoUpgrade "${ARC_DATA_EXT_INSTALLED_AUTO_UPGRADE}" "${ARC_DATA_EXT_AUTO_UPGRADE}"
+  fi
+
+  if [ "$ARC_DATA_CONTROLLER_EXISTS" = 'true' ]; then
+    if [ -z "${ARC_DATA_CONTROLLER_STATUS_SHOW}" ]; then
+      ARC_DATA_CONTROLLER_STATUS_SHOW=$(az arcdata dc status show --name "$ARC_DATA_CONTROLLER" --resource-group "$ARC_DATA_RESOURCE_GROUP")
+    fi
+    ARC_DATA_CONTROLLER_INSTALLED_VERSION=$(echo "${ARC_DATA_CONTROLLER_STATUS_SHOW}" | jq -r '.properties.k8SRaw.spec.docker.imageTag // empty')
+    ARC_DATA_CONTROLLER_INSTALLED_REPO=$(echo "${ARC_DATA_CONTROLLER_STATUS_SHOW}" | jq -r '.properties.k8SRaw.spec.docker.repository // empty')
+
+    echo "INFO |      5. Data Controller image tag installed: ${ARC_DATA_CONTROLLER_INSTALLED_VERSION:-unknown}, release env: ${ARC_DATA_CONTROLLER_VERSION}"
+    result_drift dataController imageTag "${ARC_DATA_CONTROLLER_INSTALLED_VERSION}" "${ARC_DATA_CONTROLLER_VERSION}"
+    # Release trains without a known repository, e.g. test, are not compared
+    if [ -n "${ARC_DATA_CONTROLLER_DESIRED_REPO}" ]; then
+      result_drift dataController repository "${ARC_DATA_CONTROLLER_INSTALLED_REPO}" "${ARC_DATA_CONTROLLER_DESIRED_REPO}"
+    fi
+  fi
+  echo ""
+fi
+
+# The extension update also sets the release train and auto-upgrade, az arcdata dc upgrade only moves the image tag
+if [ "${ACTION}" = 'upgrade' ]; then
+  if drift_found extension; then
+    UPGRADE_ARC_DATA_EXT='true'
+  fi
+  if drift_found dataController imageTag; then
+    UPGRADE_ARC_DATA_CONTROLLER='true'
+  fi
+fi
+
+# ==================
+# Tag Reconciliation
+# ==================
+# Resources that already exist get AZURE_TAGS merged in when onboarding or repairing. Tags removed from AZURE_TAGS are
+# left on the resource, and the Bootstrapper extension can't carry tags.
+RETAG_CONNECTED_CLUSTER_RESOURCE_GROUP='false'
+RETAG_ARC_DATA_RESOURCE_GROUP='false'
+RETAG_CONNECTED_CLUSTER='false'
+RETAG_ARC_DATA_CUSTOM_LOCATION='false'
+RETAG_ARC_DATA_CONTROLLER='false'
+
+# Succeeds if a resource is missing any of AZURE_TAGS or has another value - $1 ARM ID, $2 description and $3 name for the log
+function tags_differ {
+  local differing
+  differing=$(az tag list --resource-id "$1" | jq -r --argjson want "${AZURE_TAGS}" \
+    '(.properties.tags // {}) as $have | [$want | to_entries[] | select($have[.key] != .value) | .key] | join(", ")')
+  if [ -z "${differing}" ]; then
+    return 1
+  fi
+  echo "INFO | Tags | $2 $3 differs from AZURE_TAGS on ${differing}"
+}
+
+# Merges AZURE_TAGS into an existing resource - $1 ARM ID
+function tag_user_resource {
+  az tag update --resource-id "$1" --operation Merge --tags "${user_tags[@]}" > /dev/null
+}
+
+if { [ "${ACTION}" = 'onboard' ] || [ "${ACTION}" = 'repair' ]; } && [ "${#user_tags[@]}" -gt 0 ]; then
+  if [ "$CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS" = 'true' ] && tags_differ "${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID}" 'Connected Cluster Resource Group' "$CONNECTED_CLUSTER_RESOURCE_GROUP"; then
+    RETAG_CONNECTED_CLUSTER_RESOURCE_GROUP='true'
+  fi
+  if [ "$ARC_DATA_RESOURCE_GROUP_EXISTS" = 'true' ] && tags_differ "${ARC_DATA_RESOURCE_GROUP_ARM_ID}" 'Arc Data Services Resource Group' "$ARC_DATA_RESOURCE_GROUP"; then
+    RETAG_ARC_DATA_RESOURCE_GROUP='true'
+  fi
+  if [ "$CONNECTED_CLUSTER_EXISTS" = 'true' ] && tags_differ "${CONNECTED_CLUSTER_ARM_ID}" 'Connected Cluster' "$CONNECTED_CLUSTER"; then
+    RETAG_CONNECTED_CLUSTER='true'
+  fi
+  if [ "$ARC_DATA_CUSTOM_LOCATION_EXISTS" = 'true' ] && tags_differ "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}" 'Custom Location' "$ARC_DATA_NAMESPACE"; then
+    RETAG_ARC_DATA_CUSTOM_LOCATION='true'
+  fi
+  if [ "$ARC_DATA_CONTROLLER_EXISTS" = 'true' ] && tags_differ "${ARC_DATA_CONTROLLER_ARM_ID}" 'Data Controller' "$ARC_DATA_CONTROLLER"; then
+    RETAG_ARC_DATA_CONTROLLER='true'
+  fi
+  echo ""
+fi
+
+# ========
+# Adoption
+# ========
+# Resources created before the installer tagged what it owns are left alone by offboarding. With
+# ADOPT_EXISTING_RESOURCES=true onboarding and repair stamp the ownership tags onto the ones that exist and carry no
+# installer's tags - a resource another cluster's installer owns is never adopted.
+ADOPT_CONNECTED_CLUSTER_RESOURCE_GROUP='false'
+ADOPT_ARC_DATA_RESOURCE_GROUP='false'
+ADOPT_CONNECTED_CLUSTER='false'
+ADOPT_ARC_DATA_CUSTOM_LOCATION='false'
+ADOPT_ARC_DATA_CONTROLLER='false'
+
+if { [ "${ACTION}" = 'onboard' ] || [ "${ACTION}" = 'repair' ]; } && [ "${ADOPT_EXISTING_RESOURCES}" = 'true' ]; then
+  if [ "$CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS" = 'true' ] && unowned_by_any_installer "${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID}"; then
+    echo "INFO | Adopting Connected Cluster Resource Group $CONNECTED_CLUSTER_RESOURCE_GROUP"
+    ADOPT_CONNECTED_CLUSTER_RESOURCE_GROUP='true'
+  fi
+  if [ "$ARC_DATA_RESOURCE_GROUP_EXISTS" = 'true' ] && unowned_by_any_installer "${ARC_DATA_RESOURCE_GROUP_ARM_ID}"; then
+    echo "INFO | Adopting Arc Data Services Resource Group $ARC_DATA_RESOURCE_GROUP"
+    ADOPT_ARC_DATA_RESOURCE_GROUP='true'
+  fi
+  if [ "$CONNECTED_CLUSTER_EXISTS" = 'true' ] && unowned_by_any_installer "${CONNECTED_CLUSTER_ARM_ID}"; then
+    echo "INFO | Adopting Connected Cluster $CONNECTED_CLUSTER"
+    ADOPT_CONNECTED_CLUSTER='true'
+  fi
+  if [ "$ARC_DATA_CUSTOM_LOCATION_EXISTS" = 'true' ] && unowned_by_any_installer "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}"; then
+    echo "INFO | Adopting Custom Location $ARC_DATA_NAMESPACE"
+    ADOPT_ARC_DATA_CUSTOM_LOCATION='true'
+  fi
+  if [ "$ARC_DATA_CONTROLLER_EXISTS" = 'true' ] && unowned_by_any_installer "${ARC_DATA_CONTROLLER_ARM_ID}"; then
+    echo "INFO | Adopting Data Controller $ARC_DATA_CONTROLLER"
+    ADOPT_ARC_DATA_CONTROLLER='true'
+  fi
+  echo ""
+fi
+
+# =====================
+# Role Assignment Check
+# =====================
+# The Bootstrapper extension's managed identity needs ARC_DATA_EXT_ROLE_ASSIGNMENTS to create the Data Controller and
+# upload metrics. Onboarding and repair assign whatever is missing - a new extension's identity has nothing assigned yet.
+MISSING_ROLE_ASSIGNMENTS=()
+ARC_DATA_EXT_MSI=''
+
+# Reads the Bootstrapper extension's managed identity into ARC_DATA_EXT_MSI, waiting while it propagates
+function read_ext_principal_id {
+  for i in {1..10}
+  do
+    ARC_DATA_EXT_MSI=$(az k8s-extension show --cluster-name "${CONNECTED_CLUSTER}" --resource-group "${CONNECTED_CLUSTER_RESOURCE_GROUP}" --cluster-type connectedClusters --name "${ARC_DATA_EXT}" --query "identity.principalId" --output tsv)
+    if [ -n "${ARC_DATA_EXT_MSI}" ]; then
+      export ARC_DATA_EXT_MSI
+      return 0
+    fi
+    echo "INFO | Waiting for Bootstrapper extension $ARC_DATA_EXT's managed identity (attempt $i of 10)..."
+    echo "INFO | Sleeping for 30 seconds..."
+    sleep 30
+  done
+  echo "ERROR | Bootstrapper extension $ARC_DATA_EXT has no managed identity, manual intervention is required"
+  exit 1
+}
+
+if [ "${ACTION}" = 'onboard' ] || [ "${ACTION}" = 'repair' ]; then
+  if [ "$ARC_DATA_EXT_EXISTS" = 'true' ]; then
+    read_ext_principal_id
+    for assignment in "${ext_role_assignments[@]}"; do
+      IFS=$'\t' read -r role scope <<< "${assignment}"
+      assigned=$(az role assignment list --assignee "${ARC_DATA_EXT_MSI}" --role "${role}" --scope "${scope}" --query 'length(@)' --output tsv)
+      if [ "${assigned:-0}" -gt 0 ]; then
+        echo "INFO | Bootstrapper extension $ARC_DATA_EXT already has role $role on $scope"
+      else
+        echo "INFO | Bootstrapper extension $ARC_DATA_EXT is missing role $role on $scope"
+        MISSING_ROLE_ASSIGNMENTS+=("${assignment}")
+      fi
+    done
+  else
+    MISSING_ROLE_ASSIGNMENTS=("${ext_role_assignments[@]}")
+  fi
+  echo ""
+fi
+
+# =======================
+# Resource Provider Check
+# =======================
+# Onboarding fails with confusing errors halfway if the subscription hasn't registered a provider the resources belong
+# to. Missing providers are registered before anything else is changed with REGISTER_RESOURCE_PROVIDERS=true.
+RESOURCE_PROVIDERS=(Microsoft.Kubernetes Microsoft.KubernetesConfiguration Microsoft.ExtendedLocation Microsoft.AzureArcData)
+MISSING_RESOURCE_PROVIDERS=()
+
+if [ "${ACTION}" = 'onboard' ] || [ "${ACTION}" = 'repair' ]; then
+  result_step resourceProviders
+  for provider in "${RESOURCE_PROVIDERS[@]}"; do
+    state=$(az provider show --namespace "${provider}" --query registrationState --output tsv)
+    if [ "${state}" = 'Registered' ]; then
+      echo "INFO | Resource provider $provider is registered"
+    else
+      echo "INFO | Resource provider $provider is ${state:-NotRegistered}"
+      MISSING_RESOURCE_PROVIDERS+=("${provider}")
+    fi
+  done
+
+  if [ "${#MISSING_RESOURCE_PROVIDERS[@]}" -gt 0 ] && [ "${REGISTER_RESOURCE_PROVIDERS}" != 'true' ]; then
+    echo "ERROR | Subscription $SUBSCRIPTION_ID has not registered resource provider(s) ${MISSING_RESOURCE_PROVIDERS[*]}, register them or set REGISTER_RESOURCE_PROVIDERS=true, nothing was changed"
+    exit 1
+  fi
+  echo ""
+fi
+
+# ==========================
+# Azure Permission Preflight
+# ==========================
+# Onboarding and repair check the service principal holds every Azure permission the run needs before changing anything,
+# so a missing one is reported up front rather than failing halfway. ARM reports effective permissions per existing
+# scope, so resources are checked on their resource group, or on the subscription while the group doesn't exist yet.
+MISSING_PERMISSIONS=()
+declare -A SCOPE_PERMISSIONS
+
+# Records a missing permission - $1 ARM ID it is needed on, $2 action, $3 what it is needed for
+function require_permission {
+  local scope="$1"
+  local allowed
+  if [[ "${scope,,}/" == "${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID,,}/"* ]]; then
+    scope="${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID}"
+    if [ "$CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS" = 'false' ]; then
+      scope="/subscriptions/${SUBSCRIPTION_ID}"
+    fi
+  elif [[ "${scope,,}/" == "${ARC_DATA_RESOURCE_GROUP_ARM_ID,,}/"* ]]; then
+    scope="${ARC_DATA_RESOURCE_GROUP_ARM_ID}"
+    if [ "$ARC_DATA_RESOURCE_GROUP_EXISTS" = 'false' ]; then
+      scope="/subscriptions/${SUBSCRIPTION_ID}"
+    fi
+  fi
+
+  # A scope that can't be read grants nothing
+  if [ -z "${SCOPE_PERMISSIONS[$scope]+set}" ]; then
+    SCOPE_PERMISSIONS[$scope]=$(az rest --method get --url "${scope}/providers/Microsoft.Authorization/permissions?api-version=2015-07-01" --output json) || SCOPE_PERMISSIONS[$scope]='{}'
+  fi
+  allowed=$(echo "${SCOPE_PERMISSIONS[$scope]}" | jq -r --arg action "$2" '
+    def allows($pattern): $action | ascii_downcase | test("^" + ($pattern | ascii_downcase | gsub("\\."; "\\.") | gsub("\\*"; ".*")) + "$");
+    [(.value // [])[] | select(any(.actions[]?; allows(.)) and (any(.notActions[]?; allows(.)) | not))] | length > 0' 2> /dev/null) || allowed='false'
+
+  if [ "${allowed}" != 'true' ]; then
+    MISSING_PERMISSIONS+=("$2 on $1 - $3")
+  fi
+}
+
+if [ "${ACTION}" = 'onboard' ] || [ "${ACTION}" = 'repair' ]; then
+  result_step permissions
+  echo "INFO | Checking service principal $CLIENT_ID's Azure permissions"
+
+  # 0. Resource providers the subscription hasn't registered
+  for provider in "${MISSING_RESOURCE_PROVIDERS[@]}"; do
+    require_permission "/subscriptions/${SUBSCRIPTION_ID}" "${provider}/register/action" "register resource provider $provider"
+  done
+
+  # 1. Resource groups
+  if [ "$CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS" = 'false' ] || [ "$ARC_DATA_RESOURCE_GROUP_EXISTS" = 'false' ]; then
+    require_permission "/subscriptions/${SUBSCRIPTION_ID}" 'Microsoft.Resources/subscriptions/resourceGroups/write' 'create resource groups'
+  fi
+
+  # 2. - 5. Failed resources are deleted by repair, missing ones are created
+  if [ "$REPAIR_CONNECTED_CLUSTER" = 'true' ]; then
+    require_permission "${CONNECTED_CLUSTER_ARM_ID}" 'Microsoft.Kubernetes/connectedClusters/delete' "repair Connected Cluster $CONNECTED_CLUSTER"
+  fi
+  if [ "$CONNECTED_CLUSTER_EXISTS" = 'false' ]; then
+    require_permission "${CONNECTED_CLUSTER_ARM_ID}" 'Microsoft.Kubernetes/connectedClusters/write' "create Connected Cluster $CONNECTED_CLUSTER"
+  else
+    # 2a. An existing Connected Cluster gets Cluster-Connect and Custom-Locations enabled again
+    require_permission "${CONNECTED_CLUSTER_ARM_ID}" 'Microsoft.Kubernetes/connectedClusters/write' "enable Cluster-Connect and Custom-Locations on Connected Cluster $CONNECTED_CLUSTER"
+  fi
+  if [ "$REPAIR_ARC_DATA_EXT" = 'true' ]; then
+    require_permission "${ARC_DATA_EXT_ARM_ID}" 'Microsoft.KubernetesConfiguration/extensions/delete' "repair Bootstrapper extension $ARC_DATA_EXT"
+  fi
+  if [ "$ARC_DATA_EXT_EXISTS" = 'false' ]; then
+    require_permission "${ARC_DATA_EXT_ARM_ID}" 'Microsoft.KubernetesConfiguration/extensions/write' "create Bootstrapper extension $ARC_DATA_EXT"
+  fi
+  if [ "$REPAIR_ARC_DATA_CUSTOM_LOCATION" = 'true' ]; then
+    require_permission "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}" 'Microsoft.ExtendedLocation/customLocations/delete' "repair Custom Location $ARC_DATA_NAMESPACE"
+  fi
+  if [ "$ARC_DATA_CUSTOM_LOCATION_EXISTS" = 'false' ]; then
+    require_permission "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}" 'Microsoft.ExtendedLocation/customLocations/write' "create Custom Location $ARC_DATA_NAMESPACE"
+  fi
+  if [ "$REPAIR_ARC_DATA_CONTROLLER" = 'true' ]; then
+    require_permission "${ARC_DATA_CONTROLLER_ARM_ID}" 'Microsoft.AzureArcData/dataControllers/delete' "repair Data Controller $ARC_DATA_CONTROLLER"
+  fi
+  if [ "$ARC_DATA_CONTROLLER_EXISTS" = 'false' ]; then
+    require_permission "${ARC_DATA_CONTROLLER_ARM_ID}" 'Microsoft.AzureArcData/dataControllers/write' "create Data Controller $ARC_DATA_CONTROLLER"
+  fi
+
+  # Ownership tags on what the CLI can't tag on create or is adopted, AZURE_TAGS on what exists
+  if [ "$ARC_DATA_CUSTOM_LOCATION_EXISTS" = 'false' ] || [ "$RETAG_ARC_DATA_CUSTOM_LOCATION" = 'true' ] || [ "$ADOPT_ARC_DATA_CUSTOM_LOCATION" = 'true' ]; then
+    require_permission "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}" 'Microsoft.Resources/tags/write' "tag Custom Location $ARC_DATA_NAMESPACE"
+  fi
+  if [ "$ARC_DATA_CONTROLLER_EXISTS" = 'false' ] || [ "$RETAG_ARC_DATA_CONTROLLER" = 'true' ] || [ "$ADOPT_ARC_DATA_CONTROLLER" = 'true' ]; then
+    require_permission "${ARC_DATA_CONTROLLER_ARM_ID}" 'Microsoft.Resources/tags/write' "tag Data Controller $ARC_DATA_CONTROLLER"
+  fi
+  if [ "$RETAG_CONNECTED_CLUSTER_RESOURCE_GROUP" = 'true' ] || [ "$ADOPT_CONNECTED_CLUSTER_RESOURCE_GROUP" = 'true' ]; then
+    require_permission "${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID}" 'Microsoft.Resources/tags/write' "tag Resource Group $CONNECTED_CLUSTER_RESOURCE_GROUP"
+  fi
+  if [ "$RETAG_ARC_DATA_RESOURCE_GROUP" = 'true' ] || [ "$ADOPT_ARC_DATA_RESOURCE_GROUP" = 'true' ]; then
+    require_permission "${ARC_DATA_RESOURCE_GROUP_ARM_ID}" 'Microsoft.Resources/tags/write' "tag Resource Group $ARC_DATA_RESOURCE_GROUP"
+  fi
+  if [ "$RETAG_CONNECTED_CLUSTER" = 'true' ] || [ "$ADOPT_CONNECTED_CLUSTER" = 'true' ]; then
+    require_permission "${CONNECTED_CLUSTER_ARM_ID}" 'Microsoft.Resources/tags/write' "tag Connected Cluster $CONNECTED_CLUSTER"
+  fi
+
+  # 3a. Roles of the Bootstrapper extension's managed identity
+  for assignment in "${MISSING_ROLE_ASSIGNMENTS[@]}"; do
+    IFS=$'\t' read -r role scope <<< "${assignment}"
+    require_permission "${scope}" 'Microsoft.Authorization/roleAssignments/write' "assign role $role to Bootstrapper extension $ARC_DATA_EXT"
+  done
+
+  if [ "${#MISSING_PERMISSIONS[@]}" -gt 0 ]; then
+    for permission in "${MISSING_PERMISSIONS[@]}"; do
+      echo "ERROR | Missing Azure permission ${permission}"
+    done
+    echo "ERROR | Service principal $CLIENT_ID is missing ${#MISSING_PERMISSIONS[@]} Azure permission(s), nothing was changed"
+    exit 1
+  fi
+  echo "INFO | Service principal $CLIENT_ID has every Azure permission this run needs"
+  echo ""
+fi
+
+# ==============
+# Offboard Scope
+# ==============
+# What offboarding does with each resource - delete, keep or skip if it doesn't exist. Only resources in OFFBOARD_SCOPE
+# that carry this installer's ownership tags are deleted, a resource group shared with the Connected Cluster only with it
+# and a resource group only when everything in it is owned too.
+if [ "${ACTION}" = 'offboard' ]; then
+  echo "INFO | Offboarding with OFFBOARD_SCOPE=${OFFBOARD_SCOPE}"
+
+  IN_SCOPE_CONNECTED_CLUSTER='false'
+  IN_SCOPE_ARC_DATA_RESOURCE_GROUP='false'
+  if [ "${OFFBOARD_SCOPE}" = 'full' ]; then
+    IN_SCOPE_CONNECTED_CLUSTER='true'
+    IN_SCOPE_ARC_DATA_RESOURCE_GROUP='true'
+  elif [ "${OFFBOARD_SCOPE}" = 'keep-connected-cluster' ]; then
+    if [ "${ARC_DATA_RESOURCE_GROUP}" = "${CONNECTED_CLUSTER_RESOURCE_GROUP}" ]; then
+      echo "INFO | Arc Data Services Resource Group $ARC_DATA_RESOURCE_GROUP also holds the Connected Cluster, keeping it"
+    else
+      IN_SCOPE_ARC_DATA_RESOURCE_GROUP='true'
+    fi
+  fi
+
+  OWNED_CONNECTED_CLUSTER_RESOURCE_GROUP='false'
+  OWNED_ARC_DATA_RESOURCE_GROUP='false'
+  OWNED_CONNECTED_CLUSTER='false'
+  OWNED_ARC_DATA_EXT='false'
+  OWNED_ARC_DATA_CUSTOM_LOCATION='false'
+  OWNED_ARC_DATA_CONTROLLER='false'
+  if [ "$CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS" = 'true' ] && owned_by_installer "${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID}"; then
+    OWNED_CONNECTED_CLUSTER_RESOURCE_GROUP='true'
+  fi
+  if [ "$ARC_DATA_RESOURCE_GROUP_EXISTS" = 'true' ] && owned_by_installer "${ARC_DATA_RESOURCE_GROUP_ARM_ID}"; then
+    OWNED_ARC_DATA_RESOURCE_GROUP='true'
+  fi
+  if [ "$CONNECTED_CLUSTER_EXISTS" = 'true' ] && owned_by_installer "${CONNECTED_CLUSTER_ARM_ID}"; then
+    OWNED_CONNECTED_CLUSTER='true'
+  fi
+  if [ "$ARC_DATA_CUSTOM_LOCATION_EXISTS" = 'true' ] && owned_by_installer "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}"; then
+    OWNED_ARC_DATA_CUSTOM_LOCATION='true'
+  fi
+  if [ "$ARC_DATA_CONTROLLER_EXISTS" = 'true' ] && owned_by_installer "${ARC_DATA_CONTROLLER_ARM_ID}"; then
+    OWNED_ARC_DATA_CONTROLLER='true'
+  fi
+  if [ "$OWNED_CONNECTED_CLUSTER" = 'true' ] || [ "$OWNED_ARC_DATA_CUSTOM_LOCATION" = 'true' ]; then
+    OWNED_ARC_DATA_EXT='true'
+  fi
+
+  # Resources from an installer version before ownership tags carry none, offboarding them would delete nothing
+  if { [ "$CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS" = 'true' ] || [ "$ARC_DATA_RESOURCE_GROUP_EXISTS" = 'true' ] || [ "$CONNECTED_CLUSTER_EXISTS" = 'true' ] \
+       || [ "$ARC_DATA_CUSTOM_LOCATION_EXISTS" = 'true' ] || [ "$ARC_DATA_CONTROLLER_EXISTS" = 'true' ]; } \
+     && [ "$OWNED_CONNECTED_CLUSTER_RESOURCE_GROUP" != 'true' ] && [ "$OWNED_ARC_DATA_RESOURCE_GROUP" != 'true' ] && [ "$OWNED_CONNECTED_CLUSTER" != 'true' ] \
+     && [ "$OWNED_ARC_DATA_CUSTOM_LOCATION" != 'true' ] && [ "$OWNED_ARC_DATA_CONTROLLER" != 'true' ]; then
+    echo "ERROR | None of the existing resources carry this installer's ownership tags for ${CONNECTED_CLUSTER}, nothing was deleted"
+    echo "ERROR | Resources created by an older installer version can be adopted with ACTION=onboard and ADOPT_EXISTING_RESOURCES=true, then offboarded"
+    exit 1
+  fi
+
+  # Sets $1 to delete, keep or skip - $2 exists, $3 in scope, $4 owned, $5 description and $6 name for the log
+  function offboard_decision {
+    if [ "$2" != 'true' ]; then
+      printf -v "$1" '%s' skip
+    elif [ "$3" != 'true' ]; then
+      echo "INFO | Keeping $5 $6, outside OFFBOARD_SCOPE=${OFFBOARD_SCOPE}"
+      printf -v "$1" '%s' keep
+    elif [ "$4" != 'true' ]; then
+      echo "INFO | Keeping $5 $6, it does not carry this installer's ownership tags for ${CONNECTED_CLUSTER}"
+      printf -v "$1" '%s' keep
+    else
+      printf -v "$1" '%s' delete
+    fi
+  }
+
+  offboard_decision OFFBOARD_ARC_DATA_CONTROLLER "$ARC_DATA_CONTROLLER_EXISTS" true "$OWNED_ARC_DATA_CONTROLLER" 'Data Controller' "$ARC_DATA_CONTROLLER"
+  offboard_decision OFFBOARD_ARC_DATA_CUSTOM_LOCATION "$ARC_DATA_CUSTOM_LOCATION_EXISTS" true "$OWNED_ARC_DATA_CUSTOM_LOCATION" 'Custom Location' "$ARC_DATA_NAMESPACE"
+  offboard_decision OFFBOARD_ARC_DATA_EXT "$ARC_DATA_EXT_EXISTS" true "$OWNED_ARC_DATA_EXT" 'Bootstrapper Extension' "$ARC_DATA_EXT"
+  offboard_decision OFFBOARD_CONNECTED_CLUSTER "$CONNECTED_CLUSTER_EXISTS" "$IN_SCOPE_CONNECTED_CLUSTER" "$OWNED_CONNECTED_CLUSTER" 'Connected Cluster' "$CONNECTED_CLUSTER"
+  offboard_decision OFFBOARD_ARC_DATA_RESOURCE_GROUP "$ARC_DATA_RESOURCE_GROUP_EXISTS" "$IN_SCOPE_ARC_DATA_RESOURCE_GROUP" "$OWNED_ARC_DATA_RESOURCE_GROUP" 'Arc Data Services Resource Group' "$ARC_DATA_RESOURCE_GROUP"
+  offboard_decision OFFBOARD_CONNECTED_CLUSTER_RESOURCE_GROUP "$CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS" "$IN_SCOPE_CONNECTED_CLUSTER" "$OWNED_CONNECTED_CLUSTER_RESOURCE_GROUP" 'Connected Cluster Resource Group' "$CONNECTED_CLUSTER_RESOURCE_GROUP"
+
+  # Keeps $1 instead of deleting it when $2, a decision of something built on it, is keep - $3 description and $4 name for the log
+  function keep_for_dependent {
+    if [ "${!1}" = 'delete' ] && [ "$2" = 'keep' ]; then
+      echo "INFO | Keeping $3 $4, a kept resource depends on it"
+      printf -v "$1" '%s' keep
+    fi
+  }
+
+  keep_for_dependent OFFBOARD_ARC_DATA_CUSTOM_LOCATION "$OFFBOARD_ARC_DATA_CONTROLLER" 'Custom Location' "$ARC_DATA_NAMESPACE"
+  keep_for_dependent OFFBOARD_ARC_DATA_EXT "$OFFBOARD_ARC_DATA_CUSTOM_LOCATION" 'Bootstrapper Extension' "$ARC_DATA_EXT"
+  keep_for_dependent OFFBOARD_CONNECTED_CLUSTER "$OFFBOARD_ARC_DATA_EXT" 'Connected Cluster' "$CONNECTED_CLUSTER"
+  keep_for_dependent OFFBOARD_ARC_DATA_RESOURCE_GROUP "$OFFBOARD_ARC_DATA_CONTROLLER" 'Arc Data Services Resource Group' "$ARC_DATA_RESOURCE_GROUP"
+  keep_for_dependent OFFBOARD_ARC_DATA_RESOURCE_GROUP "$OFFBOARD_ARC_DATA_CUSTOM_LOCATION" 'Arc Data Services Resource Group' "$ARC_DATA_RESOURCE_GROUP"
+  if [ "${ARC_DATA_RESOURCE_GROUP}" = "${CONNECTED_CLUSTER_RESOURCE_GROUP}" ]; then
+    keep_for_dependent OFFBOARD_ARC_DATA_RESOURCE_GROUP "$OFFBOARD_CONNECTED_CLUSTER" 'Arc Data Services Resource Group' "$ARC_DATA_RESOURCE_GROUP"
+  fi
+  keep_for_dependent OFFBOARD_CONNECTED_CLUSTER_RESOURCE_GROUP "$OFFBOARD_CONNECTED_CLUSTER" 'Connected Cluster Resource Group' "$CONNECTED_CLUSTER_RESOURCE_GROUP"
+
+  # Keeps resource group $1 instead of deleting it when it holds resources this installer doesn't own for this cluster,
+  # listed in ${1}_FOREIGN - $2 name and $3 description for the log
+  function keep_for_foreign_resources {
+    local foreign
+    printf -v "${1}_FOREIGN" '%s' ''
+    if [ "${!1}" != 'delete' ]; then
+      return
+    fi
+    foreign=$(az resource list --resource-group "$2" --output json | jq -r --arg managedBy "${OWNER_TAG_MANAGED_BY}" --arg cluster "${CONNECTED_CLUSTER}" \
+      '[.[] | select((.tags // {}) | .["arc-installer-managed-by"] != $managedBy or .["arc-installer-cluster"] != $cluster) | .name] | join(", ")')
+    if [ -n "${foreign}" ]; then
+      echo "INFO | Keeping $3 $2, it holds resources this installer does not own for ${CONNECTED_CLUSTER}: ${foreign}"
+      printf -v "$1" '%s' keep
+      printf -v "${1}_FOREIGN" '%s' "${foreign}"
+    fi
+  }
+
+  keep_for_foreign_resources OFFBOARD_ARC_DATA_RESOURCE_GROUP "$ARC_DATA_RESOURCE_GROUP" 'Arc Data Services Resource Group'
+  keep_for_foreign_resources OFFBOARD_CONNECTED_CLUSTER_RESOURCE_GROUP "$CONNECTED_CLUSTER_RESOURCE_GROUP" 'Connected Cluster Resource Group'
+
+  # The data services' Kubernetes objects stay while any of their Azure resources is kept
+  OFFBOARD_DATA_SERVICES_NAMESPACE='true'
+  if [ "$OFFBOARD_ARC_DATA_CONTROLLER" = 'keep' ] || [ "$OFFBOARD_ARC_DATA_CUSTOM_LOCATION" = 'keep' ] || [ "$OFFBOARD_ARC_DATA_EXT" = 'keep' ]; then
+    echo "INFO | Keeping Arc Data Namespace $ARC_DATA_NAMESPACE, the data services in it are kept"
+    OFFBOARD_DATA_SERVICES_NAMESPACE='false'
+  fi
+  echo ""
+fi
+
+# =============================
+# Dry run - print plan and exit
+# =============================
+# Mirrors the create/skip/delete decisions below - keep both in sync
+if [ "${DRY_RUN}" = 'true' ]; then
+  PLAN_FILE="${PLAN_FILE:-/tmp/plan.json}"
+  PLAN_ACTIONS='[]'
+
+  # Appends an action to the plan: $1 step, $2 resource, $3 name, $4 action
+  function plan_action {
+    echo "INFO | PLAN | $1. $4 $2 $3"
+    PLAN_ACTIONS=$(echo "${PLAN_ACTIONS}" | jq -c --arg step "$1" --arg resource "$2" --arg name "$3" --arg action "$4" '. + [{step: $step, resource: $resource, name: $name, action: $action}]')
+  }
+
+  function create_or_skip {
+    if [ "$1" = 'true' ]; then echo 'skip'; else echo 'create'; fi
+  }
+
+  function upgrade_or_skip {
+    if [ "$1" = 'true' ]; then echo 'upgrade'; else echo 'skip'; fi
+  }
+
+  echo ""
+  PLAN_MODE="${ACTION}"
+  if [ "${ACTION}" = 'status' ]; then
+    echo "INFO | Plan for Arc + Data Services status: read-only, nothing to change"
+  elif [ "${ACTION}" = 'upgrade' ]; then
+    echo "INFO | Plan for Arc + Data Services upgrade:"
+    plan_action 3 Extension "${ARC_DATA_EXT}" "$(upgrade_or_skip "$UPGRADE_ARC_DATA_EXT")"
+    plan_action 5 DataController "${ARC_DATA_CONTROLLER}" "$(upgrade_or_skip "$UPGRADE_ARC_DATA_CONTROLLER")"
+    plan_action status StatusConfigMap "${STATUS_CONFIGMAP}" update
+  elif [ "${ACTION}" = 'offboard' ]; then
+    echo "INFO | Plan for Arc + Data Services destruction, OFFBOARD_SCOPE=${OFFBOARD_SCOPE}:"
+    plan_action 5 DataController "${ARC_DATA_CONTROLLER}" "${OFFBOARD_ARC_DATA_CONTROLLER}"
+    plan_action 4 CustomLocation "${ARC_DATA_NAMESPACE}" "${OFFBOARD_ARC_DATA_CUSTOM_LOCATION}"
+    plan_action 3 Extension "${ARC_DATA_EXT}" "${OFFBOARD_ARC_DATA_EXT}"
+    if [ "$OFFBOARD_ARC_DATA_EXT" = 'delete' ]; then
+      plan_action 3 CustomResourceDefinitions arcdata delete
+      plan_action 3 MutatingWebhookConfiguration "arcdata.microsoft.com-webhook-${ARC_DATA_NAMESPACE}" delete
+    fi
+    plan_action 2 ConnectedCluster "${CONNECTED_CLUSTER}" "${OFFBOARD_CONNECTED_CLUSTER}"
+    plan_action 1 ResourceGroup "${ARC_DATA_RESOURCE_GROUP}" "${OFFBOARD_ARC_DATA_RESOURCE_GROUP}"
+    plan_action 1 ResourceGroup "${CONNECTED_CLUSTER_RESOURCE_GROUP}" "${OFFBOARD_CONNECTED_CLUSTER_RESOURCE_GROUP}"
+    if [ "$OFFBOARD_DATA_SERVICES_NAMESPACE" = 'true' ]; then
+      if [ "${OPENSHIFT}" = 'true' ]; then
+        plan_action 0 OpenShiftRoutes './openshift/arc-data-routes.yaml' delete
+        plan_action 0 OpenShiftSCC './openshift/arc-data-scc.yaml' delete
+      fi
+      plan_action 0 Namespace "${ARC_DATA_NAMESPACE}" delete
+    else
+      plan_action 0 Namespace "${ARC_DATA_NAMESPACE}" keep
+    fi
+    plan_action status StatusConfigMap "${STATUS_CONFIGMAP}" update
+  else
+    echo "INFO | Plan for Arc + Data Services ${ACTION}:"
+    if [ "$AZDATA_PASSWORD_GENERATED" = 'true' ]; then
+      plan_action 0 Secret "${AZDATA_SECRET}" create
+    fi
+    for provider in "${MISSING_RESOURCE_PROVIDERS[@]}"; do
+      plan_action 0 ResourceProvider "${provider}" register
+    done
+    # repair deletes Failed resources first, onboarding then recreates them
+    if [ "$REPAIR_ARC_DATA_CONTROLLER" = 'true' ]; then
+      plan_action 5 DataController "${ARC_DATA_CONTROLLER}" delete
+    fi
+    if [ "$REPAIR_ARC_DATA_CUSTOM_LOCATION" = 'true' ]; then
+      plan_action 4 CustomLocation "${ARC_DATA_NAMESPACE}" delete
+    fi
+    if [ "$REPAIR_ARC_DATA_EXT" = 'true' ]; then
+      plan_action 3 Extension "${ARC_DATA_EXT}" delete
+    fi
+    if [ "$REPAIR_CONNECTED_CLUSTER" = 'true' ]; then
+      plan_action 2 ConnectedCluster "${CONNECTED_CLUSTER}" delete
+    fi
+    if [ "${OPENSHIFT}" = 'true' ]; then
+      plan_action 0 OpenShiftSCC './openshift/arc-data-scc.yaml' apply
+    fi
+    plan_action 1 ResourceGroup "${CONNECTED_CLUSTER_RESOURCE_GROUP}" "$(create_or_skip "$CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS")"
+    if [ "$RETAG_CONNECTED_CLUSTER_RESOURCE_GROUP" = 'true' ]; then
+      plan_action 1 ResourceGroup "${CONNECTED_CLUSTER_RESOURCE_GROUP}" tag
+    fi
+    if [ "$ADOPT_CONNECTED_CLUSTER_RESOURCE_GROUP" = 'true' ]; then
+      plan_action 1 ResourceGroup "${CONNECTED_CLUSTER_RESOURCE_GROUP}" adopt
+    fi
+    plan_action 1 ResourceGroup "${ARC_DATA_RESOURCE_GROUP}" "$(create_or_skip "$ARC_DATA_RESOURCE_GROUP_EXISTS")"
+    if [ "$RETAG_ARC_DATA_RESOURCE_GROUP" = 'true' ]; then
+      plan_action 1 ResourceGroup "${ARC_DATA_RESOURCE_GROUP}" tag
+    fi
+    if [ "$ADOPT_ARC_DATA_RESOURCE_GROUP" = 'true' ]; then
+      plan_action 1 ResourceGroup "${ARC_DATA_RESOURCE_GROUP}" adopt
+    fi
+    plan_action 2 ConnectedCluster "${CONNECTED_CLUSTER}" "$(create_or_skip "$CONNECTED_CLUSTER_EXISTS")"
+    if [ "$RETAG_CONNECTED_CLUSTER" = 'true' ]; then
+      plan_action 2 ConnectedCluster "${CONNECTED_CLUSTER}" tag
+    fi
+    if [ "$ADOPT_CONNECTED_CLUSTER" = 'true' ]; then
+      plan_action 2 ConnectedCluster "${CONNECTED_CLUSTER}" adopt
+    fi
+    if [ "$CONNECTED_CLUSTER_EXISTS" = 'true' ]; then
+      plan_action 2a ConnectedCluster "${CONNECTED_CLUSTER}" enable-features
+    fi
+    plan_action 3 Extension "${ARC_DATA_EXT}" "$(create_or_skip "$ARC_DATA_EXT_EXISTS")"
+    for assignment in "${MISSING_ROLE_ASSIGNMENTS[@]}"; do
+      IFS=$'\t' read -r role scope <<< "${assignment}"
+      plan_action 3a RoleAssignment "${role}" role-assignment
+    done
+    plan_action 4 CustomLocation "${ARC_DATA_NAMESPACE}" "$(create_or_skip "$ARC_DATA_CUSTOM_LOCATION_EXISTS")"
+    if [ "$ARC_DATA_CUSTOM_LOCATION_EXISTS" != 'true' ] || [ "$RETAG_ARC_DATA_CUSTOM_LOCATION" = 'true' ]; then
+      plan_action 4 CustomLocation "${ARC_DATA_NAMESPACE}" tag
+    fi
+    if [ "$ADOPT_ARC_DATA_CUSTOM_LOCATION" = 'true' ]; then
+      plan_action 4 CustomLocation "${ARC_DATA_NAMESPACE}" adopt
+    fi
+    plan_action 5 DataController "${ARC_DATA_CONTROLLER}" "$(create_or_skip "$ARC_DATA_CONTROLLER_EXISTS")"
+    if [ "$ARC_DATA_CONTROLLER_EXISTS" != 'true' ] || [ "$RETAG_ARC_DATA_CONTROLLER" = 'true' ]; then
+      plan_action 5 DataController "${ARC_DATA_CONTROLLER}" tag
+    fi
+    if [ "$ADOPT_ARC_DATA_CONTROLLER" = 'true' ]; then
+      plan_action 5 DataController "${ARC_DATA_CONTROLLER}" adopt
+    fi
+    if [ "${OPENSHIFT}" = 'true' ]; then
+      plan_action 5a OpenShiftRoutes './openshift/arc-data-routes.yaml' apply
+    fi
+    plan_action status StatusConfigMap "${STATUS_CONFIGMAP}" update
+  fi
+
+  PLAN=$(jq -cn --arg mode "${PLAN_MODE}" --argjson actions "${PLAN_ACTIONS}" '{mode: $mode, actions: $actions}')
+  echo "${PLAN}" > "${PLAN_FILE}"
+
+  echo ""
+  echo "INFO | Plan written to ${PLAN_FILE}:"
+  echo "INFO | Plan: ${PLAN}"
+  echo ""
+  echo "----------------------------------------------------------------------------------"
+  echo "INFO | Dry run complete, nothing was changed."
+  exit 0
+fi
+
+# ======================
+# Status only - read-only
+# ======================
+if [ "${ACTION}" = 'status' ]; then
+  echo "INFO | Arc + Data Services status:"
+
+  # Reports a resource found by the status checks - $1 result key, $2 name, $3 exists, $4 ARM ID, $5 provisioning state
+  function status_resource {
+    result_step "$1"
+    if [ "$3" = 'true' ]; then
+      echo "INFO | $1 $2: ${5:-Exists}"
+      result_resource "$2" "${5:-Exists}" "$4"
+    else
+      echo "INFO | $1 $2: Absent"
+      result_resource "$2" Absent
+    fi
+  }
+
+  status_resource connectedClusterResourceGroup "${CONNECTED_CLUSTER_RESOURCE_GROUP}" "$CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS" "${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID}"
+  status_resource arcDataResourceGroup "${ARC_DATA_RESOURCE_GROUP}" "$ARC_DATA_RESOURCE_GROUP_EXISTS" "${ARC_DATA_RESOURCE_GROUP_ARM_ID}"
+  status_resource connectedCluster "${CONNECTED_CLUSTER}" "$CONNECTED_CLUSTER_EXISTS" "${CONNECTED_CLUSTER_ARM_ID}" "${CONNECTED_CLUSTER_STATE}"
+  status_resource extension "${ARC_DATA_EXT}" "$ARC_DATA_EXT_EXISTS" "${ARC_DATA_EXT_ARM_ID}" "${ARC_DATA_EXT_STATE}"
+  status_resource customLocation "${ARC_DATA_NAMESPACE}" "$ARC_DATA_CUSTOM_LOCATION_EXISTS" "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}" "${ARC_DATA_CUSTOM_LOCATION_STATE}"
+  status_resource dataController "${ARC_DATA_CONTROLLER}" "$ARC_DATA_CONTROLLER_EXISTS" "${ARC_DATA_CONTROLLER_ARM_ID}" "${ARC_DATA_CONTROLLER_STATE}"
+
+  # The last recorded installation status is only read, never written
+  result_step status
+  RECORDED_STATUS=$(kubectl get configmap "${STATUS_CONFIGMAP}" -n "${STATUS_NAMESPACE}" --ignore-not-found -o jsonpath='{.data}')
+  echo "INFO | Recorded status in ${STATUS_NAMESPACE}/${STATUS_CONFIGMAP}: ${RECORDED_STATUS:-none}"
+
+  echo ""
+  echo "----------------------------------------------------------------------------------"
+  echo "INFO | Status complete, nothing was changed."
+  exit 0
+fi
+
 # ======================
 # Handle delete and exit
 # ======================
-if [ "${DELETE_FLAG}" = 'true' ]; then
-  echo "INFO | Starting Arc + Data Services destruction process"
+if [ "${ACTION}" = 'offboard' ]; then
+  echo "INFO | Starting Arc + Data Services destruction process, OFFBOARD_SCOPE=${OFFBOARD_SCOPE}"
+
+  # Warns when a resource group in scope was kept because the installer didn't create it, and it still holds resources - $1 name
+  function refuse_unowned_resource_group {
+    local remaining
+    remaining=$(az resource list --resource-group "$1" --query 'length(@)' --output tsv)
+    if [ "${remaining:-0}" -gt 0 ]; then
+      result_warning "Resource Group $1 was not created by this installer and still holds ${remaining} resources, refusing to delete it"
+    fi
+  }
   
   # 5. Data Controller
-  if [ "$ARC_DATA_CONTROLLER_EXISTS" = 'true' ]; then 
+  result_step dataController
+  if [ "$OFFBOARD_ARC_DATA_CONTROLLER" = 'keep' ]; then
+    result_resource "${ARC_DATA_CONTROLLER}" Kept "${ARC_DATA_CONTROLLER_ARM_ID}"
+  elif [ "$OFFBOARD_ARC_DATA_CONTROLLER" = 'delete' ]; then 
     echo "INFO | Deleting Data Controller $ARC_DATA_CONTROLLER"
     az arcdata dc delete --name "${ARC_DATA_CONTROLLER}" \
                          --subscription "${SUBSCRIPTION_ID}" \
                          --resource-group "${ARC_DATA_RESOURCE_GROUP}" \
                          --yes \
                          ${VERBOSE:+--debug --verbose}
+    result_resource "${ARC_DATA_CONTROLLER}" Deleted
   else 
     echo "INFO | Data Controller $ARC_DATA_CONTROLLER doest not exist, skipping delete"
+    result_resource "${ARC_DATA_CONTROLLER}" Absent
   fi
 
   # 4. Custom Location
-  if [ "$ARC_DATA_CUSTOM_LOCATION_EXISTS" = 'true' ]; then 
+  result_step customLocation
+  if [ "$OFFBOARD_ARC_DATA_CUSTOM_LOCATION" = 'keep' ]; then
+    result_resource "${ARC_DATA_NAMESPACE}" Kept "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}"
+  elif [ "$OFFBOARD_ARC_DATA_CUSTOM_LOCATION" = 'delete' ]; then 
     echo "INFO | Deleting Custom Location $ARC_DATA_NAMESPACE"
     az customlocation delete --name "${ARC_DATA_NAMESPACE}" \
                          --resource-group "${ARC_DATA_RESOURCE_GROUP}" \
                          --yes \
                          ${VERBOSE:+--debug --verbose}
+    result_resource "${ARC_DATA_NAMESPACE}" Deleted
   else 
     echo "INFO | Custom Location $ARC_DATA_NAMESPACE doest not exist, skipping delete"
+    result_resource "${ARC_DATA_NAMESPACE}" Absent
   fi
 
   # 3. Bootstrapper Extension
-  if [ "$ARC_DATA_EXT_EXISTS" = 'true' ]; then 
+  result_step extension
+  if [ "$OFFBOARD_ARC_DATA_EXT" = 'keep' ]; then
+    result_resource "${ARC_DATA_EXT}" Kept "${ARC_DATA_EXT_ARM_ID}"
+  elif [ "$OFFBOARD_ARC_DATA_EXT" = 'delete' ]; then 
     echo "INFO | Deleting Bootstrapper Extension $ARC_DATA_EXT"
     az k8s-extension delete --name "${ARC_DATA_EXT}" \
                         --cluster-type connectedClusters \
@@ -411,49 +1770,93 @@ if [ "${DELETE_FLAG}" = 'true' ]; then
     echo "INFO | Deleting Arc Data Mutating Webhook Configs"
     kubectl delete mutatingwebhookconfiguration arcdata.microsoft.com-webhook-"${ARC_DATA_NAMESPACE}" --ignore-not-found=true
 
+    result_resource "${ARC_DATA_EXT}" Deleted
   else 
     echo "INFO | Bootstrapper Extension $ARC_DATA_EXT doest not exist, skipping delete"
+    result_resource "${ARC_DATA_EXT}" Absent
   fi
 
   # 2. Connected Cluster
-  if [ "$CONNECTED_CLUSTER_EXISTS" = 'true' ]; then 
+  result_step connectedCluster
+  if [ "$OFFBOARD_CONNECTED_CLUSTER" = 'keep' ]; then
+    result_resource "${CONNECTED_CLUSTER}" Kept "${CONNECTED_CLUSTER_ARM_ID}"
+  elif [ "$OFFBOARD_CONNECTED_CLUSTER" = 'delete' ]; then 
     echo "INFO | Deleting Connected Cluster $CONNECTED_CLUSTER"
       az connectedk8s delete --name "${CONNECTED_CLUSTER}" \
                              --resource-group "${CONNECTED_CLUSTER_RESOURCE_GROUP}" \
                              --yes \
                              ${VERBOSE:+--debug --verbose}
+    result_resource "${CONNECTED_CLUSTER}" Deleted
   else 
     echo "INFO | Connected Cluster $CONNECTED_CLUSTER doest not exist, skipping delete"
+    result_resource "${CONNECTED_CLUSTER}" Absent
   fi
 
   # 1. Connected Cluster and Data Services RG
-  if [ "$ARC_DATA_RESOURCE_GROUP_EXISTS" = 'true' ]; then 
+  result_step arcDataResourceGroup
+  if [ "$OFFBOARD_ARC_DATA_RESOURCE_GROUP" = 'keep' ]; then
+    if [ "$IN_SCOPE_ARC_DATA_RESOURCE_GROUP" = 'true' ] && [ "$OWNED_ARC_DATA_RESOURCE_GROUP" != 'true' ]; then
+      refuse_unowned_resource_group "$ARC_DATA_RESOURCE_GROUP"
+    fi
+    if [ -n "$OFFBOARD_ARC_DATA_RESOURCE_GROUP_FOREIGN" ]; then
+      result_warning "Resource Group $ARC_DATA_RESOURCE_GROUP holds resources this installer does not own (${OFFBOARD_ARC_DATA_RESOURCE_GROUP_FOREIGN}), refusing to delete it"
+    fi
+    result_resource "${ARC_DATA_RESOURCE_GROUP}" Kept "${ARC_DATA_RESOURCE_GROUP_ARM_ID}"
+  elif [ "$OFFBOARD_ARC_DATA_RESOURCE_GROUP" = 'delete' ]; then 
     echo "INFO | Deleting Arc Data Services Resource Group $ARC_DATA_RESOURCE_GROUP"
       az group delete --resource-group "$ARC_DATA_RESOURCE_GROUP" \
                       --yes \
                       ${VERBOSE:+--debug --verbose}
+    result_resource "${ARC_DATA_RESOURCE_GROUP}" Deleted
   else 
     echo "INFO | Arc Data Services Resource Group $ARC_DATA_RESOURCE_GROUP doest not exist, skipping delete"
+    result_resource "${ARC_DATA_RESOURCE_GROUP}" Absent
   fi
 
-  if [ "$CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS" = 'true' ]; then 
+  result_step connectedClusterResourceGroup
+  if [ "$OFFBOARD_CONNECTED_CLUSTER_RESOURCE_GROUP" = 'keep' ]; then
+    if [ "$IN_SCOPE_CONNECTED_CLUSTER" = 'true' ] && [ "$OWNED_CONNECTED_CLUSTER_RESOURCE_GROUP" != 'true' ]; then
+      refuse_unowned_resource_group "$CONNECTED_CLUSTER_RESOURCE_GROUP"
+    fi
+    if [ -n "$OFFBOARD_CONNECTED_CLUSTER_RESOURCE_GROUP_FOREIGN" ]; then
+      result_warning "Resource Group $CONNECTED_CLUSTER_RESOURCE_GROUP holds resources this installer does not own (${OFFBOARD_CONNECTED_CLUSTER_RESOURCE_GROUP_FOREIGN}), refusing to delete it"
+    fi
+    result_resource "${CONNECTED_CLUSTER_RESOURCE_GROUP}" Kept "${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID}"
+  elif [ "$OFFBOARD_CONNECTED_CLUSTER_RESOURCE_GROUP" = 'delete' ]; then 
     echo "INFO | Deleting Connected Cluster Resource Group $CONNECTED_CLUSTER_RESOURCE_GROUP"
       az group delete --resource-group "$CONNECTED_CLUSTER_RESOURCE_GROUP" \
                       --yes \
                       ${VERBOSE:+--debug --verbose}
+    result_resource "${CONNECTED_CLUSTER_RESOURCE_GROUP}" Deleted
   else 
     echo "INFO | Connected Cluster Resource Group $CONNECTED_CLUSTER_RESOURCE_GROUP doest not exist, skipping delete"
+    result_resource "${CONNECTED_CLUSTER_RESOURCE_GROUP}" Absent
   fi
 
   # 0. Handle OpenShift pre-req cleanup
-  if [ "${OPENSHIFT}" = 'true' ]; then
-    echo "INFO | Removing OpenShift pre-reqs"
-    kubectl delete --ignore-not-found=true -n "${ARC_DATA_NAMESPACE}" -f './openshift/arc-data-routes.yaml'
-    kubectl delete --ignore-not-found=true -n "${ARC_DATA_NAMESPACE}" -f './openshift/arc-data-scc.yaml'
+  result_step namespace
+  if [ "$OFFBOARD_DATA_SERVICES_NAMESPACE" = 'true' ]; then
+    if [ "${OPENSHIFT}" = 'true' ]; then
+      echo "INFO | Removing OpenShift pre-reqs"
+      kubectl delete --ignore-not-found=true -n "${ARC_DATA_NAMESPACE}" -f './openshift/arc-data-routes.yaml'
+      kubectl delete --ignore-not-found=true -n "${ARC_DATA_NAMESPACE}" -f './openshift/arc-data-scc.yaml'
+    fi
+
+    echo "INFO | Deleting Arc Data Namespace"
+    kubectl delete --ignore-not-found=true namespace "${ARC_DATA_NAMESPACE}"
   fi
 
-  echo "INFO | Deleting Arc Data Namespace"
-  kubectl delete --ignore-not-found=true namespace "${ARC_DATA_NAMESPACE}"
+  # Keeps the versions that were installed, drops the IDs of resources that are gone
+  result_step status
+  update_status_configmap "$(jq -cn \
+    --arg time "$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+    --arg offboardScope "${OFFBOARD_SCOPE}" \
+    --argjson resources "${RESULT_RESOURCES}" \
+    '($resources | to_entries | map(select(.value.status == "Kept") | .key)) as $kept
+     | {state: "Offboarded", lastOffboardTime: $time, offboardScope: $offboardScope,
+        kept: (if $kept == [] then null else ($kept | join(",")) end), dataControllerState: null}
+     + ({connectedClusterId: "connectedCluster", extensionId: "extension", customLocationId: "customLocation", dataControllerId: "dataController"}
+        | with_entries(select(.value as $key | $kept | index($key) | not) | .value = null))')"
 
   echo ""
   echo "----------------------------------------------------------------------------------"
@@ -461,6 +1864,198 @@ if [ "${DELETE_FLAG}" = 'true' ]; then
   exit 0
 fi
 
+# ==========================
+# Upgrade in place and exit
+# ==========================
+if [ "${ACTION}" = 'upgrade' ]; then
+  echo "INFO | Starting Arc + Data Services upgrade process"
+
+  # 3. Bootstrapper Extension first, it serves the Data Controller's new version
+  result_step extension
+  if [ "$UPGRADE_ARC_DATA_EXT" = 'true' ]; then
+    echo "INFO | Upgrading Bootstrapper extension $ARC_DATA_EXT from ${ARC_DATA_EXT_INSTALLED_VERSION:-unknown} to ${ARC_DATA_EXT_VERSION}"
+    az k8s-extension update --name "${ARC_DATA_EXT}" \
+                            --cluster-type connectedClusters \
+                            --cluster-name "${CONNECTED_CLUSTER}" \
+                            --resource-group "${CONNECTED_CLUSTER_RESOURCE_GROUP}" \
+                            "${bootstrapper_version_param[@]}" \
+                            --yes \
+                            ${VERBOSE:+--debug --verbose}
+
+    # Loop for 10 minutes, the Data Controller must not be upgraded before the extension is done
+    for i in {1..20}
+    do
+      echo "INFO | Waiting for Bootstrapper extension $ARC_DATA_EXT to finish upgrading (attempt $i of 20)..."
+      ARC_DATA_EXT_STATUS=$(az k8s-extension show --cluster-name "$CONNECTED_CLUSTER" --resource-group "$CONNECTED_CLUSTER_RESOURCE_GROUP" --cluster-type connectedClusters --name "${ARC_DATA_EXT}" | jq -r '.provisioningState')
+      echo "INFO | Bootstrapper extension provisioning status: $ARC_DATA_EXT_STATUS"
+
+      if [ "$ARC_DATA_EXT_STATUS" = 'Succeeded' ] || [ "$ARC_DATA_EXT_STATUS" = 'Failed' ]; then
+        break
+      fi
+
+      echo "INFO | Sleeping for 30 seconds..."
+      sleep 30
+    done
+
+    if [ "$ARC_DATA_EXT_STATUS" != 'Succeeded' ]; then
+      echo "ERROR | Bootstrapper extension $ARC_DATA_EXT upgrade status is $ARC_DATA_EXT_STATUS, manual intervention is required"
+      result_resource "${ARC_DATA_EXT}" "${ARC_DATA_EXT_STATUS}" "${ARC_DATA_EXT_ARM_ID}"
+      exit 1
+    fi
+    result_resource "${ARC_DATA_EXT}" Upgraded "${ARC_DATA_EXT_ARM_ID}"
+  else
+    echo "INFO | Bootstrapper extension $ARC_DATA_EXT is already at version ${ARC_DATA_EXT_VERSION}, skipping upgrade"
+    result_resource "${ARC_DATA_EXT}" Current "${ARC_DATA_EXT_ARM_ID}"
+  fi
+
+  # 5. Data Controller
+  result_step dataController
+  if [ "$UPGRADE_ARC_DATA_CONTROLLER" = 'true' ]; then
+    echo "INFO | Upgrading Data Controller $ARC_DATA_CONTROLLER from ${ARC_DATA_CONTROLLER_INSTALLED_VERSION:-unknown} to ${ARC_DATA_CONTROLLER_VERSION}"
+    az arcdata dc upgrade --name "${ARC_DATA_CONTROLLER}" \
+                          --resource-group "${ARC_DATA_RESOURCE_GROUP}" \
+                          --desired-version "${ARC_DATA_CONTROLLER_VERSION}" \
+                          ${VERBOSE:+--debug --verbose}
+
+    # Loop for 10 minutes, sleeping for 30 seconds each time to see if Data Controller is Ready on the new image tag
+    for i in {1..20}
+    do
+      echo "INFO | Waiting for Data Controller $ARC_DATA_CONTROLLER to be ready on ${ARC_DATA_CONTROLLER_VERSION} (attempt $i of 20)..."
+      ARC_DATA_CONTROLLER_RAW=$(az arcdata dc status show --name "$ARC_DATA_CONTROLLER" --resource-group "$ARC_DATA_RESOURCE_GROUP")
+      ARC_DATA_CONTROLLER_STATUS=$(echo "${ARC_DATA_CONTROLLER_RAW}" | jq -r '.properties.k8SRaw.status.state')
+      ARC_DATA_CONTROLLER_RUNNING_VERSION=$(echo "${ARC_DATA_CONTROLLER_RAW}" | jq -r '.properties.k8SRaw.spec.docker.imageTag // empty')
+      echo "INFO | Data Controller provisioning status: $ARC_DATA_CONTROLLER_STATUS, image tag: ${ARC_DATA_CONTROLLER_RUNNING_VERSION:-unknown}"
+
+      if [ "$ARC_DATA_CONTROLLER_STATUS" = 'Failed' ]; then
+        echo "ERROR | Data Controller $ARC_DATA_CONTROLLER upgrade status is $ARC_DATA_CONTROLLER_STATUS, manual intervention is required"
+        result_resource "${ARC_DATA_CONTROLLER}" "${ARC_DATA_CONTROLLER_STATUS}" "${ARC_DATA_CONTROLLER_ARM_ID}"
+        exit 1
+      fi
+
+      if [ "$ARC_DATA_CONTROLLER_STATUS" = 'Ready' ] && [ "$ARC_DATA_CONTROLLER_RUNNING_VERSION" = "${ARC_DATA_CONTROLLER_VERSION}" ]; then
+        break
+      fi
+
+      echo "INFO | Sleeping for 30 seconds..."
+      sleep 30
+    done
+
+    # Not recording the status - the Data Controller may still run the old image tag
+    if [ "$ARC_DATA_CONTROLLER_STATUS" != 'Ready' ] || [ "$ARC_DATA_CONTROLLER_RUNNING_VERSION" != "${ARC_DATA_CONTROLLER_VERSION}" ]; then
+      echo "ERROR | Data Controller $ARC_DATA_CONTROLLER did not reach Ready state on ${ARC_DATA_CONTROLLER_VERSION} in time, last status is $ARC_DATA_CONTROLLER_STATUS on ${ARC_DATA_CONTROLLER_RUNNING_VERSION:-unknown}, manual intervention is required"
+      result_resource "${ARC_DATA_CONTROLLER}" "${ARC_DATA_CONTROLLER_STATUS}" "${ARC_DATA_CONTROLLER_ARM_ID}"
+      exit 1
+    fi
+    result_resource "${ARC_DATA_CONTROLLER}" Upgraded "${ARC_DATA_CONTROLLER_ARM_ID}"
+  else
+    echo "INFO | Data Controller $ARC_DATA_CONTROLLER is already at image tag ${ARC_DATA_CONTROLLER_VERSION}, skipping upgrade"
+    result_resource "${ARC_DATA_CONTROLLER}" Current "${ARC_DATA_CONTROLLER_ARM_ID}"
+  fi
+
+  result_step status
+  record_onboarded_status lastUpgradeTime
+
+  echo ""
+  echo "----------------------------------------------------------------------------------"
+  echo "INFO | Upgrade complete."
+  exit 0
+fi
+
+# ===================================
+# Idempotent: AZDATA Password Secret
+# ===================================
+# Stored before anything is created with the generated password, so a failed onboarding is retried with the same one
+if [ "${AZDATA_PASSWORD_GENERATED}" = 'true' ]; then
+  result_step azdataPassword
+  echo "INFO | Storing the generated AZDATA_PASSWORD in Secret ${STATUS_NAMESPACE}/${AZDATA_SECRET}"
+  ensure_status_namespace
+  # The password is read from the environment, so it never shows up in a command line
+  jq -n --arg name "${AZDATA_SECRET}" --arg namespace "${STATUS_NAMESPACE}" --arg managedBy "${OWNER_TAG_MANAGED_BY}" \
+    '{apiVersion: "v1", kind: "Secret", type: "Opaque",
+      metadata: {name: $name, namespace: $namespace, labels: {"app.kubernetes.io/managed-by": $managedBy}},
+      stringData: {AZDATA_USERNAME: env.AZDATA_USERNAME, AZDATA_PASSWORD: env.AZDATA_PASSWORD}}' \
+    | kubectl create -f -
+  echo ""
+fi
+
+# ==========================================
+# Idempotent: Resource Provider Registration
+# ==========================================
+# Registration takes a few minutes, nothing in the provider's namespace can be created until it is done
+result_step resourceProviders
+if [ "${#MISSING_RESOURCE_PROVIDERS[@]}" -gt 0 ]; then
+  for provider in "${MISSING_RESOURCE_PROVIDERS[@]}"; do
+    echo "INFO | Registering resource provider $provider"
+    az provider register --namespace "${provider}"
+  done
+
+  for i in {1..20}
+  do
+    registering=()
+    for provider in "${MISSING_RESOURCE_PROVIDERS[@]}"; do
+      state=$(az provider show --namespace "${provider}" --query registrationState --output tsv)
+      if [ "${state}" != 'Registered' ]; then
+        registering+=("${provider}")
+      fi
+    done
+
+    if [ "${#registering[@]}" -eq 0 ]; then
+      echo "INFO | Resource provider(s) ${MISSING_RESOURCE_PROVIDERS[*]} registered"
+      break
+    fi
+
+    if [ "$i" -eq 20 ]; then
+      echo "ERROR | Resource provider(s) ${registering[*]} did not finish registering, manual intervention is required"
+      exit 1
+    fi
+    echo "INFO | Waiting for resource provider(s) ${registering[*]} to register (attempt $i of 20)..."
+    echo "INFO | Sleeping for 30 seconds..."
+    sleep 30
+  done
+  echo ""
+fi
+
+# ================================
+# Repair - delete Failed resources
+# ================================
+# Onboarding below recreates them, in dependency order
+if [ "${ACTION}" = 'repair' ]; then
+  if [ "$REPAIR_ARC_DATA_CONTROLLER" = 'true' ]; then
+    echo "INFO | Repairing Data Controller $ARC_DATA_CONTROLLER in state ${ARC_DATA_CONTROLLER_STATE}, deleting it"
+    az arcdata dc delete --name "${ARC_DATA_CONTROLLER}" \
+                         --subscription "${SUBSCRIPTION_ID}" \
+                         --resource-group "${ARC_DATA_RESOURCE_GROUP}" \
+                         --yes \
+                         ${VERBOSE:+--debug --verbose}
+  fi
+
+  if [ "$REPAIR_ARC_DATA_CUSTOM_LOCATION" = 'true' ]; then
+    echo "INFO | Repairing Custom Location $ARC_DATA_NAMESPACE in state ${ARC_DATA_CUSTOM_LOCATION_STATE}, deleting it"
+    az customlocation delete --name "${ARC_DATA_NAMESPACE}" \
+                             --resource-group "${ARC_DATA_RESOURCE_GROUP}" \
+                             --yes \
+                             ${VERBOSE:+--debug --verbose}
+  fi
+
+  if [ "$REPAIR_ARC_DATA_EXT" = 'true' ]; then
+    echo "INFO | Repairing Bootstrapper extension $ARC_DATA_EXT in state ${ARC_DATA_EXT_STATE}, deleting it"
+    az k8s-extension delete --name "${ARC_DATA_EXT}" \
+                            --cluster-type connectedClusters \
+                            --cluster-name "${CONNECTED_CLUSTER}" \
+                            --resource-group "${CONNECTED_CLUSTER_RESOURCE_GROUP}" \
+                            --yes \
+                            ${VERBOSE:+--debug --verbose}
+  fi
+
+  if [ "$REPAIR_CONNECTED_CLUSTER" = 'true' ]; then
+    echo "INFO | Repairing Connected Cluster $CONNECTED_CLUSTER in state ${CONNECTED_CLUSTER_STATE}, deleting it"
+    az connectedk8s delete --name "${CONNECTED_CLUSTER}" \
+                           --resource-group "${CONNECTED_CLUSTER_RESOURCE_GROUP}" \
+                           --yes \
+                           ${VERBOSE:+--debug --verbose}
+  fi
+fi
+
 # ==========================================
 # 0. Idempotent: OpenShift pre-reqs creation
 # ==========================================
@@ -473,33 +2068,60 @@ fi
 # =========================================
 # 1. Connected Cluster and Data Services RG
 # =========================================
+result_step connectedClusterResourceGroup
 if [ "$CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS" = 'true' ]; then
     echo "INFO | Connected Cluster Resource Group $CONNECTED_CLUSTER_RESOURCE_GROUP already exists, skipping create"
+    if [ "$RETAG_CONNECTED_CLUSTER_RESOURCE_GROUP" = 'true' ]; then
+      tag_user_resource "${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID}"
+    fi
+    if [ "$ADOPT_CONNECTED_CLUSTER_RESOURCE_GROUP" = 'true' ]; then
+      adopt_resource "${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID}"
+    fi
+    result_resource "${CONNECTED_CLUSTER_RESOURCE_GROUP}" Exists "${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID}"
 else 
     echo "INFO | Creating Connected Cluster Resource Group $CONNECTED_CLUSTER_RESOURCE_GROUP"
     az group create --resource-group "$CONNECTED_CLUSTER_RESOURCE_GROUP" \
                     --location "$CONNECTED_CLUSTER_LOCATION" \
+                    --tags "${create_tags[@]}" \
                     ${VERBOSE:+--debug --verbose}
     CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS='true'
     export CONNECTED_CLUSTER_RESOURCE_GROUP_EXISTS
+    result_resource "${CONNECTED_CLUSTER_RESOURCE_GROUP}" Created "${CONNECTED_CLUSTER_RESOURCE_GROUP_ARM_ID}"
 fi
 
+result_step arcDataResourceGroup
 if [ "$ARC_DATA_RESOURCE_GROUP_EXISTS" = 'true' ]; then 
     echo "INFO | Arc Data Services Resource Group $ARC_DATA_RESOURCE_GROUP already exists, skipping create"
+    if [ "$RETAG_ARC_DATA_RESOURCE_GROUP" = 'true' ]; then
+      tag_user_resource "${ARC_DATA_RESOURCE_GROUP_ARM_ID}"
+    fi
+    if [ "$ADOPT_ARC_DATA_RESOURCE_GROUP" = 'true' ]; then
+      adopt_resource "${ARC_DATA_RESOURCE_GROUP_ARM_ID}"
+    fi
+    result_resource "${ARC_DATA_RESOURCE_GROUP}" Exists "${ARC_DATA_RESOURCE_GROUP_ARM_ID}"
 else 
     echo "INFO | Creating Arc Data Services Resource Group $ARC_DATA_RESOURCE_GROUP"
     az group create --resource-group "$ARC_DATA_RESOURCE_GROUP" \
                     --location "$ARC_DATA_LOCATION" \
+                    --tags "${create_tags[@]}" \
                     ${VERBOSE:+--debug --verbose}
     ARC_DATA_RESOURCE_GROUP_EXISTS='true'
     export ARC_DATA_RESOURCE_GROUP_EXISTS
+    result_resource "${ARC_DATA_RESOURCE_GROUP}" Created "${ARC_DATA_RESOURCE_GROUP_ARM_ID}"
 fi
 
 # ====================
 # 2. Connected Cluster
 # ====================
+result_step connectedCluster
 if [ "$CONNECTED_CLUSTER_EXISTS" = 'true' ]; then
     echo "INFO | Connected Cluster $CONNECTED_CLUSTER already exists, skipping create"
+    if [ "$RETAG_CONNECTED_CLUSTER" = 'true' ]; then
+      tag_user_resource "${CONNECTED_CLUSTER_ARM_ID}"
+    fi
+    if [ "$ADOPT_CONNECTED_CLUSTER" = 'true' ]; then
+      adopt_resource "${CONNECTED_CLUSTER_ARM_ID}"
+    fi
     # 2a. Idempotent: Enable Cluster-Connect and Custom-Locations
     echo "INFO | Enabling Cluster-Connect and Custom-Locations"
     az connectedk8s enable-features -n "${CONNECTED_CLUSTER}" \
@@ -508,31 +2130,28 @@ if [ "$CONNECTED_CLUSTER_EXISTS" = 'true' ]; then
                                     --features cluster-connect custom-locations \
                                     "${custom_location_oid_param[@]}" \
                                     ${VERBOSE:+--debug --verbose}
+    result_resource "${CONNECTED_CLUSTER}" Exists "${CONNECTED_CLUSTER_ARM_ID}"
 else 
     echo "INFO | Creating Connected Cluster $CONNECTED_CLUSTER"
     az connectedk8s connect --name "${CONNECTED_CLUSTER}" \
                             --resource-group "${CONNECTED_CLUSTER_RESOURCE_GROUP}" \
                             --location "${CONNECTED_CLUSTER_LOCATION}" \
+                            --tags "${create_tags[@]}" \
                             "${custom_location_oid_param[@]}" \
                             "${timeout_param[@]}" \
                             ${VERBOSE:+--debug --verbose}
     CONNECTED_CLUSTER_EXISTS='true'
     export CONNECTED_CLUSTER_EXISTS
+    result_resource "${CONNECTED_CLUSTER}" Created "${CONNECTED_CLUSTER_ARM_ID}"
 fi
 
 # =========================
 # 3. Bootstrapper Extension
 # =========================
+result_step extension
 if [ "$ARC_DATA_EXT_EXISTS" = 'true' ]; then
     echo "INFO | Bootstrapper extension $ARC_DATA_EXT already exists, skipping create"
-    # 3a. Idempotent: Bootstrapper MSI Extension Permissions
-    echo "INFO | Proceeding to SAMI Role Assignments on Data Services Resource Group $ARC_DATA_RESOURCE_GROUP"
-  
-    ARC_DATA_EXT_MSI=$(az k8s-extension show --cluster-name "${CONNECTED_CLUSTER}" --resource-group "${CONNECTED_CLUSTER_RESOURCE_GROUP}" --cluster-type connectedClusters --name "${ARC_DATA_EXT}" --query "identity.principalId" --output tsv)
-    export ARC_DATA_EXT_MSI
-    
-    az role assignment create --assignee "${ARC_DATA_EXT_MSI}" --role 'Contributor' --scope "/subscriptions/${SUBSCRIPTION_ID}/resourceGroups/${ARC_DATA_RESOURCE_GROUP}"
-    az role assignment create --assignee "${ARC_DATA_EXT_MSI}" --role 'Monitoring Metrics Publisher' --scope "/subscriptions/${SUBSCRIPTION_ID}/resourceGroups/${ARC_DATA_RESOURCE_GROUP}"
+    result_resource "${ARC_DATA_EXT}" Exists "${ARC_DATA_EXT_ARM_ID}"
 else 
     echo "INFO | Creating Bootstrapper extension $ARC_DATA_EXT"
     az k8s-extension create --name "${ARC_DATA_EXT}" \
@@ -551,17 +2170,61 @@ else
     if [ "$ARC_DATA_EXT_STATUS" = 'Succeeded' ]; then
       ARC_DATA_EXT_EXISTS='true'
       export ARC_DATA_EXT_EXISTS
+      result_resource "${ARC_DATA_EXT}" Created "${ARC_DATA_EXT_ARM_ID}"
     else
       echo "ERROR | Bootstrapper extension $ARC_DATA_EXT provisioning status is $ARC_DATA_EXT_STATUS, manual intervention is required"
+      result_resource "${ARC_DATA_EXT}" "${ARC_DATA_EXT_STATUS}"
       exit 1
     fi
 fi
 
+# =============================================
+# 3a. Bootstrapper Managed Identity Permissions
+# =============================================
+# Retried while a new identity propagates to Azure AD. A failure here is reported as the roleAssignments step.
+result_step roleAssignments
+if [ "${#MISSING_ROLE_ASSIGNMENTS[@]}" -gt 0 ]; then
+  if [ -z "${ARC_DATA_EXT_MSI}" ]; then
+    read_ext_principal_id
+  fi
+
+  for assignment in "${MISSING_ROLE_ASSIGNMENTS[@]}"; do
+    IFS=$'\t' read -r role scope <<< "${assignment}"
+    echo "INFO | Assigning role $role on $scope to Bootstrapper extension $ARC_DATA_EXT"
+    for i in {1..10}
+    do
+      if az role assignment create --assignee-object-id "${ARC_DATA_EXT_MSI}" \
+                                   --assignee-principal-type ServicePrincipal \
+                                   --role "${role}" \
+                                   --scope "${scope}"; then
+        break
+      fi
+
+      if [ "$i" -eq 10 ]; then
+        echo "ERROR | Could not assign role $role on $scope to Bootstrapper extension $ARC_DATA_EXT, manual intervention is required"
+        exit 1
+      fi
+      echo "INFO | Role assignment failed, the managed identity may still be propagating (attempt $i of 10)..."
+      echo "INFO | Sleeping for 30 seconds..."
+      sleep 30
+    done
+  done
+  echo ""
+fi
+
 # ==================
 # 4. Custom Location
 # ==================
+result_step customLocation
 if [ "$ARC_DATA_CUSTOM_LOCATION_EXISTS" = 'true' ]; then
     echo "INFO | Custom Location $ARC_DATA_NAMESPACE already exists, skipping create"
+    if [ "$RETAG_ARC_DATA_CUSTOM_LOCATION" = 'true' ]; then
+      tag_user_resource "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}"
+    fi
+    if [ "$ADOPT_ARC_DATA_CUSTOM_LOCATION" = 'true' ]; then
+      adopt_resource "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}"
+    fi
+    result_resource "${ARC_DATA_NAMESPACE}" Exists "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}"
 else 
     echo "INFO | Creating Custom Location $ARC_DATA_NAMESPACE"
     
@@ -578,6 +2241,7 @@ else
                              --cluster-extension-ids "${ARC_DATA_EXT_ID}" \
                              --location "${CONNECTED_CLUSTER_LOCATION}" \
                              ${VERBOSE:+--debug --verbose}
+    tag_owned_resource "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}"
     
     # Check Custom Location status
     ARC_DATA_CUSTOM_LOCATION_STATUS=$(az customlocation show --name "$ARC_DATA_NAMESPACE" --resource-group "$ARC_DATA_RESOURCE_GROUP" | jq -r '.provisioningState')
@@ -585,8 +2249,10 @@ else
     if [ "$ARC_DATA_CUSTOM_LOCATION_STATUS" = 'Succeeded' ]; then
       ARC_DATA_CUSTOM_LOCATION_EXISTS='true'
       export ARC_DATA_CUSTOM_LOCATION_EXISTS
+      result_resource "${ARC_DATA_NAMESPACE}" Created "${ARC_DATA_CUSTOM_LOCATION_ARM_ID}"
     else
       echo "ERROR | Custom Location $ARC_DATA_NAMESPACE provisioning status is $ARC_DATA_CUSTOM_LOCATION_STATUS, manual intervention is required"
+      result_resource "${ARC_DATA_NAMESPACE}" "${ARC_DATA_CUSTOM_LOCATION_STATUS}"
       exit 1
     fi
 fi
@@ -594,8 +2260,16 @@ fi
 # ==================
 # 5. Data Controller
 # ==================
+result_step dataController
 if [ "$ARC_DATA_CONTROLLER_EXISTS" = 'true' ]; then
     echo "INFO | Data Controller $ARC_DATA_CONTROLLER already exists, skipping create"
+    if [ "$RETAG_ARC_DATA_CONTROLLER" = 'true' ]; then
+      tag_user_resource "${ARC_DATA_CONTROLLER_ARM_ID}"
+    fi
+    if [ "$ADOPT_ARC_DATA_CONTROLLER" = 'true' ]; then
+      adopt_resource "${ARC_DATA_CONTROLLER_ARM_ID}"
+    fi
+    result_resource "${ARC_DATA_CONTROLLER}" Exists "${ARC_DATA_CONTROLLER_ARM_ID}"
 else 
     echo "INFO | Creating Data Controller $ARC_DATA_CONTROLLER"
 
@@ -608,12 +2282,14 @@ else
                          --location "${ARC_DATA_CONTROLLER_LOCATION}" \
                          --connectivity-mode direct \
                          ${VERBOSE:+--debug --verbose}
+    tag_owned_resource "${ARC_DATA_CONTROLLER_ARM_ID}"
 
     # Check Data Controller status
     ARC_DATA_CONTROLLER_STATUS=$(az arcdata dc status show --name "$ARC_DATA_CONTROLLER" --resource-group "$ARC_DATA_RESOURCE_GROUP" | jq -r '.properties.k8SRaw.status.state')
 
     if [ "$ARC_DATA_CONTROLLER_STATUS" = 'Failed' ]; then
       echo "ERROR | Data Controller $ARC_DATA_CONTROLLER provisioning status is $ARC_DATA_CONTROLLER_STATUS, manual intervention is required"
+      result_resource "${ARC_DATA_CONTROLLER}" "${ARC_DATA_CONTROLLER_STATUS}" "${ARC_DATA_CONTROLLER_ARM_ID}"
       exit 1
     else
       # Loop for 10 minutes, sleeping for 30 seconds each time to see if Data Controller goes to Ready
@@ -632,6 +2308,11 @@ else
         echo "INFO | Sleeping for 30 seconds..."
         sleep 30
       done
+
+      if [ "$ARC_DATA_CONTROLLER_STATUS" != 'Ready' ]; then
+        result_warning "Data Controller $ARC_DATA_CONTROLLER did not reach Ready state in time, last status is $ARC_DATA_CONTROLLER_STATUS"
+      fi
+      result_resource "${ARC_DATA_CONTROLLER}" "${ARC_DATA_CONTROLLER_STATUS}" "${ARC_DATA_CONTROLLER_ARM_ID}"
     fi
 fi
 
@@ -641,6 +2322,12 @@ if [ "${OPENSHIFT}" = 'true' ]; then
   kubectl apply -n "${ARC_DATA_NAMESPACE}" -f './openshift/arc-data-routes.yaml'
 fi
 
+# ==============================
+# Record the installation status
+# ==============================
+result_step status
+record_onboarded_status lastOnboardTime
+
 echo ""
 echo "----------------------------------------------------------------------------------"
 echo "INFO | Arc Data Services installer script complete"